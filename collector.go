@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/measurement-kit/engine/internal"
 	"github.com/measurement-kit/engine/internal/nettest"
@@ -29,6 +30,15 @@ type CollectorSubmitResults struct {
 // CollectorSubmitTask is a synchronous task for submitting or resubmitting a
 // specific OONI measurement to the OONI collector.
 type CollectorSubmitTask struct {
+	// BouncerCacheDir is the optional directory where we cache the
+	// collectors returned by the bouncer. When the bouncer is not
+	// reachable, we use the cached collectors even if stale.
+	BouncerCacheDir string
+
+	// BouncerCacheTTL is the number of seconds after which cached
+	// collectors are stale. When zero, we use a default TTL.
+	BouncerCacheTTL int64
+
 	// SerializedMeasurement is the measurement to submit.
 	SerializedMeasurement string
 
@@ -93,6 +103,8 @@ func (t *CollectorSubmitTask) runWithResults(out *CollectorSubmitResults) {
 	nettest.SoftwareName = t.SoftwareName
 	nettest.SoftwareVersion = t.SoftwareVersion
 	nettest.TestStartTime = measurement.TestStartTime
+	nettest.BouncerCacheDir = t.BouncerCacheDir
+	nettest.BouncerCacheTTL = time.Duration(t.BouncerCacheTTL) * time.Second
 	err = discoverAvailableCollectors(ctx, &nettest)
 	if err != nil {
 		out.Logs = fmt.Sprintf("cannot discover collectors: %s\n", err.Error())
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/measurement-kit/engine/internal/nettest"
 	"github.com/measurement-kit/engine/model"
@@ -74,6 +76,28 @@ func TestCollectorSubmitConstructorAndSetters(t *testing.T) {
 	}
 }
 
+// TestCollectorSubmitBouncerCache ensures that we configure the
+// nettest to use the bouncer cache.
+func TestCollectorSubmitBouncerCache(t *testing.T) {
+	savedFunc := discoverAvailableCollectors
+	defer func() {
+		discoverAvailableCollectors = savedFunc
+	}()
+	discoverAvailableCollectors = func(ctx context.Context, nt *nettest.Nettest) error {
+		if nt.BouncerCacheDir != "/tmp/bouncer" || nt.BouncerCacheTTL != time.Minute {
+			return errors.New("the nettest does not use the bouncer cache")
+		}
+		return errors.New("mocked error")
+	}
+	task := NewCollectorSubmitTask("ooniprobe-android", "2.1.0", origMeasurement)
+	task.BouncerCacheDir = "/tmp/bouncer"
+	task.BouncerCacheTTL = 60
+	results := task.Run()
+	if !strings.Contains(results.Logs, "mocked error") {
+		t.Fatal("Unexpected logs: " + results.Logs)
+	}
+}
+
 // TestCollectorSubmitUnmarshalError covers the case where we're
 // passed an invalid serialized JSON.
 func TestCollectorSubmitUnmarshalError(t *testing.T) {
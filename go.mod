@@ -13,6 +13,7 @@ require (
 	github.com/Psiphon-Labs/utls v0.0.0-20181219022742-11a4cc033322 // indirect
 	github.com/Yawning/chacha20 v0.0.0-20170904085104-e3b1f968fc63 // indirect
 	github.com/apex/log v1.1.0
+	github.com/creack/goselect v0.1.3 // indirect
 	github.com/gorilla/websocket v1.4.0 // indirect
 	github.com/grafov/m3u8 v0.6.1 // indirect
 	github.com/juju/ratelimit v1.0.1 // indirect
@@ -21,5 +22,6 @@ require (
 	github.com/mccutchen/go-httpbin v0.0.0-20190321153040-24f381761ef0
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/zach-klippenstein/goregen v0.0.0-20160303162051-795b5e3961ea // indirect
+	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
 	golang.org/x/net v0.0.0-20190509222800-a4d6f7feada5
 )
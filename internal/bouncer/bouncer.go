@@ -2,13 +2,20 @@
 //
 // Specifically we implement v2.0.0 of the OONI bouncer specification defined
 // in https://github.com/ooni/spec/blob/master/backends/bk-004-bouncer.md.
+//
+// We also implement a client for the combined probe services API, which
+// returns all the services in a single response, with fallback to the
+// separate v2.0.0 endpoints for bouncers that do not implement it.
 package bouncer
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 
+	"github.com/measurement-kit/engine/internal/errorx"
 	"github.com/measurement-kit/engine/internal/httpx"
+	"github.com/measurement-kit/engine/internal/resolver"
 	"github.com/measurement-kit/engine/model"
 )
 
@@ -16,12 +23,18 @@ import (
 type Config struct {
 	// BaseURL is the optional bouncer base URL to use.
 	BaseURL string
+
+	// Resolver is the optional resolver to use. When nil, we
+	// use the system resolver.
+	Resolver resolver.Resolver
 }
 
 // GetCollectors queries the bouncer for collectors. Returns a list of
 // entries on success; an error on failure.
 func GetCollectors(ctx context.Context, config Config) ([]model.Service, error) {
-	data, err := httpx.GETWithBaseURL(ctx, config.BaseURL, "/api/v1/collectors")
+	data, err := httpx.Client{Resolver: config.Resolver}.GETWithBaseURL(
+		ctx, config.BaseURL, "/api/v1/collectors",
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -32,7 +45,9 @@ func GetCollectors(ctx context.Context, config Config) ([]model.Service, error)
 
 // GetTestHelpers is like GetCollectors but for test helpers.
 func GetTestHelpers(ctx context.Context, config Config) (map[string][]model.Service, error) {
-	data, err := httpx.GETWithBaseURL(ctx, config.BaseURL, "/api/v1/test-helpers")
+	data, err := httpx.Client{Resolver: config.Resolver}.GETWithBaseURL(
+		ctx, config.BaseURL, "/api/v1/test-helpers",
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -40,3 +55,85 @@ func GetTestHelpers(ctx context.Context, config Config) (map[string][]model.Serv
 	err = json.Unmarshal(data, &result)
 	return result, err
 }
+
+// ServicesPath is the path of the combined probe services API.
+const ServicesPath = "/api/v3/services"
+
+// Services contains all the services returned by the bouncer.
+type Services struct {
+	// Bouncers contains the alternate bouncers.
+	Bouncers []model.Service `json:"bouncers"`
+
+	// Collectors contains the collectors.
+	Collectors []model.Service `json:"collectors"`
+
+	// TestHelpers contains the test helpers keyed by name.
+	TestHelpers map[string][]model.Service `json:"test_helpers"`
+}
+
+// errNoServices indicates that the combined probe services API returned
+// neither collectors nor test helpers, which happens, e.g., when a
+// bouncer replies to unknown paths with an empty JSON object.
+var errNoServices = errors.New("bouncer: no collectors and no test helpers")
+
+// getCombinedServices queries the combined probe services API.
+func getCombinedServices(ctx context.Context, config Config) (Services, error) {
+	var result Services
+	data, err := httpx.Client{Resolver: config.Resolver}.GETWithBaseURL(
+		ctx, config.BaseURL, ServicesPath,
+	)
+	if err != nil {
+		return result, err
+	}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return result, err
+	}
+	if len(result.Collectors) <= 0 && len(result.TestHelpers) <= 0 {
+		return result, errNoServices
+	}
+	return result, nil
+}
+
+// getServicesV2 is the fallback using the v2.0.0 API, which requires
+// one round trip for collectors and one for test helpers.
+func getServicesV2(ctx context.Context, config Config) (Services, error) {
+	var result Services
+	var err error
+	result.Collectors, err = GetCollectors(ctx, config)
+	if err != nil {
+		return result, err
+	}
+	result.TestHelpers, err = GetTestHelpers(ctx, config)
+	return result, err
+}
+
+// shouldFallback returns whether err indicates that the bouncer replied
+// but does not implement the combined probe services API, i.e., it
+// returned an HTTP error, a body that is not a services object, or
+// a services object without collectors and test helpers.
+func shouldFallback(err error) bool {
+	if err == errNoServices {
+		return true
+	}
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return true
+	}
+	return errorx.Classify(err) == errorx.FailureHTTPRequestFailed
+}
+
+// GetServices queries the bouncer for all the services using a single
+// round trip. If the bouncer replies but does not implement the combined
+// probe services API, we transparently fall back to querying collectors
+// and test helpers separately. We do not fall back when we cannot talk
+// with the bouncer, because in such case the fallback would fail as well.
+func GetServices(ctx context.Context, config Config) (Services, error) {
+	result, err := getCombinedServices(ctx, config)
+	if err == nil {
+		return result, nil
+	}
+	if !shouldFallback(err) {
+		return result, err
+	}
+	return getServicesV2(ctx, config)
+}
@@ -2,7 +2,14 @@ package bouncer
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/measurement-kit/engine/internal/dnsx/dnstest"
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/resolver"
 )
 
 // TestGetCollectorsIntegration just fetches the collectors.
@@ -46,3 +53,116 @@ func TestGetTestHelpersFailure(t *testing.T) {
 		t.Fatal("We expected an error here")
 	}
 }
+
+// TestGetCollectorsResolver checks whether we use the configured
+// resolver to resolve the bouncer name.
+func TestGetCollectorsResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"address": "https://c.example.com", "type": "https"}]`))
+		},
+	))
+	defer server.Close()
+	dnsServer, err := dnstest.NewTCPServer(map[string][]string{
+		"bouncer.example.com": {"127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dnsServer.Close()
+	entries, err := GetCollectors(context.Background(), Config{
+		BaseURL:  strings.Replace(server.URL, "127.0.0.1", "bouncer.example.com", 1),
+		Resolver: resolver.TCP{Address: dnsServer.Addr},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Address != "https://c.example.com" {
+		t.Fatalf("Unexpected entries: %+v", entries)
+	}
+}
+
+// newServer creates a bouncer. If services is empty, the bouncer does
+// not implement the combined probe services API. The returned map
+// counts the requests by path.
+func newServer(services string) (*httptest.Server, map[string]int) {
+	paths := make(map[string]int)
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			paths[r.URL.Path]++
+			switch r.URL.Path {
+			case ServicesPath:
+				if services == "" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Write([]byte(services))
+			case "/api/v1/collectors":
+				w.Write([]byte(`[{"address": "https://c.example.com", "type": "https"}]`))
+			case "/api/v1/test-helpers":
+				w.Write([]byte(`{"dns": [{"address": "8.8.8.8:53", "type": "legacy"}]}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		},
+	)), paths
+}
+
+// TestGetServices checks whether we get all the services
+// using a single round trip.
+func TestGetServices(t *testing.T) {
+	server, paths := newServer(`{
+		"bouncers": [{"address": "https://b.example.com", "type": "https"}],
+		"collectors": [{"address": "https://c.example.com", "type": "https"}],
+		"test_helpers": {"dns": [{"address": "8.8.8.8:53", "type": "legacy"}]}
+	}`)
+	defer server.Close()
+	services, err := GetServices(context.Background(), Config{BaseURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services.Bouncers) != 1 || len(services.Collectors) != 1 ||
+		len(services.TestHelpers["dns"]) != 1 {
+		t.Fatalf("Unexpected services: %+v", services)
+	}
+	if len(paths) != 1 || paths[ServicesPath] != 1 {
+		t.Fatalf("Unexpected requests: %+v", paths)
+	}
+}
+
+// TestGetServicesFallback checks whether we fall back to the
+// v2.0.0 API when the combined API is not implemented or when it
+// returns neither collectors nor test helpers.
+func TestGetServicesFallback(t *testing.T) {
+	for _, body := range []string{
+		"", "<html></html>", `{"collectors": 1}`, `{}`,
+		`{"collectors": [], "test_helpers": {}}`,
+	} {
+		server, paths := newServer(body)
+		services, err := GetServices(context.Background(), Config{BaseURL: server.URL})
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(services.Collectors) != 1 || len(services.TestHelpers["dns"]) != 1 {
+			t.Fatalf("Unexpected services: %+v", services)
+		}
+		if len(paths) != 3 {
+			t.Fatalf("Unexpected requests: %+v", paths)
+		}
+	}
+}
+
+// TestGetServicesFailure checks whether we do not fall back
+// when we cannot talk with the bouncer.
+func TestGetServicesFailure(t *testing.T) {
+	server, paths := newServer("")
+	server.Close()
+	_, err := GetServices(context.Background(), Config{BaseURL: server.URL})
+	if errorx.Classify(err) != errorx.FailureConnectionRefused {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(paths) != 0 {
+		t.Fatal("We did not expect any request")
+	}
+}
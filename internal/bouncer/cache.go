@@ -0,0 +1,133 @@
+package bouncer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultCacheTTL is the default time after which cache entries are stale.
+const DefaultCacheTTL = 24 * time.Hour
+
+// cacheEntry is an entry of the cache.
+type cacheEntry struct {
+	// BaseURL is the bouncer base URL.
+	BaseURL string `json:"base_url"`
+
+	// Kind is the kind of query, e.g., "collectors".
+	Kind string `json:"kind"`
+
+	// Services contains the services returned by the bouncer.
+	Services Services `json:"services"`
+
+	// Time is the time when we queried the bouncer.
+	Time time.Time `json:"time"`
+}
+
+// Cache caches on disk the services returned by bouncers, such that
+// we do not need to query the bouncer every time we run a nettest.
+type Cache struct {
+	// Dir is the directory where we store the cache entries.
+	Dir string
+
+	// TTL is the time after which entries are stale. When it is
+	// zero or negative, we use DefaultCacheTTL.
+	TTL time.Duration
+}
+
+// QueryFunc queries the bouncer described by config.
+type QueryFunc = func(ctx context.Context, config Config) (Services, error)
+
+// timeNow allows to mock time.Now in tests.
+var timeNow = time.Now
+
+// path returns the path of the entry for the kind query sent to
+// the bouncer at baseURL.
+func (c Cache) path(kind, baseURL string) string {
+	key := kind + " " + baseURL
+	return filepath.Join(c.Dir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(key))))
+}
+
+// ttl returns the configured TTL or the default TTL.
+func (c Cache) ttl() time.Duration {
+	if c.TTL <= 0 {
+		return DefaultCacheTTL
+	}
+	return c.TTL
+}
+
+// read reads the entry for the kind query sent to the bouncer at baseURL.
+func (c Cache) read(kind, baseURL string) (*cacheEntry, error) {
+	data, err := ioutil.ReadFile(c.path(kind, baseURL))
+	if err != nil {
+		return nil, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	if entry.BaseURL != baseURL || entry.Kind != kind {
+		return nil, fmt.Errorf("bouncer: cache entry for %s is not valid", baseURL)
+	}
+	return &entry, nil
+}
+
+// write writes entry on disk. We write a temporary file and rename
+// it, such that concurrent readers never see a partial entry.
+func (c Cache) write(entry cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return err
+	}
+	filep, err := ioutil.TempFile(c.Dir, "tmp")
+	if err != nil {
+		return err
+	}
+	_, err = filep.Write(data)
+	if closeErr := filep.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(filep.Name(), c.path(entry.Kind, entry.BaseURL))
+	}
+	if err != nil {
+		os.Remove(filep.Name())
+	}
+	return err
+}
+
+// Wrap returns a QueryFunc that is like query but uses the cache. The
+// kind identifies the query, e.g., "collectors", such that different
+// queries sent to the same bouncer use different entries. When the entry
+// for config.BaseURL is fresh, we return it without calling query.
+// Otherwise, we call query and update the entry. If query fails, we
+// return the stale entry, if any, such that we can still talk with
+// collectors when the bouncer is blocked.
+func (c Cache) Wrap(kind string, query QueryFunc) QueryFunc {
+	return func(ctx context.Context, config Config) (Services, error) {
+		entry, readErr := c.read(kind, config.BaseURL)
+		if readErr == nil && timeNow().Sub(entry.Time) < c.ttl() {
+			return entry.Services, nil
+		}
+		services, err := query(ctx, config)
+		if err != nil {
+			if readErr == nil {
+				return entry.Services, nil
+			}
+			return services, err
+		}
+		// Failing to write the cache is not fatal, since we have the services.
+		c.write(cacheEntry{
+			BaseURL: config.BaseURL, Kind: kind, Services: services, Time: timeNow(),
+		})
+		return services, nil
+	}
+}
@@ -0,0 +1,173 @@
+package bouncer
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/measurement-kit/engine/model"
+)
+
+// withCache runs fn with a cache using a temporary directory and
+// with a "services" query returning the result of query.
+func withCache(
+	t *testing.T, fn func(cache Cache, get QueryFunc),
+	query func() (Services, error),
+) {
+	dir, err := ioutil.TempDir("", "bouncer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	savedTimeNow := timeNow
+	defer func() { timeNow = savedTimeNow }()
+	cache := Cache{Dir: filepath.Join(dir, "cache"), TTL: time.Hour}
+	fn(cache, cache.Wrap("services", func(
+		ctx context.Context, config Config,
+	) (Services, error) {
+		return query()
+	}))
+}
+
+var cachedServices = Services{
+	Collectors: []model.Service{{Address: "https://c.example.com", Type: "https"}},
+}
+
+// TestCacheFresh checks whether we do not query the bouncer
+// as long as the entry is fresh.
+func TestCacheFresh(t *testing.T) {
+	var count int
+	withCache(t, func(cache Cache, get QueryFunc) {
+		config := Config{BaseURL: "https://b.example.com"}
+		for i := 0; i < 3; i++ {
+			services, err := get(context.Background(), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(services.Collectors) != 1 {
+				t.Fatal("Unexpected services")
+			}
+		}
+		if count != 1 {
+			t.Fatal("We expected to query the bouncer once")
+		}
+		config.BaseURL = "https://b2.example.com"
+		if _, err := get(context.Background(), config); err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Fatal("We expected entries to be keyed by bouncer URL")
+		}
+		other := cache.Wrap("collectors", func(
+			ctx context.Context, config Config,
+		) (Services, error) {
+			count++
+			return Services{}, nil
+		})
+		services, err := other(context.Background(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 3 || len(services.Collectors) != 0 {
+			t.Fatal("We expected entries to be keyed by query kind")
+		}
+	}, func() (Services, error) {
+		count++
+		return cachedServices, nil
+	})
+}
+
+// TestCacheStale checks whether we query the bouncer again once
+// the entry is stale and fall back to the stale entry on failure.
+func TestCacheStale(t *testing.T) {
+	var count int
+	var failure error
+	withCache(t, func(cache Cache, get QueryFunc) {
+		config := Config{BaseURL: "https://b.example.com"}
+		if _, err := get(context.Background(), config); err != nil {
+			t.Fatal(err)
+		}
+		timeNow = func() time.Time {
+			return time.Now().Add(2 * time.Hour)
+		}
+		failure = errors.New("mocked error")
+		services, err := get(context.Background(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 || len(services.Collectors) != 1 {
+			t.Fatal("We expected to use the stale entry")
+		}
+		config.BaseURL = "https://b2.example.com"
+		if _, err := get(context.Background(), config); err != failure {
+			t.Fatal("Not the error we expected")
+		}
+	}, func() (Services, error) {
+		count++
+		return cachedServices, failure
+	})
+}
+
+// TestCacheInvalidEntry checks whether we ignore entries that
+// we cannot parse and entries for another bouncer.
+func TestCacheInvalidEntry(t *testing.T) {
+	var count int
+	withCache(t, func(cache Cache, get QueryFunc) {
+		config := Config{BaseURL: "https://b.example.com"}
+		if err := os.MkdirAll(cache.Dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		path := cache.path("services", config.BaseURL)
+		if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := get(context.Background(), config); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(path, cache.path("services", "https://b2.example.com")); err != nil {
+			t.Fatal(err)
+		}
+		config.BaseURL = "https://b2.example.com"
+		if _, err := get(context.Background(), config); err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Fatal("We expected to query the bouncer twice")
+		}
+	}, func() (Services, error) {
+		count++
+		return cachedServices, nil
+	})
+}
+
+// TestCacheWriteFailure checks whether we return the services
+// even if we cannot write the cache.
+func TestCacheWriteFailure(t *testing.T) {
+	withCache(t, func(cache Cache, get QueryFunc) {
+		if err := ioutil.WriteFile(cache.Dir, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		services, err := get(context.Background(), Config{
+			BaseURL: "https://b.example.com",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(services.Collectors) != 1 {
+			t.Fatal("Unexpected services")
+		}
+	}, func() (Services, error) {
+		return cachedServices, nil
+	})
+}
+
+// TestCacheDefaultTTL checks whether we use the default TTL.
+func TestCacheDefaultTTL(t *testing.T) {
+	if (Cache{}).ttl() != DefaultCacheTTL {
+		t.Fatal("We expected the default TTL")
+	}
+}
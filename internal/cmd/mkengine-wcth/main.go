@@ -0,0 +1,18 @@
+// Command mkengine-wcth runs the web_connectivity test helper.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/measurement-kit/engine/internal/nettest/webconnectivity/wcth"
+)
+
+var address = flag.String("address", "127.0.0.1:8080", "address to listen on")
+
+func main() {
+	flag.Parse()
+	log.Printf("listening on %s", *address)
+	log.Fatal(http.ListenAndServe(*address, wcth.Handler{}))
+}
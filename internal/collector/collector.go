@@ -8,8 +8,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/measurement-kit/engine/internal/httpx"
+	"github.com/measurement-kit/engine/internal/resolver"
 	"github.com/measurement-kit/engine/model"
 )
 
@@ -17,6 +19,15 @@ import (
 type Config struct {
 	// BaseURL is the collector base URL
 	BaseURL string
+
+	// Resolver is the optional resolver. When nil, we use the
+	// system resolver.
+	Resolver resolver.Resolver
+}
+
+// client returns the client to talk with the collector.
+func (c Config) client() httpx.Client {
+	return httpx.Client{Resolver: c.Resolver}
 }
 
 // ReportTemplate is the template for opening a report
@@ -58,7 +69,7 @@ func open(ctx context.Context, conf Config, rt ReportTemplate) (Report, error) {
 	if err != nil {
 		return report, err
 	}
-	responseData, err := httpx.POSTWithBaseURL(
+	responseData, err := conf.client().POSTWithBaseURL(
 		ctx, conf.BaseURL, "/report", "application/json", requestData,
 	)
 	if err != nil {
@@ -101,7 +112,7 @@ type updateResponse struct {
 }
 
 // httpxPOSTWithBaseURL simplifies life in unit tests
-var httpxPOSTWithBaseURL = httpx.POSTWithBaseURL
+var httpxPOSTWithBaseURL = httpx.Client.POSTWithBaseURL
 
 // Update updates a report by appending a new measurement to it.
 //
@@ -116,7 +127,7 @@ func (r Report) Update(ctx context.Context, m model.Measurement) (string, error)
 		return "", err
 	}
 	data, err = httpxPOSTWithBaseURL(
-		ctx, r.Conf.BaseURL, fmt.Sprintf("/report/%s", r.ID),
+		r.Conf.client(), ctx, r.Conf.BaseURL, fmt.Sprintf("/report/%s", r.ID),
 		"application/json", data,
 	)
 	if err != nil {
@@ -132,8 +143,24 @@ func (r Report) Update(ctx context.Context, m model.Measurement) (string, error)
 
 // Close closes the report. Returns nil on success; an error on failure.
 func (r Report) Close(ctx context.Context) error {
-	_, err := httpx.POSTWithBaseURL(
+	_, err := r.Conf.client().POSTWithBaseURL(
 		ctx, r.Conf.BaseURL, fmt.Sprintf("/report/%s/close", r.ID), "", nil,
 	)
 	return err
 }
+
+// Probe checks whether the collector is reachable. Returns the time
+// elapsed until we received the response on success; an error on
+// failure. Any HTTP response, including, e.g., 404, means that the
+// collector is reachable, since we only care about reachability.
+func Probe(ctx context.Context, conf Config) (time.Duration, error) {
+	start := time.Now()
+	_, err := httpx.Request{
+		Ctx:           ctx,
+		Method:        "GET",
+		NoFailOnError: true,
+		Resolver:      conf.Resolver,
+		URL:           conf.BaseURL,
+	}.Perform()
+	return time.Now().Sub(start), err
+}
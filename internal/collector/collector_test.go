@@ -3,8 +3,12 @@ package collector
 import (
 	"context"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/measurement-kit/engine/internal/httpx"
+	"github.com/measurement-kit/engine/internal/resolver"
 	"github.com/measurement-kit/engine/model"
 )
 
@@ -129,7 +133,10 @@ func TestUpdateHTTPError(t *testing.T) {
 // JSON unmarshalling errors in Update.
 func TestUpdateJSONUnmarshalError(t *testing.T) {
 	savedFunc := httpxPOSTWithBaseURL
-	httpxPOSTWithBaseURL = func(ctx context.Context, baseURL, path, contentType string, body []byte) ([]byte, error) {
+	httpxPOSTWithBaseURL = func(
+		client httpx.Client, ctx context.Context,
+		baseURL, path, contentType string, body []byte,
+	) ([]byte, error) {
 		return []byte("{"), nil // this is not valid JSON
 	}
 	ctx := context.Background()
@@ -140,3 +147,46 @@ func TestUpdateJSONUnmarshalError(t *testing.T) {
 	}
 	httpxPOSTWithBaseURL = savedFunc
 }
+
+// TestUpdateResolver verifies that Update uses the configured resolver.
+func TestUpdateResolver(t *testing.T) {
+	savedFunc := httpxPOSTWithBaseURL
+	defer func() {
+		httpxPOSTWithBaseURL = savedFunc
+	}()
+	expected := resolver.UDP{Address: "127.0.0.1:53"}
+	httpxPOSTWithBaseURL = func(
+		client httpx.Client, ctx context.Context,
+		baseURL, path, contentType string, body []byte,
+	) ([]byte, error) {
+		if client.Resolver != expected {
+			return nil, errors.New("not the resolver we expected")
+		}
+		return []byte(`{"measurement_id": "xx"}`), nil
+	}
+	r := Report{Conf: Config{Resolver: expected}}
+	ID, err := r.Update(context.Background(), model.Measurement{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ID != "xx" {
+		t.Fatal("Unexpected measurement ID")
+	}
+}
+
+// TestProbe verifies that Probe deals with reachable and
+// unreachable collectors.
+func TestProbe(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	elapsed, err := Probe(context.Background(), Config{BaseURL: server.URL})
+	server.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed <= 0 {
+		t.Fatal("Unexpected elapsed time")
+	}
+	if _, err := Probe(context.Background(), Config{BaseURL: server.URL}); err == nil {
+		t.Fatal("We expected an error here")
+	}
+}
@@ -0,0 +1,265 @@
+// Package dnstest implements DNS servers for testing.
+package dnstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/dnsx"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Reply creates the reply to query using records, which maps domain
+// names to IPv4 and IPv6 addresses. We reply with NXDOMAIN to queries
+// for names that are not in records.
+func Reply(query []byte, records map[string][]string) ([]byte, error) {
+	var message dnsmessage.Message
+	if err := message.Unpack(query); err != nil {
+		return nil, err
+	}
+	message.Header.Response = true
+	message.Header.RecursionAvailable = true
+	if len(message.Questions) != 1 {
+		message.Header.RCode = dnsmessage.RCodeFormatError
+		return message.Pack()
+	}
+	question := message.Questions[0]
+	addrs, found := records[strings.TrimSuffix(question.Name.String(), ".")]
+	if !found {
+		message.Header.RCode = dnsmessage.RCodeNameError
+		return message.Pack()
+	}
+	header := dnsmessage.ResourceHeader{
+		Name:  question.Name,
+		Type:  question.Type,
+		Class: dnsmessage.ClassINET,
+		TTL:   60,
+	}
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		switch {
+		case ip == nil:
+		case question.Type == dnsmessage.TypeA && ip.To4() != nil:
+			var body dnsmessage.AResource
+			copy(body.A[:], ip.To4())
+			message.Answers = append(message.Answers, dnsmessage.Resource{
+				Header: header, Body: &body,
+			})
+		case question.Type == dnsmessage.TypeAAAA && ip.To4() == nil:
+			var body dnsmessage.AAAAResource
+			copy(body.AAAA[:], ip.To16())
+			message.Answers = append(message.Answers, dnsmessage.Resource{
+				Header: header, Body: &body,
+			})
+		}
+	}
+	return message.Pack()
+}
+
+// Server is a DNS server listening on the loopback interface.
+type Server struct {
+	// Addr is the address where the server is listening.
+	Addr string
+
+	// Certificate is the self signed certificate used by DNS over TLS
+	// and DNS over HTTPS servers. It is valid for 127.0.0.1.
+	Certificate *x509.Certificate
+
+	// URL is the URL of DNS over HTTPS servers.
+	URL string
+
+	closer io.Closer
+	wg     sync.WaitGroup
+}
+
+// NewUDPServer starts a DNS over UDP server replying using records.
+func NewUDPServer(records map[string][]string) (*Server, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{Addr: conn.LocalAddr().String(), closer: conn}
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		buffer := make([]byte, 1<<12)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			reply, err := Reply(buffer[:n], records)
+			if err == nil {
+				conn.WriteTo(reply, addr)
+			}
+		}
+	}()
+	return server, nil
+}
+
+// NewTCPServer starts a DNS over TCP server replying using records.
+func NewTCPServer(records map[string][]string) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{Addr: listener.Addr().String(), closer: listener}
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go ServeTCP(conn, records)
+		}
+	}()
+	return server, nil
+}
+
+// newCertificate creates a self signed certificate valid for 127.0.0.1.
+func newCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"dnstest"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf,
+	}, nil
+}
+
+// NewTLSServer starts a DNS over TLS server replying using records.
+func NewTLSServer(records map[string][]string) (*Server, error) {
+	cert, err := newCertificate()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{
+		Addr:        listener.Addr().String(),
+		Certificate: cert.Leaf,
+		closer:      listener,
+	}
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go ServeTCP(conn, records)
+		}
+	}()
+	return server, nil
+}
+
+// httpsCloser allows to use an httptest.Server as an io.Closer.
+type httpsCloser struct {
+	server *httptest.Server
+}
+
+// Close implements io.Closer.Close.
+func (c httpsCloser) Close() error {
+	c.server.Close()
+	return nil
+}
+
+// NewHTTPSServer starts a DNS over HTTPS server replying using records.
+func NewHTTPSServer(records map[string][]string) (*Server, error) {
+	cert, err := newCertificate()
+	if err != nil {
+		return nil, err
+	}
+	httpsServer := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			query, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<16))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			reply, err := Reply(query, records)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/dns-message")
+			w.Write(reply)
+		},
+	))
+	httpsServer.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	httpsServer.StartTLS()
+	return &Server{
+		Addr:        httpsServer.Listener.Addr().String(),
+		Certificate: cert.Leaf,
+		URL:         httpsServer.URL + "/dns-query",
+		closer:      httpsCloser{server: httpsServer},
+	}, nil
+}
+
+// ServeTCP replies to the queries received over conn using records
+// until conn is closed. It is also useful to implement DNS over TLS.
+func ServeTCP(conn net.Conn, records map[string][]string) {
+	defer conn.Close()
+	for {
+		query, err := dnsx.ReadTCP(conn)
+		if err != nil {
+			return
+		}
+		reply, err := Reply(query, records)
+		if err != nil {
+			return
+		}
+		if err = dnsx.WriteTCP(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.closer.Close()
+	s.wg.Wait()
+}
@@ -0,0 +1,163 @@
+package dnstest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/dnsx"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var records = map[string][]string{
+	"example.com": {"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"},
+}
+
+// exchange resolves name using the server and exchange.
+func exchange(
+	t *testing.T, server *Server, name string, qtype dnsmessage.Type,
+	fn func(context.Context, string, []byte) ([]byte, error),
+) ([]string, error) {
+	query, err := dnsx.NewQuery(name, qtype)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reply, err := fn(ctx, server.Addr, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dnsx.ParseReply(query, reply)
+}
+
+// check checks whether server replies correctly using exchange.
+func check(
+	t *testing.T, server *Server,
+	fn func(context.Context, string, []byte) ([]byte, error),
+) {
+	defer server.Close()
+	addrs, err := exchange(t, server, "example.com", dnsmessage.TypeA, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "93.184.216.34" {
+		t.Fatalf("Unexpected A addrs: %+v", addrs)
+	}
+	addrs, err = exchange(t, server, "example.com", dnsmessage.TypeAAAA, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "2606:2800:220:1:248:1893:25c8:1946" {
+		t.Fatalf("Unexpected AAAA addrs: %+v", addrs)
+	}
+	_, err = exchange(t, server, "antani.example.com", dnsmessage.TypeA, fn)
+	if err != dnsx.ErrNXDOMAIN {
+		t.Fatal("We expected NXDOMAIN")
+	}
+}
+
+// TestUDPServer checks whether the UDP server works.
+func TestUDPServer(t *testing.T) {
+	server, err := NewUDPServer(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, server, dnsx.ExchangeUDP)
+}
+
+// TestTCPServer checks whether the TCP server works.
+func TestTCPServer(t *testing.T) {
+	server, err := NewTCPServer(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, server, dnsx.ExchangeTCP)
+}
+
+// newCertPool returns a pool containing the server certificate.
+func newCertPool(server *Server) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate)
+	return pool
+}
+
+// TestTLSServer checks whether the DNS over TLS server works.
+func TestTLSServer(t *testing.T) {
+	server, err := NewTLSServer(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{RootCAs: newCertPool(server), ServerName: "127.0.0.1"}
+	check(t, server, func(ctx context.Context, address string, query []byte) ([]byte, error) {
+		return dnsx.ExchangeTLS(ctx, address, config, query)
+	})
+}
+
+// TestHTTPSServer checks whether the DNS over HTTPS server works.
+func TestHTTPSServer(t *testing.T) {
+	server, err := NewHTTPSServer(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: newCertPool(server)},
+	}}
+	check(t, server, func(ctx context.Context, address string, query []byte) ([]byte, error) {
+		return dnsx.ExchangeHTTPS(ctx, client, server.URL, query)
+	})
+}
+
+// TestHTTPSServerErrors checks whether the DNS over HTTPS server
+// rejects invalid requests.
+func TestHTTPSServerErrors(t *testing.T) {
+	server, err := NewHTTPSServer(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: newCertPool(server)},
+	}}
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatal("Unexpected status code for GET")
+	}
+	response, err = client.Post(server.URL, "application/dns-message", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatal("Unexpected status code for an invalid query")
+	}
+}
+
+// TestReplyErrors checks whether Reply deals with invalid queries.
+func TestReplyErrors(t *testing.T) {
+	if _, err := Reply([]byte{0}, records); err == nil {
+		t.Fatal("We expected an error here")
+	}
+	query, err := (&dnsmessage.Message{}).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := Reply(query, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var message dnsmessage.Message
+	if err := message.Unpack(reply); err != nil {
+		t.Fatal(err)
+	}
+	if message.Header.RCode != dnsmessage.RCodeFormatError {
+		t.Fatal("We expected a format error")
+	}
+}
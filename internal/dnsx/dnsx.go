@@ -0,0 +1,230 @@
+// Package dnsx contains DNS extensions.
+//
+// We use this package to build DNS queries, exchange them with DNS
+// servers, and parse the replies. Since we have access to the raw
+// queries and replies, nettests can archive them.
+package dnsx
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxUDPReplySize is the maximum size of a DNS reply over UDP.
+const maxUDPReplySize = 1 << 12
+
+// maxHTTPSReplySize is the maximum size of a DNS reply over HTTPS.
+const maxHTTPSReplySize = 1 << 16
+
+// dnsMessageContentType is the content type of DNS over HTTPS messages.
+const dnsMessageContentType = "application/dns-message"
+
+// ErrNXDOMAIN indicates that the domain does not exist.
+var ErrNXDOMAIN = errors.New("dns: no such host")
+
+// ErrNoAnswer indicates that the reply does not contain any address.
+var ErrNoAnswer = errors.New("dns: no answer")
+
+// ErrMismatch indicates that the reply does not match the query.
+var ErrMismatch = errors.New("dns: reply does not match query")
+
+// newID returns a random query ID.
+func newID() uint16 {
+	var b [2]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
+
+// NewQuery creates a new query for the specified name and type,
+// where qtype is either dnsmessage.TypeA or dnsmessage.TypeAAAA.
+func NewQuery(name string, qtype dnsmessage.Type) ([]byte, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	message := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               newID(),
+			RecursionDesired: true,
+		},
+		Questions: []dnsmessage.Question{{
+			Name:  qname,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	return message.Pack()
+}
+
+// ParseReply parses the reply to the specified query and returns the
+// addresses it contains. It fails if the reply does not match the query,
+// if the reply is an error, or if the reply does not contain addresses.
+func ParseReply(query, reply []byte) ([]string, error) {
+	var q, r dnsmessage.Message
+	if err := q.Unpack(query); err != nil {
+		return nil, err
+	}
+	if err := r.Unpack(reply); err != nil {
+		return nil, err
+	}
+	if !r.Header.Response || r.Header.ID != q.Header.ID {
+		return nil, ErrMismatch
+	}
+	switch r.Header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, ErrNXDOMAIN
+	default:
+		return nil, fmt.Errorf("dns: server replied %s", r.Header.RCode)
+	}
+	var addrs []string
+	for _, answer := range r.Answers {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, net.IP(body.AAAA[:]).String())
+		}
+	}
+	if len(addrs) <= 0 {
+		return nil, ErrNoAnswer
+	}
+	return addrs, nil
+}
+
+// dial connects to address using network.
+func dial(ctx context.Context, network, address string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return conn, nil
+}
+
+// ExchangeUDP sends query to the DNS server at address using UDP and
+// returns the raw reply. Use a context with a deadline to bound the
+// time we wait for the reply.
+func ExchangeUDP(ctx context.Context, address string, query []byte) ([]byte, error) {
+	conn, err := dial(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, maxUDPReplySize)
+	n, err := conn.Read(reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply[:n], nil
+}
+
+// WriteTCP writes a length-prefixed DNS message on w.
+func WriteTCP(w io.Writer, message []byte) error {
+	if len(message) > 0xffff {
+		return errors.New("dns: message too long")
+	}
+	data := make([]byte, 2, 2+len(message))
+	binary.BigEndian.PutUint16(data, uint16(len(message)))
+	_, err := w.Write(append(data, message...))
+	return err
+}
+
+// ReadTCP reads a length-prefixed DNS message from r.
+func ReadTCP(r io.Reader) ([]byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	message := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// ExchangeTCP is like ExchangeUDP but uses TCP.
+func ExchangeTCP(ctx context.Context, address string, query []byte) ([]byte, error) {
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err = WriteTCP(conn, query); err != nil {
+		return nil, err
+	}
+	return ReadTCP(conn)
+}
+
+// ExchangeTLS is like ExchangeTCP but uses TLS. If config is nil, we
+// use the default config with the host in address as the SNI.
+func ExchangeTLS(
+	ctx context.Context, address string, config *tls.Config, query []byte,
+) ([]byte, error) {
+	if config == nil {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		config = &tls.Config{ServerName: host}
+	}
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	tlsconn := tls.Client(conn, config)
+	defer tlsconn.Close()
+	if err = tlsconn.Handshake(); err != nil {
+		return nil, err
+	}
+	if err = WriteTCP(tlsconn, query); err != nil {
+		return nil, err
+	}
+	return ReadTCP(tlsconn)
+}
+
+// ExchangeHTTPS sends query to the DNS over HTTPS server at URL using
+// client, as described in RFC8484, and returns the raw reply.
+func ExchangeHTTPS(
+	ctx context.Context, client *http.Client, URL string, query []byte,
+) ([]byte, error) {
+	request, err := http.NewRequest("POST", URL, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", dnsMessageContentType)
+	request.Header.Set("Content-Type", dnsMessageContentType)
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("dns: server returned status %d", response.StatusCode)
+	}
+	if response.Header.Get("Content-Type") != dnsMessageContentType {
+		return nil, errors.New("dns: server returned unexpected content type")
+	}
+	return ioutil.ReadAll(io.LimitReader(response.Body, maxHTTPSReplySize))
+}
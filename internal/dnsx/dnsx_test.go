@@ -0,0 +1,198 @@
+package dnsx
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newReply creates the reply to query with the specified rcode and answers.
+func newReply(
+	t *testing.T, query []byte, rcode dnsmessage.RCode,
+	answers ...dnsmessage.Resource,
+) []byte {
+	var message dnsmessage.Message
+	if err := message.Unpack(query); err != nil {
+		t.Fatal(err)
+	}
+	message.Header.Response = true
+	message.Header.RCode = rcode
+	message.Answers = answers
+	reply, err := message.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+// newQuery creates a query for example.com or fails the test.
+func newQuery(t *testing.T) []byte {
+	query, err := NewQuery("example.com", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query
+}
+
+// TestNewQuery checks whether NewQuery creates a valid query.
+func TestNewQuery(t *testing.T) {
+	var message dnsmessage.Message
+	if err := message.Unpack(newQuery(t)); err != nil {
+		t.Fatal(err)
+	}
+	if len(message.Questions) != 1 {
+		t.Fatal("Unexpected number of questions")
+	}
+	if message.Questions[0].Name.String() != "example.com." {
+		t.Fatal("Unexpected name")
+	}
+	if message.Questions[0].Type != dnsmessage.TypeA {
+		t.Fatal("Unexpected type")
+	}
+	if !message.Header.RecursionDesired {
+		t.Fatal("Recursion not desired")
+	}
+}
+
+// TestNewQueryInvalidName checks whether NewQuery fails with a too long name.
+func TestNewQueryInvalidName(t *testing.T) {
+	_, err := NewQuery(string(bytes.Repeat([]byte("a"), 300)), dnsmessage.TypeA)
+	if err == nil {
+		t.Fatal("We expected an error here")
+	}
+}
+
+// TestParseReply checks whether ParseReply returns the addresses.
+func TestParseReply(t *testing.T) {
+	query := newQuery(t)
+	name := dnsmessage.MustNewName("example.com.")
+	reply := newReply(t, query, dnsmessage.RCodeSuccess, dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET,
+		},
+		Body: &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}},
+	}, dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name: name, Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET,
+		},
+		Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 15: 1}},
+	})
+	addrs, err := ParseReply(query, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || addrs[0] != "93.184.216.34" || addrs[1] != "2001::1" {
+		t.Fatalf("Unexpected addrs: %+v", addrs)
+	}
+}
+
+// TestParseReplyErrors checks whether ParseReply deals with errors.
+func TestParseReplyErrors(t *testing.T) {
+	query := newQuery(t)
+	other := newQuery(t)
+	for other[0] == query[0] && other[1] == query[1] {
+		other = newQuery(t)
+	}
+	if _, err := ParseReply(query, []byte{0}); err == nil {
+		t.Fatal("We expected an error with an invalid reply")
+	}
+	if _, err := ParseReply([]byte{0}, query); err == nil {
+		t.Fatal("We expected an error with an invalid query")
+	}
+	if _, err := ParseReply(query, query); err != ErrMismatch {
+		t.Fatal("We expected a mismatch with a non-response")
+	}
+	reply := newReply(t, other, dnsmessage.RCodeSuccess)
+	if _, err := ParseReply(query, reply); err != ErrMismatch {
+		t.Fatal("We expected a mismatch with a different ID")
+	}
+	reply = newReply(t, query, dnsmessage.RCodeNameError)
+	if _, err := ParseReply(query, reply); err != ErrNXDOMAIN {
+		t.Fatal("We expected NXDOMAIN")
+	}
+	reply = newReply(t, query, dnsmessage.RCodeServerFailure)
+	if _, err := ParseReply(query, reply); err == nil {
+		t.Fatal("We expected an error with SERVFAIL")
+	}
+	reply = newReply(t, query, dnsmessage.RCodeSuccess)
+	if _, err := ParseReply(query, reply); err != ErrNoAnswer {
+		t.Fatal("We expected no answer")
+	}
+}
+
+// TestTCPFraming checks whether WriteTCP and ReadTCP roundtrip.
+func TestTCPFraming(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := WriteTCP(&buffer, []byte("antani")); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.Len() != 8 {
+		t.Fatal("Unexpected framed message length")
+	}
+	message, err := ReadTCP(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(message) != "antani" {
+		t.Fatal("Unexpected message")
+	}
+	if _, err := ReadTCP(&buffer); err == nil {
+		t.Fatal("We expected an error reading past the end")
+	}
+	if _, err := ReadTCP(bytes.NewReader([]byte{0, 4, 1})); err == nil {
+		t.Fatal("We expected an error with a truncated message")
+	}
+	if err := WriteTCP(&buffer, make([]byte, 1<<16)); err == nil {
+		t.Fatal("We expected an error with a too long message")
+	}
+}
+
+// TestExchangeDialFailure checks whether we deal with dial errors.
+func TestExchangeDialFailure(t *testing.T) {
+	ctx := context.Background()
+	if _, err := ExchangeUDP(ctx, "antani", newQuery(t)); err == nil {
+		t.Fatal("We expected an error with UDP")
+	}
+	if _, err := ExchangeTCP(ctx, "antani", newQuery(t)); err == nil {
+		t.Fatal("We expected an error with TCP")
+	}
+	if _, err := ExchangeTLS(ctx, "antani", nil, newQuery(t)); err == nil {
+		t.Fatal("We expected an error with TLS")
+	}
+	if _, err := ExchangeTLS(ctx, "127.0.0.1:0", nil, newQuery(t)); err == nil {
+		t.Fatal("We expected an error with TLS")
+	}
+	_, err := ExchangeHTTPS(ctx, http.DefaultClient, "http://127.0.0.1:0/", newQuery(t))
+	if err == nil {
+		t.Fatal("We expected an error with HTTPS")
+	}
+	_, err = ExchangeHTTPS(ctx, http.DefaultClient, "\t", newQuery(t))
+	if err == nil {
+		t.Fatal("We expected an error with an invalid URL")
+	}
+}
+
+// TestExchangeHTTPSErrors checks whether we deal with HTTP errors.
+func TestExchangeHTTPSErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/404" {
+				w.WriteHeader(404)
+			}
+		},
+	))
+	defer server.Close()
+	ctx := context.Background()
+	_, err := ExchangeHTTPS(ctx, http.DefaultClient, server.URL+"/404", newQuery(t))
+	if err == nil {
+		t.Fatal("We expected an error with a 404 status")
+	}
+	_, err = ExchangeHTTPS(ctx, http.DefaultClient, server.URL, newQuery(t))
+	if err == nil {
+		t.Fatal("We expected an error with the wrong content type")
+	}
+}
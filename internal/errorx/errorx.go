@@ -0,0 +1,184 @@
+// Package errorx contains error extensions.
+//
+// We use this package to map the errors returned by the Go standard
+// library when performing network, DNS, TLS, and HTTP operations to the
+// OONI failure vocabulary, e.g., "connection_refused". This allows to
+// aggregate failures across platforms and implementations, where the
+// original error messages are different. Errors that we do not know how
+// to classify are mapped to "unknown_failure: " followed by the original
+// error message, so no information is lost.
+package errorx
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+)
+
+// The failures we know how to classify.
+const (
+	FailureConnectionRefused    = "connection_refused"
+	FailureConnectionReset      = "connection_reset"
+	FailureDNSNoAnswer          = "dns_no_answer"
+	FailureDNSNXDomain          = "dns_nxdomain_error"
+	FailureDNSServerMisbehaving = "dns_server_misbehaving"
+	FailureEOF                  = "eof_error"
+	FailureGenericTimeout       = "generic_timeout_error"
+	FailureHostUnreachable      = "host_unreachable"
+	FailureHTTPRequestFailed    = "http_request_failed"
+	FailureInterrupted          = "interrupted"
+	FailureNetworkUnreachable   = "network_unreachable"
+	FailureSSLFailedHandshake   = "ssl_failed_handshake"
+	FailureSSLInvalidCert       = "ssl_invalid_certificate"
+	FailureSSLInvalidHostname   = "ssl_invalid_hostname"
+	FailureSSLUnknownAuthority  = "ssl_unknown_authority"
+)
+
+// FailureUnknownPrefix is the prefix of failures we cannot classify.
+const FailureUnknownPrefix = "unknown_failure: "
+
+// knownFailures contains all the failures we know how to classify, so
+// that errors whose message is already a failure are not reclassified.
+var knownFailures = map[string]bool{
+	FailureConnectionRefused:    true,
+	FailureConnectionReset:      true,
+	FailureDNSNoAnswer:          true,
+	FailureDNSNXDomain:          true,
+	FailureDNSServerMisbehaving: true,
+	FailureEOF:                  true,
+	FailureGenericTimeout:       true,
+	FailureHostUnreachable:      true,
+	FailureHTTPRequestFailed:    true,
+	FailureInterrupted:          true,
+	FailureNetworkUnreachable:   true,
+	FailureSSLFailedHandshake:   true,
+	FailureSSLInvalidCert:       true,
+	FailureSSLInvalidHostname:   true,
+	FailureSSLUnknownAuthority:  true,
+}
+
+// Error is an error with its failure. The Error method returns the
+// original error message, while Failure is the classified failure.
+type Error struct {
+	// Err is the original error.
+	Err error
+
+	// Failure is the failure in the OONI failure vocabulary.
+	Failure string
+}
+
+// Error returns the original error message.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the original error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap returns an *Error wrapping err and its failure. It returns nil
+// when err is nil and err itself when err already is an *Error.
+func Wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{Err: err, Failure: Classify(err)}
+}
+
+// Classify returns the failure corresponding to err, or an empty
+// string when err is nil.
+func Classify(err error) string {
+	if err == nil {
+		return ""
+	}
+	if e, ok := err.(*Error); ok {
+		return e.Failure
+	}
+	if err == context.Canceled {
+		return FailureInterrupted
+	}
+	if operr, ok := err.(net.Error); ok && operr.Timeout() {
+		return FailureGenericTimeout
+	}
+	s := err.Error()
+	if knownFailures[s] {
+		return s
+	}
+	switch {
+	case strings.Contains(s, "operation was canceled"),
+		strings.Contains(s, "context canceled"):
+		return FailureInterrupted
+	case strings.Contains(s, "i/o timeout"),
+		strings.Contains(s, "deadline exceeded"),
+		strings.Contains(s, "timed out"):
+		return FailureGenericTimeout
+	case strings.Contains(s, "connection refused"):
+		return FailureConnectionRefused
+	case strings.Contains(s, "connection reset"):
+		return FailureConnectionReset
+	case strings.Contains(s, "no such host"):
+		return FailureDNSNXDomain
+	case strings.Contains(s, "server misbehaving"):
+		return FailureDNSServerMisbehaving
+	case strings.Contains(s, "dns: no answer"),
+		strings.Contains(s, "no answer from DNS server"):
+		return FailureDNSNoAnswer
+	case strings.Contains(s, "network is unreachable"):
+		return FailureNetworkUnreachable
+	case strings.Contains(s, "no route to host"),
+		strings.Contains(s, "host is unreachable"):
+		return FailureHostUnreachable
+	case strings.Contains(s, "certificate is valid for"),
+		strings.Contains(s, "certificate is not valid for any names"):
+		return FailureSSLInvalidHostname
+	case strings.Contains(s, "certificate signed by unknown authority"):
+		return FailureSSLUnknownAuthority
+	case strings.Contains(s, "x509:"):
+		return FailureSSLInvalidCert
+	case strings.Contains(s, "tls:"):
+		return FailureSSLFailedHandshake
+	case err == io.EOF, err == io.ErrUnexpectedEOF,
+		strings.HasSuffix(s, "EOF"):
+		return FailureEOF
+	}
+	return FailureUnknownPrefix + s
+}
+
+// FailureString returns the failure corresponding to err as a string
+// pointer, which is nil when err is nil. This is the type used by the
+// Failure fields of most test keys.
+func FailureString(err error) *string {
+	if err == nil {
+		return nil
+	}
+	s := Classify(err)
+	return &s
+}
+
+// Details returns the original error message of err, which we save
+// alongside the failure because the classification loses information,
+// or an empty string when err is nil.
+func Details(err error) string {
+	if err == nil {
+		return ""
+	}
+	if e, ok := err.(*Error); ok {
+		return Details(e.Err)
+	}
+	return err.Error()
+}
+
+// DetailsString is like Details but returns a string pointer, which is
+// nil when err is nil, like FailureString.
+func DetailsString(err error) *string {
+	if err == nil {
+		return nil
+	}
+	s := Details(err)
+	return &s
+}
@@ -0,0 +1,131 @@
+package errorx
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestClassify checks whether we classify errors.
+func TestClassify(t *testing.T) {
+	table := []struct {
+		err      error
+		expected string
+	}{
+		{err: nil, expected: ""},
+		{err: context.Canceled, expected: FailureInterrupted},
+		{err: context.DeadlineExceeded, expected: FailureGenericTimeout},
+		{err: &net.DNSError{Err: "no such host", Name: "x", IsNotFound: true}, expected: FailureDNSNXDomain},
+		{err: &net.DNSError{Err: "server misbehaving", Name: "x"}, expected: FailureDNSServerMisbehaving},
+		{err: errors.New("dns: no such host"), expected: FailureDNSNXDomain},
+		{err: errors.New("dns: no answer"), expected: FailureDNSNoAnswer},
+		{err: errors.New("read: connection reset by peer"), expected: FailureConnectionReset},
+		{err: errors.New("connect: network is unreachable"), expected: FailureNetworkUnreachable},
+		{err: errors.New("connect: no route to host"), expected: FailureHostUnreachable},
+		{err: errors.New("x509: certificate is valid for a, not b"), expected: FailureSSLInvalidHostname},
+		{err: errors.New("x509: certificate has expired"), expected: FailureSSLInvalidCert},
+		{err: errors.New("remote error: tls: handshake failure"), expected: FailureSSLFailedHandshake},
+		{err: io.EOF, expected: FailureEOF},
+		{err: io.ErrUnexpectedEOF, expected: FailureEOF},
+		{err: fmt.Errorf("Get \"https://x/\": %s", io.EOF), expected: FailureEOF},
+		{err: errors.New(FailureGenericTimeout), expected: FailureGenericTimeout},
+		{err: errors.New("antani"), expected: FailureUnknownPrefix + "antani"},
+		{err: &Error{Err: io.EOF, Failure: FailureConnectionReset}, expected: FailureConnectionReset},
+	}
+	for _, entry := range table {
+		if failure := Classify(entry.err); failure != entry.expected {
+			t.Fatalf("Expected %q for %v, got %q", entry.expected, entry.err, failure)
+		}
+	}
+}
+
+// TestClassifyNetworkErrors checks whether we classify the errors
+// actually returned by the standard library.
+func TestClassifyNetworkErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	_, err = net.Dial("tcp", address)
+	if failure := Classify(err); failure != FailureConnectionRefused {
+		t.Fatalf("Unexpected failure: %s (%v)", failure, err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, _, err = conn.ReadFrom(make([]byte, 1))
+	if failure := Classify(err); failure != FailureGenericTimeout {
+		t.Fatalf("Unexpected failure: %s (%v)", failure, err)
+	}
+}
+
+// TestClassifyTLSErrors checks whether we classify certificate errors.
+func TestClassifyTLSErrors(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	_, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{})
+	if failure := Classify(err); failure != FailureSSLUnknownAuthority {
+		t.Fatalf("Unexpected failure: %s (%v)", failure, err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	_, err = tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{
+		RootCAs: pool, ServerName: "www.example.org",
+	})
+	if failure := Classify(err); failure != FailureSSLInvalidHostname {
+		t.Fatalf("Unexpected failure: %s (%v)", failure, err)
+	}
+}
+
+// TestWrap checks whether Wrap preserves the original error.
+func TestWrap(t *testing.T) {
+	if Wrap(nil) != nil {
+		t.Fatal("Expected nil error")
+	}
+	original := errors.New("read: connection reset by peer")
+	err := Wrap(original)
+	if err.Error() != original.Error() || err.(*Error).Unwrap() != original {
+		t.Fatal("Wrap did not preserve the original error")
+	}
+	if Classify(err) != FailureConnectionReset || Wrap(err) != err {
+		t.Fatal("Unexpected wrapped error")
+	}
+}
+
+// TestFailureString checks whether we return the failure pointer.
+func TestFailureString(t *testing.T) {
+	if FailureString(nil) != nil {
+		t.Fatal("Expected nil failure")
+	}
+	if failure := FailureString(io.EOF); failure == nil || *failure != FailureEOF {
+		t.Fatal("Unexpected failure")
+	}
+}
+
+// TestDetails checks whether we return the original error message.
+func TestDetails(t *testing.T) {
+	if Details(nil) != "" || DetailsString(nil) != nil {
+		t.Fatal("Expected empty details")
+	}
+	original := errors.New("read: connection reset by peer")
+	err := &Error{Err: original, Failure: FailureConnectionReset}
+	if Details(err) != original.Error() {
+		t.Fatal("Unexpected details")
+	}
+	if details := DetailsString(err); details == nil || *details != original.Error() {
+		t.Fatal("Unexpected details pointer")
+	}
+}
@@ -6,11 +6,14 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 
 	"golang.org/x/net/proxy"
 
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/resolver"
 	"github.com/measurement-kit/engine/internal/version"
 )
 
@@ -34,13 +37,29 @@ type Request struct {
 	// Body is the optional request body.
 	Body []byte
 
+	// Headers contains optional headers that we send verbatim, that
+	// is, without canonicalizing their names. When Headers is not nil,
+	// we do not add any implicit User-Agent or Accept-Encoding header
+	// and we do not transparently decompress the response body.
+	Headers http.Header
+
 	// NoFailOnError controls whether an HTTP failure causes
 	// the Perform function to fail or not.
 	NoFailOnError bool
 
+	// Resolver is the optional resolver to use. The default value
+	// (nil) means we use the system resolver. It is ignored when using
+	// SOCKS5ProxyPort, because the proxy resolves names for us.
+	Resolver resolver.Resolver
+
 	// SOCKS5ProxyPort is the optional SOCKS5 proxy port to use. The
 	// default value (zero) means no proxy is used.
 	SOCKS5ProxyPort int
+
+	// Transport is the optional transport to use, e.g., a
+	// netx.HTTPTransport to save the trace of the request. When
+	// set, it takes precedence over SOCKS5ProxyPort.
+	Transport http.RoundTripper
 }
 
 // Response is an HTTP response
@@ -61,6 +80,101 @@ var ioutilReadAll = ioutil.ReadAll
 // proxySOCKS5 allows to mock proxy.SOCKS5 when testing the code.
 var proxySOCKS5 = proxy.SOCKS5
 
+// NewClient returns an HTTP client using the SOCKS5 proxy listening on
+// the specified local port. If the port is zero, no proxy is used. This
+// is useful when you need to stream the response body.
+func NewClient(SOCKS5ProxyPort int) (*http.Client, error) {
+	return newClient(SOCKS5ProxyPort, false)
+}
+
+// noCompressionClient is the client we use when compression is disabled
+// and there is no proxy. We share it, like http.DefaultClient, such that
+// we reuse its idle connections rather than leaking them.
+var noCompressionClient = &http.Client{Transport: &http.Transport{
+	DisableCompression: true,
+	Proxy:              http.ProxyFromEnvironment,
+}}
+
+// newClient is like NewClient but also allows to disable compression.
+func newClient(SOCKS5ProxyPort int, disableCompression bool) (*http.Client, error) {
+	if SOCKS5ProxyPort == 0 {
+		if !disableCompression {
+			return http.DefaultClient, nil
+		}
+		return noCompressionClient, nil
+	}
+	// TODO(bassosimone): for correctness here we MUST make sure that
+	// this proxy implementation does not leak the DNS.
+	endpoint := fmt.Sprintf("127.0.0.1:%d", SOCKS5ProxyPort)
+	dialer, err := proxySOCKS5("tcp", endpoint, nil, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	// We create a new transport for each client, therefore there
+	// is no point in keeping connections alive.
+	return &http.Client{Transport: &http.Transport{
+		Dial:               dialer.Dial,
+		DisableCompression: disableCompression,
+		DisableKeepAlives:  true,
+	}}, nil
+}
+
+// dialContext returns a function resolving the hostname in address using
+// r and connecting to each resolved address until one connect succeeds.
+func dialContext(r resolver.Resolver) func(
+	ctx context.Context, network, address string,
+) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		hostname, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := r.LookupHost(ctx, hostname)
+		if err != nil {
+			return nil, err
+		}
+		var dialer net.Dialer
+		for _, addr := range addrs {
+			var conn net.Conn
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// setRawHeaders adds headers to request without canonicalizing their names.
+func setRawHeaders(request *http.Request, headers http.Header) {
+	for key, values := range headers {
+		request.Header[key] = append(request.Header[key], values...)
+	}
+	// Setting an empty canonical User-Agent prevents the standard
+	// library from adding its own User-Agent header.
+	if _, found := request.Header["User-Agent"]; !found {
+		request.Header["User-Agent"] = []string{}
+	}
+}
+
+// newClient returns the client to perform the request.
+func (r Request) newClient() (*http.Client, error) {
+	if r.Transport != nil {
+		return &http.Client{Transport: r.Transport}, nil
+	}
+	if r.Resolver != nil && r.SOCKS5ProxyPort == 0 {
+		// We create a new transport for each request, therefore
+		// there is no point in keeping connections alive.
+		return &http.Client{Transport: &http.Transport{
+			DialContext:        dialContext(r.Resolver),
+			DisableCompression: r.Headers != nil,
+			DisableKeepAlives:  true,
+			Proxy:              http.ProxyFromEnvironment,
+		}}, nil
+	}
+	return newClient(r.SOCKS5ProxyPort, r.Headers != nil)
+}
+
 func (r Request) perform() (*Response, error) {
 	request, err := http.NewRequest(r.Method, r.URL, bytes.NewReader(r.Body))
 	if err != nil {
@@ -72,19 +186,13 @@ func (r Request) perform() (*Response, error) {
 	if r.UserAgent != "" {
 		request.Header.Set("User-Agent", r.UserAgent)
 	}
+	if r.Headers != nil {
+		setRawHeaders(request, r.Headers)
+	}
 	request = request.WithContext(r.Ctx)
-	var client *http.Client
-	if r.SOCKS5ProxyPort != 0 {
-		// TODO(bassosimone): for correctness here we MUST make sure that
-		// this proxy implementation does not leak the DNS.
-		endpoint := fmt.Sprintf("127.0.0.1:%d", r.SOCKS5ProxyPort)
-		dialer, err := proxySOCKS5("tcp", endpoint, nil, proxy.Direct)
-		if err != nil {
-			return nil, err
-		}
-		client = &http.Client{Transport: &http.Transport{Dial: dialer.Dial}}
-	} else {
-		client = http.DefaultClient
+	client, err := r.newClient()
+	if err != nil {
+		return nil, err
 	}
 	response, err := client.Do(request)
 	if err != nil {
@@ -92,9 +200,12 @@ func (r Request) perform() (*Response, error) {
 	}
 	defer response.Body.Close()
 	if response.StatusCode != 200 && !r.NoFailOnError {
-		return nil, fmt.Errorf(
-			"Request failed with status %d", response.StatusCode,
-		)
+		return nil, &errorx.Error{
+			Err: fmt.Errorf(
+				"Request failed with status %d", response.StatusCode,
+			),
+			Failure: errorx.FailureHTTPRequestFailed,
+		}
 	}
 	data, err := ioutilReadAll(response.Body)
 	if err != nil {
@@ -107,13 +218,18 @@ func (r Request) perform() (*Response, error) {
 	}, nil
 }
 
-// Perform performs an HTTP request and returns the response.
+// Perform performs an HTTP request and returns the response. On failure,
+// the returned error is an *errorx.Error whose Failure classifies the
+// underlying error using the OONI failure vocabulary.
 func (r Request) Perform() (*Response, error) {
 	response, err := r.perform()
 	if err != nil {
-		return nil, fmt.Errorf(
-			"%s %s failed: %s", r.Method, r.URL, err.Error(),
-		)
+		return nil, &errorx.Error{
+			Err: fmt.Errorf(
+				"%s %s failed: %s", r.Method, r.URL, err.Error(),
+			),
+			Failure: errorx.Classify(err),
+		}
 	}
 	return response, nil
 }
@@ -123,11 +239,19 @@ func userAgent() string {
 	return fmt.Sprintf("MKEngine/%s", version.Version)
 }
 
+// Client performs requests with the OONI backends.
+type Client struct {
+	// Resolver is the optional resolver to use. The default value
+	// (nil) means we use the system resolver.
+	Resolver resolver.Resolver
+}
+
 // GET performs a GET request and returns the body.
-func GET(ctx context.Context, URL string) ([]byte, error) {
+func (c Client) GET(ctx context.Context, URL string) ([]byte, error) {
 	response, err := Request{
 		Ctx:       ctx,
 		Method:    "GET",
+		Resolver:  c.Resolver,
 		URL:       URL,
 		UserAgent: userAgent(),
 	}.Perform()
@@ -138,20 +262,21 @@ func GET(ctx context.Context, URL string) ([]byte, error) {
 }
 
 // GETWithBaseURL is like GET but with baseURL and path.
-func GETWithBaseURL(ctx context.Context, baseURL, path string) ([]byte, error) {
+func (c Client) GETWithBaseURL(ctx context.Context, baseURL, path string) ([]byte, error) {
 	URL, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, err
 	}
 	URL.Path = path
-	return GET(ctx, URL.String())
+	return c.GET(ctx, URL.String())
 }
 
 // POST performs a POST request and returns the body.
-func POST(ctx context.Context, URL, contentType string, body []byte) ([]byte, error) {
+func (c Client) POST(ctx context.Context, URL, contentType string, body []byte) ([]byte, error) {
 	response, err := Request{
 		Ctx:         ctx,
 		Method:      "POST",
+		Resolver:    c.Resolver,
 		URL:         URL,
 		Body:        body,
 		ContentType: contentType,
@@ -164,11 +289,33 @@ func POST(ctx context.Context, URL, contentType string, body []byte) ([]byte, er
 }
 
 // POSTWithBaseURL performs a POST with a baseURL.
-func POSTWithBaseURL(ctx context.Context, baseURL, path, contentType string, body []byte) ([]byte, error) {
+func (c Client) POSTWithBaseURL(
+	ctx context.Context, baseURL, path, contentType string, body []byte,
+) ([]byte, error) {
 	URL, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, err
 	}
 	URL.Path = path
-	return POST(ctx, URL.String(), contentType, body)
+	return c.POST(ctx, URL.String(), contentType, body)
+}
+
+// GET is like Client.GET but uses the system resolver.
+func GET(ctx context.Context, URL string) ([]byte, error) {
+	return Client{}.GET(ctx, URL)
+}
+
+// GETWithBaseURL is like Client.GETWithBaseURL but uses the system resolver.
+func GETWithBaseURL(ctx context.Context, baseURL, path string) ([]byte, error) {
+	return Client{}.GETWithBaseURL(ctx, baseURL, path)
+}
+
+// POST is like Client.POST but uses the system resolver.
+func POST(ctx context.Context, URL, contentType string, body []byte) ([]byte, error) {
+	return Client{}.POST(ctx, URL, contentType, body)
+}
+
+// POSTWithBaseURL is like Client.POSTWithBaseURL but uses the system resolver.
+func POSTWithBaseURL(ctx context.Context, baseURL, path, contentType string, body []byte) ([]byte, error) {
+	return Client{}.POSTWithBaseURL(ctx, baseURL, path, contentType, body)
 }
@@ -1,15 +1,23 @@
 package httpx
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"io"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"golang.org/x/net/proxy"
 
 	"github.com/mccutchen/go-httpbin/httpbin"
+	"github.com/measurement-kit/engine/internal/dnsx/dnstest"
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/netx"
+	"github.com/measurement-kit/engine/internal/resolver"
 )
 
 // maxBodySize is the max body size we can request to httpbin
@@ -67,9 +75,66 @@ func TestPerform400(t *testing.T) {
 		if err == nil {
 			t.Fatal("An error was expected")
 		}
+		if errorx.Classify(err) != errorx.FailureHTTPRequestFailed {
+			t.Fatal("Unexpected failure")
+		}
+	})
+}
+
+// TestPerformTransport verifies that httpx.Perform uses the
+// transport and saves the trace when using netx
+func TestPerformTransport(t *testing.T) {
+	withHTTPBin(t, func(baseURL string) {
+		trace := &netx.Trace{}
+		saver := netx.NewSaver(trace, nil)
+		transport, err := netx.NewHTTPTransport(
+			context.Background(), netx.Config{}, saver,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer transport.CloseIdleConnections()
+		response, err := Request{
+			Ctx:             context.Background(),
+			Method:          "GET",
+			URL:             baseURL + "/status/200",
+			SOCKS5ProxyPort: 1,
+			Transport:       transport,
+		}.Perform()
+		saver.Stop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != 200 || len(trace.Requests) != 1 {
+			t.Fatal("Unexpected response or trace")
+		}
+		if len(trace.TCPConnect) != 1 || len(trace.NetworkEvents) <= 0 {
+			t.Fatal("Unexpected trace")
+		}
 	})
 }
 
+// TestPerformConnectionRefused verifies that httpx.Perform classifies
+// the underlying network error while preserving its message
+func TestPerformConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	var r Request
+	r.Ctx = context.Background()
+	r.Method = "GET"
+	r.URL = "http://" + listener.Addr().String() + "/"
+	_, err = r.Perform()
+	if errorx.Classify(err) != errorx.FailureConnectionRefused {
+		t.Fatalf("Unexpected failure: %s", errorx.Classify(err))
+	}
+	if !strings.Contains(err.Error(), "GET "+r.URL+" failed") {
+		t.Fatal("The original message was not preserved")
+	}
+}
+
 // TestPerformReadBodyError verifies that httpx.Perform handles an
 // error while reading the body
 func TestPerformReadBodyError(t *testing.T) {
@@ -113,6 +178,98 @@ func TestPerformProxySOCKS5Error(t *testing.T) {
 	})
 }
 
+// TestNewClient checks whether NewClient only uses a proxy
+// when the proxy port is not zero.
+func TestNewClient(t *testing.T) {
+	client, err := NewClient(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client != http.DefaultClient {
+		t.Fatal("We expected the default client")
+	}
+	client, err = NewClient(9999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client == http.DefaultClient {
+		t.Fatal("We did not expect the default client")
+	}
+}
+
+// TestNewClientDisableCompression checks whether newClient does
+// not use the default client when disabling compression.
+func TestNewClientDisableCompression(t *testing.T) {
+	client, err := newClient(0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client == http.DefaultClient {
+		t.Fatal("We did not expect the default client")
+	}
+	if !client.Transport.(*http.Transport).DisableCompression {
+		t.Fatal("We expected compression to be disabled")
+	}
+	other, err := newClient(0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other != client {
+		t.Fatal("We expected to reuse the same client")
+	}
+}
+
+// TestPerformRawHeaders checks whether we send the headers verbatim
+// and without any implicit header.
+func TestPerformRawHeaders(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	requests := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		var request string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			request += line
+			if line == "\r\n" {
+				break
+			}
+		}
+		requests <- request
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))
+	}()
+	_, err = Request{
+		Ctx:     context.Background(),
+		Method:  "GET",
+		URL:     "http://" + listener.Addr().String() + "/",
+		Headers: http.Header{"aCcEpT": {"*/*"}},
+	}.Perform()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := <-requests
+	if !strings.Contains(request, "\r\naCcEpT: */*\r\n") {
+		t.Fatal("The header was not sent verbatim")
+	}
+	if strings.Contains(request, "User-Agent") {
+		t.Fatal("We sent an implicit User-Agent")
+	}
+	if strings.Contains(request, "Accept-Encoding") {
+		t.Fatal("We sent an implicit Accept-Encoding")
+	}
+}
+
 // TestGETSimple performs a simple httpx.GET test
 func TestGETSimple(t *testing.T) {
 	withHTTPBin(t, func(baseURL string) {
@@ -204,3 +361,34 @@ func TestPOSTWithBaseURLError(t *testing.T) {
 		}
 	})
 }
+
+// TestClientResolver checks whether Client uses the configured
+// resolver rather than the system resolver.
+func TestClientResolver(t *testing.T) {
+	server, err := dnstest.NewUDPServer(map[string][]string{
+		"backend.example.com": {"127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := Client{Resolver: resolver.UDP{Address: server.Addr}}
+	withHTTPBin(t, func(baseURL string) {
+		ctx := context.Background()
+		baseURL = strings.Replace(baseURL, "127.0.0.1", "backend.example.com", 1)
+		if _, err := client.GETWithBaseURL(ctx, baseURL, "/status/200"); err != nil {
+			t.Fatal(err)
+		}
+		_, err := client.POSTWithBaseURL(
+			ctx, baseURL, "/status/200", "text/plain", []byte("1234"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		baseURL = strings.Replace(baseURL, "backend", "antani", 1)
+		_, err = client.GETWithBaseURL(ctx, baseURL, "/status/200")
+		if errorx.Classify(err) != errorx.FailureDNSNXDomain {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package bridgereachability
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// ErrInvalidBridgeLine indicates that a bridge line is not valid.
+var ErrInvalidBridgeLine = errors.New("invalid bridge line")
+
+// Bridge is a parsed bridge line, e.g., "obfs4 192.0.2.1:443
+// 0123456789ABCDEF0123456789ABCDEF01234567 cert=... iat-mode=0".
+type Bridge struct {
+	// Address is the "host:port" address of the bridge.
+	Address string
+
+	// Fingerprint is the optional bridge fingerprint.
+	Fingerprint string
+
+	// Params contains the transport parameters.
+	Params map[string]string
+
+	// Transport is the pluggable transport name, or "vanilla"
+	// when the bridge line does not specify any transport.
+	Transport string
+}
+
+// ParseBridgeLine parses a bridge line in the format used by the tor
+// Bridge config option, optionally prefixed by "Bridge".
+func ParseBridgeLine(line string) (*Bridge, error) {
+	fields := strings.Fields(line)
+	if len(fields) > 0 && fields[0] == "Bridge" {
+		fields = fields[1:]
+	}
+	bridge := &Bridge{Params: make(map[string]string), Transport: "vanilla"}
+	if len(fields) > 0 && !strings.Contains(fields[0], ":") {
+		bridge.Transport, fields = fields[0], fields[1:]
+	}
+	if len(fields) <= 0 {
+		return nil, ErrInvalidBridgeLine
+	}
+	if _, _, err := net.SplitHostPort(fields[0]); err != nil {
+		return nil, ErrInvalidBridgeLine
+	}
+	bridge.Address, fields = fields[0], fields[1:]
+	if len(fields) > 0 && !strings.Contains(fields[0], "=") {
+		bridge.Fingerprint, fields = fields[0], fields[1:]
+	}
+	for _, field := range fields {
+		index := strings.Index(field, "=")
+		if index <= 0 {
+			return nil, ErrInvalidBridgeLine
+		}
+		bridge.Params[field[:index]] = field[index+1:]
+	}
+	return bridge, nil
+}
@@ -0,0 +1,41 @@
+package bridgereachability
+
+import "testing"
+
+// TestParseBridgeLine checks whether we parse bridge lines.
+func TestParseBridgeLine(t *testing.T) {
+	bridge, err := ParseBridgeLine(
+		"Bridge obfs4 192.0.2.1:443 0123456789ABCDEF0123456789ABCDEF01234567 cert=AA== iat-mode=0",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bridge.Transport != "obfs4" || bridge.Address != "192.0.2.1:443" {
+		t.Fatalf("Unexpected bridge: %+v", bridge)
+	}
+	if bridge.Fingerprint != "0123456789ABCDEF0123456789ABCDEF01234567" {
+		t.Fatal("Unexpected fingerprint")
+	}
+	if len(bridge.Params) != 2 || bridge.Params["cert"] != "AA==" || bridge.Params["iat-mode"] != "0" {
+		t.Fatalf("Unexpected params: %+v", bridge.Params)
+	}
+	bridge, err = ParseBridgeLine("[2001:db8::1]:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bridge.Transport != "vanilla" || bridge.Address != "[2001:db8::1]:9001" || bridge.Fingerprint != "" {
+		t.Fatalf("Unexpected bridge: %+v", bridge)
+	}
+}
+
+// TestParseBridgeLineErrors checks whether we reject invalid bridge lines.
+func TestParseBridgeLineErrors(t *testing.T) {
+	for _, line := range []string{
+		"", "Bridge", "obfs4", "obfs4 192.0.2.1", "obfs4 192.0.2.1:443 FP =x",
+		"obfs4 192.0.2.1:443 FP cert",
+	} {
+		if _, err := ParseBridgeLine(line); err != ErrInvalidBridgeLine {
+			t.Fatalf("Expected ErrInvalidBridgeLine with %q", line)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Package bridgereachability implements the bridge_reachability nettest.
+//
+// The input of each measurement is a tor bridge line. When the input is
+// empty, we measure all the bridge lines distributed by the bouncer as
+// "tor-bridges" test helpers. For each bridge we attempt a TCP connect
+// and, depending on the transport, a handshake: the obfs4 handshake for
+// obfs4 bridges and the TLS handshake with the front for meek bridges.
+package bridgereachability
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/nettest/bridgereachability/obfs4"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+)
+
+// TestHelperName is the name of the test helpers containing the
+// bridge lines to measure when the input is empty.
+const TestHelperName = "tor-bridges"
+
+// connectTimeout is the timeout for each TCP connect.
+const connectTimeout = 10 * time.Second
+
+// handshakeTimeout is the timeout for each handshake. It is a variable
+// such that we can make it shorter in tests.
+var handshakeTimeout = 10 * time.Second
+
+// rootCAs allows to use custom root CAs in tests. We use the system
+// root CAs when it is nil.
+var rootCAs *x509.CertPool
+
+// ErrNoBridges indicates that there are no bridges to measure.
+var ErrNoBridges = errors.New("no bridges to measure")
+
+// ErrUnsupportedTransport indicates that we do not know how to
+// measure the bridge transport.
+var ErrUnsupportedTransport = errors.New("unsupported transport")
+
+// The outcomes of measuring a bridge.
+const (
+	OutcomeConnectFailed        = "connect_failed"
+	OutcomeHandshakeFailed      = "handshake_failed"
+	OutcomeInvalidBridgeLine    = "invalid_bridge_line"
+	OutcomeReachable            = "reachable"
+	OutcomeUnsupportedTransport = "unsupported_transport"
+)
+
+// Result contains the result of measuring a bridge.
+type Result struct {
+	// Address is the "host:port" endpoint we connected to, which, for
+	// meek bridges, is the endpoint of the front.
+	Address string `json:"address"`
+
+	// BridgeLine is the bridge line we measured.
+	BridgeLine string `json:"bridge_line"`
+
+	// ConnectTime is the number of seconds it took to connect.
+	ConnectTime float64 `json:"connect_time"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// HandshakeTime is the number of seconds it took to complete the
+	// handshake. It is zero for vanilla bridges.
+	HandshakeTime float64 `json:"handshake_time"`
+
+	// Outcome is one of "reachable", "connect_failed", "handshake_failed",
+	// "invalid_bridge_line", and "unsupported_transport".
+	Outcome string `json:"outcome"`
+
+	// Transport is the bridge transport, e.g., "obfs4".
+	Transport string `json:"transport"`
+}
+
+// TestKeys contains the bridge_reachability test keys.
+type TestKeys struct {
+	// Bridges contains the result of measuring each bridge.
+	Bridges []Result `json:"bridges"`
+
+	// Failure is the failure that prevented the measurement, if any.
+	Failure *string `json:"failure"`
+
+	// FailureDetails is the original error message, if any.
+	FailureDetails *string `json:"failure_details"`
+}
+
+// handshakeFunc performs the transport handshake using conn.
+type handshakeFunc func(conn net.Conn) error
+
+// prepare returns the endpoint to connect to and the handshake to
+// perform, which is nil if we only need to connect.
+func prepare(bridge *Bridge) (string, handshakeFunc, error) {
+	switch bridge.Transport {
+	case "vanilla":
+		return bridge.Address, nil, nil
+	case "obfs4":
+		cert, err := obfs4.ParseCert(bridge.Params["cert"])
+		if err != nil {
+			return "", nil, err
+		}
+		return bridge.Address, func(conn net.Conn) error {
+			return obfs4.Handshake(conn, cert)
+		}, nil
+	case "meek", "meek_lite":
+		URL, err := url.Parse(bridge.Params["url"])
+		if err != nil || URL.Scheme != "https" || URL.Hostname() == "" {
+			return "", nil, ErrInvalidBridgeLine
+		}
+		front := bridge.Params["front"]
+		if front == "" {
+			front = URL.Hostname()
+		}
+		endpoint := front
+		if _, _, err := net.SplitHostPort(front); err != nil {
+			endpoint = net.JoinHostPort(front, "443")
+		}
+		host, _, _ := net.SplitHostPort(endpoint)
+		return endpoint, func(conn net.Conn) error {
+			return tls.Client(conn, &tls.Config{
+				RootCAs:    rootCAs,
+				ServerName: host,
+			}).Handshake()
+		}, nil
+	}
+	return "", nil, ErrUnsupportedTransport
+}
+
+// measure measures the bridge in line.
+func measure(ctx context.Context, line string) Result {
+	result := Result{BridgeLine: line}
+	bridge, err := ParseBridgeLine(line)
+	if err != nil {
+		result.Failure = errorx.FailureString(err)
+		result.Outcome = OutcomeInvalidBridgeLine
+		return result
+	}
+	result.Transport = bridge.Transport
+	endpoint, handshake, err := prepare(bridge)
+	if err == ErrUnsupportedTransport {
+		result.Failure = errorx.FailureString(err)
+		result.Outcome = OutcomeUnsupportedTransport
+		return result
+	}
+	if err != nil {
+		result.Failure = errorx.FailureString(err)
+		result.Outcome = OutcomeInvalidBridgeLine
+		return result
+	}
+	result.Address = endpoint
+	dialer := net.Dialer{Timeout: connectTimeout}
+	begin := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", endpoint)
+	result.ConnectTime = time.Now().Sub(begin).Seconds()
+	if err != nil {
+		result.Failure = errorx.FailureString(err)
+		result.Outcome = OutcomeConnectFailed
+		return result
+	}
+	defer conn.Close()
+	if handshake != nil {
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+		begin = time.Now()
+		err = handshake(conn)
+		result.HandshakeTime = time.Now().Sub(begin).Seconds()
+		if err != nil {
+			result.Failure = errorx.FailureString(err)
+			result.Outcome = OutcomeHandshakeFailed
+			return result
+		}
+	}
+	result.Outcome = OutcomeReachable
+	return result
+}
+
+// bridgesFromHelpers returns the bridge lines in helpers.
+func bridgesFromHelpers(helpers map[string][]model.Service) []string {
+	var lines []string
+	for _, e := range helpers[TestHelperName] {
+		lines = append(lines, e.Address)
+	}
+	return lines
+}
+
+// Measure runs bridge_reachability for the bridge lines. It returns
+// the test keys. For each bridge we emit a "bridge_reachability.bridge"
+// event containing its Result.
+func Measure(ctx context.Context, lines []string, out chan<- model.Event) *TestKeys {
+	tk := &TestKeys{}
+	if len(lines) <= 0 {
+		tk.Failure = errorx.FailureString(ErrNoBridges)
+		tk.FailureDetails = errorx.DetailsString(ErrNoBridges)
+		out <- model.NewFailureMeasurementEvent(0, ErrNoBridges)
+		return tk
+	}
+	for _, line := range lines {
+		result := measure(ctx, line)
+		tk.Bridges = append(tk.Bridges, result)
+		out <- model.Event{
+			Key:   "bridge_reachability.bridge",
+			Value: result,
+		}
+	}
+	return tk
+}
+
+// NewNettest creates a new bridge_reachability nettest. The input of
+// each measurement is the bridge line to measure. If the input is empty
+// we measure all the "tor-bridges" test helpers.
+func NewNettest() *nettest.Nettest {
+	nt := &nettest.Nettest{
+		TestName:        "bridge_reachability",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+	}
+	nt.Main = func(
+		ctx context.Context,
+		input string,
+		measurement *model.Measurement,
+		out chan<- model.Event,
+	) {
+		lines := []string{input}
+		if input == "" {
+			lines = bridgesFromHelpers(nt.AvailableTestHelpers)
+		}
+		measurement.TestKeys = Measure(ctx, lines, out)
+	}
+	return nt
+}
@@ -0,0 +1,194 @@
+package bridgereachability
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest/bridgereachability/obfs4/obfs4test"
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// run runs Measure and returns the test keys.
+func run(lines []string) *TestKeys {
+	var tk *TestKeys
+	nettesttest.Drain(func(out chan<- model.Event) {
+		tk = Measure(context.Background(), lines, out)
+	})
+	return tk
+}
+
+// measureOne measures line and returns its result.
+func measureOne(t *testing.T, line string) Result {
+	tk := run([]string{line})
+	if tk.Failure != nil || len(tk.Bridges) != 1 {
+		t.Fatal("Unexpected test keys")
+	}
+	if tk.Bridges[0].BridgeLine != line {
+		t.Fatal("Unexpected bridge line")
+	}
+	return tk.Bridges[0]
+}
+
+// closedAddress returns the address of a closed port.
+func closedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	return listener.Addr().String()
+}
+
+// TestMeasureObfs4 checks whether we can handshake with obfs4 bridges.
+func TestMeasureObfs4(t *testing.T) {
+	server, err := obfs4test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	result := measureOne(t, "obfs4 "+server.Addr+" FP cert="+server.Cert.String()+" iat-mode=0")
+	if result.Outcome != OutcomeReachable || result.Failure != nil {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+	if result.Transport != "obfs4" || result.Address != server.Addr || result.HandshakeTime <= 0 {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+}
+
+// TestMeasureObfs4WrongCert checks whether we detect handshake failures.
+func TestMeasureObfs4WrongCert(t *testing.T) {
+	server, err := obfs4test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	other, err := obfs4test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	savedTimeout := handshakeTimeout
+	handshakeTimeout = 500 * time.Millisecond
+	defer func() { handshakeTimeout = savedTimeout }()
+	result := measureOne(t, "obfs4 "+server.Addr+" cert="+other.Cert.String())
+	if result.Outcome != OutcomeHandshakeFailed || result.Failure == nil {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+}
+
+// TestMeasureVanilla checks whether we can connect to vanilla bridges.
+func TestMeasureVanilla(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	result := measureOne(t, listener.Addr().String())
+	if result.Outcome != OutcomeReachable || result.Transport != "vanilla" {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+	result = measureOne(t, closedAddress(t))
+	if result.Outcome != OutcomeConnectFailed || result.Failure == nil {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+}
+
+// TestMeasureMeek checks whether we can handshake with the meek front.
+func TestMeasureMeek(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	front := strings.TrimPrefix(server.URL, "https://")
+	line := "meek_lite 192.0.2.2:2 FP url=https://meek.example.com/ front=" + front
+	result := measureOne(t, line)
+	if result.Outcome != OutcomeHandshakeFailed || result.Address != front {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+	savedRootCAs := rootCAs
+	rootCAs = x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+	defer func() { rootCAs = savedRootCAs }()
+	result = measureOne(t, line)
+	if result.Outcome != OutcomeReachable || result.Transport != "meek_lite" {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+}
+
+// TestPrepareMeekDefaults checks whether we use the URL host and port
+// 443 when the meek front does not specify them.
+func TestPrepareMeekDefaults(t *testing.T) {
+	table := map[string]string{
+		"meek 0.0.2.0:3 url=https://meek.example.com/":                       "meek.example.com:443",
+		"meek 0.0.2.0:3 url=https://meek.example.com/ front=www.example.com": "www.example.com:443",
+	}
+	for line, expected := range table {
+		bridge, err := ParseBridgeLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoint, handshake, err := prepare(bridge)
+		if err != nil || endpoint != expected || handshake == nil {
+			t.Fatalf("Unexpected endpoint for %q: %s", line, endpoint)
+		}
+	}
+}
+
+// TestMeasureInvalid checks whether we deal with invalid bridges.
+func TestMeasureInvalid(t *testing.T) {
+	table := map[string]string{
+		"obfs4":                                  OutcomeInvalidBridgeLine,
+		"obfs4 192.0.2.1:443 cert=antani":        OutcomeInvalidBridgeLine,
+		"meek_lite 192.0.2.2:2 url=http://x/":    OutcomeInvalidBridgeLine,
+		"snowflake 192.0.2.3:1 FP":               OutcomeUnsupportedTransport,
+		"meek_lite 192.0.2.2:2 url=https://%zz/": OutcomeInvalidBridgeLine,
+	}
+	for line, outcome := range table {
+		result := measureOne(t, line)
+		if result.Outcome != outcome || result.Failure == nil {
+			t.Fatalf("Unexpected result for %q: %+v", line, result)
+		}
+	}
+}
+
+// TestMeasureNoBridges checks whether we deal with no bridges.
+func TestMeasureNoBridges(t *testing.T) {
+	tk := run(nil)
+	if tk.Failure == nil || *tk.Failure != errorx.Classify(ErrNoBridges) {
+		t.Fatal("We expected ErrNoBridges")
+	}
+}
+
+// TestNewNettest checks whether we use the test helpers when
+// the input is empty and the input otherwise.
+func TestNewNettest(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	nt := NewNettest()
+	nt.AvailableTestHelpers = map[string][]model.Service{
+		TestHelperName: {
+			{Address: listener.Addr().String(), Type: "bridge"},
+			{Address: closedAddress(t), Type: "bridge"},
+		},
+	}
+	measurement := nettesttest.Run(nt, "")
+	tk := measurement.TestKeys.(*TestKeys)
+	if len(tk.Bridges) != 2 || tk.Bridges[0].Outcome != OutcomeReachable ||
+		tk.Bridges[1].Outcome != OutcomeConnectFailed {
+		t.Fatal("Unexpected result")
+	}
+	measurement = nettesttest.Run(nt, listener.Addr().String())
+	tk = measurement.TestKeys.(*TestKeys)
+	if len(tk.Bridges) != 1 || tk.Bridges[0].Outcome != OutcomeReachable {
+		t.Fatal("Unexpected result")
+	}
+}
@@ -0,0 +1,133 @@
+package obfs4
+
+import (
+	"crypto/rand"
+	"math/big"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// KeyLength is the length of keys and representatives.
+const KeyLength = 32
+
+var (
+	// fieldPrime is 2^255 - 19.
+	fieldPrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+	// halfFieldPrime is (p - 1) / 2.
+	halfFieldPrime = new(big.Int).Rsh(fieldPrime, 1)
+
+	// curveA is the A parameter of curve25519.
+	curveA = big.NewInt(486662)
+
+	// two is the non-square used by Elligator2.
+	two = big.NewInt(2)
+)
+
+// Keypair is a curve25519 keypair whose public key can be encoded
+// as a uniformly random looking representative using Elligator2.
+type Keypair struct {
+	// Private is the private key.
+	Private [KeyLength]byte
+
+	// Public is the public key.
+	Public [KeyLength]byte
+
+	// Representative is the Elligator2 representative of Public.
+	Representative [KeyLength]byte
+}
+
+// decode decodes a little endian field element.
+func decode(b [KeyLength]byte) *big.Int {
+	var be [KeyLength]byte
+	for i := range b {
+		be[KeyLength-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be[:])
+}
+
+// encode encodes a field element as little endian.
+func encode(n *big.Int) [KeyLength]byte {
+	var b [KeyLength]byte
+	be := n.Bytes()
+	for i := range be {
+		b[i] = be[len(be)-1-i]
+	}
+	return b
+}
+
+// representative returns the Elligator2 representative of the public
+// key u, or false if u cannot be represented. This happens for about
+// half of the public keys.
+func representative(public [KeyLength]byte) ([KeyLength]byte, bool) {
+	u := decode(public)
+	uPlusA := new(big.Int).Add(u, curveA)
+	// The representative exists iff -2u(u+A) is a square.
+	t := new(big.Int).Mul(u, uPlusA)
+	t.Mul(t, two)
+	t.Neg(t)
+	t.Mod(t, fieldPrime)
+	if u.Sign() == 0 || big.Jacobi(t, fieldPrime) != 1 {
+		return [KeyLength]byte{}, false
+	}
+	// r = sqrt(-u / (2(u+A)))
+	denominator := new(big.Int).Mul(two, uPlusA)
+	denominator.ModInverse(denominator, fieldPrime)
+	r := new(big.Int).Neg(u)
+	r.Mul(r, denominator)
+	r.Mod(r, fieldPrime)
+	if r.ModSqrt(r, fieldPrime) == nil {
+		return [KeyLength]byte{}, false
+	}
+	if r.Cmp(halfFieldPrime) > 0 {
+		r.Sub(fieldPrime, r)
+	}
+	return encode(r), true
+}
+
+// RepresentativeToPublic maps a representative to the public key
+// it represents using Elligator2.
+func RepresentativeToPublic(repr [KeyLength]byte) [KeyLength]byte {
+	repr[KeyLength-1] &= 0x3f // the two high bits are not used
+	r := decode(repr)
+	// v = -A / (1 + 2r^2)
+	v := new(big.Int).Mul(r, r)
+	v.Mul(v, two)
+	v.Add(v, big.NewInt(1))
+	v.Mod(v, fieldPrime)
+	if v.ModInverse(v, fieldPrime) == nil {
+		v.SetInt64(0)
+	}
+	v.Mul(v, curveA)
+	v.Neg(v)
+	v.Mod(v, fieldPrime)
+	// If v^3 + Av^2 + v is not a square, u = -v - A, otherwise u = v.
+	f := new(big.Int).Add(v, curveA)
+	f.Mul(f, v)
+	f.Add(f, big.NewInt(1))
+	f.Mul(f, v)
+	f.Mod(f, fieldPrime)
+	if f.Sign() != 0 && big.Jacobi(f, fieldPrime) != 1 {
+		v.Add(v, curveA)
+		v.Neg(v)
+		v.Mod(v, fieldPrime)
+	}
+	return encode(v)
+}
+
+// NewKeypair generates a new keypair whose public key has
+// an Elligator2 representative.
+func NewKeypair() (*Keypair, error) {
+	kp := &Keypair{}
+	for {
+		if _, err := rand.Read(kp.Private[:]); err != nil {
+			return nil, err
+		}
+		curve25519.ScalarBaseMult(&kp.Public, &kp.Private)
+		repr, ok := representative(kp.Public)
+		if ok {
+			kp.Representative = repr
+			return kp, nil
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Package obfs4 implements the obfs4 handshake.
+//
+// See https://gitlab.com/yawning/obfs4/blob/master/doc/obfs4-spec.txt
+// for the specification. We only implement the handshake, which is
+// what we need to know whether an obfs4 bridge is reachable, and not
+// the framing used to exchange data after the handshake.
+package obfs4
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// NodeIDLength is the length of the bridge node ID.
+const NodeIDLength = 20
+
+const (
+	markLength               = sha256.Size / 2
+	macLength                = sha256.Size / 2
+	authLength               = sha256.Size
+	maxHandshakeLength       = 8192
+	clientMinHandshakeLength = KeyLength + markLength + macLength
+	serverMinHandshakeLength = KeyLength + authLength + markLength + macLength
+	inlineSeedFrameLength    = 45
+	clientMinPadLength       = serverMinHandshakeLength + inlineSeedFrameLength - clientMinHandshakeLength
+	clientMaxPadLength       = maxHandshakeLength - clientMinHandshakeLength
+	serverMaxPadLength       = maxHandshakeLength - (serverMinHandshakeLength + inlineSeedFrameLength)
+)
+
+// protoID is the ntor protocol identifier.
+const protoID = "ntor-curve25519-sha256-1"
+
+var (
+	// ErrInvalidCert indicates that the bridge cert is not valid.
+	ErrInvalidCert = errors.New("obfs4: invalid cert")
+
+	// ErrInvalidHandshake indicates that the peer handshake is not valid.
+	ErrInvalidHandshake = errors.New("obfs4: invalid handshake")
+
+	// ErrInvalidAuth indicates that the server failed to prove that
+	// it knows the bridge private key.
+	ErrInvalidAuth = errors.New("obfs4: invalid server auth")
+)
+
+// Cert contains the bridge identity contained in the cert parameter
+// of obfs4 bridge lines.
+type Cert struct {
+	// NodeID is the bridge node ID.
+	NodeID [NodeIDLength]byte
+
+	// PublicKey is the bridge ntor public key.
+	PublicKey [KeyLength]byte
+}
+
+// ParseCert parses the cert parameter of a bridge line.
+func ParseCert(s string) (*Cert, error) {
+	data, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil || len(data) != NodeIDLength+KeyLength {
+		return nil, ErrInvalidCert
+	}
+	cert := &Cert{}
+	copy(cert.NodeID[:], data[:NodeIDLength])
+	copy(cert.PublicKey[:], data[NodeIDLength:])
+	return cert, nil
+}
+
+// String returns the cert parameter corresponding to cert.
+func (cert *Cert) String() string {
+	return base64.RawStdEncoding.EncodeToString(
+		append(cert.NodeID[:], cert.PublicKey[:]...),
+	)
+}
+
+// hmacKey returns the key used to compute marks and MACs.
+func (cert *Cert) hmacKey() []byte {
+	return append(cert.PublicKey[:], cert.NodeID[:]...)
+}
+
+// sum computes the HMAC-SHA256 of data using key.
+func sum(key []byte, data ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// timeNow allows to mock time.Now in tests.
+var timeNow = time.Now
+
+// epochHour returns the hours since the Unix epoch plus delta.
+func epochHour(delta int64) []byte {
+	return []byte(strconv.FormatInt(timeNow().Unix()/3600+delta, 10))
+}
+
+// exp computes the curve25519 shared secret and returns false if it
+// is all zeros, which happens with small order points.
+func exp(private, public [KeyLength]byte) ([]byte, bool) {
+	var secret [KeyLength]byte
+	curve25519.ScalarMult(&secret, &private, &public)
+	return secret[:], secret != [KeyLength]byte{}
+}
+
+// auth computes the ntor AUTH given secret_input, i.e., the two
+// shared secrets, and the public keys.
+func auth(secretInput []byte, cert *Cert, x, y [KeyLength]byte) []byte {
+	// Note that obfs4 writes B twice and ID after PROTOID, hence the
+	// suffix differs from the one in the ntor specification.
+	var suffix bytes.Buffer
+	suffix.Write(cert.PublicKey[:])
+	suffix.Write(cert.PublicKey[:])
+	suffix.Write(x[:])
+	suffix.Write(y[:])
+	suffix.WriteString(protoID)
+	suffix.Write(cert.NodeID[:])
+	verify := sum([]byte(protoID+":key_verify"), secretInput, suffix.Bytes())
+	return sum([]byte(protoID+":mac"), verify, suffix.Bytes(), []byte("Server"))
+}
+
+// randomBytes returns a random slice whose length is between min and max.
+func randomBytes(min, max int) ([]byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max-min+1)))
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, min+int(n.Int64()))
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// newMessage returns repr | padding | mark | MAC, where the mark is
+// the HMAC of repr and the MAC also covers the epoch hour.
+func newMessage(
+	key []byte, repr [KeyLength]byte, prefix []byte, minPad, maxPad int,
+	hour []byte,
+) ([]byte, error) {
+	padding, err := randomBytes(minPad, maxPad)
+	if err != nil {
+		return nil, err
+	}
+	var message bytes.Buffer
+	message.Write(repr[:])
+	message.Write(prefix)
+	message.Write(padding)
+	message.Write(sum(key, repr[:])[:markLength])
+	message.Write(sum(key, message.Bytes(), hour)[:macLength])
+	return message.Bytes(), nil
+}
+
+// readMessage reads from r until it finds the mark of the representative
+// at the beginning of the message, starting from offset, and a valid MAC
+// computed using one of the epoch hours. It returns the message without
+// the mark and the MAC, and the epoch hour used to compute the MAC.
+func readMessage(
+	r io.Reader, key []byte, offset int, hours [][]byte,
+) ([]byte, []byte, error) {
+	var message []byte
+	buffer := make([]byte, maxHandshakeLength)
+	for len(message) < maxHandshakeLength {
+		n, err := r.Read(buffer[:maxHandshakeLength-len(message)])
+		if err != nil {
+			return nil, nil, err
+		}
+		message = append(message, buffer[:n]...)
+		if len(message) < offset+markLength+macLength {
+			continue
+		}
+		mark := sum(key, message[:KeyLength])[:markLength]
+		pos := bytes.Index(message[offset:], mark)
+		if pos < 0 {
+			continue
+		}
+		pos += offset
+		if len(message) < pos+markLength+macLength {
+			continue
+		}
+		received := message[pos+markLength : pos+markLength+macLength]
+		for _, hour := range hours {
+			expected := sum(key, message[:pos+markLength], hour)[:macLength]
+			if hmac.Equal(expected, received) {
+				return message[:pos], hour, nil
+			}
+		}
+		return nil, nil, ErrInvalidHandshake
+	}
+	return nil, nil, ErrInvalidHandshake
+}
+
+// Handshake performs the client side of the obfs4 handshake with the
+// bridge identified by cert using conn. It returns nil if the bridge
+// proved that it knows the private key corresponding to cert.
+func Handshake(conn io.ReadWriter, cert *Cert) error {
+	kp, err := NewKeypair()
+	if err != nil {
+		return err
+	}
+	// The server computes the MAC of its reply using the epoch hour we
+	// used, hence we must not recompute it when the reply arrives.
+	key, hour := cert.hmacKey(), epochHour(0)
+	message, err := newMessage(
+		key, kp.Representative, nil, clientMinPadLength, clientMaxPadLength, hour,
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(message); err != nil {
+		return err
+	}
+	reply, _, err := readMessage(conn, key, KeyLength+authLength, [][]byte{hour})
+	if err != nil {
+		return err
+	}
+	var serverRepr [KeyLength]byte
+	copy(serverRepr[:], reply[:KeyLength])
+	y := RepresentativeToPublic(serverRepr)
+	secretYx, ok1 := exp(kp.Private, y)
+	secretBx, ok2 := exp(kp.Private, cert.PublicKey)
+	if !ok1 || !ok2 {
+		return ErrInvalidHandshake
+	}
+	expected := auth(append(secretYx, secretBx...), cert, kp.Public, y)
+	if !hmac.Equal(expected, reply[KeyLength:KeyLength+authLength]) {
+		return ErrInvalidAuth
+	}
+	return nil
+}
+
+// ServerHandshake performs the server side of the obfs4 handshake
+// using conn. The private key corresponds to the cert public key. This
+// is useful to implement a stand-in bridge for testing.
+func ServerHandshake(conn io.ReadWriter, cert *Cert, private [KeyLength]byte) error {
+	key := cert.hmacKey()
+	request, hour, err := readMessage(
+		conn, key, KeyLength+clientMinPadLength,
+		[][]byte{epochHour(-1), epochHour(0), epochHour(1)},
+	)
+	if err != nil {
+		return err
+	}
+	var clientRepr [KeyLength]byte
+	copy(clientRepr[:], request[:KeyLength])
+	x := RepresentativeToPublic(clientRepr)
+	kp, err := NewKeypair()
+	if err != nil {
+		return err
+	}
+	secretXy, ok1 := exp(kp.Private, x)
+	secretXb, ok2 := exp(private, x)
+	if !ok1 || !ok2 {
+		return ErrInvalidHandshake
+	}
+	serverAuth := auth(append(secretXy, secretXb...), cert, x, kp.Public)
+	message, err := newMessage(
+		key, kp.Representative, serverAuth, 0, serverMaxPadLength, hour,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(message)
+	return err
+}
@@ -0,0 +1,173 @@
+package obfs4
+
+import (
+	"crypto/rand"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCert returns a new cert and the corresponding private key.
+func newCert(t *testing.T) (*Cert, [KeyLength]byte) {
+	kp, err := NewKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &Cert{PublicKey: kp.Public}
+	if _, err := rand.Read(cert.NodeID[:]); err != nil {
+		t.Fatal(err)
+	}
+	return cert, kp.Private
+}
+
+// TestElligator checks whether representatives map back to public keys.
+func TestElligator(t *testing.T) {
+	for i := 0; i < 64; i++ {
+		kp, err := NewKeypair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kp.Representative[KeyLength-1]&0xc0 != 0 {
+			t.Fatal("The representative uses the high bits")
+		}
+		if RepresentativeToPublic(kp.Representative) != kp.Public {
+			t.Fatal("The representative does not map to the public key")
+		}
+		repr := kp.Representative
+		repr[KeyLength-1] |= 0xc0
+		if RepresentativeToPublic(repr) != kp.Public {
+			t.Fatal("The high bits of the representative are not ignored")
+		}
+	}
+}
+
+// TestRepresentativeZero checks whether we deal with the zero point.
+func TestRepresentativeZero(t *testing.T) {
+	if _, ok := representative([KeyLength]byte{}); ok {
+		t.Fatal("We did not expect a representative for zero")
+	}
+	if RepresentativeToPublic([KeyLength]byte{}) != ([KeyLength]byte{}) {
+		t.Fatal("Unexpected public key for the zero representative")
+	}
+}
+
+// TestCert checks whether we can parse and serialize certs.
+func TestCert(t *testing.T) {
+	const s = "qUVQ0srL1JI/vO6V6m/24anYXiJD3QP2HgzUKQtQ7GRqqUvs7P+tG43RtAqdhLOALP7DJQ"
+	cert, err := ParseCert(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.String() != s {
+		t.Fatal("Unexpected serialized cert")
+	}
+	for _, invalid := range []string{"", "antani", s + "AA", s[:len(s)-1] + "!"} {
+		if _, err := ParseCert(invalid); err != ErrInvalidCert {
+			t.Fatalf("Expected ErrInvalidCert with %q", invalid)
+		}
+	}
+}
+
+// handshake runs the client and the server handshakes and returns
+// the client and the server errors.
+func handshake(t *testing.T, client *Cert, server *Cert, private [KeyLength]byte) (error, error) {
+	clientConn, serverConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		defer serverConn.Close()
+		done <- ServerHandshake(serverConn, server, private)
+	}()
+	// Like a real bridge, the server keeps reading when it cannot find
+	// the mark, hence we need a deadline to fail the client handshake.
+	clientConn.SetDeadline(time.Now().Add(time.Second))
+	err := Handshake(clientConn, client)
+	clientConn.Close()
+	return err, <-done
+}
+
+// TestHandshake checks whether the handshake works.
+func TestHandshake(t *testing.T) {
+	cert, private := newCert(t)
+	clientErr, serverErr := handshake(t, cert, cert, private)
+	if clientErr != nil || serverErr != nil {
+		t.Fatal(clientErr, serverErr)
+	}
+}
+
+// TestHandshakeHourBoundary checks whether the handshake works when the
+// epoch hour changes after the client has sent its message.
+func TestHandshakeHourBoundary(t *testing.T) {
+	savedFunc := timeNow
+	defer func() { timeNow = savedFunc }()
+	begin := time.Date(2019, 5, 13, 10, 59, 59, 0, time.UTC)
+	var calls int32
+	timeNow = func() time.Time {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return begin // the client message
+		}
+		return begin.Add(time.Hour)
+	}
+	cert, private := newCert(t)
+	clientErr, serverErr := handshake(t, cert, cert, private)
+	if clientErr != nil || serverErr != nil {
+		t.Fatal(clientErr, serverErr)
+	}
+}
+
+// TestHandshakeWrongKey checks whether we detect a bridge that does
+// not know the private key corresponding to the cert.
+func TestHandshakeWrongKey(t *testing.T) {
+	cert, _ := newCert(t)
+	_, private := newCert(t)
+	clientErr, serverErr := handshake(t, cert, cert, private)
+	if clientErr != ErrInvalidAuth || serverErr != nil {
+		t.Fatal(clientErr, serverErr)
+	}
+}
+
+// TestHandshakeWrongNodeID checks whether the bridge ignores clients
+// that do not know its node ID.
+func TestHandshakeWrongNodeID(t *testing.T) {
+	cert, private := newCert(t)
+	wrong := *cert
+	wrong.NodeID[0] ^= 0xff
+	clientErr, serverErr := handshake(t, &wrong, cert, private)
+	if clientErr == nil || serverErr == nil {
+		t.Fatal("We expected both handshakes to fail")
+	}
+}
+
+// TestReadMessageErrors checks whether we deal with invalid messages.
+func TestReadMessageErrors(t *testing.T) {
+	cert, _ := newCert(t)
+	key := cert.hmacKey()
+	var repr [KeyLength]byte
+	message, err := newMessage(key, repr, nil, 0, 0, epochHour(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message[len(message)-1] ^= 0xff
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		serverConn.Write(message)
+		serverConn.Close()
+	}()
+	if _, _, err := readMessage(clientConn, key, KeyLength, [][]byte{epochHour(0)}); err != ErrInvalidHandshake {
+		t.Fatal("We expected ErrInvalidHandshake with an invalid MAC")
+	}
+	clientConn, serverConn = net.Pipe()
+	go func() {
+		serverConn.Write(make([]byte, maxHandshakeLength))
+		serverConn.Close()
+	}()
+	if _, _, err := readMessage(clientConn, key, KeyLength, [][]byte{epochHour(0)}); err != ErrInvalidHandshake {
+		t.Fatal("We expected ErrInvalidHandshake without a mark")
+	}
+	clientConn, serverConn = net.Pipe()
+	serverConn.Close()
+	if _, _, err := readMessage(clientConn, key, KeyLength, [][]byte{epochHour(0)}); err != io.EOF {
+		t.Fatal("We expected EOF")
+	}
+}
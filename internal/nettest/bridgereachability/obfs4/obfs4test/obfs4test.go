@@ -0,0 +1,85 @@
+// Package obfs4test implements a stand-in obfs4 bridge.
+//
+// The bridge performs the server side of the obfs4 handshake and then
+// discards whatever the client sends until the client disconnects.
+package obfs4test
+
+import (
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+
+	"github.com/measurement-kit/engine/internal/nettest/bridgereachability/obfs4"
+)
+
+// Server is an obfs4 bridge.
+type Server struct {
+	// Addr is the address where the server is listening.
+	Addr string
+
+	// Cert is the bridge cert.
+	Cert *obfs4.Cert
+
+	listener net.Listener
+	private  [obfs4.KeyLength]byte
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a new obfs4 bridge.
+func NewServer() (*Server, error) {
+	kp, err := obfs4.NewKeypair()
+	if err != nil {
+		return nil, err
+	}
+	cert := &obfs4.Cert{PublicKey: kp.Public}
+	if _, err := rand.Read(cert.NodeID[:]); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		Cert:     cert,
+		listener: listener,
+		private:  kp.Private,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// serve accepts and handles connections.
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle performs the handshake and drains conn.
+func (s *Server) handle(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	if err := obfs4.ServerHandshake(conn, s.Cert, s.private); err != nil {
+		return
+	}
+	io.Copy(ioutil.Discard, conn)
+}
+
+// Close shuts down the server and waits for pending connections, hence
+// clients must close their connections before calling Close.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
@@ -0,0 +1,28 @@
+package obfs4test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/nettest/bridgereachability/obfs4"
+)
+
+// TestServer checks whether we can handshake with the server.
+func TestServer(t *testing.T) {
+	server, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	err = obfs4.Handshake(conn, server.Cert)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,377 @@
+// Package dash implements the dash nettest.
+//
+// We use the neubot DASH protocol. We negotiate with the server to get
+// an authorization token, then we download numIterations chunks, each
+// one sized such that it contains chunkDuration seconds of video at the
+// current bitrate, adapting the bitrate to the measured speed after each
+// chunk. Finally, we upload our results to the server and we receive
+// the results measured by the server.
+package dash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/httpx"
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+)
+
+// DefaultRates contains the video bitrates in kbit/s.
+var DefaultRates = []int64{
+	100, 150, 200, 250, 300, 400, 500, 700, 900, 1200, 1500, 2000,
+	2500, 3000, 4000, 5000, 6000, 7000, 10000, 20000,
+}
+
+// chunkDuration is the number of seconds of video in each chunk.
+const chunkDuration = 2
+
+// numIterations is the number of chunks we download.
+const numIterations = 15
+
+// maxNegotiations is the maximum number of times we negotiate
+// while waiting in the server queue.
+const maxNegotiations = 10
+
+// negotiateInterval is the time we wait between negotiations. It is
+// a variable such that we can make it shorter in tests.
+var negotiateInterval = time.Second
+
+// locateURL is the mlab-ns URL used to discover the server. It is
+// a variable such that we can change it in tests.
+var locateURL = "https://locate.measurementlab.net/neubot"
+
+// ErrNotUnchoked indicates that the server kept us in queue.
+var ErrNotUnchoked = errors.New("dash: server did not allow us to run")
+
+// Config contains the dash nettest configuration.
+type Config struct {
+	// ServerURL is the base URL of the DASH server. When empty, we
+	// discover the server using mlab-ns.
+	ServerURL string
+}
+
+// ClientResult contains the results of downloading a chunk.
+type ClientResult struct {
+	// Elapsed is the number of seconds it took to download the chunk.
+	Elapsed float64 `json:"elapsed"`
+
+	// ElapsedTarget is the number of seconds of video in the chunk.
+	ElapsedTarget int64 `json:"elapsed_target"`
+
+	// Iteration is the zero based chunk index.
+	Iteration int64 `json:"iteration"`
+
+	// Platform is the client platform.
+	Platform string `json:"platform"`
+
+	// Rate is the video bitrate of the chunk in kbit/s.
+	Rate int64 `json:"rate"`
+
+	// Received is the number of bytes we received.
+	Received int64 `json:"received"`
+
+	// Speed is the download speed in kbit/s.
+	Speed float64 `json:"speed"`
+
+	// Timestamp is the Unix time when we finished the download.
+	Timestamp int64 `json:"timestamp"`
+
+	// Version is the client version.
+	Version string `json:"version"`
+}
+
+// Simple contains the summary of the measurement.
+type Simple struct {
+	// ConnectLatency is the number of seconds it took to negotiate.
+	ConnectLatency float64 `json:"connect_latency"`
+
+	// MedianBitrate is the median video bitrate in kbit/s, i.e., the
+	// estimated bitrate at which we could play the video.
+	MedianBitrate int64 `json:"median_bitrate"`
+
+	// MinPlayoutDelay is the minimum number of seconds of video we
+	// would have needed to buffer to play without stalling.
+	MinPlayoutDelay float64 `json:"min_playout_delay"`
+}
+
+// TestKeys contains the dash test keys.
+type TestKeys struct {
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// FailureDetails is the original error message, if any.
+	FailureDetails *string `json:"failure_details"`
+
+	// ReceiverData contains the results of each chunk.
+	ReceiverData []ClientResult `json:"receiver_data"`
+
+	// ServerURL is the base URL of the server we used.
+	ServerURL string `json:"server_url"`
+
+	// ServerResults contains the results measured by the server.
+	ServerResults interface{} `json:"server_results"`
+
+	// Simple contains the summary of the measurement.
+	Simple Simple `json:"simple"`
+}
+
+// negotiateRequest is the body of the negotiate request.
+type negotiateRequest struct {
+	DASHRates []int64 `json:"dash_rates"`
+}
+
+// negotiateResponse is the body of the negotiate response.
+type negotiateResponse struct {
+	Authorization string `json:"authorization"`
+	QueuePos      int64  `json:"queue_pos"`
+	RealAddress   string `json:"real_address"`
+	Unchoked      int    `json:"unchoked"`
+}
+
+// userAgent returns the user agent we use.
+func userAgent() string {
+	return "MKEngine/" + version.Version
+}
+
+// locate returns the base URL of the closest DASH server.
+func locate(ctx context.Context) (string, error) {
+	data, err := httpx.GET(ctx, locateURL)
+	if err != nil {
+		return "", err
+	}
+	var reply struct {
+		FQDN string `json:"fqdn"`
+	}
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return "", err
+	}
+	if reply.FQDN == "" {
+		return "", errors.New("dash: mlab-ns returned no server")
+	}
+	return "http://" + reply.FQDN, nil
+}
+
+// newTransport creates the transport we use for all the requests of
+// a measurement, such that we reuse the same connection and the time
+// to download a chunk does not include the connect and TLS handshake.
+func newTransport() *http.Transport {
+	return &http.Transport{
+		DisableCompression: true,
+		Proxy:              http.ProxyFromEnvironment,
+	}
+}
+
+// perform performs a request using transport and the authorization token.
+func perform(
+	ctx context.Context, transport http.RoundTripper,
+	method, URL, authorization string, body []byte,
+) (*httpx.Response, error) {
+	headers := http.Header{"User-Agent": {userAgent()}}
+	if authorization != "" {
+		headers["Authorization"] = []string{authorization}
+	}
+	if body != nil {
+		headers["Content-Type"] = []string{"application/json"}
+	}
+	return httpx.Request{
+		Ctx:       ctx,
+		Method:    method,
+		URL:       URL,
+		Body:      body,
+		Headers:   headers,
+		Transport: transport,
+	}.Perform()
+}
+
+// negotiate negotiates with the server and returns the authorization.
+func negotiate(
+	ctx context.Context, transport http.RoundTripper,
+	baseURL string, out chan<- model.Event,
+) (string, error) {
+	body, err := json.Marshal(negotiateRequest{DASHRates: DefaultRates})
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < maxNegotiations; i++ {
+		response, err := perform(
+			ctx, transport, "POST", baseURL+"/negotiate/dash", "", body,
+		)
+		if err != nil {
+			return "", err
+		}
+		var reply negotiateResponse
+		if err := json.Unmarshal(response.Body, &reply); err != nil {
+			return "", err
+		}
+		if reply.Unchoked != 0 && reply.Authorization != "" {
+			return reply.Authorization, nil
+		}
+		out <- model.NewLogInfoEvent(fmt.Sprintf(
+			"dash: waiting in queue at position %d", reply.QueuePos,
+		))
+		select {
+		case <-time.After(negotiateInterval):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	return "", ErrNotUnchoked
+}
+
+// nextRate returns the highest rate not greater than speed.
+func nextRate(speed float64) int64 {
+	rate := DefaultRates[0]
+	for _, r := range DefaultRates {
+		if float64(r) <= speed {
+			rate = r
+		}
+	}
+	return rate
+}
+
+// download downloads numIterations chunks and emits progress events.
+func download(
+	ctx context.Context, transport http.RoundTripper, baseURL, authorization string,
+	tk *TestKeys, out chan<- model.Event,
+) error {
+	rate := DefaultRates[0]
+	for i := int64(0); i < numIterations; i++ {
+		size := rate * 1000 / 8 * chunkDuration
+		begin := time.Now()
+		response, err := perform(
+			ctx, transport, "GET", fmt.Sprintf("%s/dash/download/%d", baseURL, size),
+			authorization, nil,
+		)
+		if err != nil {
+			return err
+		}
+		elapsed := time.Now().Sub(begin).Seconds()
+		result := ClientResult{
+			Elapsed:       elapsed,
+			ElapsedTarget: chunkDuration,
+			Iteration:     i,
+			Platform:      "go",
+			Rate:          rate,
+			Received:      int64(len(response.Body)),
+			Timestamp:     time.Now().Unix(),
+			Version:       version.Version,
+		}
+		if elapsed > 0 {
+			result.Speed = float64(result.Received) * 8 / 1000 / elapsed
+		}
+		tk.ReceiverData = append(tk.ReceiverData, result)
+		out <- model.Event{
+			Key:   "dash.download",
+			Value: result,
+		}
+		rate = nextRate(result.Speed)
+	}
+	return nil
+}
+
+// collect uploads our results and saves the server results.
+func collect(
+	ctx context.Context, transport http.RoundTripper,
+	baseURL, authorization string, tk *TestKeys,
+) error {
+	body, err := json.Marshal(tk.ReceiverData)
+	if err != nil {
+		return err
+	}
+	response, err := perform(
+		ctx, transport, "POST", baseURL+"/collect/dash", authorization, body,
+	)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(response.Body, &tk.ServerResults)
+}
+
+// analyze computes the summary of the measurement.
+func analyze(results []ClientResult) Simple {
+	var simple Simple
+	if len(results) <= 0 {
+		return simple
+	}
+	var rates []int64
+	var delay float64
+	for _, result := range results {
+		rates = append(rates, result.Rate)
+		delay += result.Elapsed - float64(result.ElapsedTarget)
+		if delay > simple.MinPlayoutDelay {
+			simple.MinPlayoutDelay = delay
+		}
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i] < rates[j] })
+	simple.MedianBitrate = rates[len(rates)/2]
+	if len(rates)%2 == 0 {
+		simple.MedianBitrate = (rates[len(rates)/2-1] + rates[len(rates)/2]) / 2
+	}
+	return simple
+}
+
+// Measure runs dash using config. It returns the test keys.
+func Measure(ctx context.Context, config Config, out chan<- model.Event) *TestKeys {
+	tk := &TestKeys{ServerURL: strings.TrimSuffix(config.ServerURL, "/")}
+	fail := func(err error) *TestKeys {
+		tk.Failure = errorx.FailureString(err)
+		tk.FailureDetails = errorx.DetailsString(err)
+		out <- model.NewFailureMeasurementEvent(0, err)
+		return tk
+	}
+	if tk.ServerURL == "" {
+		out <- model.NewLogInfoEvent("dash: discovering the server")
+		URL, err := locate(ctx)
+		if err != nil {
+			return fail(err)
+		}
+		tk.ServerURL = URL
+	}
+	transport := newTransport()
+	defer transport.CloseIdleConnections()
+	out <- model.NewLogInfoEvent("dash: negotiating with " + tk.ServerURL)
+	begin := time.Now()
+	authorization, err := negotiate(ctx, transport, tk.ServerURL, out)
+	if err != nil {
+		return fail(err)
+	}
+	connectLatency := time.Now().Sub(begin).Seconds()
+	err = download(ctx, transport, tk.ServerURL, authorization, tk, out)
+	tk.Simple = analyze(tk.ReceiverData)
+	tk.Simple.ConnectLatency = connectLatency
+	if err != nil {
+		return fail(err)
+	}
+	if err := collect(ctx, transport, tk.ServerURL, authorization, tk); err != nil {
+		return fail(err)
+	}
+	return tk
+}
+
+// NewNettest creates a new dash nettest.
+func NewNettest(config Config) *nettest.Nettest {
+	return &nettest.Nettest{
+		TestName:        "dash",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+		Main: func(
+			ctx context.Context,
+			input string,
+			measurement *model.Measurement,
+			out chan<- model.Event,
+		) {
+			measurement.TestKeys = Measure(ctx, config, out)
+		},
+	}
+}
@@ -0,0 +1,201 @@
+package dash
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest/dash/dashtest"
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// measure runs Measure and returns the test keys and the events.
+func measure(config Config) (*TestKeys, []model.Event) {
+	out := make(chan model.Event)
+	done := make(chan *TestKeys, 1)
+	go func() {
+		defer close(out)
+		done <- Measure(context.Background(), config, out)
+	}()
+	var events []model.Event
+	for ev := range out {
+		events = append(events, ev)
+	}
+	return <-done, events
+}
+
+// TestMeasure checks whether we can measure using the DASH server.
+func TestMeasure(t *testing.T) {
+	server := dashtest.NewServer()
+	server.Queued = 1
+	defer server.Close()
+	savedInterval := negotiateInterval
+	negotiateInterval = time.Millisecond
+	defer func() { negotiateInterval = savedInterval }()
+	tk, events := measure(Config{ServerURL: server.URL + "/"})
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.ServerURL != server.URL || len(tk.ReceiverData) != numIterations {
+		t.Fatal("Unexpected test keys")
+	}
+	var progress int
+	for _, ev := range events {
+		if ev.Key == "dash.download" {
+			progress++
+		}
+	}
+	if progress != numIterations {
+		t.Fatal("Unexpected number of progress events")
+	}
+	first := tk.ReceiverData[0]
+	if first.Rate != DefaultRates[0] || first.Received != first.Rate*1000/8*chunkDuration {
+		t.Fatal("Unexpected first chunk")
+	}
+	last := tk.ReceiverData[numIterations-1]
+	if last.Rate <= first.Rate {
+		t.Fatal("We expected the bitrate to increase")
+	}
+	if tk.Simple.MedianBitrate <= 0 || tk.Simple.ConnectLatency <= 0 {
+		t.Fatalf("Unexpected summary: %+v", tk.Simple)
+	}
+	var collected []ClientResult
+	if err := json.Unmarshal(server.Collected(), &collected); err != nil {
+		t.Fatal(err)
+	}
+	if len(collected) != numIterations {
+		t.Fatal("The server did not receive our results")
+	}
+	if tk.ServerResults == nil {
+		t.Fatal("Missing server results")
+	}
+	if server.Connections() != 1 {
+		t.Fatal("We expected to reuse the same connection")
+	}
+}
+
+// TestMeasureLocate checks whether we use mlab-ns to discover the server.
+func TestMeasureLocate(t *testing.T) {
+	server := dashtest.NewServer()
+	defer server.Close()
+	mlabns := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"fqdn": "` + strings.TrimPrefix(server.URL, "http://") + `"}`))
+		},
+	))
+	defer mlabns.Close()
+	savedLocateURL := locateURL
+	locateURL = mlabns.URL
+	defer func() { locateURL = savedLocateURL }()
+	tk, _ := measure(Config{})
+	if tk.Failure != nil || tk.ServerURL != server.URL {
+		t.Fatal("Unexpected test keys")
+	}
+}
+
+// TestMeasureLocateFailure checks whether we deal with mlab-ns errors.
+func TestMeasureLocateFailure(t *testing.T) {
+	mlabns := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.URL.Query().Get("reply")))
+		},
+	))
+	defer mlabns.Close()
+	savedLocateURL := locateURL
+	defer func() { locateURL = savedLocateURL }()
+	for _, reply := range []string{"{", "{}"} {
+		locateURL = mlabns.URL + "/?reply=" + reply
+		tk, _ := measure(Config{})
+		if tk.Failure == nil {
+			t.Fatalf("We expected a failure with %q", reply)
+		}
+	}
+	locateURL = "http://127.0.0.1:0/"
+	if tk, _ := measure(Config{}); tk.Failure == nil {
+		t.Fatal("We expected a failure")
+	}
+}
+
+// TestMeasureNotUnchoked checks whether we give up when the server
+// keeps us in queue for too long.
+func TestMeasureNotUnchoked(t *testing.T) {
+	server := dashtest.NewServer()
+	server.Queued = maxNegotiations
+	defer server.Close()
+	savedInterval := negotiateInterval
+	negotiateInterval = time.Millisecond
+	defer func() { negotiateInterval = savedInterval }()
+	tk, _ := measure(Config{ServerURL: server.URL})
+	if tk.Failure == nil || *tk.Failure != errorx.Classify(ErrNotUnchoked) {
+		t.Fatal("We expected ErrNotUnchoked")
+	}
+}
+
+// TestMeasureDownloadFailure checks whether we deal with errors
+// occurring while downloading chunks.
+func TestMeasureDownloadFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/negotiate/dash" {
+				w.WriteHeader(500)
+				return
+			}
+			w.Write([]byte(`{"authorization": "x", "unchoked": 1}`))
+		},
+	))
+	defer server.Close()
+	tk, _ := measure(Config{ServerURL: server.URL})
+	if tk.Failure == nil || len(tk.ReceiverData) != 0 {
+		t.Fatal("We expected a failure")
+	}
+}
+
+// TestAnalyze checks whether we compute the summary correctly.
+func TestAnalyze(t *testing.T) {
+	if analyze(nil) != (Simple{}) {
+		t.Fatal("Unexpected summary with no results")
+	}
+	simple := analyze([]ClientResult{
+		{Elapsed: 3, ElapsedTarget: 2, Rate: 300},
+		{Elapsed: 1, ElapsedTarget: 2, Rate: 100},
+		{Elapsed: 4, ElapsedTarget: 2, Rate: 200},
+	})
+	if simple.MedianBitrate != 200 || simple.MinPlayoutDelay != 2 {
+		t.Fatalf("Unexpected summary: %+v", simple)
+	}
+	simple = analyze([]ClientResult{
+		{Elapsed: 1, ElapsedTarget: 2, Rate: 100},
+		{Elapsed: 1, ElapsedTarget: 2, Rate: 200},
+	})
+	if simple.MedianBitrate != 150 || simple.MinPlayoutDelay != 0 {
+		t.Fatalf("Unexpected summary: %+v", simple)
+	}
+}
+
+// TestNextRate checks whether we select the right bitrate.
+func TestNextRate(t *testing.T) {
+	table := map[float64]int64{0: 100, 99: 100, 100: 100, 2600: 2500, 1e06: 20000}
+	for speed, rate := range table {
+		if nextRate(speed) != rate {
+			t.Fatalf("Unexpected rate for %f", speed)
+		}
+	}
+}
+
+// TestNewNettest checks whether the nettest works.
+func TestNewNettest(t *testing.T) {
+	server := dashtest.NewServer()
+	defer server.Close()
+	nt := NewNettest(Config{ServerURL: server.URL})
+	measurement := nettesttest.Run(nt, "")
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.Failure != nil || len(tk.ReceiverData) != numIterations {
+		t.Fatal("Unexpected result")
+	}
+}
@@ -0,0 +1,152 @@
+// Package dashtest implements a stand-in for a neubot DASH server.
+//
+// The server negotiates with the client, serves synthetic chunks of
+// the requested size, and collects the client results.
+package dashtest
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Authorization is the authorization token returned by the server.
+const Authorization = "0xdeadbeef"
+
+// MaxChunkSize is the maximum chunk size we serve.
+const MaxChunkSize = 1 << 24
+
+// zeros is a reader that never ends and returns zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// Server is a DASH server.
+type Server struct {
+	// Queued is the number of negotiations during which the client
+	// is kept in queue before being allowed to run.
+	Queued int
+
+	// URL is the server base URL.
+	URL string
+
+	mu          sync.Mutex
+	collected   []byte
+	connections int
+	server      *httptest.Server
+}
+
+// NewServer starts a new DASH server.
+func NewServer() *Server {
+	s := &Server{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/negotiate/dash", s.negotiate)
+	mux.HandleFunc("/dash/download/", s.download)
+	mux.HandleFunc("/collect/dash", s.collect)
+	s.server = httptest.NewUnstartedServer(mux)
+	s.server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			s.mu.Lock()
+			s.connections++
+			s.mu.Unlock()
+		}
+	}
+	s.server.Start()
+	s.URL = s.server.URL
+	return s
+}
+
+// negotiate handles the negotiation.
+func (s *Server) negotiate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	queued := s.Queued
+	if s.Queued > 0 {
+		s.Queued--
+	}
+	s.mu.Unlock()
+	reply := map[string]interface{}{
+		"authorization": "",
+		"queue_pos":     queued,
+		"real_address":  "127.0.0.1",
+		"unchoked":      0,
+	}
+	if queued <= 0 {
+		reply["authorization"] = Authorization
+		reply["unchoked"] = 1
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// authorized returns whether the request is authorized.
+func authorized(r *http.Request) bool {
+	return r.Header.Get("Authorization") == Authorization
+}
+
+// download serves a synthetic chunk of the size in the path.
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	size, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/dash/download/"))
+	if err != nil || size < 0 || size > MaxChunkSize {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	w.Header().Set("Content-Type", "video/mp4")
+	io.CopyN(w, zeros{}, int64(size))
+}
+
+// collect saves the client results and returns the server results.
+func (s *Server) collect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || !authorized(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil || !json.Valid(data) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.collected = data
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`[{"platform": "dashtest"}]`))
+}
+
+// Collected returns the client results collected by the server.
+func (s *Server) Collected() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.collected
+}
+
+// Connections returns the number of connections accepted by the server.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
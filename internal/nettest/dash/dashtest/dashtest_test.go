@@ -0,0 +1,77 @@
+package dashtest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// do performs a request using authorization and returns the response
+// status code and body.
+func do(t *testing.T, method, URL, authorization, body string) (int, string) {
+	request, err := http.NewRequest(method, URL, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", authorization)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, string(data)
+}
+
+// TestServer checks whether the server implements the protocol.
+func TestServer(t *testing.T) {
+	server := NewServer()
+	server.Queued = 1
+	defer server.Close()
+	status, body := do(t, "POST", server.URL+"/negotiate/dash", "", "{}")
+	if status != 200 || !strings.Contains(body, `"unchoked":0`) {
+		t.Fatalf("Unexpected negotiate reply: %s", body)
+	}
+	status, body = do(t, "POST", server.URL+"/negotiate/dash", "", "{}")
+	if status != 200 || !strings.Contains(body, Authorization) {
+		t.Fatalf("Unexpected negotiate reply: %s", body)
+	}
+	status, body = do(t, "GET", server.URL+"/dash/download/1024", Authorization, "")
+	if status != 200 || len(body) != 1024 {
+		t.Fatal("Unexpected chunk")
+	}
+	status, body = do(t, "POST", server.URL+"/collect/dash", Authorization, "[]")
+	if status != 200 || !strings.Contains(body, "dashtest") {
+		t.Fatal("Unexpected collect reply")
+	}
+	if string(server.Collected()) != "[]" {
+		t.Fatal("Unexpected collected data")
+	}
+}
+
+// TestServerErrors checks whether the server rejects invalid requests.
+func TestServerErrors(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	table := []struct {
+		method, path, authorization, body string
+		status                            int
+	}{
+		{"GET", "/negotiate/dash", "", "", 405},
+		{"GET", "/dash/download/1024", "", "", 403},
+		{"GET", "/dash/download/antani", Authorization, "", 400},
+		{"GET", "/dash/download/-1", Authorization, "", 400},
+		{"POST", "/collect/dash", "", "[]", 403},
+		{"POST", "/collect/dash", Authorization, "{", 400},
+	}
+	for _, e := range table {
+		status, _ := do(t, e.method, server.URL+e.path, e.authorization, e.body)
+		if status != e.status {
+			t.Fatalf("Unexpected status for %s %s: %d", e.method, e.path, status)
+		}
+	}
+}
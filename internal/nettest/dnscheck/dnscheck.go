@@ -0,0 +1,222 @@
+// Package dnscheck implements the dnscheck nettest.
+//
+// The input of each measurement is a resolver URL. We support DNS over
+// HTTPS ("https://dns.google/dns-query"), DNS over TLS ("dot://dns.google"
+// or "dot://8.8.8.8:853"), and classic DNS over UDP and TCP ("udp://8.8.8.8"
+// or "tcp://8.8.8.8:53"). For each configured domain, we send an A and an
+// AAAA query to the resolver and we record the answers, the failure, and
+// the latency. This allows to determine whether the resolver is blocked.
+//
+// We use a new connection for each query with all the engines, including
+// DNS over HTTPS, where we disable keep-alives. Hence, the latency of each
+// query with DoH, DoT, and TCP includes connecting to the resolver, and
+// the latencies of all the queries are comparable.
+package dnscheck
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/dnsx"
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/resolver"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultDomains contains the domains we resolve by default.
+var DefaultDomains = []string{"example.com", "example.org"}
+
+// queryTimeout is the timeout for each query.
+const queryTimeout = 10 * time.Second
+
+// rootCAs allows to use custom root CAs in tests. We use the system
+// root CAs when it is nil.
+var rootCAs *x509.CertPool
+
+// ErrInvalidResolverURL indicates that the input is not a resolver URL
+// we know how to use.
+var ErrInvalidResolverURL = errors.New("input is not a valid resolver URL")
+
+// Config contains the dnscheck nettest configuration.
+type Config struct {
+	// Domains contains the domains to resolve. When empty, we
+	// use DefaultDomains.
+	Domains []string
+}
+
+// Query is a query sent to the resolver.
+type Query struct {
+	// Answers contains the resolved IP addresses.
+	Answers []string `json:"answers"`
+
+	// Domain is the domain we resolved.
+	Domain string `json:"domain"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Latency is the number of seconds it took to receive the reply
+	// or to fail the query, including connecting to the resolver.
+	Latency float64 `json:"latency"`
+
+	// QueryType is either "A" or "AAAA".
+	QueryType string `json:"query_type"`
+
+	// RawQuery is the raw query, serialized as base64.
+	RawQuery []byte `json:"raw_query"`
+
+	// RawReply is the raw reply, serialized as base64. It is empty
+	// when we did not receive any reply.
+	RawReply []byte `json:"raw_reply"`
+}
+
+// TestKeys contains the dnscheck test keys.
+type TestKeys struct {
+	// Engine is one of "doh", "dot", "udp", and "tcp".
+	Engine string `json:"engine"`
+
+	// Failure is the failure that prevented the measurement, if any.
+	Failure *string `json:"failure"`
+
+	// FailureDetails is the original error message, if any.
+	FailureDetails *string `json:"failure_details"`
+
+	// Queries contains the queries we sent.
+	Queries []Query `json:"queries"`
+
+	// Reachable indicates whether we received at least a valid reply,
+	// including replies saying that the domain does not exist.
+	Reachable bool `json:"reachable"`
+
+	// ResolverURL is the resolver URL we measured.
+	ResolverURL string `json:"resolver_url"`
+}
+
+// exchangeFunc sends a raw query and returns the raw reply.
+type exchangeFunc func(ctx context.Context, query []byte) ([]byte, error)
+
+// newExchange returns the engine and the exchangeFunc for resolverURL,
+// which is parsed using resolver.Parse.
+func newExchange(resolverURL string) (string, exchangeFunc, error) {
+	epnt, err := resolver.Parse(resolverURL)
+	if err != nil {
+		return "", nil, ErrInvalidResolverURL
+	}
+	switch epnt.Engine {
+	case "doh":
+		client := &http.Client{Transport: &http.Transport{
+			DisableKeepAlives: true,
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   &tls.Config{RootCAs: rootCAs},
+		}}
+		return "doh", func(ctx context.Context, query []byte) ([]byte, error) {
+			return dnsx.ExchangeHTTPS(ctx, client, epnt.URL, query)
+		}, nil
+	case "dot":
+		host, _, _ := net.SplitHostPort(epnt.Address)
+		config := &tls.Config{RootCAs: rootCAs, ServerName: host}
+		return "dot", func(ctx context.Context, query []byte) ([]byte, error) {
+			return dnsx.ExchangeTLS(ctx, epnt.Address, config, query)
+		}, nil
+	case "udp":
+		return "udp", func(ctx context.Context, query []byte) ([]byte, error) {
+			return dnsx.ExchangeUDP(ctx, epnt.Address, query)
+		}, nil
+	case "tcp":
+		return "tcp", func(ctx context.Context, query []byte) ([]byte, error) {
+			return dnsx.ExchangeTCP(ctx, epnt.Address, query)
+		}, nil
+	}
+	return "", nil, ErrInvalidResolverURL
+}
+
+// resolve resolves domain using exchange and qtype. It returns the
+// query and the error, if any.
+func resolve(
+	ctx context.Context, exchange exchangeFunc,
+	domain string, qtype dnsmessage.Type,
+) (Query, error) {
+	query := Query{Domain: domain, QueryType: "A"}
+	if qtype == dnsmessage.TypeAAAA {
+		query.QueryType = "AAAA"
+	}
+	rawQuery, err := dnsx.NewQuery(domain, qtype)
+	if err != nil {
+		query.Failure = errorx.FailureString(err)
+		return query, err
+	}
+	query.RawQuery = rawQuery
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	begin := time.Now()
+	query.RawReply, err = exchange(ctx, rawQuery)
+	query.Latency = time.Now().Sub(begin).Seconds()
+	if err != nil {
+		query.Failure = errorx.FailureString(err)
+		return query, err
+	}
+	query.Answers, err = dnsx.ParseReply(rawQuery, query.RawReply)
+	query.Failure = errorx.FailureString(err)
+	return query, err
+}
+
+// Measure runs dnscheck for the resolver URL in input using config. It
+// returns the test keys.
+func Measure(
+	ctx context.Context, config Config, input string, out chan<- model.Event,
+) *TestKeys {
+	tk := &TestKeys{ResolverURL: input}
+	engine, exchange, err := newExchange(input)
+	if err != nil {
+		tk.Failure = errorx.FailureString(err)
+		tk.FailureDetails = errorx.DetailsString(err)
+		out <- model.NewFailureMeasurementEvent(0, err)
+		return tk
+	}
+	tk.Engine = engine
+	domains := config.Domains
+	if len(domains) <= 0 {
+		domains = DefaultDomains
+	}
+	for _, domain := range domains {
+		for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+			query, err := resolve(ctx, exchange, domain, qtype)
+			tk.Queries = append(tk.Queries, query)
+			switch err {
+			case nil, dnsx.ErrNXDOMAIN, dnsx.ErrNoAnswer:
+				tk.Reachable = true
+			default:
+				out <- model.NewLogWarningEvent(err, "dnscheck: query failed")
+			}
+		}
+	}
+	return tk
+}
+
+// NewNettest creates a new dnscheck nettest. The input of each
+// measurement is the resolver URL to check.
+func NewNettest(config Config) *nettest.Nettest {
+	return &nettest.Nettest{
+		TestName:        "dnscheck",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+		Main: func(
+			ctx context.Context,
+			input string,
+			measurement *model.Measurement,
+			out chan<- model.Event,
+		) {
+			measurement.TestKeys = Measure(ctx, config, input, out)
+		},
+	}
+}
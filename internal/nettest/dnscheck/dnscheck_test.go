@@ -0,0 +1,172 @@
+package dnscheck
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"testing"
+
+	"github.com/measurement-kit/engine/internal/dnsx/dnstest"
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/model"
+)
+
+var records = map[string][]string{
+	"example.com": {"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"},
+}
+
+// measure runs Measure and returns the test keys.
+func measure(config Config, input string) *TestKeys {
+	var tk *TestKeys
+	nettesttest.Drain(func(out chan<- model.Event) {
+		tk = Measure(context.Background(), config, input, out)
+	})
+	return tk
+}
+
+// withServer runs fn with server and makes its certificate trusted.
+func withServer(
+	t *testing.T, newServer func(map[string][]string) (*dnstest.Server, error),
+	fn func(server *dnstest.Server),
+) {
+	server, err := newServer(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	savedRootCAs := rootCAs
+	defer func() { rootCAs = savedRootCAs }()
+	if server.Certificate != nil {
+		rootCAs = x509.NewCertPool()
+		rootCAs.AddCert(server.Certificate)
+	}
+	fn(server)
+}
+
+// check checks whether we correctly measure resolverURL.
+func check(t *testing.T, resolverURL, engine string) {
+	tk := measure(Config{Domains: []string{"example.com", "antani.example.com"}}, resolverURL)
+	if tk.Failure != nil || !tk.Reachable || tk.Engine != engine {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+	if tk.ResolverURL != resolverURL || len(tk.Queries) != 4 {
+		t.Fatal("Unexpected number of queries")
+	}
+	expected := []struct {
+		qtype  string
+		answer string
+	}{
+		{"A", "93.184.216.34"},
+		{"AAAA", "2606:2800:220:1:248:1893:25c8:1946"},
+	}
+	for idx, e := range expected {
+		query := tk.Queries[idx]
+		if query.Failure != nil || query.QueryType != e.qtype {
+			t.Fatalf("Unexpected query: %+v", query)
+		}
+		if len(query.Answers) != 1 || query.Answers[0] != e.answer {
+			t.Fatalf("Unexpected answers: %+v", query.Answers)
+		}
+		if len(query.RawQuery) <= 0 || len(query.RawReply) <= 0 {
+			t.Fatal("Missing raw query or reply")
+		}
+	}
+	for _, query := range tk.Queries[2:] {
+		if query.Failure == nil || *query.Failure != errorx.FailureDNSNXDomain {
+			t.Fatal("We expected NXDOMAIN")
+		}
+	}
+}
+
+// TestMeasureUDP checks whether we can measure a UDP resolver.
+func TestMeasureUDP(t *testing.T) {
+	withServer(t, dnstest.NewUDPServer, func(server *dnstest.Server) {
+		check(t, "udp://"+server.Addr, "udp")
+	})
+}
+
+// TestMeasureTCP checks whether we can measure a TCP resolver.
+func TestMeasureTCP(t *testing.T) {
+	withServer(t, dnstest.NewTCPServer, func(server *dnstest.Server) {
+		check(t, "tcp://"+server.Addr, "tcp")
+	})
+}
+
+// TestMeasureDoT checks whether we can measure a DNS over TLS resolver.
+func TestMeasureDoT(t *testing.T) {
+	withServer(t, dnstest.NewTLSServer, func(server *dnstest.Server) {
+		check(t, "dot://"+server.Addr, "dot")
+	})
+}
+
+// TestMeasureDoH checks whether we can measure a DNS over HTTPS resolver.
+func TestMeasureDoH(t *testing.T) {
+	withServer(t, dnstest.NewHTTPSServer, func(server *dnstest.Server) {
+		check(t, server.URL, "doh")
+	})
+}
+
+// TestMeasureUntrustedDoT checks whether we fail when we cannot
+// verify the certificate of the DNS over TLS resolver.
+func TestMeasureUntrustedDoT(t *testing.T) {
+	server, err := dnstest.NewTLSServer(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	tk := measure(Config{}, "tls://"+server.Addr)
+	if tk.Reachable || tk.Engine != "dot" || len(tk.Queries) != 4 {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+	for _, query := range tk.Queries {
+		if query.Failure == nil || *query.Failure != errorx.FailureSSLUnknownAuthority {
+			t.Fatal("We expected a certificate error")
+		}
+	}
+}
+
+// TestMeasureUnreachable checks whether we deal with resolvers
+// that do not accept connections.
+func TestMeasureUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	tk := measure(Config{}, "tcp://"+address)
+	if tk.Reachable || tk.Failure != nil || len(tk.Queries) != 4 {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+	for _, query := range tk.Queries {
+		if query.Failure == nil || len(query.RawReply) != 0 {
+			t.Fatal("We expected a failure")
+		}
+	}
+}
+
+// TestMeasureInvalidInput checks whether we deal with invalid inputs.
+func TestMeasureInvalidInput(t *testing.T) {
+	for _, input := range []string{"", "\t", "8.8.8.8", "http://8.8.8.8/", "udp://"} {
+		tk := measure(Config{}, input)
+		if tk.Failure == nil || *tk.Failure != errorx.Classify(ErrInvalidResolverURL) {
+			t.Fatalf("Expected ErrInvalidResolverURL with %q", input)
+		}
+		if tk.Queries != nil {
+			t.Fatal("We expected no queries")
+		}
+	}
+}
+
+// TestNewNettest checks whether the nettest uses the input.
+func TestNewNettest(t *testing.T) {
+	withServer(t, dnstest.NewUDPServer, func(server *dnstest.Server) {
+		nt := NewNettest(Config{Domains: []string{"example.com"}})
+		measurement := nettesttest.Run(nt, "udp://"+server.Addr)
+		tk := measurement.TestKeys.(*TestKeys)
+		if !tk.Reachable || tk.Engine != "udp" || len(tk.Queries) != 2 {
+			t.Fatal("Unexpected result")
+		}
+	})
+}
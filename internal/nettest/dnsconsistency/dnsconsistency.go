@@ -0,0 +1,298 @@
+// Package dnsconsistency implements the dns_consistency nettest.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-002-dns-consistency.md
+// for the original nettest specification. We resolve the input domain
+// using the system resolver and using each control resolver, and we
+// flag the control resolvers whose answers do not overlap with the
+// answers of the system resolver.
+package dnsconsistency
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/dnsx"
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// TestHelperName is the name of the test helper we use.
+const TestHelperName = "dns"
+
+// queryTimeout is the timeout for each DNS query.
+const queryTimeout = 10 * time.Second
+
+// SystemResolver is the name of the system resolver in the test keys.
+const SystemResolver = "system"
+
+// ErrNoControlResolver indicates that no control resolver is available.
+var ErrNoControlResolver = errors.New("no available control resolver")
+
+// ErrInvalidResolverType indicates that a resolver type is not supported.
+var ErrInvalidResolverType = errors.New("resolver type is not supported")
+
+// lookupIPAddr allows to mock the system resolver in tests.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// Config contains the dns_consistency nettest configuration.
+type Config struct {
+	// ControlResolvers contains the control resolvers. The Type of each
+	// entry is either "udp" or "tcp" and the Address is an "ip:port"
+	// endpoint. When empty, we use the "dns" test helpers in the nettest
+	// AvailableTestHelpers, whose "legacy" type means DNS over TCP.
+	ControlResolvers []model.Service
+}
+
+// DNSAnswer is an answer to a DNS query.
+type DNSAnswer struct {
+	// AnswerType is always "A".
+	AnswerType string `json:"answer_type"`
+
+	// IPv4 is the IPv4 address.
+	IPv4 string `json:"ipv4"`
+}
+
+// DNSQuery is a DNS query and its answers.
+type DNSQuery struct {
+	// Answers contains the answers.
+	Answers []DNSAnswer `json:"answers"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Hostname is the hostname we resolved.
+	Hostname string `json:"hostname"`
+
+	// QueryType is always "A".
+	QueryType string `json:"query_type"`
+
+	// RawQuery is the raw query, serialized as base64. It is empty
+	// for the system resolver, which does not expose it.
+	RawQuery []byte `json:"raw_query"`
+
+	// RawReply is the raw reply, serialized as base64. It is empty
+	// for the system resolver and when we did not receive a reply.
+	RawReply []byte `json:"raw_reply"`
+
+	// Resolver is either "system" or the control resolver URL, e.g.,
+	// "udp://8.8.8.8:53" or "tcp://8.8.8.8:53".
+	Resolver string `json:"resolver"`
+}
+
+// TestKeys contains the dns_consistency test keys.
+type TestKeys struct {
+	// ControlResolvers contains the URLs of the control resolvers.
+	ControlResolvers []string `json:"control_resolvers"`
+
+	// DNSConsistency is "consistent" if at least a control resolver
+	// agrees with the system resolver, "inconsistent" if all of them
+	// disagree, and empty if we could not use any control resolver.
+	DNSConsistency string `json:"dns_consistency"`
+
+	// Failure is the failure that prevented the measurement, if any.
+	Failure *string `json:"failure"`
+
+	// FailureDetails is the original error message, if any.
+	FailureDetails *string `json:"failure_details"`
+
+	// Inconsistent contains the URLs of the control resolvers whose
+	// answers disagree with the system resolver.
+	Inconsistent []string `json:"inconsistent"`
+
+	// Queries contains the DNS queries.
+	Queries []DNSQuery `json:"queries"`
+}
+
+// newAnswers converts the resolved addresses into answers.
+func newAnswers(addrs []string) []DNSAnswer {
+	var answers []DNSAnswer
+	for _, addr := range addrs {
+		answers = append(answers, DNSAnswer{AnswerType: "A", IPv4: addr})
+	}
+	return answers
+}
+
+// resolveSystem resolves hostname using the system resolver. Since
+// we only send A queries to the control resolvers, we only keep
+// the IPv4 addresses returned by the system resolver.
+func resolveSystem(ctx context.Context, hostname string) DNSQuery {
+	query := DNSQuery{
+		Hostname:  hostname,
+		QueryType: "A",
+		Resolver:  SystemResolver,
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	addrs, err := lookupIPAddr(ctx, hostname)
+	if err != nil {
+		query.Failure = errorx.FailureString(err)
+		return query
+	}
+	var ipv4 []string
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			ipv4 = append(ipv4, addr.IP.String())
+		}
+	}
+	if len(ipv4) <= 0 {
+		query.Failure = errorx.FailureString(dnsx.ErrNoAnswer)
+		return query
+	}
+	query.Answers = newAnswers(ipv4)
+	return query
+}
+
+// resolveControl resolves hostname using the control resolver.
+func resolveControl(
+	ctx context.Context, hostname string, resolver model.Service,
+) (DNSQuery, error) {
+	query := DNSQuery{
+		Hostname:  hostname,
+		QueryType: "A",
+		Resolver:  resolver.Type + "://" + resolver.Address,
+	}
+	var exchange func(context.Context, string, []byte) ([]byte, error)
+	switch resolver.Type {
+	case "udp":
+		exchange = dnsx.ExchangeUDP
+	case "tcp":
+		exchange = dnsx.ExchangeTCP
+	default:
+		query.Failure = errorx.FailureString(ErrInvalidResolverType)
+		return query, ErrInvalidResolverType
+	}
+	rawQuery, err := dnsx.NewQuery(hostname, dnsmessage.TypeA)
+	if err != nil {
+		query.Failure = errorx.FailureString(err)
+		return query, err
+	}
+	query.RawQuery = rawQuery
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	query.RawReply, err = exchange(ctx, resolver.Address, rawQuery)
+	if err != nil {
+		query.Failure = errorx.FailureString(err)
+		return query, err
+	}
+	addrs, err := dnsx.ParseReply(rawQuery, query.RawReply)
+	query.Answers = newAnswers(addrs)
+	query.Failure = errorx.FailureString(err)
+	return query, err
+}
+
+// agree returns whether the control answers agree with the system
+// ones. They agree when they have at least an address in common or
+// when both resolvers say that the domain does not exist.
+func agree(system DNSQuery, control DNSQuery, err error) bool {
+	if err == dnsx.ErrNXDOMAIN {
+		return system.Failure != nil && *system.Failure == errorx.FailureDNSNXDomain
+	}
+	for _, c := range control.Answers {
+		for _, s := range system.Answers {
+			if c.IPv4 == s.IPv4 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// usable returns whether we can compare the answers of a control
+// resolver with the system ones. We cannot when the control resolver
+// failed for reasons other than the domain not existing.
+func usable(err error) bool {
+	return err == nil || err == dnsx.ErrNXDOMAIN
+}
+
+// resolversFromHelpers returns the control resolvers in helpers.
+func resolversFromHelpers(helpers map[string][]model.Service) []model.Service {
+	var resolvers []model.Service
+	for _, e := range helpers[TestHelperName] {
+		switch e.Type {
+		case "legacy":
+			resolvers = append(resolvers, model.Service{
+				Address: e.Address, Type: "tcp",
+			})
+		case "tcp", "udp":
+			resolvers = append(resolvers, e)
+		}
+	}
+	return resolvers
+}
+
+// Measure runs dns_consistency for the hostname in input using the
+// specified control resolvers. It returns the test keys.
+func Measure(
+	ctx context.Context, resolvers []model.Service, input string,
+	out chan<- model.Event,
+) *TestKeys {
+	tk := &TestKeys{}
+	if len(resolvers) <= 0 {
+		tk.Failure = errorx.FailureString(ErrNoControlResolver)
+		tk.FailureDetails = errorx.DetailsString(ErrNoControlResolver)
+		out <- model.NewFailureMeasurementEvent(0, ErrNoControlResolver)
+		return tk
+	}
+	out <- model.NewLogInfoEvent("dns_consistency: resolving " + input)
+	system := resolveSystem(ctx, input)
+	tk.Queries = append(tk.Queries, system)
+	var numUsable int
+	for _, resolver := range resolvers {
+		query, err := resolveControl(ctx, input, resolver)
+		out <- model.NewLogInfoEvent("dns_consistency: queried " + query.Resolver)
+		tk.ControlResolvers = append(tk.ControlResolvers, query.Resolver)
+		tk.Queries = append(tk.Queries, query)
+		if !usable(err) {
+			out <- model.NewLogWarningEvent(err, "dns_consistency: control failed")
+			continue
+		}
+		numUsable++
+		if !agree(system, query, err) {
+			tk.Inconsistent = append(tk.Inconsistent, query.Resolver)
+		}
+	}
+	switch {
+	case numUsable <= 0:
+	case len(tk.Inconsistent) < numUsable:
+		tk.DNSConsistency = "consistent"
+	default:
+		tk.DNSConsistency = "inconsistent"
+	}
+	return tk
+}
+
+// NewNettest creates a new dns_consistency nettest. The input of each
+// measurement is the domain to resolve. See Config for how we choose
+// the control resolvers.
+func NewNettest(config Config) *nettest.Nettest {
+	nt := &nettest.Nettest{
+		TestName:        "dns_consistency",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+	}
+	nt.Main = func(
+		ctx context.Context,
+		input string,
+		measurement *model.Measurement,
+		out chan<- model.Event,
+	) {
+		resolvers := config.ControlResolvers
+		if len(resolvers) <= 0 {
+			resolvers = resolversFromHelpers(nt.AvailableTestHelpers)
+			if len(resolvers) > 0 {
+				measurement.TestHelpers = map[string]string{
+					"backend": resolvers[0].Address,
+				}
+			}
+		}
+		measurement.TestKeys = Measure(ctx, resolvers, input, out)
+	}
+	return nt
+}
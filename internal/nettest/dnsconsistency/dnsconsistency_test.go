@@ -0,0 +1,229 @@
+package dnsconsistency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/measurement-kit/engine/internal/dnsx/dnstest"
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/model"
+)
+
+var records = map[string][]string{
+	"example.com": {"93.184.216.34"},
+}
+
+// withResolvers runs fn with local UDP and TCP control resolvers.
+func withResolvers(t *testing.T, fn func(resolvers []model.Service)) {
+	udp, err := dnstest.NewUDPServer(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	tcp, err := dnstest.NewTCPServer(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	fn([]model.Service{
+		{Address: udp.Addr, Type: "udp"},
+		{Address: tcp.Addr, Type: "tcp"},
+	})
+}
+
+// withSystemResolver runs fn with a mocked system resolver.
+func withSystemResolver(
+	addrs []string, err error, fn func(),
+) {
+	savedFunc := lookupIPAddr
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		var out []net.IPAddr
+		for _, addr := range addrs {
+			out = append(out, net.IPAddr{IP: net.ParseIP(addr)})
+		}
+		return out, err
+	}
+	defer func() { lookupIPAddr = savedFunc }()
+	fn()
+}
+
+// measure runs Measure draining the events.
+func measure(resolvers []model.Service, input string) *TestKeys {
+	var tk *TestKeys
+	nettesttest.Drain(func(out chan<- model.Event) {
+		tk = Measure(context.Background(), resolvers, input, out)
+	})
+	return tk
+}
+
+// TestMeasureConsistent checks whether we flag consistent answers.
+func TestMeasureConsistent(t *testing.T) {
+	withResolvers(t, func(resolvers []model.Service) {
+		withSystemResolver([]string{
+			"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946",
+		}, nil, func() {
+			tk := measure(resolvers, "example.com")
+			if tk.DNSConsistency != "consistent" {
+				t.Fatal("Expected consistent")
+			}
+			if len(tk.Inconsistent) != 0 {
+				t.Fatal("Expected no inconsistent resolvers")
+			}
+			if len(tk.Queries) != 3 || len(tk.ControlResolvers) != 2 {
+				t.Fatal("Unexpected number of queries or resolvers")
+			}
+			if len(tk.Queries[0].Answers) != 1 {
+				t.Fatal("We did not filter out IPv6 addresses")
+			}
+			for _, query := range tk.Queries[1:] {
+				if query.Failure != nil {
+					t.Fatal(*query.Failure)
+				}
+				if len(query.RawQuery) <= 0 || len(query.RawReply) <= 0 {
+					t.Fatal("Missing raw query or reply")
+				}
+			}
+			data, err := json.Marshal(tk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("%s", string(data))
+		})
+	})
+}
+
+// TestMeasureInconsistent checks whether we flag inconsistent answers.
+func TestMeasureInconsistent(t *testing.T) {
+	withResolvers(t, func(resolvers []model.Service) {
+		withSystemResolver([]string{"10.10.34.35"}, nil, func() {
+			tk := measure(resolvers, "example.com")
+			if tk.DNSConsistency != "inconsistent" {
+				t.Fatal("Expected inconsistent")
+			}
+			if len(tk.Inconsistent) != 2 {
+				t.Fatal("Expected two inconsistent resolvers")
+			}
+		})
+	})
+}
+
+// TestMeasureSystemFailure checks whether a system failure is
+// inconsistent when the control resolvers succeed.
+func TestMeasureSystemFailure(t *testing.T) {
+	withResolvers(t, func(resolvers []model.Service) {
+		withSystemResolver(nil, errors.New("mocked error"), func() {
+			tk := measure(resolvers, "example.com")
+			if tk.Queries[0].Failure == nil {
+				t.Fatal("Expected a system resolver failure")
+			}
+			if tk.DNSConsistency != "inconsistent" {
+				t.Fatal("Expected inconsistent")
+			}
+		})
+	})
+}
+
+// TestMeasureNXDOMAIN checks whether NXDOMAIN everywhere is consistent.
+func TestMeasureNXDOMAIN(t *testing.T) {
+	withResolvers(t, func(resolvers []model.Service) {
+		withSystemResolver(nil, &net.DNSError{
+			Err: "no such host", Name: "antani.example.com",
+		}, func() {
+			tk := measure(resolvers, "antani.example.com")
+			if tk.DNSConsistency != "consistent" {
+				t.Fatal("Expected consistent")
+			}
+		})
+	})
+}
+
+// TestMeasureNXDOMAINSystemFailure checks whether a system failure other
+// than NXDOMAIN is inconsistent when the domain does not exist.
+func TestMeasureNXDOMAINSystemFailure(t *testing.T) {
+	withResolvers(t, func(resolvers []model.Service) {
+		withSystemResolver(nil, errors.New("i/o timeout"), func() {
+			tk := measure(resolvers, "antani.example.com")
+			if tk.DNSConsistency != "inconsistent" {
+				t.Fatal("Expected inconsistent")
+			}
+		})
+	})
+}
+
+// TestMeasureNoIPv4 checks whether we fail without IPv4 addresses.
+func TestMeasureNoIPv4(t *testing.T) {
+	withResolvers(t, func(resolvers []model.Service) {
+		withSystemResolver([]string{"::1"}, nil, func() {
+			tk := measure(resolvers, "example.com")
+			if tk.Queries[0].Failure == nil {
+				t.Fatal("Expected a system resolver failure")
+			}
+		})
+	})
+}
+
+// TestMeasureControlFailure checks whether we cannot decide when
+// all the control resolvers fail.
+func TestMeasureControlFailure(t *testing.T) {
+	withSystemResolver([]string{"93.184.216.34"}, nil, func() {
+		tk := measure([]model.Service{
+			{Address: "antani", Type: "udp"},
+			{Address: "127.0.0.1:53", Type: "https"},
+		}, "example.com")
+		if tk.DNSConsistency != "" {
+			t.Fatal("Expected no consistency result")
+		}
+		if tk.Queries[1].Failure == nil || tk.Queries[2].Failure == nil {
+			t.Fatal("Expected control failures")
+		}
+	})
+}
+
+// TestMeasureNoResolvers checks whether we fail without resolvers.
+func TestMeasureNoResolvers(t *testing.T) {
+	tk := measure(nil, "example.com")
+	if tk.Failure == nil || *tk.Failure != errorx.Classify(ErrNoControlResolver) {
+		t.Fatal("Expected ErrNoControlResolver")
+	}
+}
+
+// TestResolversFromHelpers checks whether we use the right helpers.
+func TestResolversFromHelpers(t *testing.T) {
+	resolvers := resolversFromHelpers(map[string][]model.Service{
+		TestHelperName: {
+			{Address: "1.1.1.1:57004", Type: "legacy"},
+			{Address: "1.1.1.1:53", Type: "udp"},
+			{Address: "https://1.1.1.1/", Type: "https"},
+		},
+	})
+	if len(resolvers) != 2 {
+		t.Fatal("Unexpected number of resolvers")
+	}
+	if resolvers[0].Type != "tcp" || resolvers[1].Type != "udp" {
+		t.Fatal("Unexpected resolver types")
+	}
+}
+
+// TestNewNettest checks whether the nettest uses the test helpers.
+func TestNewNettest(t *testing.T) {
+	withResolvers(t, func(resolvers []model.Service) {
+		withSystemResolver([]string{"93.184.216.34"}, nil, func() {
+			nt := NewNettest(Config{})
+			nt.AvailableTestHelpers = map[string][]model.Service{
+				TestHelperName: resolvers,
+			}
+			measurement := nettesttest.Run(nt, "example.com")
+			if measurement.TestHelpers["backend"] != resolvers[0].Address {
+				t.Fatal("Unexpected backend test helper")
+			}
+			tk := measurement.TestKeys.(*TestKeys)
+			if tk.DNSConsistency != "consistent" {
+				t.Fatal("Expected consistent")
+			}
+		})
+	})
+}
@@ -0,0 +1,222 @@
+// Package fbmessenger implements the facebook_messenger nettest.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-019-facebook-messenger.md
+// for the nettest specification. We resolve the hostnames of the Facebook
+// Messenger services, we check whether the resolved addresses belong to
+// Facebook, and we connect to them using TCP.
+package fbmessenger
+
+import (
+	"context"
+	"net"
+
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/nettest/im"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+)
+
+// lookupHost allows to mock the system resolver in tests.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// Services contains the names of the Facebook Messenger services, in
+// the order in which we measure them. We use the names to build the
+// test keys names, e.g., facebook_b_api_dns_consistent.
+var Services = []string{
+	"stun", "b_api", "b_graph", "edge", "external_cdn", "scontent_cdn", "star",
+}
+
+// DefaultEndpoints maps each service to its "hostname:port" endpoint.
+var DefaultEndpoints = map[string]string{
+	"stun":         "stun.fbsbx.com:443",
+	"b_api":        "b-api.facebook.com:443",
+	"b_graph":      "b-graph.facebook.com:443",
+	"edge":         "edge-mqtt.facebook.com:443",
+	"external_cdn": "external.xx.fbcdn.net:443",
+	"scontent_cdn": "scontent.xx.fbcdn.net:443",
+	"star":         "star.c10r.facebook.com:443",
+}
+
+// DefaultNetblocks contains the default Facebook netblocks.
+var DefaultNetblocks = []string{
+	"31.13.24.0/21", "31.13.64.0/18", "45.64.40.0/22", "66.220.144.0/20",
+	"69.63.176.0/20", "69.171.224.0/19", "74.119.76.0/22", "102.132.96.0/20",
+	"103.4.96.0/22", "129.134.0.0/16", "147.75.208.0/20", "157.240.0.0/16",
+	"173.252.64.0/18", "179.60.192.0/22", "185.60.216.0/22", "185.89.216.0/22",
+	"204.15.20.0/22", "2620:0:1c00::/40", "2a03:2880::/32",
+}
+
+// Config contains the facebook_messenger nettest configuration.
+type Config struct {
+	// Endpoints maps each service in Services to its "hostname:port"
+	// endpoint. We use DefaultEndpoints for missing services.
+	Endpoints map[string]string
+
+	// Netblocks contains the netblocks, in CIDR notation, that belong
+	// to Facebook. When empty, we use DefaultNetblocks.
+	Netblocks []string
+}
+
+// TestKeys contains the facebook_messenger test keys. The per service
+// keys are null when we could not determine their value.
+type TestKeys struct {
+	FacebookBAPIDNSConsistent        *bool `json:"facebook_b_api_dns_consistent"`
+	FacebookBAPIReachable            *bool `json:"facebook_b_api_reachable"`
+	FacebookBGraphDNSConsistent      *bool `json:"facebook_b_graph_dns_consistent"`
+	FacebookBGraphReachable          *bool `json:"facebook_b_graph_reachable"`
+	FacebookEdgeDNSConsistent        *bool `json:"facebook_edge_dns_consistent"`
+	FacebookEdgeReachable            *bool `json:"facebook_edge_reachable"`
+	FacebookExternalCDNDNSConsistent *bool `json:"facebook_external_cdn_dns_consistent"`
+	FacebookExternalCDNReachable     *bool `json:"facebook_external_cdn_reachable"`
+	FacebookScontentCDNDNSConsistent *bool `json:"facebook_scontent_cdn_dns_consistent"`
+	FacebookScontentCDNReachable     *bool `json:"facebook_scontent_cdn_reachable"`
+	FacebookStarDNSConsistent        *bool `json:"facebook_star_dns_consistent"`
+	FacebookStarReachable            *bool `json:"facebook_star_reachable"`
+	FacebookSTUNDNSConsistent        *bool `json:"facebook_stun_dns_consistent"`
+	FacebookSTUNReachable            *bool `json:"facebook_stun_reachable"`
+
+	// FacebookDNSBlocking indicates whether the DNS of at least a
+	// service is not consistent.
+	FacebookDNSBlocking bool `json:"facebook_dns_blocking"`
+
+	// FacebookTCPBlocking indicates whether at least a service is
+	// not reachable.
+	FacebookTCPBlocking bool `json:"facebook_tcp_blocking"`
+
+	// Queries contains the DNS queries.
+	Queries []im.DNSQuery `json:"queries"`
+
+	// TCPConnect contains the TCP connects.
+	TCPConnect []im.TCPConnect `json:"tcp_connect"`
+}
+
+// keys returns pointers to the DNS consistent and reachable keys of
+// service. It returns nil pointers for unknown services.
+func (tk *TestKeys) keys(service string) (**bool, **bool) {
+	switch service {
+	case "b_api":
+		return &tk.FacebookBAPIDNSConsistent, &tk.FacebookBAPIReachable
+	case "b_graph":
+		return &tk.FacebookBGraphDNSConsistent, &tk.FacebookBGraphReachable
+	case "edge":
+		return &tk.FacebookEdgeDNSConsistent, &tk.FacebookEdgeReachable
+	case "external_cdn":
+		return &tk.FacebookExternalCDNDNSConsistent, &tk.FacebookExternalCDNReachable
+	case "scontent_cdn":
+		return &tk.FacebookScontentCDNDNSConsistent, &tk.FacebookScontentCDNReachable
+	case "star":
+		return &tk.FacebookStarDNSConsistent, &tk.FacebookStarReachable
+	case "stun":
+		return &tk.FacebookSTUNDNSConsistent, &tk.FacebookSTUNReachable
+	}
+	return nil, nil
+}
+
+// boolPointer returns a pointer to v.
+func boolPointer(v bool) *bool {
+	return &v
+}
+
+// parseNetblocks parses the netblocks, ignoring invalid ones.
+func parseNetblocks(netblocks []string) []*net.IPNet {
+	var out []*net.IPNet
+	for _, netblock := range netblocks {
+		if _, ipnet, err := net.ParseCIDR(netblock); err == nil {
+			out = append(out, ipnet)
+		}
+	}
+	return out
+}
+
+// consistent returns whether at least one of addrs is in netblocks.
+func consistent(addrs []string, netblocks []*net.IPNet) bool {
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		for _, netblock := range netblocks {
+			if ip != nil && netblock.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// measureService measures service using endpoint and fills tk. We
+// only connect to the addresses that belong to Facebook, and we do
+// not connect at all to the STUN service, which uses UDP.
+func measureService(
+	ctx context.Context, service, endpoint string, netblocks []*net.IPNet,
+	tk *TestKeys, out chan<- model.Event,
+) {
+	dnsConsistent, reachable := tk.keys(service)
+	hostname, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		out <- model.NewLogWarningEvent(err, "facebook_messenger: invalid endpoint")
+		return
+	}
+	out <- model.NewLogInfoEvent("facebook_messenger: resolving " + hostname)
+	query := im.Resolve(ctx, lookupHost, hostname)
+	tk.Queries = append(tk.Queries, query)
+	addrs := query.Addrs
+	*dnsConsistent = boolPointer(query.Failure == nil && consistent(addrs, netblocks))
+	if !**dnsConsistent {
+		tk.FacebookDNSBlocking = true
+		return
+	}
+	if service == "stun" {
+		return
+	}
+	*reachable = boolPointer(false)
+	for _, addr := range addrs {
+		if !consistent([]string{addr}, netblocks) {
+			continue
+		}
+		out <- model.NewLogInfoEvent("facebook_messenger: connecting to " + addr)
+		entry := im.Connect(ctx, net.JoinHostPort(addr, port))
+		tk.TCPConnect = append(tk.TCPConnect, entry)
+		if entry.Status.Success {
+			*reachable = boolPointer(true)
+		}
+	}
+	if !**reachable {
+		tk.FacebookTCPBlocking = true
+	}
+}
+
+// Measure runs facebook_messenger using config. It returns the test keys.
+func Measure(ctx context.Context, config Config, out chan<- model.Event) *TestKeys {
+	tk := &TestKeys{}
+	netblocks := config.Netblocks
+	if len(netblocks) <= 0 {
+		netblocks = DefaultNetblocks
+	}
+	parsed := parseNetblocks(netblocks)
+	for _, service := range Services {
+		endpoint, found := config.Endpoints[service]
+		if !found {
+			endpoint = DefaultEndpoints[service]
+		}
+		measureService(ctx, service, endpoint, parsed, tk, out)
+	}
+	return tk
+}
+
+// NewNettest creates a new facebook_messenger nettest. We do not use
+// the measurement input.
+func NewNettest(config Config) *nettest.Nettest {
+	return &nettest.Nettest{
+		TestName:        "facebook_messenger",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+		Main: func(
+			ctx context.Context,
+			input string,
+			measurement *model.Measurement,
+			out chan<- model.Event,
+		) {
+			measurement.TestKeys = Measure(ctx, config, out)
+		},
+	}
+}
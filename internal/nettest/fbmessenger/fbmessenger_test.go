@@ -0,0 +1,183 @@
+package fbmessenger
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// withListener runs fn with a local TCP listener.
+func withListener(t *testing.T, fn func(port string)) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn(port)
+}
+
+// withSystemResolver runs fn with a mocked system resolver using records.
+func withSystemResolver(records map[string][]string, fn func()) {
+	savedFunc := lookupHost
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		addrs, found := records[host]
+		if !found {
+			return nil, errors.New("mocked error")
+		}
+		return addrs, nil
+	}
+	defer func() { lookupHost = savedFunc }()
+	fn()
+}
+
+// newConfig returns a config where each service uses port.
+func newConfig(port string) Config {
+	config := Config{
+		Endpoints: make(map[string]string),
+		Netblocks: []string{"127.0.0.0/8", "antani"},
+	}
+	for _, service := range Services {
+		config.Endpoints[service] = net.JoinHostPort(service+".example", port)
+	}
+	return config
+}
+
+// newRecords returns records resolving every service to 127.0.0.1.
+func newRecords() map[string][]string {
+	records := make(map[string][]string)
+	for _, service := range Services {
+		records[service+".example"] = []string{"10.0.0.1", "127.0.0.1"}
+	}
+	return records
+}
+
+// measure runs Measure draining the events.
+func measure(config Config) *TestKeys {
+	var tk *TestKeys
+	nettesttest.Drain(func(out chan<- model.Event) {
+		tk = Measure(context.Background(), config, out)
+	})
+	return tk
+}
+
+// TestMeasureOK checks whether we do not flag blocking when
+// Facebook Messenger is reachable.
+func TestMeasureOK(t *testing.T) {
+	withListener(t, func(port string) {
+		withSystemResolver(newRecords(), func() {
+			tk := measure(newConfig(port))
+			if tk.FacebookDNSBlocking || tk.FacebookTCPBlocking {
+				t.Fatal("Unexpected blocking")
+			}
+			for _, service := range Services {
+				dnsConsistent, reachable := tk.keys(service)
+				if *dnsConsistent == nil || !**dnsConsistent {
+					t.Fatalf("Unexpected DNS inconsistency for %s", service)
+				}
+				if service == "stun" {
+					if *reachable != nil {
+						t.Fatal("We did not expect to connect to STUN")
+					}
+					continue
+				}
+				if *reachable == nil || !**reachable {
+					t.Fatalf("Unexpected unreachable %s", service)
+				}
+			}
+			if len(tk.Queries) != 7 || len(tk.TCPConnect) != 6 {
+				t.Fatal("Unexpected number of queries or connects")
+			}
+		})
+	})
+}
+
+// TestMeasureDNSBlocking checks whether we flag DNS blocking.
+func TestMeasureDNSBlocking(t *testing.T) {
+	withListener(t, func(port string) {
+		records := newRecords()
+		records["b_api.example"] = []string{"10.0.0.1"}
+		delete(records, "edge.example")
+		withSystemResolver(records, func() {
+			tk := measure(newConfig(port))
+			if !tk.FacebookDNSBlocking || tk.FacebookTCPBlocking {
+				t.Fatal("Expected only DNS blocking")
+			}
+			if *tk.FacebookBAPIDNSConsistent || *tk.FacebookEdgeDNSConsistent {
+				t.Fatal("Expected DNS inconsistency")
+			}
+			if tk.FacebookBAPIReachable != nil {
+				t.Fatal("We did not expect to connect")
+			}
+		})
+	})
+}
+
+// TestMeasureTCPBlocking checks whether we flag TCP blocking.
+func TestMeasureTCPBlocking(t *testing.T) {
+	var closedPort string
+	withListener(t, func(port string) {
+		closedPort = port
+	})
+	withSystemResolver(newRecords(), func() {
+		tk := measure(newConfig(closedPort))
+		if tk.FacebookDNSBlocking || !tk.FacebookTCPBlocking {
+			t.Fatal("Expected only TCP blocking")
+		}
+		if *tk.FacebookStarReachable {
+			t.Fatal("Expected unreachable service")
+		}
+	})
+}
+
+// TestMeasureInvalidEndpoint checks whether we deal with invalid endpoints.
+func TestMeasureInvalidEndpoint(t *testing.T) {
+	withSystemResolver(newRecords(), func() {
+		config := newConfig("443")
+		for _, service := range Services {
+			config.Endpoints[service] = "antani"
+		}
+		tk := measure(config)
+		if len(tk.Queries) != 0 || tk.FacebookBAPIDNSConsistent != nil {
+			t.Fatal("Unexpected result")
+		}
+	})
+}
+
+// TestKeysUnknownService checks whether keys deals with unknown services.
+func TestKeysUnknownService(t *testing.T) {
+	dnsConsistent, reachable := (&TestKeys{}).keys("antani")
+	if dnsConsistent != nil || reachable != nil {
+		t.Fatal("Expected nil pointers")
+	}
+}
+
+// TestNewNettest checks whether the nettest uses the config.
+func TestNewNettest(t *testing.T) {
+	withListener(t, func(port string) {
+		withSystemResolver(newRecords(), func() {
+			nt := NewNettest(newConfig(port))
+			measurement := nettesttest.Run(nt, "")
+			tk := measurement.TestKeys.(*TestKeys)
+			if tk.FacebookDNSBlocking || tk.FacebookTCPBlocking {
+				t.Fatal("Unexpected result")
+			}
+		})
+	})
+}
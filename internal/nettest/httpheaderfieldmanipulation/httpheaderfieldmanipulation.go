@@ -0,0 +1,271 @@
+// Package httpheaderfieldmanipulation implements the
+// http_header_field_manipulation nettest.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-006-header-field-manipulation.md
+// for the nettest specification. We send a request with randomly
+// capitalized header names to a test helper that replies with the
+// headers it received. A transparent HTTP proxy in the path is likely
+// to normalize, add, remove, or modify headers, hence we flag
+// tampering when what the test helper received differs from what
+// we sent.
+package httpheaderfieldmanipulation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/httpx"
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/nettest/httpheaderfieldmanipulation/jsonheaders"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+)
+
+// TestHelperName is the name of the test helper we use.
+const TestHelperName = "http-return-json-headers"
+
+// requestTimeout is the timeout for the request to the test helper.
+const requestTimeout = 30 * time.Second
+
+// ErrNoTestHelper indicates that no http-return-json-headers test
+// helper is available.
+var ErrNoTestHelper = errors.New("no available http-return-json-headers test helper")
+
+// requestHeaders contains the headers we send, before randomizing the
+// capitalization of their names. We cannot randomize the capitalization
+// of the Host header, which is always written by the standard library,
+// therefore we do not include it here and we do not compare it.
+var requestHeaders = map[string]string{
+	"Accept":          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+	"Accept-Charset":  "ISO-8859-1,utf-8;q=0.7,*;q=0.3",
+	"Accept-Encoding": "gzip,deflate,sdch",
+	"Accept-Language": "en-US,en;q=0.8",
+	"User-Agent":      "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/74.0.3729.169 Safari/537.36",
+}
+
+// HelperResponse is the response returned by the test helper.
+type HelperResponse = jsonheaders.Response
+
+// Tampering describes how the request was manipulated.
+type Tampering struct {
+	// HeaderFieldName indicates whether headers were added or removed.
+	HeaderFieldName bool `json:"header_field_name"`
+
+	// HeaderNameCapitalization indicates whether the capitalization of
+	// the name of at least a header was changed.
+	HeaderNameCapitalization bool `json:"header_name_capitalization"`
+
+	// HeaderNameDiff contains the lowercase names of the headers that
+	// were added or removed.
+	HeaderNameDiff []string `json:"header_name_diff"`
+
+	// HeadersAdded contains the lowercase names of the added headers.
+	HeadersAdded []string `json:"headers_added"`
+
+	// HeadersModified contains the lowercase names of the headers
+	// whose value was modified.
+	HeadersModified []string `json:"headers_modified"`
+
+	// HeadersRemoved contains the lowercase names of the removed headers.
+	HeadersRemoved []string `json:"headers_removed"`
+
+	// RequestLineCapitalization indicates whether the request line
+	// was modified.
+	RequestLineCapitalization bool `json:"request_line_capitalization"`
+
+	// Total indicates whether there was any kind of tampering.
+	Total bool `json:"total"`
+}
+
+// TestKeys contains the http_header_field_manipulation test keys.
+type TestKeys struct {
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// FailureDetails is the original error message, if any.
+	FailureDetails *string `json:"failure_details"`
+
+	// Received is what the test helper told us it received.
+	Received *HelperResponse `json:"received"`
+
+	// RequestHeaders contains the headers we sent.
+	RequestHeaders map[string]string `json:"request_headers"`
+
+	// RequestLine is the request line we sent.
+	RequestLine string `json:"request_line"`
+
+	// Tampering describes the tampering. It is null on failure.
+	Tampering *Tampering `json:"tampering"`
+}
+
+// randomCapitalization randomly changes the capitalization of s.
+func randomCapitalization(s string) string {
+	b := []byte(s)
+	for i := range b {
+		if rand.Intn(2) == 0 {
+			b[i] = strings.ToUpper(string(b[i]))[0]
+		} else {
+			b[i] = strings.ToLower(string(b[i]))[0]
+		}
+	}
+	return string(b)
+}
+
+// newRequestHeaders returns the headers to send.
+func newRequestHeaders() map[string]string {
+	headers := make(map[string]string)
+	for key, value := range requestHeaders {
+		headers[randomCapitalization(key)] = value
+	}
+	return headers
+}
+
+// compare compares the headers and the request line we sent with
+// the ones received by the test helper.
+func compare(
+	sent map[string]string, requestLine string, received HelperResponse,
+) *Tampering {
+	tampering := &Tampering{
+		RequestLineCapitalization: received.RequestLine != requestLine,
+	}
+	sentByLowerName := make(map[string]string)
+	for key := range sent {
+		sentByLowerName[strings.ToLower(key)] = key
+	}
+	receivedByLowerName := make(map[string]string)
+	for key := range received.HeadersDict {
+		lower := strings.ToLower(key)
+		if lower == "host" {
+			continue
+		}
+		receivedByLowerName[lower] = key
+		sentKey, found := sentByLowerName[lower]
+		if !found {
+			tampering.HeadersAdded = append(tampering.HeadersAdded, lower)
+			continue
+		}
+		if sentKey != key {
+			tampering.HeaderNameCapitalization = true
+		}
+		values := received.HeadersDict[key]
+		if len(values) != 1 || values[0] != sent[sentKey] {
+			tampering.HeadersModified = append(tampering.HeadersModified, lower)
+		}
+	}
+	for lower := range sentByLowerName {
+		if _, found := receivedByLowerName[lower]; !found {
+			tampering.HeadersRemoved = append(tampering.HeadersRemoved, lower)
+		}
+	}
+	sort.Strings(tampering.HeadersAdded)
+	sort.Strings(tampering.HeadersModified)
+	sort.Strings(tampering.HeadersRemoved)
+	tampering.HeaderNameDiff = append(
+		append([]string{}, tampering.HeadersAdded...),
+		tampering.HeadersRemoved...,
+	)
+	sort.Strings(tampering.HeaderNameDiff)
+	tampering.HeaderFieldName = len(tampering.HeaderNameDiff) > 0
+	tampering.Total = tampering.HeaderFieldName ||
+		tampering.HeaderNameCapitalization ||
+		len(tampering.HeadersModified) > 0 ||
+		tampering.RequestLineCapitalization
+	return tampering
+}
+
+// helperURL returns the URL of the first usable test helper.
+func helperURL(helpers map[string][]model.Service) string {
+	for _, e := range helpers[TestHelperName] {
+		if e.Type == "legacy" && strings.HasPrefix(e.Address, "http://") {
+			return e.Address
+		}
+	}
+	return ""
+}
+
+// Measure runs http_header_field_manipulation using the test helper
+// at helper. It returns the test keys.
+func Measure(
+	ctx context.Context, helper string, out chan<- model.Event,
+) *TestKeys {
+	tk := &TestKeys{RequestHeaders: newRequestHeaders()}
+	if helper == "" {
+		tk.Failure = errorx.FailureString(ErrNoTestHelper)
+		tk.FailureDetails = errorx.DetailsString(ErrNoTestHelper)
+		out <- model.NewFailureMeasurementEvent(0, ErrNoTestHelper)
+		return tk
+	}
+	URL, err := url.Parse(helper)
+	if err != nil {
+		tk.Failure = errorx.FailureString(err)
+		tk.FailureDetails = errorx.DetailsString(err)
+		out <- model.NewFailureMeasurementEvent(0, err)
+		return tk
+	}
+	tk.RequestLine = fmt.Sprintf("GET %s HTTP/1.1", URL.RequestURI())
+	headers := make(http.Header)
+	for key, value := range tk.RequestHeaders {
+		headers[key] = []string{value}
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	out <- model.NewLogInfoEvent("http_header_field_manipulation: contacting " + helper)
+	response, err := httpx.Request{
+		Ctx:     ctx,
+		Method:  "GET",
+		URL:     helper,
+		Headers: headers,
+	}.Perform()
+	if err != nil {
+		tk.Failure = errorx.FailureString(err)
+		tk.FailureDetails = errorx.DetailsString(err)
+		out <- model.NewFailureMeasurementEvent(0, err)
+		return tk
+	}
+	var received HelperResponse
+	if err := json.Unmarshal(response.Body, &received); err != nil {
+		err = fmt.Errorf("cannot parse JSON returned by test helper: %s", err.Error())
+		tk.Failure = errorx.FailureString(err)
+		tk.FailureDetails = errorx.DetailsString(err)
+		out <- model.NewFailureMeasurementEvent(0, err)
+		return tk
+	}
+	tk.Received = &received
+	tk.Tampering = compare(tk.RequestHeaders, tk.RequestLine, received)
+	return tk
+}
+
+// NewNettest creates a new http_header_field_manipulation nettest. We
+// do not use the measurement input. We use the "http-return-json-headers"
+// test helper in the nettest AvailableTestHelpers.
+func NewNettest() *nettest.Nettest {
+	nt := &nettest.Nettest{
+		TestName:        "http_header_field_manipulation",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+	}
+	nt.Main = func(
+		ctx context.Context,
+		input string,
+		measurement *model.Measurement,
+		out chan<- model.Event,
+	) {
+		helper := helperURL(nt.AvailableTestHelpers)
+		if helper != "" {
+			measurement.TestHelpers = map[string]string{"backend": helper}
+		}
+		measurement.TestKeys = Measure(ctx, helper, out)
+	}
+	return nt
+}
@@ -0,0 +1,175 @@
+package httpheaderfieldmanipulation
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/measurement-kit/engine/internal/nettest/httpheaderfieldmanipulation/jsonheaders"
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// withTestHelper runs fn with a local stand-in for the test helper.
+func withTestHelper(t *testing.T, fn func(URL string)) {
+	server, err := jsonheaders.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	fn(server.URL)
+}
+
+// withProxy runs fn with a reverse proxy in front of the test helper,
+// which canonicalizes the header names and adds X-Forwarded-For.
+func withProxy(t *testing.T, fn func(URL string)) {
+	withTestHelper(t, func(helper string) {
+		URL, err := url.Parse(helper)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := httptest.NewServer(httputil.NewSingleHostReverseProxy(URL))
+		defer server.Close()
+		fn(server.URL + "/")
+	})
+}
+
+// measure runs Measure draining the events.
+func measure(helper string) *TestKeys {
+	var tk *TestKeys
+	nettesttest.Drain(func(out chan<- model.Event) {
+		tk = Measure(context.Background(), helper, out)
+	})
+	return tk
+}
+
+// TestMeasureNoTampering checks whether we do not flag tampering when
+// there is no proxy between us and the test helper.
+func TestMeasureNoTampering(t *testing.T) {
+	withTestHelper(t, func(URL string) {
+		tk := measure(URL)
+		if tk.Failure != nil {
+			t.Fatal(*tk.Failure)
+		}
+		if tk.Tampering == nil || tk.Tampering.Total {
+			t.Fatalf("Unexpected tampering: %+v", tk.Tampering)
+		}
+		if tk.RequestLine != "GET / HTTP/1.1" {
+			t.Fatal("Unexpected request line")
+		}
+	})
+}
+
+// TestMeasureTampering checks whether we flag tampering when there
+// is a proxy between us and the test helper.
+func TestMeasureTampering(t *testing.T) {
+	withProxy(t, func(URL string) {
+		tk := measure(URL)
+		if tk.Failure != nil {
+			t.Fatal(*tk.Failure)
+		}
+		if tk.Tampering == nil || !tk.Tampering.Total {
+			t.Fatal("Expected tampering")
+		}
+		if !tk.Tampering.HeaderNameCapitalization {
+			t.Fatal("Expected header name capitalization")
+		}
+		if !reflect.DeepEqual(tk.Tampering.HeadersAdded, []string{"x-forwarded-for"}) {
+			t.Fatalf("Unexpected added headers: %+v", tk.Tampering.HeadersAdded)
+		}
+	})
+}
+
+// TestMeasureFailures checks whether we deal with failures.
+func TestMeasureFailures(t *testing.T) {
+	inputs := []string{"", "\t", "http://127.0.0.1:0/"}
+	for _, input := range inputs {
+		tk := measure(input)
+		if tk.Failure == nil {
+			t.Fatalf("Expected a failure with %q", input)
+		}
+		if tk.Tampering != nil {
+			t.Fatal("Unexpected tampering")
+		}
+	}
+}
+
+// TestMeasureInvalidJSON checks whether we deal with invalid JSON.
+func TestMeasureInvalidJSON(t *testing.T) {
+	server := httptest.NewServer(nil)
+	defer server.Close()
+	tk := measure(server.URL + "/")
+	if tk.Failure == nil {
+		t.Fatal("Expected a failure")
+	}
+}
+
+// TestCompare checks whether compare classifies manipulations.
+func TestCompare(t *testing.T) {
+	sent := map[string]string{"aCcEpT": "*/*", "X-Antani": "a", "X-Mascetti": "b"}
+	tampering := compare(sent, "GET / HTTP/1.1", HelperResponse{
+		HeadersDict: map[string][]string{
+			"Host":       {"example.com"},
+			"aCcEpT":     {"*/*"},
+			"X-ANTANI":   {"a"},
+			"X-Mascetti": {"c"},
+			"Via":        {"1.1 proxy"},
+		},
+		RequestLine: "GET / HTTP/1.1",
+	})
+	expected := &Tampering{
+		HeaderFieldName:          true,
+		HeaderNameCapitalization: true,
+		HeaderNameDiff:           []string{"via"},
+		HeadersAdded:             []string{"via"},
+		HeadersModified:          []string{"x-mascetti"},
+		Total:                    true,
+	}
+	if !reflect.DeepEqual(tampering, expected) {
+		t.Fatalf("Unexpected tampering: %+v", tampering)
+	}
+	tampering = compare(sent, "GET / HTTP/1.1", HelperResponse{
+		HeadersDict: map[string][]string{"aCcEpT": {"*/*"}},
+		RequestLine: "get / HTTP/1.1",
+	})
+	if !reflect.DeepEqual(tampering.HeadersRemoved, []string{"x-antani", "x-mascetti"}) {
+		t.Fatal("Unexpected removed headers")
+	}
+	if !tampering.RequestLineCapitalization || !tampering.Total {
+		t.Fatal("Expected request line tampering")
+	}
+}
+
+// TestHelperURL checks whether we pick the right test helper.
+func TestHelperURL(t *testing.T) {
+	URL := helperURL(map[string][]model.Service{
+		TestHelperName: {
+			{Address: "1.1.1.1", Type: "legacy"},
+			{Address: "http://1.1.1.1:80", Type: "legacy"},
+		},
+	})
+	if URL != "http://1.1.1.1:80" {
+		t.Fatal("Unexpected URL")
+	}
+}
+
+// TestNewNettest checks whether the nettest uses the test helpers.
+func TestNewNettest(t *testing.T) {
+	withTestHelper(t, func(URL string) {
+		nt := NewNettest()
+		nt.AvailableTestHelpers = map[string][]model.Service{
+			TestHelperName: {{Address: URL, Type: "legacy"}},
+		}
+		measurement := nettesttest.Run(nt, "")
+		if measurement.TestHelpers["backend"] != URL {
+			t.Fatal("Unexpected backend test helper")
+		}
+		tk := measurement.TestKeys.(*TestKeys)
+		if tk.Failure != nil || tk.Tampering.Total {
+			t.Fatal("Unexpected result")
+		}
+	})
+}
@@ -0,0 +1,118 @@
+// Package jsonheaders implements a stand-in for the OONI
+// http-return-json-headers test helper.
+//
+// The test helper replies with a JSON describing the request line and
+// the headers it received. Since the net/http server canonicalizes
+// header names, we parse requests ourselves to preserve them verbatim.
+package jsonheaders
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// maxHeaders is the maximum number of headers we accept.
+const maxHeaders = 100
+
+// Response is the response returned by the test helper.
+type Response struct {
+	// HeadersDict maps each header name to its values.
+	HeadersDict map[string][]string `json:"headers_dict"`
+
+	// RequestHeaders contains the headers as name, value pairs in
+	// the order in which we received them.
+	RequestHeaders [][]string `json:"request_headers"`
+
+	// RequestLine is the request line.
+	RequestLine string `json:"request_line"`
+}
+
+// readRequest reads the request line and the headers from reader.
+func readRequest(reader *bufio.Reader) (Response, error) {
+	response := Response{HeadersDict: make(map[string][]string)}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return response, err
+	}
+	response.RequestLine = strings.TrimRight(line, "\r\n")
+	for i := 0; i < maxHeaders; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return response, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return response, nil
+		}
+		index := strings.Index(line, ":")
+		if index < 0 {
+			return response, fmt.Errorf("invalid header line: %s", line)
+		}
+		key, value := line[:index], strings.TrimSpace(line[index+1:])
+		response.RequestHeaders = append(
+			response.RequestHeaders, []string{key, value},
+		)
+		response.HeadersDict[key] = append(response.HeadersDict[key], value)
+	}
+	return response, fmt.Errorf("too many headers")
+}
+
+// serve serves a single request received on conn.
+func serve(conn net.Conn) {
+	defer conn.Close()
+	response, err := readRequest(bufio.NewReader(conn))
+	if err != nil {
+		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n"))
+		return
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n"+
+		"Content-Length: %d\r\nConnection: close\r\n\r\n", len(data))
+	conn.Write(data)
+}
+
+// Server is a test helper listening on the loopback interface.
+type Server struct {
+	// URL is the test helper URL.
+	URL string
+
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a new test helper.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{
+		URL:      "http://" + listener.Addr().String() + "/",
+		listener: listener,
+	}
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return server, nil
+}
+
+// Close stops the test helper.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
@@ -0,0 +1,71 @@
+package jsonheaders
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestServer checks whether the server returns the raw headers.
+func TestServer(t *testing.T) {
+	server, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("tcp", strings.TrimSuffix(
+		strings.TrimPrefix(server.URL, "http://"), "/",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(
+		"gEt / HTTP/1.1\r\nhOsT: example.com\r\nX-Antani: a\r\nX-Antani: b\r\n\r\n",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result Response
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.RequestLine != "gEt / HTTP/1.1" {
+		t.Fatal("Unexpected request line")
+	}
+	if len(result.RequestHeaders) != 3 || result.RequestHeaders[0][0] != "hOsT" {
+		t.Fatal("Unexpected request headers")
+	}
+	if len(result.HeadersDict["X-Antani"]) != 2 {
+		t.Fatal("Unexpected headers dict")
+	}
+}
+
+// TestReadRequestErrors checks whether readRequest deals with errors.
+func TestReadRequestErrors(t *testing.T) {
+	inputs := []string{
+		"",
+		"GET / HTTP/1.1\r\n",
+		"GET / HTTP/1.1\r\nantani\r\n\r\n",
+		"GET / HTTP/1.1\r\n" + strings.Repeat("X-Antani: a\r\n", maxHeaders+1),
+	}
+	for _, input := range inputs {
+		_, err := readRequest(bufio.NewReader(strings.NewReader(input)))
+		if err == nil {
+			t.Fatalf("We expected an error with %q", input)
+		}
+	}
+}
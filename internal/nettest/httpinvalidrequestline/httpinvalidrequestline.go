@@ -0,0 +1,190 @@
+// Package httpinvalidrequestline implements the http_invalid_request_line
+// nettest.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-007-http-invalid-request-line.md
+// for the nettest specification. We send malformed HTTP request lines to
+// a TCP echo test helper. A transparent HTTP proxy in the path is likely
+// to reject or rewrite them, hence we flag tampering when what we
+// receive differs from what we sent.
+package httpinvalidrequestline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+)
+
+// TestHelperName is the name of the test helper we use.
+const TestHelperName = "tcp-echo"
+
+// defaultPort is the port we use when the test helper address does
+// not specify a port, as is the case for the OONI bouncer.
+const defaultPort = "80"
+
+// connectTimeout is the timeout for each TCP connect.
+const connectTimeout = 10 * time.Second
+
+// readTimeout is the maximum time we wait for the echoed data.
+const readTimeout = 5 * time.Second
+
+// ErrNoTestHelper indicates that no tcp-echo test helper is available.
+var ErrNoTestHelper = errors.New("no available tcp-echo test helper")
+
+// TestKeys contains the http_invalid_request_line test keys.
+type TestKeys struct {
+	// Failure is the first error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// FailureDetails is the original error message, if any.
+	FailureDetails *string `json:"failure_details"`
+
+	// Received contains what we received for each request line.
+	Received []string `json:"received"`
+
+	// Sent contains the request lines we sent.
+	Sent []string `json:"sent"`
+
+	// Tampering indicates whether what we received differs from what
+	// we sent for at least a request line.
+	Tampering bool `json:"tampering"`
+}
+
+// randomString returns a random alphanumeric string of length n.
+func randomString(n int) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = alphabet[rand.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
+// requestLines returns the malformed request lines we send.
+func requestLines() []string {
+	fields := []string{
+		randomString(5), randomString(5), randomString(5), randomString(5),
+	}
+	return []string{
+		// random_invalid_method
+		randomString(4) + " / HTTP/1.1\n\r",
+		// random_invalid_field_count
+		strings.Join(fields, " ") + "\n\r",
+		// random_big_request_method
+		randomString(1024) + " / HTTP/1.1\n\r",
+		// random_invalid_version_number
+		"GET / HTTP/" + randomString(3) + "\n\r",
+		// squid_cache_manager
+		"GET cache_object://localhost/ HTTP/1.0\n\r",
+	}
+}
+
+// isTimeout returns whether err is a timeout.
+func isTimeout(err error) bool {
+	operr, ok := err.(net.Error)
+	return ok && operr.Timeout()
+}
+
+// send sends line to the echo server at address and returns what we
+// receive back. We stop reading when we have received as many bytes
+// as we sent, when the server closes the connection, or on timeout.
+func send(ctx context.Context, address, line string) (string, error) {
+	dialer := net.Dialer{Timeout: connectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(readTimeout))
+	if _, err = conn.Write([]byte(line)); err != nil {
+		return "", err
+	}
+	data := make([]byte, len(line))
+	n, err := io.ReadFull(conn, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF || isTimeout(err) {
+		err = nil
+	}
+	return string(data[:n]), err
+}
+
+// helperAddress returns the address of the first usable test helper.
+func helperAddress(helpers map[string][]model.Service) string {
+	for _, e := range helpers[TestHelperName] {
+		if e.Type != "legacy" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(e.Address); err == nil {
+			return e.Address
+		}
+		return net.JoinHostPort(e.Address, defaultPort)
+	}
+	return ""
+}
+
+// Measure runs http_invalid_request_line using the echo test helper
+// listening at address. It returns the test keys.
+func Measure(
+	ctx context.Context, address string, out chan<- model.Event,
+) *TestKeys {
+	tk := &TestKeys{}
+	if address == "" {
+		tk.Failure = errorx.FailureString(ErrNoTestHelper)
+		tk.FailureDetails = errorx.DetailsString(ErrNoTestHelper)
+		out <- model.NewFailureMeasurementEvent(0, ErrNoTestHelper)
+		return tk
+	}
+	for _, line := range requestLines() {
+		out <- model.NewLogInfoEvent(
+			"http_invalid_request_line: sending request line to " + address,
+		)
+		received, err := send(ctx, address, line)
+		tk.Sent = append(tk.Sent, line)
+		tk.Received = append(tk.Received, received)
+		if err != nil {
+			out <- model.NewLogWarningEvent(err, "http_invalid_request_line")
+			if tk.Failure == nil {
+				tk.Failure = errorx.FailureString(err)
+				tk.FailureDetails = errorx.DetailsString(err)
+			}
+			continue
+		}
+		if received != line {
+			tk.Tampering = true
+		}
+	}
+	return tk
+}
+
+// NewNettest creates a new http_invalid_request_line nettest. We do
+// not use the measurement input. We use the "tcp-echo" test helper
+// in the nettest AvailableTestHelpers.
+func NewNettest() *nettest.Nettest {
+	nt := &nettest.Nettest{
+		TestName:        "http_invalid_request_line",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+	}
+	nt.Main = func(
+		ctx context.Context,
+		input string,
+		measurement *model.Measurement,
+		out chan<- model.Event,
+	) {
+		address := helperAddress(nt.AvailableTestHelpers)
+		if address != "" {
+			measurement.TestHelpers = map[string]string{"backend": address}
+		}
+		measurement.TestKeys = Measure(ctx, address, out)
+	}
+	return nt
+}
@@ -0,0 +1,148 @@
+package httpinvalidrequestline
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest/httpinvalidrequestline/tcpecho"
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// withEcho runs fn with a local stand-in for the tcp-echo test helper.
+func withEcho(t *testing.T, fn func(address string)) {
+	server, err := tcpecho.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	fn(server.Addr)
+}
+
+// withProxy runs fn with a server behaving like a transparent HTTP
+// proxy, i.e., replying with an error to the malformed request line.
+func withProxy(t *testing.T, fn func(address string)) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			bufio.NewReader(conn).ReadString('\r')
+			conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+	fn(listener.Addr().String())
+}
+
+// measure runs Measure draining the events.
+func measure(address string) *TestKeys {
+	var tk *TestKeys
+	nettesttest.Drain(func(out chan<- model.Event) {
+		tk = Measure(context.Background(), address, out)
+	})
+	return tk
+}
+
+// TestMeasureNoTampering checks whether we do not flag tampering
+// when the request lines are echoed back.
+func TestMeasureNoTampering(t *testing.T) {
+	withEcho(t, func(address string) {
+		tk := measure(address)
+		if tk.Failure != nil {
+			t.Fatal(*tk.Failure)
+		}
+		if tk.Tampering {
+			t.Fatal("Unexpected tampering")
+		}
+		if len(tk.Sent) != 5 || len(tk.Received) != 5 {
+			t.Fatal("Unexpected number of request lines")
+		}
+	})
+}
+
+// TestMeasureTampering checks whether we flag tampering when the
+// request lines are not echoed back.
+func TestMeasureTampering(t *testing.T) {
+	withProxy(t, func(address string) {
+		tk := measure(address)
+		if tk.Failure != nil {
+			t.Fatal(*tk.Failure)
+		}
+		if !tk.Tampering {
+			t.Fatal("Expected tampering")
+		}
+		if !strings.HasPrefix(tk.Received[0], "HTTP/1.1 400") {
+			t.Fatal("Unexpected received data")
+		}
+	})
+}
+
+// TestMeasureConnectFailure checks whether we deal with connect errors.
+func TestMeasureConnectFailure(t *testing.T) {
+	tk := measure("antani")
+	if tk.Failure == nil {
+		t.Fatal("Expected a failure")
+	}
+	if tk.Tampering {
+		t.Fatal("Unexpected tampering")
+	}
+}
+
+// TestMeasureNoTestHelper checks whether we fail without test helper.
+func TestMeasureNoTestHelper(t *testing.T) {
+	tk := measure("")
+	if tk.Failure == nil || *tk.Failure != errorx.Classify(ErrNoTestHelper) {
+		t.Fatal("Expected ErrNoTestHelper")
+	}
+}
+
+// TestHelperAddress checks whether we pick the right test helper.
+func TestHelperAddress(t *testing.T) {
+	address := helperAddress(map[string][]model.Service{
+		TestHelperName: {
+			{Address: "https://1.1.1.1/", Type: "https"},
+			{Address: "1.1.1.1", Type: "legacy"},
+		},
+	})
+	if address != "1.1.1.1:80" {
+		t.Fatal("Unexpected address")
+	}
+	address = helperAddress(map[string][]model.Service{
+		TestHelperName: {{Address: "1.1.1.1:7", Type: "legacy"}},
+	})
+	if address != "1.1.1.1:7" {
+		t.Fatal("Unexpected address with port")
+	}
+	if helperAddress(nil) != "" {
+		t.Fatal("Expected no address")
+	}
+}
+
+// TestNewNettest checks whether the nettest uses the test helpers.
+func TestNewNettest(t *testing.T) {
+	withEcho(t, func(address string) {
+		nt := NewNettest()
+		nt.AvailableTestHelpers = map[string][]model.Service{
+			TestHelperName: {{Address: address, Type: "legacy"}},
+		}
+		measurement := nettesttest.Run(nt, "")
+		if measurement.TestHelpers["backend"] != address {
+			t.Fatal("Unexpected backend test helper")
+		}
+		tk := measurement.TestKeys.(*TestKeys)
+		if tk.Failure != nil || tk.Tampering {
+			t.Fatal("Unexpected result")
+		}
+	})
+}
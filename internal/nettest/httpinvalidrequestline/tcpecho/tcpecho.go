@@ -0,0 +1,47 @@
+// Package tcpecho implements a TCP echo server for testing.
+package tcpecho
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// Server is a TCP echo server listening on the loopback interface.
+type Server struct {
+	// Addr is the address where the server is listening.
+	Addr string
+
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a new TCP echo server.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{Addr: listener.Addr().String(), listener: listener}
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return server, nil
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
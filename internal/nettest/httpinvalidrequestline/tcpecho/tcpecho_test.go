@@ -0,0 +1,31 @@
+package tcpecho
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// TestServer checks whether the server echoes what we send.
+func TestServer(t *testing.T) {
+	server, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("antani")); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 6)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "antani" {
+		t.Fatal("Unexpected echoed data")
+	}
+}
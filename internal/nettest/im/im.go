@@ -0,0 +1,123 @@
+// Package im contains the code shared by the instant messaging nettests,
+// i.e., telegram, whatsapp, and facebook_messenger.
+//
+// All these nettests resolve the hostnames of the service endpoints, if
+// needed, connect to the resulting addresses using TCP, and possibly send
+// HTTP requests. We use the same data format for all of them.
+package im
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+)
+
+// connectTimeout is the timeout for each TCP connect.
+const connectTimeout = 10 * time.Second
+
+// httpTimeout is the timeout for each HTTP request.
+const httpTimeout = 15 * time.Second
+
+// DNSQuery is a DNS query and its answers.
+type DNSQuery struct {
+	// Addrs contains the resolved addresses.
+	Addrs []string `json:"addrs"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Hostname is the hostname we resolved.
+	Hostname string `json:"hostname"`
+}
+
+// TCPConnectStatus is the status of a TCP connect.
+type TCPConnectStatus struct {
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Success indicates whether we could connect.
+	Success bool `json:"success"`
+}
+
+// TCPConnect is a TCP connect to an endpoint.
+type TCPConnect struct {
+	// IP is the IP address we connected to.
+	IP string `json:"ip"`
+
+	// Port is the port we connected to.
+	Port int `json:"port"`
+
+	// Status is the connect status.
+	Status TCPConnectStatus `json:"status"`
+}
+
+// HTTPRequest is an HTTP request and its result.
+type HTTPRequest struct {
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Method is the request method.
+	Method string `json:"method"`
+
+	// StatusCode is the response status code, or zero on failure.
+	StatusCode int64 `json:"status_code"`
+
+	// URL is the request URL.
+	URL string `json:"url"`
+}
+
+// LookupHostFunc is the signature of net.Resolver.LookupHost. The
+// nettests pass it to Resolve, such that they can mock it in tests.
+type LookupHostFunc = func(ctx context.Context, hostname string) ([]string, error)
+
+// Resolve resolves hostname using lookupHost and returns the query.
+func Resolve(ctx context.Context, lookupHost LookupHostFunc, hostname string) DNSQuery {
+	addrs, err := lookupHost(ctx, hostname)
+	return DNSQuery{
+		Addrs: addrs, Failure: errorx.FailureString(err), Hostname: hostname,
+	}
+}
+
+// Connect connects to the "ip:port" endpoint and returns the result.
+func Connect(ctx context.Context, endpoint string) TCPConnect {
+	var entry TCPConnect
+	host, port, err := net.SplitHostPort(endpoint)
+	if err == nil {
+		entry.IP = host
+		entry.Port, _ = strconv.Atoi(port)
+		dialer := net.Dialer{Timeout: connectTimeout}
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, "tcp", endpoint)
+		if err == nil {
+			conn.Close()
+		}
+	}
+	entry.Status.Failure = errorx.FailureString(err)
+	entry.Status.Success = err == nil
+	return entry
+}
+
+// Request sends a request with method to URL and returns the result. We
+// consider any response a success, regardless of the status code.
+func Request(ctx context.Context, method, URL string) HTTPRequest {
+	result := HTTPRequest{Method: method, URL: URL}
+	ctx, cancel := context.WithTimeout(ctx, httpTimeout)
+	defer cancel()
+	req, err := http.NewRequest(method, URL, nil)
+	if err != nil {
+		result.Failure = errorx.FailureString(err)
+		return result
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		result.Failure = errorx.FailureString(err)
+		return result
+	}
+	resp.Body.Close()
+	result.StatusCode = int64(resp.StatusCode)
+	return result
+}
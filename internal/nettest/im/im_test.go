@@ -0,0 +1,71 @@
+package im
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestResolve checks whether we record the query.
+func TestResolve(t *testing.T) {
+	query := Resolve(context.Background(), func(
+		ctx context.Context, hostname string,
+	) ([]string, error) {
+		return []string{"127.0.0.1"}, nil
+	}, "www.example.com")
+	if query.Hostname != "www.example.com" || len(query.Addrs) != 1 || query.Failure != nil {
+		t.Fatalf("Unexpected query: %+v", query)
+	}
+	query = Resolve(context.Background(), func(
+		ctx context.Context, hostname string,
+	) ([]string, error) {
+		return nil, errors.New("mocked error")
+	}, "www.example.com")
+	if query.Failure == nil || query.Addrs != nil {
+		t.Fatalf("Unexpected query: %+v", query)
+	}
+}
+
+// TestConnect checks whether we record successes and failures.
+func TestConnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := listener.Addr().String()
+	entry := Connect(context.Background(), endpoint)
+	if !entry.Status.Success || entry.IP != "127.0.0.1" || entry.Port == 0 {
+		t.Fatalf("Unexpected entry: %+v", entry)
+	}
+	listener.Close()
+	entry = Connect(context.Background(), endpoint)
+	if entry.Status.Success || entry.Status.Failure == nil {
+		t.Fatal("We expected a failure")
+	}
+	entry = Connect(context.Background(), "antani")
+	if entry.Status.Success || entry.Status.Failure == nil {
+		t.Fatal("We expected a failure with an invalid endpoint")
+	}
+}
+
+// TestRequest checks whether we record the status code and failures.
+func TestRequest(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	result := Request(context.Background(), "GET", server.URL)
+	if result.Failure != nil || result.StatusCode != 404 {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+	result = Request(context.Background(), "\t", server.URL)
+	if result.Failure == nil {
+		t.Fatal("We expected a failure with an invalid method")
+	}
+	URL := strings.Replace(server.URL, "http://", "antani://", 1)
+	if result = Request(context.Background(), "GET", URL); result.Failure == nil {
+		t.Fatal("We expected a failure with an invalid URL")
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	upstream "github.com/m-lab/ndt7-client-go"
 	upstreamSpec "github.com/m-lab/ndt7-client-go/spec"
+	"github.com/measurement-kit/engine/internal/errorx"
 	"github.com/measurement-kit/engine/internal/nettest"
 	"github.com/measurement-kit/engine/internal/version"
 	"github.com/measurement-kit/engine/model"
@@ -21,6 +22,9 @@ type testKeys struct {
 	// Failure is the failure string
 	Failure string `json:"failure"`
 
+	// FailureDetails is the original error message
+	FailureDetails string `json:"failure_details"`
+
 	// Download contains download results
 	Download []upstreamSpec.Measurement `json:"download"`
 
@@ -40,7 +44,8 @@ func run(
 	client := upstream.NewClient("MKengine/" + version.Version)
 	ch, err := client.StartDownload(ctx)
 	if err != nil {
-		testkeys.Failure = err.Error()
+		testkeys.Failure = errorx.Classify(err)
+		testkeys.FailureDetails = errorx.Details(err)
 		out <- model.NewFailureMeasurementEvent(0, err)
 		return
 	}
@@ -53,7 +58,8 @@ func run(
 	}
 	ch, err = client.StartUpload(ctx)
 	if err != nil {
-		testkeys.Failure = err.Error()
+		testkeys.Failure = errorx.Classify(err)
+		testkeys.FailureDetails = errorx.Details(err)
 		out <- model.NewFailureMeasurementEvent(0, err)
 		return
 	}
@@ -63,6 +63,11 @@
 // are supported. We'll try them in order and use the first one
 // that successfully returns us a valid response.
 //
+// On censored networks, the first bouncers may time out. In such case,
+// set nettest.RaceBackends to query all the bouncers concurrently and use
+// the first valid response. This also causes OpenReport to probe the
+// collectors in parallel and to try them in order of latency.
+//
 // Discovering collectors
 //
 // We recommend you to automatically discover collectors. Otherwise
@@ -81,6 +86,12 @@
 // bouncer. You can set the nettest.AvailableBouncers field to
 // force the code to use one, or few, specific bouncers.
 //
+// To avoid querying the bouncer every time you run a nettest, set
+// the nettest.BouncerCacheDir field to a directory where we will cache
+// the bouncer responses. We reuse cached responses until they are older
+// than nettest.BouncerCacheTTL and we fall back to stale responses when
+// we cannot reach the bouncer.
+//
 // Alternatively, just populate nettest.AvailableCollectors manually.
 //
 // Discovering test helpers
@@ -101,6 +112,19 @@
 //
 // Alternatively, just populate nettest.AvailableTestHelpers manually.
 //
+// Discovering collectors and test helpers at once
+//
+// If you need both collectors and test helpers, use instead:
+//
+//     err = nettest.DiscoverAvailableServices(ctx)
+//     if err != nil {
+//       return
+//     }
+//
+// This will populate both the nettest.AvailableCollectors and the
+// nettest.AvailableTestHelpers fields using a single round trip with
+// bouncers implementing the combined probe services API.
+//
 // Geolocation
 //
 // Geolocating a probe means discover its IP, CC (country code),
@@ -219,10 +243,12 @@ package nettest
 import (
 	"context"
 	"errors"
+	"sort"
 	"time"
 
 	"github.com/measurement-kit/engine/internal/bouncer"
 	"github.com/measurement-kit/engine/internal/collector"
+	"github.com/measurement-kit/engine/internal/resolver"
 	"github.com/measurement-kit/engine/model"
 )
 
@@ -273,6 +299,20 @@ type Nettest struct {
 	// AvailableBouncers contains all the available bouncers.
 	AvailableBouncers []model.Service
 
+	// BouncerCacheDir is the optional directory where we cache the
+	// services returned by bouncers. When it is empty, we query the
+	// bouncers every time we discover services.
+	BouncerCacheDir string
+
+	// BouncerCacheTTL is the time after which cached services are
+	// stale. When zero, we use bouncer.DefaultCacheTTL.
+	BouncerCacheTTL time.Duration
+
+	// RaceBackends indicates whether we should query all the bouncers
+	// concurrently, rather than in order, and probe the collectors in
+	// parallel to try them in order of latency when opening a report.
+	RaceBackends bool
+
 	// AvailableCollectors contains all the available collectors.
 	AvailableCollectors []model.Service
 
@@ -300,6 +340,11 @@ type Nettest struct {
 	// ResolverIP is the resolver's IP.
 	ResolverIP string
 
+	// Resolver is the optional resolver we use to resolve the names
+	// of the bouncers and of the collectors. When nil, we use the
+	// system resolver.
+	Resolver resolver.Resolver
+
 	// Report is the report bound to this nettest.
 	Report collector.Report
 }
@@ -319,40 +364,116 @@ func (nettest *Nettest) getAvailableBouncers() []model.Service {
 	}
 }
 
-// DiscoverAvailableCollectors discovers the available collectors.
-func (nettest *Nettest) DiscoverAvailableCollectors(ctx context.Context) error {
+// queryFunc queries a bouncer.
+type queryFunc = bouncer.QueryFunc
+
+// raceBouncers queries all the bouncers concurrently using query and
+// returns the services returned by the first one that succeeds. We
+// cancel the pending queries as soon as we have a good answer.
+func (nettest *Nettest) raceBouncers(
+	ctx context.Context, query queryFunc, bouncers []model.Service,
+) (bouncer.Services, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		services bouncer.Services
+		err      error
+	}
+	results := make(chan result, len(bouncers))
+	for _, e := range bouncers {
+		go func(address string) {
+			services, err := query(ctx, bouncer.Config{
+				BaseURL:  address,
+				Resolver: nettest.Resolver,
+			})
+			results <- result{services: services, err: err}
+		}(e.Address)
+	}
+	for range bouncers {
+		r := <-results
+		if r.err == nil {
+			return r.services, nil
+		}
+	}
+	return bouncer.Services{}, errors.New("all bouncers failed")
+}
+
+// queryBouncers queries the available HTTPS bouncers in order using
+// query and returns the services returned by the first one that
+// succeeds. When the cache is configured, we wrap query with it, using
+// kind to identify the query. When RaceBackends is true, we query the
+// bouncers concurrently.
+func (nettest *Nettest) queryBouncers(
+	ctx context.Context, kind string, query queryFunc,
+) (bouncer.Services, error) {
+	if nettest.BouncerCacheDir != "" {
+		query = bouncer.Cache{
+			Dir: nettest.BouncerCacheDir,
+			TTL: nettest.BouncerCacheTTL,
+		}.Wrap(kind, query)
+	}
+	var bouncers []model.Service
 	for _, e := range nettest.getAvailableBouncers() {
-		if e.Type != "https" {
-			continue
+		if e.Type == "https" {
+			bouncers = append(bouncers, e)
 		}
-		collectors, err := bouncer.GetCollectors(ctx, bouncer.Config{
-			BaseURL: e.Address,
+	}
+	if nettest.RaceBackends {
+		return nettest.raceBouncers(ctx, query, bouncers)
+	}
+	for _, e := range bouncers {
+		services, err := query(ctx, bouncer.Config{
+			BaseURL:  e.Address,
+			Resolver: nettest.Resolver,
 		})
 		if err != nil {
 			continue
 		}
-		nettest.AvailableCollectors = collectors
-		return nil
+		return services, nil
+	}
+	return bouncer.Services{}, errors.New("all bouncers failed")
+}
+
+// DiscoverAvailableCollectors discovers the available collectors.
+func (nettest *Nettest) DiscoverAvailableCollectors(ctx context.Context) error {
+	services, err := nettest.queryBouncers(ctx, "collectors", func(
+		ctx context.Context, config bouncer.Config,
+	) (bouncer.Services, error) {
+		collectors, err := bouncer.GetCollectors(ctx, config)
+		return bouncer.Services{Collectors: collectors}, err
+	})
+	if err != nil {
+		return errors.New("Cannot discover available collectors")
 	}
-	return errors.New("Cannot discover available collectors")
+	nettest.AvailableCollectors = services.Collectors
+	return nil
 }
 
 // DiscoverAvailableTestHelpers discovers the available test helpers.
 func (nettest *Nettest) DiscoverAvailableTestHelpers(ctx context.Context) error {
-	for _, e := range nettest.getAvailableBouncers() {
-		if e.Type != "https" {
-			continue
-		}
-		testHelpers, err := bouncer.GetTestHelpers(ctx, bouncer.Config{
-			BaseURL: e.Address,
-		})
-		if err != nil {
-			continue
-		}
-		nettest.AvailableTestHelpers = testHelpers
-		return nil
+	services, err := nettest.queryBouncers(ctx, "test-helpers", func(
+		ctx context.Context, config bouncer.Config,
+	) (bouncer.Services, error) {
+		testHelpers, err := bouncer.GetTestHelpers(ctx, config)
+		return bouncer.Services{TestHelpers: testHelpers}, err
+	})
+	if err != nil {
+		return errors.New("Cannot discover available test helpers")
+	}
+	nettest.AvailableTestHelpers = services.TestHelpers
+	return nil
+}
+
+// DiscoverAvailableServices discovers the available collectors and
+// test helpers at the same time.
+func (nettest *Nettest) DiscoverAvailableServices(ctx context.Context) error {
+	services, err := nettest.queryBouncers(ctx, "services", bouncer.GetServices)
+	if err != nil {
+		return errors.New("Cannot discover available services")
 	}
-	return errors.New("Cannot discover available test helpers")
+	nettest.AvailableCollectors = services.Collectors
+	nettest.AvailableTestHelpers = services.TestHelpers
+	return nil
 }
 
 // ErrNoDatabasesPath indicates that the MMDB databases path are not specified.
@@ -372,17 +493,76 @@ func (nettest *Nettest) ResolverLookup(ctx context.Context) error {
 	return errors.New("Not implemented")
 }
 
-// OpenReport opens a new report for the nettest.
+// collectorProbeTimeout is the timeout for probing each collector.
+var collectorProbeTimeout = 10 * time.Second
+
+// rankAvailableCollectors probes the available HTTPS collectors in
+// parallel and returns the reachable ones sorted by latency, followed
+// by the unreachable ones in their original order, such that we still
+// try them if the reachable ones fail when opening the report.
+func (nettest *Nettest) rankAvailableCollectors(ctx context.Context) []model.Service {
+	type result struct {
+		elapsed time.Duration
+		err     error
+		service model.Service
+	}
+	ctx, cancel := context.WithTimeout(ctx, collectorProbeTimeout)
+	defer cancel()
+	var count int
+	results := make(chan result, len(nettest.AvailableCollectors))
+	for _, e := range nettest.AvailableCollectors {
+		if e.Type != "https" {
+			continue
+		}
+		count++
+		go func(service model.Service) {
+			elapsed, err := collector.Probe(ctx, collector.Config{
+				BaseURL:  service.Address,
+				Resolver: nettest.Resolver,
+			})
+			results <- result{elapsed: elapsed, err: err, service: service}
+		}(e)
+	}
+	var reachable []result
+	ranked := make(map[model.Service]bool)
+	for i := 0; i < count; i++ {
+		if r := <-results; r.err == nil {
+			reachable = append(reachable, r)
+			ranked[r.service] = true
+		}
+	}
+	sort.SliceStable(reachable, func(i, j int) bool {
+		return reachable[i].elapsed < reachable[j].elapsed
+	})
+	var collectors []model.Service
+	for _, r := range reachable {
+		collectors = append(collectors, r.service)
+	}
+	for _, e := range nettest.AvailableCollectors {
+		if e.Type == "https" && !ranked[e] {
+			collectors = append(collectors, e)
+		}
+	}
+	return collectors
+}
+
+// OpenReport opens a new report for the nettest. When RaceBackends is
+// true, we try the reachable collectors in order of latency.
 func (nettest *Nettest) OpenReport(ctx context.Context) error {
 	if nettest.Report.ID != "" {
 		return nil
 	}
-	for _, e := range nettest.AvailableCollectors {
+	collectors := nettest.AvailableCollectors
+	if nettest.RaceBackends {
+		collectors = nettest.rankAvailableCollectors(ctx)
+	}
+	for _, e := range collectors {
 		if e.Type != "https" {
 			continue
 		}
 		report, err := collector.Open(ctx, collector.Config{
-			BaseURL: e.Address,
+			BaseURL:  e.Address,
+			Resolver: nettest.Resolver,
 		}, collector.ReportTemplate{
 			ProbeASN:        nettest.ProbeASN,
 			ProbeCC:         nettest.ProbeCC,
@@ -3,6 +3,10 @@ package nettest
 import (
 	"context"
 	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
@@ -115,6 +119,188 @@ func TestDiscoverAvailableTestHelpersQueryFailure(t *testing.T) {
 	}
 }
 
+// TestDiscoverAvailableServices discovers collectors and test
+// helpers using a local bouncer.
+func TestDiscoverAvailableServices(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{
+				"collectors": [{"address": "https://c.example.com", "type": "https"}],
+				"test_helpers": {"dns": [{"address": "8.8.8.8:53", "type": "legacy"}]}
+			}`))
+		},
+	))
+	defer server.Close()
+	nettest := &Nettest{
+		AvailableBouncers: []model.Service{
+			{
+				Address: "\t", // fail b/c URL is invalid
+				Type:    "https",
+			},
+			{
+				Address: server.URL,
+				Type:    "https",
+			},
+		},
+	}
+	err := nettest.DiscoverAvailableServices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nettest.AvailableCollectors) != 1 ||
+		len(nettest.AvailableTestHelpers["dns"]) != 1 {
+		t.Fatal("Unexpected available services")
+	}
+}
+
+// TestDiscoverAvailableServicesFailure deals with the case
+// where we cannot discover the available services.
+func TestDiscoverAvailableServicesFailure(t *testing.T) {
+	nettest := &Nettest{
+		AvailableBouncers: []model.Service{
+			{
+				Address: "httpo://42q7ug46dspcsvkw.onion",
+				Type:    "onion",
+			},
+			{
+				Address: "\t", // fail b/c URL is invalid
+				Type:    "https",
+			},
+		},
+	}
+	err := nettest.DiscoverAvailableServices(context.Background())
+	if err == nil {
+		t.Fatal("We expected a failure here")
+	}
+}
+
+// TestDiscoverAvailableCollectorsCache checks whether we use the
+// cache rather than querying again the bouncer.
+func TestDiscoverAvailableCollectorsCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nettest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"address": "https://c.example.com", "type": "https"}]`))
+		},
+	))
+	bouncers := []model.Service{{Address: server.URL, Type: "https"}}
+	nettest := &Nettest{AvailableBouncers: bouncers, BouncerCacheDir: dir}
+	err = nettest.DiscoverAvailableCollectors(context.Background())
+	server.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nettest = &Nettest{AvailableBouncers: bouncers, BouncerCacheDir: dir}
+	err = nettest.DiscoverAvailableCollectors(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nettest.AvailableCollectors) != 1 {
+		t.Fatal("Unexpected available collectors")
+	}
+	err = nettest.DiscoverAvailableTestHelpers(context.Background())
+	if err == nil {
+		t.Fatal("We expected the cache to be keyed by query kind")
+	}
+}
+
+// newSlowServer creates a server that replies with body after delay
+// or when the client gives up, whatever happens first.
+func newSlowServer(delay time.Duration, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+			}
+			w.Write([]byte(body))
+		},
+	))
+}
+
+// TestDiscoverAvailableServicesRace checks whether we query the
+// bouncers concurrently when racing backends.
+func TestDiscoverAvailableServicesRace(t *testing.T) {
+	const body = `{"collectors": [{"address": "https://c.example.com", "type": "https"}]}`
+	slow := newSlowServer(10*time.Second, body)
+	defer slow.Close()
+	fast := newSlowServer(0, body)
+	defer fast.Close()
+	nettest := &Nettest{
+		AvailableBouncers: []model.Service{
+			{
+				Address: "\t", // fail b/c URL is invalid
+				Type:    "https",
+			},
+			{
+				Address: slow.URL,
+				Type:    "https",
+			},
+			{
+				Address: fast.URL,
+				Type:    "https",
+			},
+		},
+		RaceBackends: true,
+	}
+	start := time.Now()
+	err := nettest.DiscoverAvailableServices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Now().Sub(start) > 5*time.Second {
+		t.Fatal("We did not race the bouncers")
+	}
+	if len(nettest.AvailableCollectors) != 1 {
+		t.Fatal("Unexpected available collectors")
+	}
+	nettest.AvailableBouncers = nettest.AvailableBouncers[:1]
+	if err := nettest.DiscoverAvailableServices(context.Background()); err == nil {
+		t.Fatal("We expected a failure here")
+	}
+}
+
+// TestOpenReportRace checks whether we rank the collectors by
+// latency and try the unreachable ones last when racing backends.
+func TestOpenReportRace(t *testing.T) {
+	const body = `{"report_id": "xx"}`
+	closed := newSlowServer(0, body)
+	closed.Close()
+	slow := newSlowServer(250*time.Millisecond, body)
+	defer slow.Close()
+	fast := newSlowServer(0, body)
+	defer fast.Close()
+	nettest := &Nettest{
+		AvailableCollectors: []model.Service{
+			{Address: closed.URL, Type: "https"},
+			{Address: "httpo://42q7ug46dspcsvkw.onion", Type: "onion"},
+			{Address: slow.URL, Type: "https"},
+			{Address: fast.URL, Type: "https"},
+		},
+		RaceBackends: true,
+	}
+	collectors := nettest.rankAvailableCollectors(context.Background())
+	if len(collectors) != 3 || collectors[0].Address != fast.URL ||
+		collectors[1].Address != slow.URL || collectors[2].Address != closed.URL {
+		t.Fatalf("Unexpected collectors: %+v", collectors)
+	}
+	if err := nettest.OpenReport(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if nettest.Report.ID != "xx" || nettest.Report.Conf.BaseURL != fast.URL {
+		t.Fatal("We did not use the fastest collector")
+	}
+	nettest.AvailableCollectors = nettest.AvailableCollectors[:2]
+	collectors = nettest.rankAvailableCollectors(context.Background())
+	if len(collectors) != 1 || collectors[0].Address != closed.URL {
+		t.Fatal("We expected the unreachable collector")
+	}
+}
+
 // TestOpenReportIntegration opens a report.
 func TestOpenReportIntegration(t *testing.T) {
 	nettest := &Nettest{
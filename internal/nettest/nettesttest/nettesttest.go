@@ -0,0 +1,30 @@
+// Package nettesttest contains helpers for testing nettests.
+package nettesttest
+
+import (
+	"context"
+
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// Drain calls fn in a background goroutine, discards the events that fn
+// emits on out, and returns when fn returns.
+func Drain(fn func(out chan<- model.Event)) {
+	out := make(chan model.Event)
+	go func() {
+		defer close(out)
+		fn(out)
+	}()
+	for range out {
+	}
+}
+
+// Run runs a measurement of nt with input, discarding the events, and
+// returns the measurement.
+func Run(nt *nettest.Nettest, input string) model.Measurement {
+	measurement := nt.NewMeasurement()
+	for range nt.StartMeasurement(context.Background(), input, &measurement) {
+	}
+	return measurement
+}
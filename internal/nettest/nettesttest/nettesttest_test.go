@@ -0,0 +1,40 @@
+package nettesttest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// TestDrain checks whether we return after fn returns.
+func TestDrain(t *testing.T) {
+	var count int
+	Drain(func(out chan<- model.Event) {
+		for i := 0; i < 3; i++ {
+			out <- model.NewLogInfoEvent("antani")
+			count++
+		}
+	})
+	if count != 3 {
+		t.Fatal("We did not drain all the events")
+	}
+}
+
+// TestRun checks whether we run the measurement with the input.
+func TestRun(t *testing.T) {
+	nt := &nettest.Nettest{
+		TestName: "dummy",
+		Main: func(
+			ctx context.Context, input string,
+			measurement *model.Measurement, out chan<- model.Event,
+		) {
+			measurement.TestKeys = input
+		},
+	}
+	measurement := Run(nt, "antani")
+	if measurement.TestKeys != "antani" {
+		t.Fatalf("Unexpected measurement: %+v", measurement)
+	}
+}
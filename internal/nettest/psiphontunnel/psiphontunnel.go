@@ -13,7 +13,9 @@ import (
 // Config contains the psiphontunnel nettest configuration.
 type Config = runner.Config
 
-// NewNettest creates a new psiphontunnel nettest.
+// NewNettest creates a new psiphontunnel nettest. If the measurement
+// input is not empty, it is the URL to fetch using the tunnel, and it
+// overrides the URLs specified in the config.
 func NewNettest(config Config) *nettest.Nettest {
 	return &nettest.Nettest{
 		TestName:        "psiphontunnel",
@@ -27,7 +29,11 @@ func NewNettest(config Config) *nettest.Nettest {
 			measurement *model.Measurement,
 			out chan<- model.Event,
 		) {
-			measurement.TestKeys = runner.Run(ctx, config)
+			config := config
+			if input != "" {
+				config.URLs = []string{input}
+			}
+			measurement.TestKeys = runner.Run(ctx, config, out)
 		},
 	}
 }
@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Psiphon-Labs/psiphon-tunnel-core/psiphon"
+)
+
+// decryptConfig decrypts a Psiphon config encrypted with AES-256-CBC and
+// PKCS#7 padding. This is the format generated by running
+//
+//	openssl aes-256-cbc -K <key> -iv <iv> -in <plain> -out <encrypted>
+//
+// where key and iv are hex encoded. The key must be 32 bytes long and
+// the iv must be 16 bytes long. The plaintext is only kept in memory.
+func decryptConfig(data []byte, hexKey, hexIV string) ([]byte, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode ConfigKey: %s", err.Error())
+	}
+	if len(key) != 32 {
+		return nil, errors.New("ConfigKey is not a 256 bit key")
+	}
+	iv, err := hex.DecodeString(hexIV)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode ConfigIV: %s", err.Error())
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, errors.New("ConfigIV has invalid length")
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted config has invalid length")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, data)
+	padlen := int(plaintext[len(plaintext)-1])
+	if padlen <= 0 || padlen > aes.BlockSize {
+		return nil, errors.New("cannot decrypt config: invalid padding")
+	}
+	padding := plaintext[len(plaintext)-padlen:]
+	if !bytes.Equal(padding, bytes.Repeat([]byte{byte(padlen)}, padlen)) {
+		return nil, errors.New("cannot decrypt config: invalid padding")
+	}
+	return plaintext[:len(plaintext)-padlen], nil
+}
+
+// validateConfig makes sure that configJSON is a valid Psiphon config. We
+// parse it like clientlib.StartTunnel does and we check the required
+// fields, such that config errors are detected before starting the
+// tunnel and never classified by looking at Psiphon error messages.
+func validateConfig(configJSON []byte) error {
+	parsed, err := psiphon.LoadConfig(configJSON)
+	if err != nil {
+		return fmt.Errorf("cannot parse Psiphon config: %s", err.Error())
+	}
+	if parsed.PropagationChannelId == "" {
+		return errors.New("Psiphon config: missing required field PropagationChannelId")
+	}
+	if parsed.SponsorId == "" {
+		return errors.New("Psiphon config: missing required field SponsorId")
+	}
+	return nil
+}
+
+// enableDiagnosticNotices returns a copy of configJSON where we have set
+// EmitDiagnosticNotices to true. Psiphon only emits the notices we use to
+// track the bootstrap (e.g. CandidateServers and ActiveTunnel) when
+// this setting is enabled.
+func enableDiagnosticNotices(configJSON []byte) ([]byte, error) {
+	var fields map[string]interface{}
+	err := json.Unmarshal(configJSON, &fields)
+	if err != nil {
+		return nil, err
+	}
+	fields["EmitDiagnosticNotices"] = true
+	return json.Marshal(fields)
+}
+
+// loadconfig returns the Psiphon config JSON. We use, in order of
+// preference, the inline ConfigJSON, the EncryptedConfigFilePath, and
+// the plaintext ConfigFilePath. The config is validated before
+// being returned to the caller.
+func loadconfig(config Config) ([]byte, error) {
+	var (
+		configJSON []byte
+		data       []byte
+		err        error
+	)
+	switch {
+	case config.ConfigJSON != "":
+		configJSON = []byte(config.ConfigJSON)
+	case config.EncryptedConfigFilePath != "":
+		data, err = ioutilReadFile(config.EncryptedConfigFilePath)
+		if err != nil {
+			return nil, err
+		}
+		configJSON, err = decryptConfig(data, config.ConfigKey, config.ConfigIV)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		configJSON, err = ioutilReadFile(config.ConfigFilePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = validateConfig(configJSON)
+	if err != nil {
+		return nil, err
+	}
+	return configJSON, nil
+}
@@ -0,0 +1,187 @@
+package runner
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Psiphon-Labs/psiphon-tunnel-core/psiphon"
+)
+
+const (
+	testConfigKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testConfigIV  = "0f0e0d0c0b0a09080706050403020100"
+	testConfig    = `{"PropagationChannelId":"ABCDEF","SponsorId":"012345"}`
+)
+
+// encryptConfig is the inverse of decryptConfig.
+func encryptConfig(t *testing.T, plaintext []byte) []byte {
+	key, _ := hex.DecodeString(testConfigKey)
+	iv, _ := hex.DecodeString(testConfigIV)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	padlen := aes.BlockSize - len(plaintext)%aes.BlockSize
+	data := append([]byte{}, plaintext...)
+	data = append(data, bytes.Repeat([]byte{byte(padlen)}, padlen)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return data
+}
+
+// TestDecryptConfigRoundTrip checks whether we can decrypt what
+// we have encrypted using AES-256-CBC.
+func TestDecryptConfigRoundTrip(t *testing.T) {
+	data := encryptConfig(t, []byte(testConfig))
+	plaintext, err := decryptConfig(data, testConfigKey, testConfigIV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != testConfig {
+		t.Fatal("The decrypted config is not the original one")
+	}
+}
+
+// TestDecryptConfigErrors checks whether decryptConfig deals
+// with invalid keys, IVs, and ciphertexts.
+func TestDecryptConfigErrors(t *testing.T) {
+	data := encryptConfig(t, []byte(testConfig))
+	wrongKey := "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+	var cases = []struct {
+		data []byte
+		key  string
+		iv   string
+	}{
+		{data, "zz", testConfigIV},
+		{data, "0011", testConfigIV},
+		{data, testConfigKey, "zz"},
+		{data, testConfigKey, "0011"},
+		{nil, testConfigKey, testConfigIV},
+		{data[:len(data)-1], testConfigKey, testConfigIV},
+		{data, wrongKey, testConfigIV},
+	}
+	for _, c := range cases {
+		_, err := decryptConfig(c.data, c.key, c.iv)
+		if err == nil {
+			t.Fatalf("We expected an error with key %s and iv %s", c.key, c.iv)
+		}
+	}
+}
+
+// TestValidateConfig checks whether validateConfig rejects
+// configs that are not usable.
+func TestValidateConfig(t *testing.T) {
+	if err := validateConfig([]byte(testConfig)); err != nil {
+		t.Fatal(err)
+	}
+	var invalid = []string{
+		`{`,
+		`[]`,
+		`{"SponsorId":"012345"}`,
+		`{"PropagationChannelId":"ABCDEF","SponsorId":""}`,
+		`{"PropagationChannelId":17,"SponsorId":"012345"}`,
+	}
+	for _, s := range invalid {
+		if err := validateConfig([]byte(s)); err == nil {
+			t.Fatalf("We expected %s to be invalid", s)
+		}
+	}
+}
+
+// TestEnableDiagnosticNotices checks whether we enable the diagnostic
+// notices while preserving the rest of the config.
+func TestEnableDiagnosticNotices(t *testing.T) {
+	configJSON, err := enableDiagnosticNotices([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := psiphon.LoadConfig(configJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.EmitDiagnosticNotices || parsed.SponsorId != "012345" {
+		t.Fatal("Not the config we expected")
+	}
+	if _, err := enableDiagnosticNotices([]byte(`[]`)); err == nil {
+		t.Fatal("We expected an error here")
+	}
+}
+
+// TestLoadconfigInline checks whether the inline config takes
+// precedence over the config file paths.
+func TestLoadconfigInline(t *testing.T) {
+	configJSON, err := loadconfig(Config{
+		ConfigJSON:              testConfig,
+		ConfigFilePath:          "/nonexistent/psiphon.json",
+		EncryptedConfigFilePath: "/nonexistent/psiphon.json.enc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(configJSON) != testConfig {
+		t.Fatal("Not the config we expected")
+	}
+}
+
+// TestLoadconfigInlineInvalid checks whether we validate the inline config.
+func TestLoadconfigInlineInvalid(t *testing.T) {
+	_, err := loadconfig(Config{ConfigJSON: `{}`})
+	if err == nil {
+		t.Fatal("We expected an error here")
+	}
+}
+
+// TestLoadconfigEncrypted checks whether we can load an encrypted config.
+func TestLoadconfigEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "psiphon_config.json.enc")
+	err = ioutil.WriteFile(path, encryptConfig(t, []byte(testConfig)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configJSON, err := loadconfig(Config{
+		EncryptedConfigFilePath: path,
+		ConfigKey:               testConfigKey,
+		ConfigIV:                testConfigIV,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(configJSON) != testConfig {
+		t.Fatal("Not the config we expected")
+	}
+	_, err = loadconfig(Config{
+		EncryptedConfigFilePath: path,
+		ConfigKey:               testConfigKey,
+	})
+	if err == nil {
+		t.Fatal("We expected an error with a missing IV")
+	}
+}
+
+// TestLoadconfigEncryptedReadError checks whether we deal with
+// errors when reading the encrypted config file.
+func TestLoadconfigEncryptedReadError(t *testing.T) {
+	savedFunc := ioutilReadFile
+	mockedError := errors.New("mocked error")
+	ioutilReadFile = func(string) ([]byte, error) {
+		return nil, mockedError
+	}
+	_, err := loadconfig(Config{
+		EncryptedConfigFilePath: "/nonexistent/psiphon.json.enc",
+	})
+	if err != mockedError {
+		t.Fatal("Not the error we expected")
+	}
+	ioutilReadFile = savedFunc
+}
@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"sync"
+
+	"github.com/Psiphon-Labs/psiphon-tunnel-core/ClientLibrary/clientlib"
+	"github.com/measurement-kit/engine/model"
+)
+
+// BootstrapEvent is the value of the "psiphontunnel.bootstrap" events
+// that we emit while Psiphon is bootstrapping.
+type BootstrapEvent struct {
+	// Phase is the bootstrap phase. It is one of "candidate_servers",
+	// "connecting_server", "connected_server", and "active_tunnel".
+	Phase string `json:"phase"`
+
+	// Count is the number of candidate servers.
+	Count int64 `json:"count,omitempty"`
+
+	// Protocol is the tunnel protocol being used.
+	Protocol string `json:"protocol,omitempty"`
+
+	// Region is the region of the server.
+	Region string `json:"region,omitempty"`
+}
+
+// noticeHandler receives Psiphon notices, emits the corresponding
+// events, and keeps track of the tunnel protocol and region.
+//
+// Psiphon may call us from background goroutines and may keep doing
+// that after the tunnel has been stopped, since the notice writer is
+// a global variable. Hence, we stop emitting events once stop has
+// been called, because by then the out channel may be closed. We do
+// not hold the mutex while emitting, such that a slow reader does not
+// block Psiphon; stop waits for the pending events instead.
+type noticeHandler struct {
+	candidates int64
+	mu         sync.Mutex
+	out        chan<- model.Event
+	pending    sync.WaitGroup
+	protocol   string
+	region     string
+	stopped    bool
+}
+
+// newNoticeHandler creates a new noticeHandler posting on out.
+func newNoticeHandler(out chan<- model.Event) *noticeHandler {
+	return &noticeHandler{candidates: -1, out: out}
+}
+
+// getString returns the string value of key inside data.
+func getString(data map[string]interface{}, key string) string {
+	value, _ := data[key].(string)
+	return value
+}
+
+// receive handles a Psiphon notice.
+func (h *noticeHandler) receive(notice clientlib.NoticeEvent) {
+	h.mu.Lock()
+	if h.stopped {
+		h.mu.Unlock()
+		return
+	}
+	ev, ok := h.update(notice)
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	h.pending.Add(1)
+	h.mu.Unlock()
+	h.out <- model.Event{
+		Key:   "psiphontunnel.bootstrap",
+		Value: ev,
+	}
+	h.pending.Done()
+}
+
+// update updates the handler state using notice and returns the event
+// to emit, if any. The caller must hold the mutex.
+func (h *noticeHandler) update(notice clientlib.NoticeEvent) (BootstrapEvent, bool) {
+	var ev BootstrapEvent
+	switch notice.Type {
+	case "CandidateServers":
+		count, _ := notice.Data["count"].(float64)
+		h.candidates = int64(count)
+		ev = BootstrapEvent{
+			Phase:  "candidate_servers",
+			Count:  h.candidates,
+			Region: getString(notice.Data, "region"),
+		}
+	case "ConnectingServer":
+		ev = BootstrapEvent{
+			Phase:    "connecting_server",
+			Protocol: getString(notice.Data, "protocol"),
+			Region:   getString(notice.Data, "region"),
+		}
+	case "ConnectedServer":
+		h.protocol = getString(notice.Data, "protocol")
+		h.region = getString(notice.Data, "region")
+		ev = BootstrapEvent{
+			Phase:    "connected_server",
+			Protocol: h.protocol,
+			Region:   h.region,
+		}
+	case "ActiveTunnel":
+		h.protocol = getString(notice.Data, "protocol")
+		ev = BootstrapEvent{
+			Phase:    "active_tunnel",
+			Protocol: h.protocol,
+		}
+	default:
+		return ev, false
+	}
+	return ev, true
+}
+
+// stop stops emitting events, waits for the pending events, and returns
+// the number of candidate servers (or -1 if unknown), the protocol, and
+// the server region.
+func (h *noticeHandler) stop() (int64, string, string) {
+	h.mu.Lock()
+	h.stopped = true
+	candidates, protocol, region := h.candidates, h.protocol, h.region
+	h.mu.Unlock()
+	h.pending.Wait()
+	return candidates, protocol, region
+}
@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/Psiphon-Labs/psiphon-tunnel-core/ClientLibrary/clientlib"
+	"github.com/measurement-kit/engine/model"
+)
+
+// TestNoticeHandler checks whether noticeHandler emits bootstrap events
+// and keeps track of the candidate servers, protocol and region.
+func TestNoticeHandler(t *testing.T) {
+	out := make(chan model.Event, 16)
+	handler := newNoticeHandler(out)
+	for _, notice := range []clientlib.NoticeEvent{
+		{Type: "Info", Data: map[string]interface{}{"message": "x"}},
+		{Type: "CandidateServers", Data: map[string]interface{}{
+			"count": float64(7), "region": "",
+		}},
+		{Type: "ConnectingServer", Data: map[string]interface{}{
+			"protocol": "OSSH", "region": "CA",
+		}},
+		{Type: "ConnectedServer", Data: map[string]interface{}{
+			"protocol": "OSSH", "region": "CA",
+		}},
+		{Type: "ActiveTunnel", Data: map[string]interface{}{
+			"protocol": "OSSH",
+		}},
+	} {
+		handler.receive(notice)
+	}
+	if len(out) != 4 {
+		t.Fatal("Unexpected number of events")
+	}
+	phases := []string{
+		"candidate_servers", "connecting_server",
+		"connected_server", "active_tunnel",
+	}
+	for _, phase := range phases {
+		ev := <-out
+		if ev.Key != "psiphontunnel.bootstrap" {
+			t.Fatal("Unexpected event key")
+		}
+		if ev.Value.(BootstrapEvent).Phase != phase {
+			t.Fatal("Unexpected bootstrap phase")
+		}
+	}
+	candidates, protocol, region := handler.stop()
+	if candidates != 7 || protocol != "OSSH" || region != "CA" {
+		t.Fatal("Unexpected state after stop")
+	}
+	handler.receive(clientlib.NoticeEvent{Type: "ActiveTunnel"})
+	if len(out) != 0 {
+		t.Fatal("We emitted an event after stop")
+	}
+}
@@ -5,12 +5,16 @@ import (
 	"context"
 	"errors"
 	"io/ioutil"
+	"net/http/httptrace"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/Psiphon-Labs/psiphon-tunnel-core/ClientLibrary/clientlib"
+	"github.com/measurement-kit/engine/internal/errorx"
 	"github.com/measurement-kit/engine/internal/httpx"
+	"github.com/measurement-kit/engine/model"
 )
 
 // Config contains the nettest configuration.
@@ -18,21 +22,129 @@ type Config struct {
 	// ConfigFilePath is the path where Psiphon config file is located.
 	ConfigFilePath string `json:"config_file_path"`
 
+	// ConfigJSON is the optional inline Psiphon config. When it is not
+	// empty, it takes precedence over all the config file paths.
+	ConfigJSON string `json:"config_json"`
+
+	// EncryptedConfigFilePath is the optional path of a Psiphon config
+	// encrypted using AES-256-CBC. When it is not empty, it takes precedence
+	// over ConfigFilePath. The config is decrypted in memory.
+	EncryptedConfigFilePath string `json:"encrypted_config_file_path"`
+
+	// ConfigKey is the hex encoded key for EncryptedConfigFilePath.
+	ConfigKey string `json:"config_key"`
+
+	// ConfigIV is the hex encoded IV for EncryptedConfigFilePath.
+	ConfigIV string `json:"config_iv"`
+
+	// KeepWorkDir indicates whether we should keep the Psiphon working
+	// directory across runs. By default we remove it, so that every run
+	// is a cold bootstrap. When keeping it, we only remove it if its size
+	// exceeds MaxWorkDirSize, hence most runs are warm bootstraps.
+	KeepWorkDir bool `json:"keep_work_dir"`
+
+	// MaxWorkDirSize is the maximum size in bytes of the Psiphon working
+	// directory when KeepWorkDir is true. If zero, we use DefaultMaxWorkDirSize.
+	MaxWorkDirSize int64 `json:"max_work_dir_size"`
+
+	// ThroughputURL is the optional URL of a large resource to download
+	// through the tunnel to measure its throughput. If empty, we skip the
+	// throughput phase and only verify that the tunnel works.
+	ThroughputURL string `json:"throughput_url"`
+
+	// ThroughputSize is the maximum number of bytes to download from
+	// ThroughputURL. If zero, we use DefaultThroughputSize.
+	ThroughputSize int64 `json:"throughput_size"`
+
+	// URLs contains the URLs to fetch using the tunnel. If empty, we
+	// will fetch the DefaultURL.
+	URLs []string `json:"urls"`
+
 	// WorkDirPath is the directory where Psiphon should store
 	// its configuration database.
 	WorkDirPath string `json:"work_dir_path"`
 }
 
+// DefaultURL is the URL we fetch when Config.URLs is empty.
+const DefaultURL = "https://www.google.com/humans.txt"
+
+// RequestResult contains the result of fetching a URL using the tunnel.
+type RequestResult struct {
+	// URL is the URL we fetched.
+	URL string `json:"url"`
+
+	// Failure contains the failure that occurred, if any.
+	Failure string `json:"failure"`
+
+	// FailureDetails contains the error that caused the failure.
+	FailureDetails string `json:"failure_details"`
+
+	// StatusCode is the HTTP status code.
+	StatusCode int `json:"status_code"`
+
+	// BodyLength is the length of the response body in bytes.
+	BodyLength int64 `json:"body_length"`
+
+	// TimeToFirstByte is the time in seconds between issuing the
+	// request and receiving the first byte of the response.
+	TimeToFirstByte float64 `json:"time_to_first_byte"`
+
+	// TotalTime is the time in seconds it took to fetch the
+	// whole response body, or to fail.
+	TotalTime float64 `json:"total_time"`
+}
+
+// The following are the possible values of Result.Failure.
+const (
+	// FailureConfigError indicates that the config is not valid or
+	// that we could not prepare the working directory.
+	FailureConfigError = "config_error"
+
+	// FailureFetchError indicates that the tunnel was established but
+	// we could not fetch one or more URLs through it.
+	FailureFetchError = "fetch_error"
+
+	// FailureNoServers indicates that Psiphon did not have any
+	// candidate server it could connect to.
+	FailureNoServers = "no_servers"
+
+	// FailureTimeout indicates that bootstrapping timed out.
+	FailureTimeout = "timeout"
+
+	// FailureTunnelError indicates any other bootstrap failure.
+	FailureTunnelError = "tunnel_error"
+)
+
 // Result contains the nettest result.
 //
 // This is what will end up into the Measurement.TestKeys field
 // when you run this nettest.
 type Result struct {
-	// Failure contains the failure that occurred.
+	// Failure contains the failure that occurred. See above for
+	// the list of the possible failure values.
 	Failure string `json:"failure"`
 
+	// FailureDetails contains the error that caused the failure.
+	FailureDetails string `json:"failure_details"`
+
 	// BootstrapTime is the time it took to bootstrap Psiphon.
 	BootstrapTime float64 `json:"bootstrap_time"`
+
+	// BootstrapType is either BootstrapCold or BootstrapWarm.
+	BootstrapType string `json:"bootstrap_type"`
+
+	// Protocol is the tunnel protocol that we used.
+	Protocol string `json:"protocol"`
+
+	// Region is the region of the Psiphon server we connected to.
+	Region string `json:"region"`
+
+	// Requests contains the results of fetching each URL.
+	Requests []RequestResult `json:"requests"`
+
+	// Throughput contains the results of the throughput phase. It is
+	// nil if we did not run the throughput phase.
+	Throughput *ThroughputResult `json:"throughput,omitempty"`
 }
 
 // osRemoveAll is a mockable os.RemoveAll
@@ -44,38 +156,119 @@ var osMkdirAll = os.MkdirAll
 // ioutilReadFile is a mockable ioutil.ReadFile
 var ioutilReadFile = ioutil.ReadFile
 
-func processconfig(config Config) ([]byte, clientlib.Parameters, error) {
+// setup is the result of processing the config.
+type setup struct {
+	// bootstrapType is either BootstrapCold or BootstrapWarm.
+	bootstrapType string
+
+	// configJSON is the Psiphon config.
+	configJSON []byte
+
+	// params contains the Psiphon parameters.
+	params clientlib.Parameters
+
+	// release releases the lock on the working directory, which we
+	// only take when keeping the working directory across runs.
+	release func()
+}
+
+func processconfig(config Config) (setup, error) {
 	if config.WorkDirPath == "" {
-		return nil, clientlib.Parameters{}, errors.New("WorkDirPath is empty")
+		return setup{}, errors.New("WorkDirPath is empty")
 	}
 	const testdirname = "oonipsiphontunnelcore"
 	workdir := filepath.Join(config.WorkDirPath, testdirname)
-	err := osRemoveAll(workdir)
+	err := osMkdirAll(config.WorkDirPath, 0700)
 	if err != nil {
-		return nil, clientlib.Parameters{}, err
+		return setup{}, err
+	}
+	release := func() {}
+	if config.KeepWorkDir {
+		release, err = lockworkdir(workdir)
+		if err != nil {
+			return setup{}, err
+		}
+	}
+	if mustwipe(config, workdir) {
+		err = osRemoveAll(workdir)
+		if err != nil {
+			release()
+			return setup{}, err
+		}
 	}
 	err = osMkdirAll(workdir, 0700)
 	if err != nil {
-		return nil, clientlib.Parameters{}, err
+		release()
+		return setup{}, err
 	}
-	params := clientlib.Parameters{
-		DataRootDirectory: &workdir,
+	configJSON, err := loadconfig(config)
+	if err != nil {
+		release()
+		return setup{}, err
 	}
-	configJSON, err := ioutilReadFile(config.ConfigFilePath)
+	configJSON, err = enableDiagnosticNotices(configJSON)
 	if err != nil {
-		return nil, clientlib.Parameters{}, err
+		release()
+		return setup{}, err
 	}
-	return configJSON, params, nil
+	return setup{
+		bootstrapType: bootstraptype(workdir),
+		configJSON:    configJSON,
+		params: clientlib.Parameters{
+			DataRootDirectory: &workdir,
+		},
+		release: release,
+	}, nil
 }
 
-func usetunnel(ctx context.Context, t *clientlib.PsiphonTunnel) error {
-	_, err := httpx.Request{
-		Ctx:             ctx,
+// fetch fetches URL using the SOCKS5 proxy listening on port.
+func fetch(ctx context.Context, port int, URL string) RequestResult {
+	result := RequestResult{URL: URL}
+	t0 := time.Now()
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			result.TimeToFirstByte = time.Now().Sub(t0).Seconds()
+		},
+	}
+	response, err := httpx.Request{
+		Ctx:             httptrace.WithClientTrace(ctx, trace),
 		Method:          "GET",
-		URL:             "https://www.google.com/humans.txt",
-		SOCKS5ProxyPort: t.SOCKSProxyPort,
+		URL:             URL,
+		NoFailOnError:   true,
+		SOCKS5ProxyPort: port,
 	}.Perform()
-	return err
+	result.TotalTime = time.Now().Sub(t0).Seconds()
+	if err != nil {
+		result.Failure = errorx.Classify(err)
+		result.FailureDetails = errorx.Details(err)
+		return result
+	}
+	result.StatusCode = response.StatusCode
+	result.BodyLength = int64(len(response.Body))
+	return result
+}
+
+// usetunnel fetches all the configured URLs using the tunnel. It
+// returns the results of all fetches and the first error, if any.
+func usetunnel(
+	ctx context.Context, t *clientlib.PsiphonTunnel, config Config,
+) ([]RequestResult, error) {
+	URLs := config.URLs
+	if len(URLs) == 0 {
+		URLs = []string{DefaultURL}
+	}
+	var (
+		results []RequestResult
+		err     error
+	)
+	for _, URL := range URLs {
+		result := fetch(ctx, t.SOCKSProxyPort, URL)
+		if result.Failure != "" && err == nil {
+			err = errors.New(result.FailureDetails)
+		}
+		results = append(results, result)
+	}
+	return results, err
 }
 
 // clientlibStartTunnel is a mockable clientlib.StartTunnel
@@ -84,26 +277,66 @@ var clientlibStartTunnel = clientlib.StartTunnel
 // mockableUsetunnel is mockable usetunnel
 var mockableUsetunnel = usetunnel
 
-// Run runs the nettest and returns the result.
-func Run(ctx context.Context, config Config) Result {
+// setFailure sets the result failure and the failure details.
+func (result *Result) setFailure(failure string, err error) {
+	result.Failure = failure
+	result.FailureDetails = err.Error()
+}
+
+// classifyStartTunnelError maps an error returned when starting the
+// tunnel to one of the possible Result.Failure values. We validate
+// the config before starting the tunnel, but Psiphon may still reject
+// it when committing it along with the parameters we override.
+func classifyStartTunnelError(
+	ctx context.Context, err error, candidates int64,
+) string {
+	if err == clientlib.ErrTimeout || ctx.Err() != nil {
+		if candidates == 0 {
+			return FailureNoServers
+		}
+		return FailureTimeout
+	}
+	if strings.Contains(err.Error(), "config.Commit failed") {
+		return FailureConfigError
+	}
+	return FailureTunnelError
+}
+
+// Run runs the nettest and returns the result. While bootstrapping
+// we post "psiphontunnel.bootstrap" events on out. While measuring
+// the throughput we post "psiphontunnel.throughput" events on out.
+func Run(ctx context.Context, config Config, out chan<- model.Event) Result {
 	var result Result
-	configJSON, params, err := processconfig(config)
+	setup, err := processconfig(config)
 	if err != nil {
-		result.Failure = err.Error()
+		result.setFailure(FailureConfigError, err)
 		return result
 	}
+	defer setup.release()
+	result.BootstrapType = setup.bootstrapType
+	handler := newNoticeHandler(out)
 	t0 := time.Now()
-	tunnel, err := clientlibStartTunnel(ctx, configJSON, "", params, nil, nil)
+	tunnel, err := clientlibStartTunnel(
+		ctx, setup.configJSON, "", setup.params, nil, handler.receive,
+	)
 	if err != nil {
-		result.Failure = err.Error()
+		var candidates int64
+		candidates, result.Protocol, result.Region = handler.stop()
+		result.setFailure(classifyStartTunnelError(ctx, err, candidates), err)
 		return result
 	}
 	result.BootstrapTime = time.Now().Sub(t0).Seconds()
 	defer tunnel.Stop()
-	err = mockableUsetunnel(ctx, tunnel)
+	result.Requests, err = mockableUsetunnel(ctx, tunnel, config)
+	_, result.Protocol, result.Region = handler.stop()
 	if err != nil {
-		result.Failure = err.Error()
+		result.setFailure(FailureFetchError, err)
 		return result
 	}
+	if config.ThroughputURL != "" {
+		result.Throughput = measurethroughput(
+			ctx, tunnel.SOCKSProxyPort, config, out,
+		)
+	}
 	return result
 }
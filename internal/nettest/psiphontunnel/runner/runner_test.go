@@ -4,16 +4,35 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/Psiphon-Labs/psiphon-tunnel-core/ClientLibrary/clientlib"
+	"github.com/measurement-kit/engine/model"
 )
 
+// run calls Run and discards the events it emits.
+func run(ctx context.Context, config Config) Result {
+	out := make(chan model.Event)
+	done := make(chan struct{})
+	go func() {
+		for range out {
+		}
+		close(done)
+	}()
+	result := Run(ctx, config, out)
+	close(out)
+	<-done
+	return result
+}
+
 // TestProcessconfigEmptyWorkDir checks whether processconfig deals
 // with an empty WorkDir in a sane way.
 func TestProcessconfigEmptyWorkDir(t *testing.T) {
-	_, _, err := processconfig(Config{})
+	_, err := processconfig(Config{})
 	if err == nil || err.Error() != "WorkDirPath is empty" {
 		t.Fatal("No error or unexpected error")
 	}
@@ -27,7 +46,7 @@ func TestProcessconfigOSRemoveAllError(t *testing.T) {
 	osRemoveAll = func(string) error {
 		return mockedError
 	}
-	_, _, err := processconfig(Config{
+	_, err := processconfig(Config{
 		WorkDirPath: "/tmp",
 	})
 	if err != mockedError {
@@ -44,7 +63,7 @@ func TestProcessconfigOSMkdirAllError(t *testing.T) {
 	osMkdirAll = func(string, os.FileMode) error {
 		return mockedError
 	}
-	_, _, err := processconfig(Config{
+	_, err := processconfig(Config{
 		WorkDirPath: "/tmp",
 	})
 	if err != mockedError {
@@ -61,7 +80,7 @@ func TestProcessconfigIoutilReadFileError(t *testing.T) {
 	ioutilReadFile = func(string) ([]byte, error) {
 		return nil, mockedError
 	}
-	_, _, err := processconfig(Config{
+	_, err := processconfig(Config{
 		WorkDirPath:    "/tmp",
 		ConfigFilePath: "../../../../testdata/psiphon_config.json",
 	})
@@ -77,7 +96,7 @@ func TestRunIntegration(t *testing.T) {
 		ConfigFilePath: "../../../../testdata/psiphon_config.json",
 		WorkDirPath:    "/tmp/",
 	}
-	result := Run(context.Background(), config)
+	result := run(context.Background(), config)
 	fmt.Printf("%+v\n", result)
 	if result.Failure != "" {
 		t.Fatal("Failure is not empty")
@@ -85,6 +104,9 @@ func TestRunIntegration(t *testing.T) {
 	if result.BootstrapTime <= 0.0 {
 		t.Fatal("BootstrapTime is not positive")
 	}
+	if len(result.Requests) != 1 || result.Requests[0].URL != DefaultURL {
+		t.Fatal("We did not fetch the default URL")
+	}
 }
 
 // TestRunProcessconfigFailure checks whether Run deals with a
@@ -94,9 +116,9 @@ func TestRunProcessconfigFailure(t *testing.T) {
 		ConfigFilePath: "/nonexistent/psiphon.json",
 		WorkDirPath:    "/tmp/",
 	}
-	result := Run(context.Background(), config)
-	if result.Failure == "" {
-		t.Fatal("We expected a failure, found no error")
+	result := run(context.Background(), config)
+	if result.Failure != FailureConfigError {
+		t.Fatal("Not the failure that we were expecting")
 	}
 }
 
@@ -112,14 +134,20 @@ func TestRunClientlibStartTunnelFailure(t *testing.T) {
 		params clientlib.Parameters,
 		paramsDelta clientlib.ClientParametersDelta,
 		noticeReceiver func(clientlib.NoticeEvent)) (tunnel *clientlib.PsiphonTunnel, err error) {
+		if !strings.Contains(string(configJSON), `"EmitDiagnosticNotices":true`) {
+			return nil, errors.New("diagnostic notices are not enabled")
+		}
 		return nil, mockedError
 	}
 	config := Config{
 		ConfigFilePath: "../../../../testdata/psiphon_config.json",
 		WorkDirPath:    "/tmp/",
 	}
-	result := Run(context.Background(), config)
-	if result.Failure != mockedError.Error() {
+	result := run(context.Background(), config)
+	if result.Failure != FailureTunnelError {
+		t.Fatal("Not the failure that we were expecting")
+	}
+	if result.FailureDetails != mockedError.Error() {
 		t.Fatal("Not the error that we were expecting")
 	}
 	clientlibStartTunnel = savedFunc
@@ -130,16 +158,105 @@ func TestRunClientlibStartTunnelFailure(t *testing.T) {
 func TestRunUsetunnelFailure(t *testing.T) {
 	savedFunc := mockableUsetunnel
 	mockedError := errors.New("mocked error")
-	mockableUsetunnel = func(ctx context.Context, t *clientlib.PsiphonTunnel) error {
-		return mockedError
+	mockableUsetunnel = func(
+		ctx context.Context, t *clientlib.PsiphonTunnel, config Config,
+	) ([]RequestResult, error) {
+		return nil, mockedError
 	}
 	config := Config{
 		ConfigFilePath: "../../../../testdata/psiphon_config.json",
 		WorkDirPath:    "/tmp/",
 	}
-	result := Run(context.Background(), config)
-	if result.Failure != mockedError.Error() {
+	result := run(context.Background(), config)
+	if result.Failure != FailureFetchError {
+		t.Fatal("Not the failure that we were expecting")
+	}
+	if result.FailureDetails != mockedError.Error() {
 		t.Fatal("Not the error that we were expecting")
 	}
 	mockableUsetunnel = savedFunc
 }
+
+// TestFetchTiming checks whether fetch records the status
+// code, the body length, and the timing.
+func TestFetchTiming(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(404)
+			w.Write([]byte("not found"))
+		},
+	))
+	defer srv.Close()
+	result := fetch(context.Background(), 0, srv.URL)
+	if result.Failure != "" {
+		t.Fatal(result.Failure)
+	}
+	if result.StatusCode != 404 {
+		t.Fatal("Unexpected status code")
+	}
+	if result.BodyLength != int64(len("not found")) {
+		t.Fatal("Unexpected body length")
+	}
+	if result.TimeToFirstByte <= 0 || result.TimeToFirstByte > result.TotalTime {
+		t.Fatal("Unexpected timing")
+	}
+}
+
+// TestFetchFailure checks whether fetch records failures.
+func TestFetchFailure(t *testing.T) {
+	result := fetch(context.Background(), 0, "\t")
+	if result.Failure == "" {
+		t.Fatal("We expected a failure here")
+	}
+}
+
+// TestUsetunnelMultipleURLs checks whether usetunnel fetches all the
+// URLs and returns the first error that occurred.
+func TestUsetunnelMultipleURLs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {},
+	))
+	defer srv.Close()
+	results, err := usetunnel(
+		context.Background(), &clientlib.PsiphonTunnel{},
+		Config{URLs: []string{srv.URL, "\t", srv.URL + "/x"}},
+	)
+	if err == nil {
+		t.Fatal("We expected an error here")
+	}
+	if len(results) != 3 {
+		t.Fatal("We did not fetch all the URLs")
+	}
+	if results[0].StatusCode != 200 || results[2].StatusCode != 200 {
+		t.Fatal("Unexpected status code")
+	}
+	if results[1].FailureDetails != err.Error() {
+		t.Fatal("Not the error we expected")
+	}
+}
+
+// TestClassifyStartTunnelError checks whether we map errors
+// occurring when starting the tunnel to the right failure.
+func TestClassifyStartTunnelError(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	var cases = []struct {
+		ctx        context.Context
+		err        error
+		candidates int64
+		failure    string
+	}{
+		{context.Background(), clientlib.ErrTimeout, 17, FailureTimeout},
+		{context.Background(), clientlib.ErrTimeout, -1, FailureTimeout},
+		{context.Background(), clientlib.ErrTimeout, 0, FailureNoServers},
+		{canceled, errors.New("controller.Run exited unexpectedly"), 3, FailureTimeout},
+		{context.Background(), errors.New("config.Commit failed: x"), 0, FailureConfigError},
+		{context.Background(), errors.New("failed to open data store"), 0, FailureTunnelError},
+	}
+	for _, c := range cases {
+		failure := classifyStartTunnelError(c.ctx, c.err, c.candidates)
+		if failure != c.failure {
+			t.Fatalf("%s: expected %s, got %s", c.err, c.failure, failure)
+		}
+	}
+}
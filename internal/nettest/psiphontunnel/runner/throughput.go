@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/httpx"
+	"github.com/measurement-kit/engine/model"
+)
+
+// DefaultThroughputSize is the number of bytes we download during the
+// throughput phase when Config.ThroughputSize is zero.
+const DefaultThroughputSize = 10 << 20
+
+// numLatencySamples is the number of latency samples we collect before
+// starting the download in the throughput phase.
+const numLatencySamples = 5
+
+// throughputSampleInterval is the interval between throughput samples.
+const throughputSampleInterval = 250 * time.Millisecond
+
+// ThroughputSample is a sample collected during the throughput phase. It is
+// also the value of the "psiphontunnel.throughput" events we emit.
+type ThroughputSample struct {
+	// Elapsed is the number of seconds since the download started.
+	Elapsed float64 `json:"elapsed"`
+
+	// NumBytes is the number of body bytes received so far.
+	NumBytes int64 `json:"num_bytes"`
+
+	// Goodput is the average goodput so far in kbit/s.
+	Goodput float64 `json:"goodput"`
+}
+
+// ThroughputResult contains the results of the throughput phase.
+type ThroughputResult struct {
+	// URL is the URL of the resource we downloaded.
+	URL string `json:"url"`
+
+	// Failure contains the failure that occurred, if any. A failure
+	// here does not cause Result.Failure to be set, since the tunnel
+	// has already been proven to work by fetching the URLs.
+	Failure string `json:"failure"`
+
+	// FailureDetails contains the error that caused the failure.
+	FailureDetails string `json:"failure_details"`
+
+	// LatencySamples contains the time in seconds it took to receive
+	// the response headers for each HEAD request we sent. The client we
+	// use with the tunnel does not reuse connections, hence each sample
+	// also includes the time to connect through the tunnel.
+	LatencySamples []float64 `json:"latency_samples"`
+
+	// Samples contains the samples collected while downloading.
+	Samples []ThroughputSample `json:"samples"`
+
+	// NumBytes is the number of body bytes we downloaded.
+	NumBytes int64 `json:"num_bytes"`
+
+	// Elapsed is the number of seconds the download took.
+	Elapsed float64 `json:"elapsed"`
+
+	// Goodput is the average goodput in kbit/s.
+	Goodput float64 `json:"goodput"`
+}
+
+// newThroughputSample creates a new sample.
+func newThroughputSample(elapsed time.Duration, numBytes int64) ThroughputSample {
+	sample := ThroughputSample{
+		Elapsed:  elapsed.Seconds(),
+		NumBytes: numBytes,
+	}
+	if sample.Elapsed > 0 {
+		sample.Goodput = float64(numBytes) * 8 / sample.Elapsed / 1000
+	}
+	return sample
+}
+
+// measurelatency sends HEAD requests for URL and returns the time it
+// took to receive the headers of each response.
+func measurelatency(
+	ctx context.Context, client *http.Client, URL string,
+) ([]float64, error) {
+	var samples []float64
+	for i := 0; i < numLatencySamples; i++ {
+		request, err := http.NewRequest("HEAD", URL, nil)
+		if err != nil {
+			return samples, err
+		}
+		t0 := time.Now()
+		response, err := client.Do(request.WithContext(ctx))
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, time.Now().Sub(t0).Seconds())
+		response.Body.Close()
+	}
+	return samples, nil
+}
+
+// download downloads at most maxBytes bytes from URL, collecting samples
+// and posting "psiphontunnel.throughput" events on out.
+func download(
+	ctx context.Context, client *http.Client, URL string, maxBytes int64,
+	result *ThroughputResult, out chan<- model.Event,
+) error {
+	request, err := http.NewRequest("GET", URL, nil)
+	if err != nil {
+		return err
+	}
+	t0 := time.Now()
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return &errorx.Error{
+			Err: fmt.Errorf(
+				"Request failed with status %d", response.StatusCode,
+			),
+			Failure: errorx.FailureHTTPRequestFailed,
+		}
+	}
+	reader := io.LimitReader(response.Body, maxBytes)
+	buffer := make([]byte, 1<<16)
+	lastSample := t0
+	for {
+		n, err := reader.Read(buffer)
+		result.NumBytes += int64(n)
+		if now := time.Now(); now.Sub(lastSample) >= throughputSampleInterval {
+			sample := newThroughputSample(now.Sub(t0), result.NumBytes)
+			result.Samples = append(result.Samples, sample)
+			out <- model.Event{Key: "psiphontunnel.throughput", Value: sample}
+			lastSample = now
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	final := newThroughputSample(time.Now().Sub(t0), result.NumBytes)
+	result.Elapsed, result.Goodput = final.Elapsed, final.Goodput
+	return nil
+}
+
+// measurethroughput runs the throughput phase using the SOCKS5 proxy
+// listening on port. See Config.ThroughputURL for more info.
+func measurethroughput(
+	ctx context.Context, port int, config Config, out chan<- model.Event,
+) *ThroughputResult {
+	result := &ThroughputResult{URL: config.ThroughputURL}
+	maxBytes := config.ThroughputSize
+	if maxBytes <= 0 {
+		maxBytes = DefaultThroughputSize
+	}
+	client, err := httpx.NewClient(port)
+	if err != nil {
+		result.Failure = errorx.Classify(err)
+		result.FailureDetails = errorx.Details(err)
+		return result
+	}
+	result.LatencySamples, err = measurelatency(ctx, client, config.ThroughputURL)
+	if err != nil {
+		result.Failure = errorx.Classify(err)
+		result.FailureDetails = errorx.Details(err)
+		return result
+	}
+	err = download(ctx, client, config.ThroughputURL, maxBytes, result, out)
+	if err != nil {
+		result.Failure = errorx.Classify(err)
+		result.FailureDetails = errorx.Details(err)
+		return result
+	}
+	return result
+}
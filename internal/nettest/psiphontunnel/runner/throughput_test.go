@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/model"
+)
+
+// withThroughputServer runs fn with a server returning size bytes.
+func withThroughputServer(t *testing.T, size int, fn func(URL string)) {
+	body := bytes.Repeat([]byte("A"), size)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/download" {
+				w.WriteHeader(404)
+				return
+			}
+			w.Write(body)
+		},
+	))
+	defer srv.Close()
+	fn(srv.URL + "/download")
+}
+
+// TestMeasurethroughput checks whether we download at most the
+// configured number of bytes and we collect latency samples.
+func TestMeasurethroughput(t *testing.T) {
+	withThroughputServer(t, 1<<20, func(URL string) {
+		out := make(chan model.Event, 1024)
+		result := measurethroughput(context.Background(), 0, Config{
+			ThroughputURL:  URL,
+			ThroughputSize: 1 << 19,
+		}, out)
+		if result.Failure != "" {
+			t.Fatal(result.Failure)
+		}
+		if result.NumBytes != 1<<19 {
+			t.Fatal("Unexpected number of bytes")
+		}
+		if len(result.LatencySamples) != numLatencySamples {
+			t.Fatal("Unexpected number of latency samples")
+		}
+		if result.Goodput <= 0 || result.Elapsed <= 0 {
+			t.Fatal("Unexpected goodput or elapsed")
+		}
+		if len(out) != len(result.Samples) {
+			t.Fatal("We did not emit an event for each sample")
+		}
+	})
+}
+
+// TestMeasurethroughputFailure checks whether we deal with
+// failures when measuring the throughput.
+func TestMeasurethroughputFailure(t *testing.T) {
+	withThroughputServer(t, 1024, func(URL string) {
+		result := measurethroughput(context.Background(), 0, Config{
+			ThroughputURL: "\t",
+		}, make(chan model.Event))
+		if result.Failure == "" {
+			t.Fatal("We expected a failure with an invalid URL")
+		}
+		result = measurethroughput(context.Background(), 0, Config{
+			ThroughputURL: URL + "/nonexistent",
+		}, make(chan model.Event))
+		if result.Failure != errorx.FailureHTTPRequestFailed {
+			t.Fatalf("Unexpected failure: %s", result.Failure)
+		}
+	})
+}
+
+// TestNewThroughputSample checks whether we compute the goodput correctly.
+func TestNewThroughputSample(t *testing.T) {
+	sample := newThroughputSample(2e9, 1000)
+	if sample.Elapsed != 2 || sample.Goodput != 4 {
+		t.Fatal("Unexpected sample")
+	}
+	if newThroughputSample(0, 1000).Goodput != 0 {
+		t.Fatal("Unexpected goodput with zero elapsed")
+	}
+}
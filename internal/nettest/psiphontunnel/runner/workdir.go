@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultMaxWorkDirSize is the maximum size in bytes of the Psiphon
+// working directory we use when Config.MaxWorkDirSize is zero.
+const DefaultMaxWorkDirSize = 64 << 20
+
+// staleLockAge is the age after which we consider a lock file left
+// behind by a crashed run as stale and remove it.
+const staleLockAge = time.Hour
+
+// ErrWorkDirBusy indicates that another run is using the working directory.
+var ErrWorkDirBusy = errors.New("Psiphon working directory is in use")
+
+// The following are the possible values of Result.BootstrapType.
+const (
+	// BootstrapCold indicates that we started from an empty
+	// working directory, like a first time user would do.
+	BootstrapCold = "cold"
+
+	// BootstrapWarm indicates that we reused the working directory
+	// of a previous run, like a returning user would do.
+	BootstrapWarm = "warm"
+)
+
+// lockworkdir creates the lock file protecting workdir, so that two
+// concurrent runs do not share the same kept working directory. On
+// success it returns the function that removes the lock file.
+func lockworkdir(workdir string) (func(), error) {
+	lockfile := workdir + ".lock"
+	for attempt := 0; attempt < 2; attempt++ {
+		fp, err := os.OpenFile(lockfile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fp.Close()
+			return func() { os.Remove(lockfile) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		info, err := os.Stat(lockfile)
+		if err != nil || time.Now().Sub(info.ModTime()) < staleLockAge {
+			break
+		}
+		os.Remove(lockfile)
+	}
+	return nil, ErrWorkDirBusy
+}
+
+// dirsize returns the total size in bytes of the files inside dir.
+func dirsize(dir string) (int64, error) {
+	var total int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// mustwipe returns whether we should remove workdir before running. We
+// always do that unless config.KeepWorkDir is true. In such case we only
+// do that when workdir exceeds the maximum configured size.
+func mustwipe(config Config, workdir string) bool {
+	if !config.KeepWorkDir {
+		return true
+	}
+	maxsize := config.MaxWorkDirSize
+	if maxsize <= 0 {
+		maxsize = DefaultMaxWorkDirSize
+	}
+	size, err := dirsize(workdir)
+	return err != nil || size > maxsize
+}
+
+// bootstraptype returns whether the bootstrap using workdir
+// is going to be a cold or a warm bootstrap.
+func bootstraptype(workdir string) string {
+	entries, err := ioutil.ReadDir(workdir)
+	if err != nil || len(entries) <= 0 {
+		return BootstrapCold
+	}
+	return BootstrapWarm
+}
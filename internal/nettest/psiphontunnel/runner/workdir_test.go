@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// withTempDir runs fn with a temporary directory.
+func withTempDir(t *testing.T, fn func(dir string)) {
+	dir, err := ioutil.TempDir("", "runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn(dir)
+}
+
+// TestLockworkdir checks whether we prevent two runs from
+// concurrently using the same working directory.
+func TestLockworkdir(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		workdir := filepath.Join(dir, "workdir")
+		release, err := lockworkdir(workdir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = lockworkdir(workdir)
+		if err != ErrWorkDirBusy {
+			t.Fatal("Not the error we expected")
+		}
+		release()
+		release, err = lockworkdir(workdir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	})
+}
+
+// TestLockworkdirStale checks whether we remove stale lock files.
+func TestLockworkdirStale(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		workdir := filepath.Join(dir, "workdir")
+		err := ioutil.WriteFile(workdir+".lock", nil, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-2 * staleLockAge)
+		err = os.Chtimes(workdir+".lock", old, old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release, err := lockworkdir(workdir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	})
+}
+
+// TestMustwipe checks the policy for wiping the working directory.
+func TestMustwipe(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		err := ioutil.WriteFile(filepath.Join(dir, "x"), make([]byte, 128), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !mustwipe(Config{}, dir) {
+			t.Fatal("We should wipe when not keeping the workdir")
+		}
+		if mustwipe(Config{KeepWorkDir: true}, dir) {
+			t.Fatal("We should not wipe a small workdir")
+		}
+		if !mustwipe(Config{KeepWorkDir: true, MaxWorkDirSize: 64}, dir) {
+			t.Fatal("We should wipe a workdir that is too large")
+		}
+		if !mustwipe(Config{KeepWorkDir: true}, filepath.Join(dir, "y")) {
+			t.Fatal("We should wipe a workdir we cannot walk")
+		}
+	})
+}
+
+// TestProcessconfigKeepWorkDir checks whether we get a cold bootstrap
+// the first time and a warm bootstrap afterwards.
+func TestProcessconfigKeepWorkDir(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		config := Config{
+			ConfigJSON:  testConfig,
+			KeepWorkDir: true,
+			WorkDirPath: dir,
+		}
+		setup, err := processconfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if setup.bootstrapType != BootstrapCold {
+			t.Fatal("We expected a cold bootstrap")
+		}
+		_, err = processconfig(config)
+		if err != ErrWorkDirBusy {
+			t.Fatal("We expected the workdir to be locked")
+		}
+		datastore := filepath.Join(*setup.params.DataRootDirectory, "psiphon.db")
+		err = ioutil.WriteFile(datastore, []byte("x"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setup.release()
+		setup, err = processconfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if setup.bootstrapType != BootstrapWarm {
+			t.Fatal("We expected a warm bootstrap")
+		}
+		setup.release()
+		config.KeepWorkDir = false
+		setup, err = processconfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if setup.bootstrapType != BootstrapCold {
+			t.Fatal("We expected a cold bootstrap")
+		}
+		other, err := processconfig(config)
+		if err != nil {
+			t.Fatal("We expected no lock without KeepWorkDir")
+		}
+		other.release()
+		setup.release()
+	})
+}
@@ -0,0 +1,246 @@
+// Package sniblocking implements the sni_blocking nettest.
+//
+// For each input domain, we perform two TLS handshakes with the same
+// control endpoint: the first using the control SNI and the second using
+// the input domain as the SNI. Since the control endpoint does not serve
+// the input domain, we expect the second handshake to fail because of
+// a certificate mismatch. Any other outcome, e.g., a connection reset
+// or a timeout, means someone interfered based on the SNI.
+package sniblocking
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/tlsx"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+)
+
+// DefaultControlSNI is the default control SNI.
+const DefaultControlSNI = "example.com"
+
+// connectTimeout is the timeout for each TCP connect.
+const connectTimeout = 10 * time.Second
+
+// tlsHandshakeTimeout is the timeout for each TLS handshake. It is a
+// variable such that we can make it shorter in tests.
+var tlsHandshakeTimeout = 10 * time.Second
+
+// rootCAs allows to use custom root CAs in tests. We use the system
+// root CAs when it is nil.
+var rootCAs *x509.CertPool
+
+// ErrInvalidInput indicates that the input is not a domain name.
+var ErrInvalidInput = errors.New("input is not a valid domain name")
+
+// The outcomes of a TLS handshake.
+const (
+	OutcomeConnectFailed      = "connect_failed"
+	OutcomeConnectionReset    = "connection_reset"
+	OutcomeEOF                = "eof"
+	OutcomeInvalidCertificate = "ssl_invalid_certificate"
+	OutcomeInvalidHostname    = "ssl_invalid_hostname"
+	OutcomeOther              = "other"
+	OutcomeSuccess            = "success"
+	OutcomeTimeout            = "timeout"
+	OutcomeUnknownAuthority   = "ssl_unknown_authority"
+)
+
+// resultAccessible is the result when there is no SNI blocking.
+const resultAccessible = "accessible"
+
+// resultTestHelperUnreachable is the result when the control fails.
+const resultTestHelperUnreachable = "anomaly.test_helper_unreachable"
+
+// Config contains the sni_blocking nettest configuration.
+type Config struct {
+	// ControlSNI is the SNI served by the control endpoint. When empty,
+	// we use DefaultControlSNI.
+	ControlSNI string
+
+	// Endpoint is the "host:port" control endpoint. When empty, we use
+	// the ControlSNI and port 443.
+	Endpoint string
+}
+
+// PeerCertificate is a certificate sent by the TLS peer.
+type PeerCertificate struct {
+	// Data is the DER encoded certificate, serialized as base64.
+	Data []byte `json:"data"`
+
+	// Format is always "base64".
+	Format string `json:"format"`
+}
+
+// Handshake is the result of a TLS handshake.
+type Handshake struct {
+	// Address is the endpoint we connected to.
+	Address string `json:"address"`
+
+	// CipherSuite is the negotiated cipher suite.
+	CipherSuite string `json:"cipher_suite"`
+
+	// Elapsed is the number of seconds it took to connect and to
+	// complete or fail the handshake.
+	Elapsed float64 `json:"elapsed"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Outcome classifies the handshake result. It is one of "success",
+	// "connect_failed", "connection_reset", "eof", "timeout",
+	// "ssl_invalid_hostname", "ssl_unknown_authority",
+	// "ssl_invalid_certificate", and "other".
+	Outcome string `json:"outcome"`
+
+	// PeerCertificates contains the certificates sent by the peer.
+	PeerCertificates []PeerCertificate `json:"peer_certificates"`
+
+	// ServerName is the SNI we sent.
+	ServerName string `json:"server_name"`
+
+	// TLSVersion is the negotiated TLS version.
+	TLSVersion string `json:"tls_version"`
+}
+
+// TestKeys contains the sni_blocking test keys.
+type TestKeys struct {
+	// Control is the handshake using the control SNI.
+	Control Handshake `json:"control"`
+
+	// Failure is the failure that prevented the measurement, if any.
+	Failure *string `json:"failure"`
+
+	// FailureDetails is the original error message, if any.
+	FailureDetails *string `json:"failure_details"`
+
+	// Result is "accessible" when the target handshake reached the
+	// control endpoint, "anomaly.test_helper_unreachable" when the control
+	// handshake failed, and "anomaly." followed by the target handshake
+	// outcome, e.g., "anomaly.connection_reset", otherwise.
+	Result string `json:"result"`
+
+	// Target is the handshake using the input domain as SNI.
+	Target Handshake `json:"target"`
+}
+
+// outcomes maps the errorx failures to the handshake outcomes. The
+// failures that are not listed here are mapped to OutcomeOther.
+var outcomes = map[string]string{
+	"":                                OutcomeSuccess,
+	errorx.FailureConnectionReset:     OutcomeConnectionReset,
+	errorx.FailureEOF:                 OutcomeEOF,
+	errorx.FailureGenericTimeout:      OutcomeTimeout,
+	errorx.FailureSSLInvalidCert:      OutcomeInvalidCertificate,
+	errorx.FailureSSLInvalidHostname:  OutcomeInvalidHostname,
+	errorx.FailureSSLUnknownAuthority: OutcomeUnknownAuthority,
+}
+
+// classify returns the outcome corresponding to err.
+func classify(err error) string {
+	if outcome, ok := outcomes[errorx.Classify(err)]; ok {
+		return outcome
+	}
+	return OutcomeOther
+}
+
+// handshake connects to endpoint and performs a TLS handshake
+// using serverName as the SNI.
+func handshake(ctx context.Context, endpoint, serverName string) Handshake {
+	result := Handshake{Address: endpoint, ServerName: serverName}
+	begin := time.Now()
+	defer func() { result.Elapsed = time.Now().Sub(begin).Seconds() }()
+	dialer := net.Dialer{Timeout: connectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", endpoint)
+	if err != nil {
+		result.Failure = errorx.FailureString(err)
+		result.Outcome = OutcomeConnectFailed
+		return result
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	tlsconn := tls.Client(conn, &tls.Config{
+		RootCAs:    rootCAs,
+		ServerName: serverName,
+	})
+	err = tlsconn.Handshake()
+	result.Failure = errorx.FailureString(err)
+	result.Outcome = classify(err)
+	state := tlsconn.ConnectionState()
+	result.CipherSuite = tlsx.CipherSuiteString(state.CipherSuite)
+	result.TLSVersion = tlsx.VersionString(state.Version)
+	for _, cert := range state.PeerCertificates {
+		result.PeerCertificates = append(result.PeerCertificates, PeerCertificate{
+			Data: cert.Raw, Format: "base64",
+		})
+	}
+	return result
+}
+
+// analyze returns the result of comparing the handshakes.
+func analyze(tk *TestKeys) string {
+	if tk.Control.Outcome != OutcomeSuccess {
+		return resultTestHelperUnreachable
+	}
+	switch tk.Target.Outcome {
+	case OutcomeSuccess, OutcomeInvalidHostname:
+		return resultAccessible
+	}
+	return "anomaly." + tk.Target.Outcome
+}
+
+// Measure runs sni_blocking for the domain in input using config. It
+// returns the test keys.
+func Measure(
+	ctx context.Context, config Config, input string, out chan<- model.Event,
+) *TestKeys {
+	tk := &TestKeys{}
+	if input == "" || strings.ContainsAny(input, ":/ ") {
+		tk.Failure = errorx.FailureString(ErrInvalidInput)
+		tk.FailureDetails = errorx.DetailsString(ErrInvalidInput)
+		out <- model.NewFailureMeasurementEvent(0, ErrInvalidInput)
+		return tk
+	}
+	controlSNI := config.ControlSNI
+	if controlSNI == "" {
+		controlSNI = DefaultControlSNI
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = net.JoinHostPort(controlSNI, "443")
+	}
+	out <- model.NewLogInfoEvent("sni_blocking: handshake using " + controlSNI)
+	tk.Control = handshake(ctx, endpoint, controlSNI)
+	out <- model.NewLogInfoEvent("sni_blocking: handshake using " + input)
+	tk.Target = handshake(ctx, endpoint, input)
+	tk.Result = analyze(tk)
+	return tk
+}
+
+// NewNettest creates a new sni_blocking nettest. The input of each
+// measurement is the domain to use as SNI.
+func NewNettest(config Config) *nettest.Nettest {
+	return &nettest.Nettest{
+		TestName:        "sni_blocking",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+		Main: func(
+			ctx context.Context,
+			input string,
+			measurement *model.Measurement,
+			out chan<- model.Event,
+		) {
+			measurement.TestKeys = Measure(ctx, config, input, out)
+		},
+	}
+}
@@ -0,0 +1,185 @@
+package sniblocking
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// blockedSNI is the SNI blocked by the middlebox.
+const blockedSNI = "www.blocked.org"
+
+// withServer runs fn with a local control endpoint serving example.com.
+func withServer(t *testing.T, fn func(endpoint string)) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	savedRootCAs := rootCAs
+	rootCAs = x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+	defer func() { rootCAs = savedRootCAs }()
+	fn(strings.TrimPrefix(server.URL, "https://"))
+}
+
+// withMiddlebox runs fn with a middlebox in front of the local control
+// endpoint that calls block for connections using blockedSNI.
+func withMiddlebox(t *testing.T, block func(conn *net.TCPConn), fn func(endpoint string)) {
+	withServer(t, func(endpoint string) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					hello := make([]byte, 1<<12)
+					n, err := conn.Read(hello)
+					if err != nil {
+						return
+					}
+					if bytes.Contains(hello[:n], []byte(blockedSNI)) {
+						block(conn.(*net.TCPConn))
+						return
+					}
+					backend, err := net.Dial("tcp", endpoint)
+					if err != nil {
+						return
+					}
+					defer backend.Close()
+					backend.Write(hello[:n])
+					go io.Copy(backend, conn)
+					io.Copy(conn, backend)
+				}()
+			}
+		}()
+		fn(listener.Addr().String())
+	})
+}
+
+// measure runs Measure draining the events.
+func measure(config Config, input string) *TestKeys {
+	var tk *TestKeys
+	nettesttest.Drain(func(out chan<- model.Event) {
+		tk = Measure(context.Background(), config, input, out)
+	})
+	return tk
+}
+
+// TestMeasureAccessible checks whether we do not flag blocking when
+// the target handshake fails because of the certificate mismatch.
+func TestMeasureAccessible(t *testing.T) {
+	withMiddlebox(t, func(conn *net.TCPConn) {}, func(endpoint string) {
+		tk := measure(Config{Endpoint: endpoint}, "www.antani.org")
+		if tk.Control.Outcome != OutcomeSuccess {
+			t.Fatalf("Unexpected control outcome: %s", tk.Control.Outcome)
+		}
+		if tk.Control.TLSVersion == "" || len(tk.Control.PeerCertificates) != 1 {
+			t.Fatal("Missing control handshake details")
+		}
+		if tk.Target.Outcome != OutcomeInvalidHostname {
+			t.Fatalf("Unexpected target outcome: %s", tk.Target.Outcome)
+		}
+		if tk.Result != "accessible" {
+			t.Fatalf("Unexpected result: %s", tk.Result)
+		}
+	})
+}
+
+// TestMeasureReset checks whether we detect connection resets.
+func TestMeasureReset(t *testing.T) {
+	reset := func(conn *net.TCPConn) {
+		conn.SetLinger(0)
+	}
+	withMiddlebox(t, reset, func(endpoint string) {
+		tk := measure(Config{Endpoint: endpoint}, blockedSNI)
+		if tk.Control.Outcome != OutcomeSuccess {
+			t.Fatalf("Unexpected control outcome: %s", tk.Control.Outcome)
+		}
+		if tk.Result != "anomaly.connection_reset" {
+			t.Fatalf("Unexpected result: %s", tk.Result)
+		}
+	})
+}
+
+// TestMeasureTimeout checks whether we detect timeouts.
+func TestMeasureTimeout(t *testing.T) {
+	savedTimeout := tlsHandshakeTimeout
+	tlsHandshakeTimeout = 250 * time.Millisecond
+	defer func() { tlsHandshakeTimeout = savedTimeout }()
+	hang := func(conn *net.TCPConn) {
+		time.Sleep(time.Second)
+	}
+	withMiddlebox(t, hang, func(endpoint string) {
+		tk := measure(Config{Endpoint: endpoint}, blockedSNI)
+		if tk.Result != "anomaly.timeout" {
+			t.Fatalf("Unexpected result: %s", tk.Result)
+		}
+	})
+}
+
+// TestMeasureControlFailure checks whether we detect control failures.
+func TestMeasureControlFailure(t *testing.T) {
+	tk := measure(Config{Endpoint: "127.0.0.1:0"}, "www.antani.org")
+	if tk.Control.Outcome != OutcomeConnectFailed {
+		t.Fatal("Unexpected control outcome")
+	}
+	if tk.Result != "anomaly.test_helper_unreachable" {
+		t.Fatal("Unexpected result")
+	}
+}
+
+// TestMeasureInvalidInput checks whether we deal with invalid inputs.
+func TestMeasureInvalidInput(t *testing.T) {
+	for _, input := range []string{"", "https://www.example.com/", "x:443"} {
+		tk := measure(Config{}, input)
+		if tk.Failure == nil || *tk.Failure != errorx.Classify(ErrInvalidInput) {
+			t.Fatalf("Expected ErrInvalidInput with %q", input)
+		}
+	}
+}
+
+// TestClassify checks whether we classify errors.
+func TestClassify(t *testing.T) {
+	table := map[string]error{
+		OutcomeSuccess:            nil,
+		OutcomeEOF:                io.EOF,
+		OutcomeTimeout:            context.DeadlineExceeded,
+		OutcomeUnknownAuthority:   errors.New("x509: certificate signed by unknown authority"),
+		OutcomeInvalidCertificate: errors.New("x509: certificate has expired"),
+		OutcomeOther:              errors.New("mocked error"),
+	}
+	for outcome, err := range table {
+		if classify(err) != outcome {
+			t.Fatalf("Unexpected outcome for %s", err.Error())
+		}
+	}
+}
+
+// TestNewNettest checks whether the nettest uses the input.
+func TestNewNettest(t *testing.T) {
+	withServer(t, func(endpoint string) {
+		nt := NewNettest(Config{Endpoint: endpoint})
+		measurement := nettesttest.Run(nt, "www.antani.org")
+		tk := measurement.TestKeys.(*TestKeys)
+		if tk.Result != "accessible" || tk.Target.ServerName != "www.antani.org" {
+			t.Fatal("Unexpected result")
+		}
+	})
+}
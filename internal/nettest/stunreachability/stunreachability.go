@@ -0,0 +1,271 @@
+// Package stunreachability implements the stun_reachability nettest.
+//
+// The input of each measurement is a STUN server endpoint, e.g.,
+// "stun.l.google.com:19302", where the port defaults to 3478. We send
+// a binding request over UDP, retransmitting it with exponential backoff
+// until we receive a response or we time out. We record the mapped
+// address, the RTT, and whether the failure, if any, was a timeout or
+// an ICMP error, which allows to determine how STUN is blocked.
+package stunreachability
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/stunx"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+)
+
+// DefaultPort is the port we use when the input does not specify it.
+const DefaultPort = "3478"
+
+// Scrubbed is the value we use in place of the mapped address when
+// we are not allowed to save it.
+const Scrubbed = "[scrubbed]"
+
+// initialInterval is the initial retransmission interval, which we
+// double after each retransmission. It is a variable such that we can
+// make it shorter in tests.
+var initialInterval = 500 * time.Millisecond
+
+// timeout is the time after which we give up waiting for a response. It
+// is a variable such that we can make it shorter in tests.
+var timeout = 10 * time.Second
+
+// ErrInvalidEndpoint indicates that the input is not a valid endpoint.
+var ErrInvalidEndpoint = errors.New("input is not a valid STUN endpoint")
+
+// ErrTimeout indicates that we did not receive any response.
+var ErrTimeout error = &errorx.Error{
+	Err:     errors.New("stun_reachability: no response received"),
+	Failure: errorx.FailureGenericTimeout,
+}
+
+// The outcomes of a measurement.
+const (
+	OutcomeICMPError       = "icmp_error"
+	OutcomeInvalidEndpoint = "invalid_endpoint"
+	OutcomeInvalidResponse = "invalid_response"
+	OutcomeOtherError      = "other_error"
+	OutcomeSuccess         = "success"
+	OutcomeTimeout         = "timeout"
+)
+
+// Config contains the stun_reachability nettest configuration.
+type Config struct {
+	// SaveProbeIP indicates whether we can save the mapped address,
+	// which most likely is the probe IP. When false, we replace it
+	// with Scrubbed.
+	SaveProbeIP bool
+}
+
+// TestKeys contains the stun_reachability test keys.
+type TestKeys struct {
+	// Attempts is the number of binding requests we sent.
+	Attempts int `json:"attempts"`
+
+	// Endpoint is the "host:port" endpoint we measured.
+	Endpoint string `json:"endpoint"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// FailureDetails is the original error message, if any.
+	FailureDetails *string `json:"failure_details"`
+
+	// MappedAddress is the "ip:port" address the server saw, or
+	// Scrubbed if we are not allowed to save the probe IP.
+	MappedAddress string `json:"mapped_address"`
+
+	// Outcome is one of "success", "timeout", "icmp_error",
+	// "invalid_response", "invalid_endpoint", and "other_error".
+	Outcome string `json:"outcome"`
+
+	// RTT is the number of seconds elapsed between sending the last
+	// binding request and receiving the response.
+	RTT float64 `json:"rtt"`
+}
+
+// isICMPError returns whether err was caused by an ICMP error received
+// in response to one of our datagrams, which the kernel reports as an
+// error on the connected UDP socket.
+func isICMPError(err error) bool {
+	if operr, ok := err.(*net.OpError); ok {
+		err = operr.Err
+	}
+	if syscallerr, ok := err.(*os.SyscallError); ok {
+		err = syscallerr.Err
+	}
+	switch err {
+	case syscall.ECONNREFUSED, syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+		return true
+	}
+	return false
+}
+
+// endpoint returns the endpoint to measure given the input.
+func endpoint(input string) (string, error) {
+	if input == "" {
+		return "", ErrInvalidEndpoint
+	}
+	if _, _, err := net.SplitHostPort(input); err == nil {
+		return input, nil
+	}
+	return net.JoinHostPort(input, DefaultPort), nil
+}
+
+// classify returns the outcome corresponding to a network error.
+func classify(err error) string {
+	if isICMPError(err) {
+		return OutcomeICMPError
+	}
+	return OutcomeOtherError
+}
+
+// interrupted returns the outcome and the error to use when ctx is
+// done, which we detect because we close conn to unblock reads.
+func interrupted(ctx context.Context) (string, string, error) {
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", OutcomeTimeout, ErrTimeout
+	}
+	return "", OutcomeOtherError, ctx.Err()
+}
+
+// exchange sends request using conn until it receives a response,
+// deadline expires, or ctx is done. It returns the mapped address
+// and the outcome.
+func exchange(
+	ctx context.Context, conn net.Conn, request []byte, deadline time.Time,
+	tk *TestKeys, out chan<- model.Event,
+) (string, string, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close() // unblock reads
+		case <-done:
+		}
+	}()
+	buffer := make([]byte, 1<<12)
+	for interval := initialInterval; ; interval *= 2 {
+		if tk.Attempts > 0 {
+			out <- model.NewLogInfoEvent("retransmitting STUN binding request")
+		}
+		begin := time.Now()
+		if _, err := conn.Write(request); err != nil {
+			if ctx.Err() != nil {
+				return interrupted(ctx)
+			}
+			return "", classify(err), err
+		}
+		tk.Attempts++
+		readDeadline := begin.Add(interval)
+		if readDeadline.After(deadline) {
+			readDeadline = deadline
+		}
+		conn.SetReadDeadline(readDeadline)
+		for {
+			n, err := conn.Read(buffer)
+			if operr, ok := err.(net.Error); ok && operr.Timeout() {
+				if !time.Now().Before(deadline) {
+					return "", OutcomeTimeout, ErrTimeout
+				}
+				break
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return interrupted(ctx)
+				}
+				return "", classify(err), err
+			}
+			mapped, err := stunx.ParseBindingResponse(request, buffer[:n])
+			if err == stunx.ErrMismatch {
+				continue // not a response to our request
+			}
+			tk.RTT = time.Now().Sub(begin).Seconds()
+			if err != nil {
+				return "", OutcomeInvalidResponse, err
+			}
+			return mapped, OutcomeSuccess, nil
+		}
+	}
+}
+
+// Measure runs stun_reachability with the specified config and input and
+// returns the test keys.
+func Measure(
+	ctx context.Context, config Config, input string, out chan<- model.Event,
+) *TestKeys {
+	tk := &TestKeys{}
+	var err error
+	tk.Endpoint, err = endpoint(input)
+	if err != nil {
+		tk.Failure = errorx.FailureString(err)
+		tk.FailureDetails = errorx.DetailsString(err)
+		tk.Outcome = OutcomeInvalidEndpoint
+		out <- model.NewFailureMeasurementEvent(0, err)
+		return tk
+	}
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	request, err := stunx.NewBindingRequest()
+	if err != nil {
+		tk.Failure = errorx.FailureString(err)
+		tk.FailureDetails = errorx.DetailsString(err)
+		tk.Outcome = OutcomeOtherError
+		return tk
+	}
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "udp", tk.Endpoint)
+	if err != nil {
+		tk.Failure = errorx.FailureString(err)
+		tk.FailureDetails = errorx.DetailsString(err)
+		tk.Outcome = OutcomeOtherError
+		out <- model.NewFailureMeasurementEvent(0, err)
+		return tk
+	}
+	defer conn.Close()
+	mapped, outcome, err := exchange(ctx, conn, request, deadline, tk, out)
+	tk.Outcome = outcome
+	if err != nil {
+		tk.Failure = errorx.FailureString(err)
+		tk.FailureDetails = errorx.DetailsString(err)
+		out <- model.NewFailureMeasurementEvent(0, err)
+		return tk
+	}
+	tk.MappedAddress = Scrubbed
+	if config.SaveProbeIP {
+		tk.MappedAddress = mapped
+	}
+	return tk
+}
+
+// NewNettest creates a new stun_reachability nettest using config. The
+// input of each measurement is the STUN endpoint to measure.
+func NewNettest(config Config) *nettest.Nettest {
+	return &nettest.Nettest{
+		TestName:        "stun_reachability",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+		Main: func(
+			ctx context.Context,
+			input string,
+			measurement *model.Measurement,
+			out chan<- model.Event,
+		) {
+			measurement.TestKeys = Measure(ctx, config, input, out)
+		},
+	}
+}
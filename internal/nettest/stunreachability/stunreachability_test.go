@@ -0,0 +1,178 @@
+package stunreachability
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/internal/stunx"
+	"github.com/measurement-kit/engine/internal/stunx/stuntest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// run runs Measure and returns the test keys.
+func run(config Config, input string) *TestKeys {
+	var tk *TestKeys
+	nettesttest.Drain(func(out chan<- model.Event) {
+		tk = Measure(context.Background(), config, input, out)
+	})
+	return tk
+}
+
+// shorten makes the retransmission interval and the timeout shorter
+// and returns a function restoring them.
+func shorten() func() {
+	savedInterval, savedTimeout := initialInterval, timeout
+	initialInterval, timeout = 50*time.Millisecond, 500*time.Millisecond
+	return func() { initialInterval, timeout = savedInterval, savedTimeout }
+}
+
+// newServer starts a STUN server dropping drop requests.
+func newServer(t *testing.T, drop int) *stuntest.Server {
+	server, err := stuntest.NewServer(drop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// TestMeasureSuccess checks whether we save the mapped address only
+// when we are allowed to and whether we retransmit requests.
+func TestMeasureSuccess(t *testing.T) {
+	defer shorten()()
+	server := newServer(t, 0)
+	defer server.Close()
+	tk := run(Config{}, server.Addr)
+	if tk.Outcome != OutcomeSuccess || tk.Failure != nil || tk.Attempts != 1 {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+	if tk.MappedAddress != Scrubbed || tk.RTT <= 0 || tk.Endpoint != server.Addr {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+	dropping := newServer(t, 2)
+	defer dropping.Close()
+	tk = run(Config{SaveProbeIP: true}, dropping.Addr)
+	if tk.Outcome != OutcomeSuccess || tk.Attempts != 3 {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+	host, _, err := net.SplitHostPort(tk.MappedAddress)
+	if err != nil || host != "127.0.0.1" {
+		t.Fatalf("Unexpected mapped address: %s", tk.MappedAddress)
+	}
+}
+
+// TestMeasureTimeout checks whether we detect timeouts.
+func TestMeasureTimeout(t *testing.T) {
+	defer shorten()()
+	server := newServer(t, 1000)
+	defer server.Close()
+	tk := run(Config{}, server.Addr)
+	if tk.Outcome != OutcomeTimeout || tk.Failure == nil || *tk.Failure != errorx.FailureGenericTimeout {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+	if tk.Attempts < 2 || tk.MappedAddress != "" {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+	if tk.FailureDetails == nil || *tk.FailureDetails != ErrTimeout.Error() {
+		t.Fatal("Unexpected failure details")
+	}
+}
+
+// TestMeasureInterrupted checks whether we stop waiting for the
+// response as soon as the context is canceled.
+func TestMeasureInterrupted(t *testing.T) {
+	server := newServer(t, 1000)
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	begin := time.Now()
+	var tk *TestKeys
+	nettesttest.Drain(func(out chan<- model.Event) {
+		tk = Measure(ctx, Config{}, server.Addr, out)
+	})
+	if time.Now().Sub(begin) > time.Second {
+		t.Fatal("We did not stop when the context was canceled")
+	}
+	if tk.Outcome != OutcomeOtherError || tk.Failure == nil ||
+		*tk.Failure != errorx.FailureInterrupted {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+}
+
+// TestMeasureICMPError checks whether we detect ICMP errors, which we
+// cause by sending requests to a closed port.
+func TestMeasureICMPError(t *testing.T) {
+	defer shorten()()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	tk := run(Config{}, addr)
+	if tk.Outcome != OutcomeICMPError || tk.Failure == nil {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+}
+
+// TestMeasureInvalidResponse checks whether we detect invalid responses
+// and whether we ignore responses to other requests.
+func TestMeasureInvalidResponse(t *testing.T) {
+	defer shorten()()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buffer := make([]byte, 1<<12)
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		other, _ := stunx.NewBindingRequest()
+		conn.WriteTo(other, addr)
+		message, _ := stunx.Unpack(buffer[:n])
+		response := &stunx.Message{
+			TransactionID: message.TransactionID,
+			Type:          stunx.TypeBindingSuccess,
+		}
+		conn.WriteTo(response.Pack(), addr)
+	}()
+	tk := run(Config{}, conn.LocalAddr().String())
+	if tk.Outcome != OutcomeInvalidResponse || tk.Failure == nil {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+	if *tk.Failure != errorx.Classify(stunx.ErrNoMappedAddress) {
+		t.Fatalf("Unexpected failure: %s", *tk.Failure)
+	}
+}
+
+// TestMeasureInvalidEndpoint checks whether we deal with invalid inputs.
+func TestMeasureInvalidEndpoint(t *testing.T) {
+	tk := run(Config{}, "")
+	if tk.Outcome != OutcomeInvalidEndpoint || tk.Failure == nil {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+	tk = run(Config{}, "[::1")
+	if tk.Outcome != OutcomeOtherError || tk.Failure == nil {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+}
+
+// TestEndpoint checks whether we use the default port when needed.
+func TestEndpoint(t *testing.T) {
+	table := map[string]string{
+		"stun.example.com":       "stun.example.com:3478",
+		"stun.example.com:19302": "stun.example.com:19302",
+		"2001:db8::1":            "[2001:db8::1]:3478",
+	}
+	for input, expected := range table {
+		if result, err := endpoint(input); err != nil || result != expected {
+			t.Fatalf("Unexpected endpoint for %q: %s", input, result)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Package tcpconnect implements the tcp_connect nettest.
+//
+// The input of each measurement is a "host:port" endpoint. We resolve
+// the host and we attempt to connect to each resolved address, recording
+// the connect time and the failure, if any. Failures are classified such
+// that we can tell refused and reset connections apart from timeouts and
+// from DNS failures, which allows to determine how an endpoint is blocked.
+package tcpconnect
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+)
+
+// connectTimeout is the timeout for each connect attempt. It is a
+// variable such that we can make it shorter in tests.
+var connectTimeout = 10 * time.Second
+
+// lookupHost allows to mock the DNS resolution in tests.
+var lookupHost = func(ctx context.Context, host string) ([]string, error) {
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// ErrInvalidInput indicates that the input is not a "host:port" endpoint.
+var ErrInvalidInput = errors.New("input is not a valid endpoint")
+
+// Connect is the result of connecting to a resolved address.
+type Connect struct {
+	// Address is the "ip:port" endpoint we connected to.
+	Address string `json:"address"`
+
+	// ConnectTime is the number of seconds it took to connect.
+	ConnectTime float64 `json:"connect_time"`
+
+	// Failure is the failure, if any.
+	Failure *string `json:"failure"`
+
+	// FailureDetails is the original error message, if any.
+	FailureDetails *string `json:"failure_details"`
+}
+
+// TestKeys contains the tcp_connect test keys.
+type TestKeys struct {
+	// Addresses contains the addresses we resolved.
+	Addresses []string `json:"addresses"`
+
+	// Connects contains the result of each connect attempt.
+	Connects []Connect `json:"connects"`
+
+	// Failure is the failure that prevented us from connecting, if
+	// any. When we could connect to at least one address, it is nil.
+	Failure *string `json:"failure"`
+
+	// FailureDetails is the original error message, if any.
+	FailureDetails *string `json:"failure_details"`
+
+	// Success indicates whether we could connect to at least one address.
+	Success bool `json:"success"`
+}
+
+// connect connects to address and returns the result.
+func connect(ctx context.Context, address string) Connect {
+	result := Connect{Address: address}
+	dialer := net.Dialer{Timeout: connectTimeout}
+	begin := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	result.ConnectTime = time.Now().Sub(begin).Seconds()
+	if err != nil {
+		result.Failure = errorx.FailureString(err)
+		result.FailureDetails = errorx.DetailsString(err)
+		return result
+	}
+	conn.Close()
+	return result
+}
+
+// Measure runs tcp_connect with the specified input and returns the test
+// keys. For each connect attempt we emit a "tcp_connect.connect" event
+// containing its Connect result.
+func Measure(ctx context.Context, input string, out chan<- model.Event) *TestKeys {
+	tk := &TestKeys{}
+	host, port, err := net.SplitHostPort(input)
+	if err != nil || host == "" || port == "" {
+		tk.Failure = errorx.FailureString(ErrInvalidInput)
+		tk.FailureDetails = errorx.DetailsString(ErrInvalidInput)
+		out <- model.NewFailureMeasurementEvent(0, ErrInvalidInput)
+		return tk
+	}
+	tk.Addresses, err = lookupHost(ctx, host)
+	if err != nil {
+		tk.Failure = errorx.FailureString(err)
+		tk.FailureDetails = errorx.DetailsString(err)
+		out <- model.NewFailureMeasurementEvent(0, err)
+		return tk
+	}
+	for _, addr := range tk.Addresses {
+		result := connect(ctx, net.JoinHostPort(addr, port))
+		tk.Connects = append(tk.Connects, result)
+		out <- model.Event{
+			Key:   "tcp_connect.connect",
+			Value: result,
+		}
+		if result.Failure == nil {
+			tk.Success = true
+		}
+	}
+	if !tk.Success && len(tk.Connects) > 0 {
+		last := tk.Connects[len(tk.Connects)-1]
+		tk.Failure, tk.FailureDetails = last.Failure, last.FailureDetails
+	}
+	return tk
+}
+
+// NewNettest creates a new tcp_connect nettest. The input of each
+// measurement is the "host:port" endpoint to connect to.
+func NewNettest() *nettest.Nettest {
+	return &nettest.Nettest{
+		TestName:        "tcp_connect",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+		Main: func(
+			ctx context.Context,
+			input string,
+			measurement *model.Measurement,
+			out chan<- model.Event,
+		) {
+			measurement.TestKeys = Measure(ctx, input, out)
+		},
+	}
+}
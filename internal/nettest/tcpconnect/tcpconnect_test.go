@@ -0,0 +1,113 @@
+package tcpconnect
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// run runs Measure and returns the test keys.
+func run(input string) *TestKeys {
+	var tk *TestKeys
+	nettesttest.Drain(func(out chan<- model.Event) {
+		tk = Measure(context.Background(), input, out)
+	})
+	return tk
+}
+
+// mockLookupHost makes lookupHost return addrs and err and returns a
+// function restoring the original lookupHost.
+func mockLookupHost(addrs []string, err error) func() {
+	savedLookupHost := lookupHost
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return addrs, err
+	}
+	return func() { lookupHost = savedLookupHost }
+}
+
+// closedPort returns a port on 127.0.0.1 where nobody is listening.
+func closedPort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+// TestMeasureSuccess checks whether we succeed when we can connect
+// to at least one of the resolved addresses.
+func TestMeasureSuccess(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	tk := run(listener.Addr().String())
+	if !tk.Success || tk.Failure != nil || len(tk.Connects) != 1 {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+	if tk.Connects[0].Address != listener.Addr().String() || tk.Connects[0].Failure != nil {
+		t.Fatalf("Unexpected connect: %+v", tk.Connects[0])
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	savedTimeout := connectTimeout
+	connectTimeout = time.Second
+	defer func() { connectTimeout = savedTimeout }()
+	defer mockLookupHost([]string{"127.0.0.2", "127.0.0.1"}, nil)()
+	tk = run(net.JoinHostPort("example.com", port))
+	if !tk.Success || tk.Failure != nil || len(tk.Connects) != 2 || len(tk.Addresses) != 2 {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+}
+
+// TestMeasureConnectionRefused checks whether we classify refused
+// connections and whether we report the connect failure.
+func TestMeasureConnectionRefused(t *testing.T) {
+	tk := run(net.JoinHostPort("127.0.0.1", closedPort(t)))
+	if tk.Success || tk.Failure == nil || *tk.Failure != errorx.FailureConnectionRefused {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+	if len(tk.Connects) != 1 || *tk.Connects[0].Failure != errorx.FailureConnectionRefused {
+		t.Fatalf("Unexpected connects: %+v", tk.Connects)
+	}
+	if tk.FailureDetails == nil || !strings.Contains(*tk.FailureDetails, "connection refused") {
+		t.Fatal("We expected the original error message")
+	}
+}
+
+// TestMeasureDNSFailures checks whether we classify DNS failures.
+func TestMeasureDNSFailures(t *testing.T) {
+	table := map[string]*net.DNSError{
+		errorx.FailureDNSNXDomain:    {Err: "no such host", Name: "x.example", IsNotFound: true},
+		errorx.FailureGenericTimeout: {Err: "i/o timeout", Name: "x.example", IsTimeout: true},
+	}
+	for expected, err := range table {
+		restore := mockLookupHost(nil, err)
+		tk := run("x.example:80")
+		restore()
+		if tk.Success || tk.Failure == nil || *tk.Failure != expected {
+			t.Fatalf("Unexpected test keys: %+v", tk)
+		}
+		if len(tk.Connects) != 0 {
+			t.Fatal("We should not have attempted to connect")
+		}
+	}
+}
+
+// TestMeasureInvalidInput checks whether we deal with invalid inputs.
+func TestMeasureInvalidInput(t *testing.T) {
+	for _, input := range []string{"", "example.com", ":80", "example.com:"} {
+		tk := run(input)
+		if tk.Failure == nil || *tk.Failure != errorx.Classify(ErrInvalidInput) {
+			t.Fatalf("Unexpected test keys for %q: %+v", input, tk)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Package telegram implements the telegram nettest.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-020-telegram.md
+// for the nettest specification. We connect to the Telegram access points
+// (also known as DCs) using TCP, we send them HTTP POST requests, and we
+// fetch the Telegram web interface.
+package telegram
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/nettest/im"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+)
+
+// DefaultDCs contains the default Telegram access points.
+var DefaultDCs = []string{
+	"149.154.175.50:80", "149.154.175.50:443",
+	"149.154.167.51:80", "149.154.167.51:443",
+	"149.154.175.100:80", "149.154.175.100:443",
+	"149.154.167.91:80", "149.154.167.91:443",
+	"149.154.171.5:80", "149.154.171.5:443",
+}
+
+// DefaultWebURLs contains the default Telegram web interface URLs.
+var DefaultWebURLs = []string{
+	"http://web.telegram.org/",
+	"https://web.telegram.org/",
+}
+
+// Config contains the telegram nettest configuration.
+type Config struct {
+	// DCs contains the "ip:port" endpoints of the access points. When
+	// empty, we use DefaultDCs.
+	DCs []string
+
+	// WebURLs contains the URLs of the web interface. When empty, we
+	// use DefaultWebURLs.
+	WebURLs []string
+}
+
+// TestKeys contains the telegram test keys.
+type TestKeys struct {
+	// Requests contains the HTTP requests.
+	Requests []im.HTTPRequest `json:"requests"`
+
+	// TCPConnect contains the TCP connects.
+	TCPConnect []im.TCPConnect `json:"tcp_connect"`
+
+	// TelegramHTTPBlocking indicates whether all the HTTP requests to
+	// the access points failed.
+	TelegramHTTPBlocking bool `json:"telegram_http_blocking"`
+
+	// TelegramTCPBlocking indicates whether all the TCP connects to
+	// the access points failed.
+	TelegramTCPBlocking bool `json:"telegram_tcp_blocking"`
+
+	// TelegramWebFailure is the first web interface failure, if any.
+	TelegramWebFailure *string `json:"telegram_web_failure"`
+
+	// TelegramWebStatus is "ok" if we could fetch all the web interface
+	// URLs and "blocked" otherwise.
+	TelegramWebStatus string `json:"telegram_web_status"`
+}
+
+// Measure runs telegram using config. It returns the test keys.
+func Measure(ctx context.Context, config Config, out chan<- model.Event) *TestKeys {
+	tk := &TestKeys{
+		TelegramHTTPBlocking: true,
+		TelegramTCPBlocking:  true,
+		TelegramWebStatus:    "ok",
+	}
+	dcs := config.DCs
+	if len(dcs) <= 0 {
+		dcs = DefaultDCs
+	}
+	for _, endpoint := range dcs {
+		out <- model.NewLogInfoEvent("telegram: connecting to " + endpoint)
+		entry := im.Connect(ctx, endpoint)
+		tk.TCPConnect = append(tk.TCPConnect, entry)
+		if entry.Status.Success {
+			tk.TelegramTCPBlocking = false
+		}
+		result := im.Request(ctx, "POST", "http://"+endpoint+"/")
+		tk.Requests = append(tk.Requests, result)
+		if result.Failure == nil {
+			tk.TelegramHTTPBlocking = false
+		}
+	}
+	webURLs := config.WebURLs
+	if len(webURLs) <= 0 {
+		webURLs = DefaultWebURLs
+	}
+	for _, URL := range webURLs {
+		out <- model.NewLogInfoEvent("telegram: fetching " + URL)
+		result := im.Request(ctx, "GET", URL)
+		if result.Failure == nil && result.StatusCode != 200 {
+			result.Failure = errorx.FailureString(fmt.Errorf(
+				"Request failed with status %d", result.StatusCode,
+			))
+		}
+		tk.Requests = append(tk.Requests, result)
+		if result.Failure != nil && tk.TelegramWebFailure == nil {
+			tk.TelegramWebFailure = result.Failure
+			tk.TelegramWebStatus = "blocked"
+		}
+	}
+	return tk
+}
+
+// NewNettest creates a new telegram nettest. We do not use the
+// measurement input.
+func NewNettest(config Config) *nettest.Nettest {
+	return &nettest.Nettest{
+		TestName:        "telegram",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+		Main: func(
+			ctx context.Context,
+			input string,
+			measurement *model.Measurement,
+			out chan<- model.Event,
+		) {
+			measurement.TestKeys = Measure(ctx, config, out)
+		},
+	}
+}
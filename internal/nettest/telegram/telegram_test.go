@@ -0,0 +1,117 @@
+package telegram
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// withServer runs fn with a local stand-in for Telegram.
+func withServer(t *testing.T, fn func(endpoint string)) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "POST" {
+				w.WriteHeader(501)
+			}
+		},
+	))
+	defer server.Close()
+	fn(strings.TrimPrefix(server.URL, "http://"))
+}
+
+// closedEndpoint returns an endpoint where nobody is listening.
+func closedEndpoint(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := listener.Addr().String()
+	listener.Close()
+	return endpoint
+}
+
+// measure runs Measure draining the events.
+func measure(config Config) *TestKeys {
+	var tk *TestKeys
+	nettesttest.Drain(func(out chan<- model.Event) {
+		tk = Measure(context.Background(), config, out)
+	})
+	return tk
+}
+
+// TestMeasureOK checks whether we do not flag blocking when
+// Telegram is reachable.
+func TestMeasureOK(t *testing.T) {
+	withServer(t, func(endpoint string) {
+		tk := measure(Config{
+			DCs:     []string{closedEndpoint(t), endpoint},
+			WebURLs: []string{"http://" + endpoint + "/"},
+		})
+		if tk.TelegramTCPBlocking || tk.TelegramHTTPBlocking {
+			t.Fatal("Unexpected access points blocking")
+		}
+		if tk.TelegramWebStatus != "ok" || tk.TelegramWebFailure != nil {
+			t.Fatal("Unexpected web blocking")
+		}
+		if len(tk.TCPConnect) != 2 || len(tk.Requests) != 3 {
+			t.Fatal("Unexpected number of connects or requests")
+		}
+		if tk.Requests[1].StatusCode != 501 {
+			t.Fatal("Unexpected status code")
+		}
+	})
+}
+
+// TestMeasureBlocked checks whether we flag blocking when
+// Telegram is not reachable.
+func TestMeasureBlocked(t *testing.T) {
+	withServer(t, func(endpoint string) {
+		tk := measure(Config{
+			DCs: []string{closedEndpoint(t), "antani"},
+			WebURLs: []string{
+				"http://" + endpoint + "/antani", "http://" + closedEndpoint(t),
+			},
+		})
+		if !tk.TelegramTCPBlocking || !tk.TelegramHTTPBlocking {
+			t.Fatal("Expected access points blocking")
+		}
+		if tk.TelegramWebStatus != "blocked" || tk.TelegramWebFailure == nil {
+			t.Fatal("Expected web blocking")
+		}
+	})
+}
+
+// TestMeasureWebStatusCode checks whether we consider a non 200
+// status code for the web interface as a failure.
+func TestMeasureWebStatusCode(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	tk := measure(Config{
+		DCs:     []string{closedEndpoint(t)},
+		WebURLs: []string{server.URL},
+	})
+	if tk.TelegramWebStatus != "blocked" {
+		t.Fatal("Expected web blocking")
+	}
+}
+
+// TestNewNettest checks whether the nettest uses the config.
+func TestNewNettest(t *testing.T) {
+	withServer(t, func(endpoint string) {
+		nt := NewNettest(Config{
+			DCs:     []string{endpoint},
+			WebURLs: []string{"http://" + endpoint + "/"},
+		})
+		measurement := nettesttest.Run(nt, "")
+		tk := measurement.TestKeys.(*TestKeys)
+		if tk.TelegramTCPBlocking || tk.TelegramWebStatus != "ok" {
+			t.Fatal("Unexpected result")
+		}
+	})
+}
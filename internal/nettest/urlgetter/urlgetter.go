@@ -0,0 +1,145 @@
+// Package urlgetter implements the urlgetter nettest.
+//
+// We fetch the input URL following redirects and we record a complete
+// trace of what happened using the netx package. While measuring, we
+// also emit each entry of the trace as a "netx.<kind>" event.
+package urlgetter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/netx"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+)
+
+// httpTimeout is the timeout for fetching the URL.
+const httpTimeout = 60 * time.Second
+
+// ErrInvalidInput indicates that the input is not an HTTP or HTTPS URL.
+var ErrInvalidInput = errors.New("input is not a valid HTTP or HTTPS URL")
+
+// ErrInvalidResolver indicates that the resolver URL is not valid.
+var ErrInvalidResolver = netx.ErrInvalidResolver
+
+// httpRequestHeaders contains the headers we send.
+var httpRequestHeaders = map[string][]string{
+	"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+	"Accept-Language": {"en-US;q=0.8,en;q=0.5"},
+	"User-Agent":      {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/74.0.3729.169 Safari/537.36"},
+}
+
+// Config contains the urlgetter nettest configuration.
+type Config struct {
+	// HostHeader overrides the Host header of the first request.
+	HostHeader string
+
+	// NoTLSVerify disables the verification of TLS certificates.
+	NoTLSVerify bool
+
+	// ResolverURL is the resolver to use. It is either empty, for the
+	// system resolver, or "udp://ip[:port]", or "tcp://ip[:port]", where
+	// the default port is 53. See resolver.Parse for the syntax.
+	ResolverURL string
+
+	// SNI overrides the SNI we send during TLS handshakes.
+	SNI string
+}
+
+// TestKeys contains the urlgetter test keys.
+type TestKeys struct {
+	// Agent is the HTTP agent we used. We follow redirects, hence
+	// the value of this field is always "redirect".
+	Agent string `json:"agent"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// FailureDetails is the original error message, if any.
+	FailureDetails *string `json:"failure_details"`
+
+	// Trace contains the trace of the measurement.
+	netx.Trace
+}
+
+// fetch fetches URL using config and saves what happens using saver.
+func fetch(ctx context.Context, config Config, URL string, saver *netx.Saver) error {
+	ctx, cancel := context.WithTimeout(ctx, httpTimeout)
+	defer cancel()
+	transport, err := netx.NewHTTPTransport(ctx, netx.Config{
+		NoTLSVerify: config.NoTLSVerify,
+		ResolverURL: config.ResolverURL,
+		SNI:         config.SNI,
+	}, saver)
+	if err != nil {
+		return err
+	}
+	defer transport.CloseIdleConnections()
+	request, err := http.NewRequest("GET", URL, nil)
+	if err != nil {
+		return err
+	}
+	request.Header = make(http.Header)
+	for key, values := range httpRequestHeaders {
+		request.Header[key] = values
+	}
+	request.Host = config.HostHeader
+	client := &http.Client{Transport: transport}
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// Measure runs urlgetter for input using config. It returns the test keys.
+func Measure(
+	ctx context.Context, config Config, input string, out chan<- model.Event,
+) *TestKeys {
+	tk := &TestKeys{Agent: "redirect"}
+	URL, err := url.Parse(input)
+	if err != nil || (URL.Scheme != "http" && URL.Scheme != "https") ||
+		URL.Hostname() == "" {
+		tk.Failure = errorx.FailureString(ErrInvalidInput)
+		tk.FailureDetails = errorx.DetailsString(ErrInvalidInput)
+		out <- model.NewFailureMeasurementEvent(0, ErrInvalidInput)
+		return tk
+	}
+	out <- model.NewLogInfoEvent("urlgetter: fetching " + input)
+	saver := netx.NewSaver(&tk.Trace, out)
+	err = fetch(ctx, config, input, saver)
+	saver.Stop()
+	if err != nil {
+		tk.Failure = errorx.FailureString(err)
+		tk.FailureDetails = errorx.DetailsString(err)
+		out <- model.NewFailureMeasurementEvent(0, err)
+	}
+	return tk
+}
+
+// NewNettest creates a new urlgetter nettest. The input of each
+// measurement is the URL to fetch.
+func NewNettest(config Config) *nettest.Nettest {
+	return &nettest.Nettest{
+		TestName:        "urlgetter",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+		Main: func(
+			ctx context.Context,
+			input string,
+			measurement *model.Measurement,
+			out chan<- model.Event,
+		) {
+			measurement.TestKeys = Measure(ctx, config, input, out)
+		},
+	}
+}
@@ -0,0 +1,188 @@
+package urlgetter
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/measurement-kit/engine/internal/dnsx/dnstest"
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// handler is the handler of the local stand-in for the website.
+var handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/redirect" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	w.Header().Set("X-Host", r.Host)
+	w.Write([]byte("antani"))
+})
+
+// measure runs Measure draining the events.
+func measure(config Config, input string) *TestKeys {
+	var tk *TestKeys
+	nettesttest.Drain(func(out chan<- model.Event) {
+		tk = Measure(context.Background(), config, input, out)
+	})
+	return tk
+}
+
+// hasOperation returns whether tk contains an operation network event.
+func hasOperation(tk *TestKeys, operation string) bool {
+	for _, ev := range tk.NetworkEvents {
+		if ev.Operation == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// TestMeasureHTTP checks whether we save the trace of an HTTP fetch
+// using a custom resolver and following redirects.
+func TestMeasureHTTP(t *testing.T) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	resolver, err := dnstest.NewUDPServer(map[string][]string{
+		"www.example.com": {"127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resolver.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	tk := measure(Config{
+		ResolverURL: "udp://" + resolver.Addr,
+	}, "http://www.example.com:"+port+"/redirect")
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if len(tk.Queries) != 2 || tk.Queries[0].Answers[0].IPv4 != "127.0.0.1" {
+		t.Fatal("Unexpected queries")
+	}
+	if tk.Queries[0].Engine != "udp" || tk.Queries[1].Failure == nil {
+		t.Fatal("Unexpected queries engine or AAAA result")
+	}
+	if len(tk.TCPConnect) != 1 || !tk.TCPConnect[0].Status.Success {
+		t.Fatal("Unexpected TCP connects")
+	}
+	if len(tk.Requests) != 2 {
+		t.Fatal("Unexpected number of requests")
+	}
+	if tk.Requests[0].Response.Code != 302 || tk.Requests[1].Response.Body != "antani" {
+		t.Fatal("Unexpected requests")
+	}
+	for _, operation := range []string{"resolve_start", "resolve_done", "connect", "read", "write"} {
+		if !hasOperation(tk, operation) {
+			t.Fatalf("Missing %s network event", operation)
+		}
+	}
+	if _, err := json.Marshal(tk); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMeasureHostHeader checks whether we override the Host header.
+func TestMeasureHostHeader(t *testing.T) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	tk := measure(Config{HostHeader: "www.example.com"}, server.URL)
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.Requests[0].Response.Headers["X-Host"] != "www.example.com" {
+		t.Fatal("The Host header was not overridden")
+	}
+	if tk.Requests[0].Request.Headers["Host"] != "www.example.com" {
+		t.Fatal("The Host header was not saved")
+	}
+}
+
+// TestMeasureHTTPS checks whether we save TLS handshakes and whether
+// we override the SNI.
+func TestMeasureHTTPS(t *testing.T) {
+	server := httptest.NewUnstartedServer(handler)
+	serverNames := make(chan string, 1)
+	server.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNames <- hello.ServerName
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	defer server.Close()
+	tk := measure(Config{NoTLSVerify: true, SNI: "www.example.com"}, server.URL)
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if <-serverNames != "www.example.com" {
+		t.Fatal("The SNI was not overridden")
+	}
+	if len(tk.TLSHandshakes) != 1 {
+		t.Fatal("Unexpected number of TLS handshakes")
+	}
+	handshake := tk.TLSHandshakes[0]
+	if handshake.Failure != nil || !handshake.NoTLSVerify {
+		t.Fatal("Unexpected TLS handshake result")
+	}
+	if handshake.TLSVersion == "" || handshake.CipherSuite == "" {
+		t.Fatal("Missing TLS version or cipher suite")
+	}
+	if len(handshake.PeerCertificates) != 1 || handshake.ServerName != "www.example.com" {
+		t.Fatal("Unexpected peer certificates or server name")
+	}
+	if !hasOperation(tk, "tls_handshake_done") {
+		t.Fatal("Missing tls_handshake_done network event")
+	}
+}
+
+// TestMeasureTLSFailure checks whether we verify certificates by default.
+func TestMeasureTLSFailure(t *testing.T) {
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+	tk := measure(Config{}, server.URL)
+	if tk.Failure == nil {
+		t.Fatal("Expected a failure")
+	}
+	if len(tk.TLSHandshakes) != 1 || tk.TLSHandshakes[0].Failure == nil {
+		t.Fatal("Expected a TLS handshake failure")
+	}
+	if len(tk.Requests) != 1 || tk.Requests[0].Failure == nil {
+		t.Fatal("Expected a request failure")
+	}
+}
+
+// TestMeasureInvalidInput checks whether we deal with invalid inputs.
+func TestMeasureInvalidInput(t *testing.T) {
+	tk := measure(Config{}, "ftp://www.example.com/")
+	if tk.Failure == nil || *tk.Failure != errorx.Classify(ErrInvalidInput) {
+		t.Fatal("Expected ErrInvalidInput")
+	}
+}
+
+// TestMeasureInvalidResolver checks whether we deal with invalid resolvers.
+func TestMeasureInvalidResolver(t *testing.T) {
+	tk := measure(Config{ResolverURL: "https://1.1.1.1/"}, "http://www.example.com/")
+	if tk.Failure == nil || *tk.Failure != errorx.Classify(ErrInvalidResolver) {
+		t.Fatal("Expected ErrInvalidResolver")
+	}
+}
+
+// TestNewNettest checks whether the nettest uses the input.
+func TestNewNettest(t *testing.T) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	nt := NewNettest(Config{})
+	measurement := nettesttest.Run(nt, server.URL)
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.Failure != nil || len(tk.Requests) != 1 {
+		t.Fatal("Unexpected result")
+	}
+}
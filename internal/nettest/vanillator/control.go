@@ -0,0 +1,177 @@
+package vanillator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// BootstrapEvent is the value of the "vanilla_tor.bootstrap" events
+// that we emit while tor is bootstrapping.
+type BootstrapEvent struct {
+	// Progress is the bootstrap percentage.
+	Progress int64 `json:"progress"`
+
+	// Summary is the human readable bootstrap phase.
+	Summary string `json:"summary"`
+
+	// Tag is the machine readable bootstrap phase.
+	Tag string `json:"tag"`
+}
+
+// parseKeywords parses the space separated KEY=VALUE pairs in s, where
+// VALUE may be a quoted string containing spaces.
+func parseKeywords(s string) map[string]string {
+	keywords := make(map[string]string)
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		index := strings.IndexAny(s, "= ")
+		if index < 0 {
+			return keywords
+		}
+		if s[index] == ' ' {
+			s = s[index:]
+			continue
+		}
+		key, rest := s[:index], s[index+1:]
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				keywords[key] = rest[1:]
+				return keywords
+			}
+			keywords[key], s = rest[1:end+1], rest[end+2:]
+			continue
+		}
+		end := strings.Index(rest, " ")
+		if end < 0 {
+			end = len(rest)
+		}
+		keywords[key], s = rest[:end], rest[end:]
+	}
+	return keywords
+}
+
+// parseBootstrap parses a bootstrap status line such as the ones
+// contained in STATUS_CLIENT events and in the reply to GETINFO
+// status/bootstrap-phase. It returns false if line is not one of them.
+func parseBootstrap(line string) (BootstrapEvent, bool) {
+	index := strings.Index(line, " BOOTSTRAP ")
+	if index < 0 {
+		return BootstrapEvent{}, false
+	}
+	keywords := parseKeywords(line[index+len(" BOOTSTRAP "):])
+	progress, err := strconv.ParseInt(keywords["PROGRESS"], 10, 64)
+	if err != nil {
+		return BootstrapEvent{}, false
+	}
+	return BootstrapEvent{
+		Progress: progress,
+		Summary:  keywords["SUMMARY"],
+		Tag:      keywords["TAG"],
+	}, true
+}
+
+// controller speaks the tor control protocol. See
+// https://gitweb.torproject.org/torspec.git/tree/control-spec.txt.
+type controller struct {
+	bootstrap BootstrapEvent
+	conn      io.Writer
+	progress  func(ev BootstrapEvent)
+	reader    *bufio.Reader
+}
+
+// newController creates a controller using conn that calls progress
+// whenever the bootstrap progress changes.
+func newController(conn io.ReadWriter, progress func(ev BootstrapEvent)) *controller {
+	return &controller{
+		conn:     conn,
+		progress: progress,
+		reader:   bufio.NewReader(conn),
+	}
+}
+
+// update updates the bootstrap progress using line.
+func (c *controller) update(line string) {
+	ev, ok := parseBootstrap(line)
+	if !ok || ev == c.bootstrap {
+		return
+	}
+	c.bootstrap = ev
+	c.progress(ev)
+}
+
+// readLine reads the next line and handles asynchronous events. It
+// returns the line, which is empty for asynchronous events.
+func (c *controller) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "650") {
+		c.update(line)
+		return "", nil
+	}
+	return line, nil
+}
+
+// command sends cmd and returns the reply lines, with the status
+// code removed, or an error if tor did not accept cmd.
+func (c *controller) command(cmd string) ([]string, error) {
+	if _, err := fmt.Fprintf(c.conn, "%s\r\n", cmd); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 4 {
+			continue
+		}
+		lines = append(lines, line[4:])
+		if line[3] != ' ' {
+			continue
+		}
+		if !strings.HasPrefix(line, "250") {
+			return nil, fmt.Errorf("vanilla_tor: %s: %s", cmd, line)
+		}
+		return lines, nil
+	}
+}
+
+// getinfo returns the value of key.
+func (c *controller) getinfo(key string) (string, error) {
+	lines, err := c.command("GETINFO " + key)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, key+"=") {
+			return line[len(key)+1:], nil
+		}
+	}
+	return "", fmt.Errorf("vanilla_tor: no value for %s", key)
+}
+
+// waitBootstrap subscribes to bootstrap events and waits until tor
+// has bootstrapped or an error occurs.
+func (c *controller) waitBootstrap() error {
+	if _, err := c.command("SETEVENTS STATUS_CLIENT"); err != nil {
+		return err
+	}
+	phase, err := c.getinfo("status/bootstrap-phase")
+	if err != nil {
+		return err
+	}
+	c.update(phase)
+	for c.bootstrap.Progress < 100 {
+		if _, err := c.readLine(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package vanillator
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestParseKeywords checks whether we parse keywords.
+func TestParseKeywords(t *testing.T) {
+	keywords := parseKeywords(`PROGRESS=10 NOKEY TAG=conn SUMMARY="Connecting to a relay" X=`)
+	if len(keywords) != 4 || keywords["PROGRESS"] != "10" || keywords["TAG"] != "conn" {
+		t.Fatalf("Unexpected keywords: %+v", keywords)
+	}
+	if keywords["SUMMARY"] != "Connecting to a relay" || keywords["X"] != "" {
+		t.Fatalf("Unexpected keywords: %+v", keywords)
+	}
+	keywords = parseKeywords(`SUMMARY="unterminated`)
+	if keywords["SUMMARY"] != "unterminated" {
+		t.Fatalf("Unexpected keywords: %+v", keywords)
+	}
+	if len(parseKeywords("NOKEY")) != 0 {
+		t.Fatal("We expected no keywords")
+	}
+}
+
+// TestParseBootstrap checks whether we parse bootstrap lines.
+func TestParseBootstrap(t *testing.T) {
+	ev, ok := parseBootstrap(`650 STATUS_CLIENT NOTICE BOOTSTRAP PROGRESS=100 TAG=done SUMMARY="Done"`)
+	if !ok || ev != (BootstrapEvent{Progress: 100, Summary: "Done", Tag: "done"}) {
+		t.Fatalf("Unexpected event: %+v", ev)
+	}
+	for _, line := range []string{
+		"650 STATUS_CLIENT NOTICE CIRCUIT_ESTABLISHED",
+		"650 STATUS_CLIENT NOTICE BOOTSTRAP PROGRESS=x",
+	} {
+		if _, ok := parseBootstrap(line); ok {
+			t.Fatalf("We did not expect to parse %q", line)
+		}
+	}
+}
+
+// readWriter combines a reader and a writer.
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+// newTestController returns a controller reading the reply from
+// a buffer and the buffer where it writes commands.
+func newTestController(reply string) (*controller, *bytes.Buffer) {
+	var commands bytes.Buffer
+	conn := readWriter{Reader: strings.NewReader(reply), Writer: &commands}
+	return newController(conn, func(BootstrapEvent) {}), &commands
+}
+
+// TestControllerGetinfo checks whether getinfo works.
+func TestControllerGetinfo(t *testing.T) {
+	c, commands := newTestController(
+		"650 STATUS_CLIENT NOTICE BOOTSTRAP PROGRESS=5 TAG=conn SUMMARY=\"x\"\r\n" +
+			"250-version=0.4.0\r\n250 OK\r\n",
+	)
+	value, err := c.getinfo("version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "0.4.0" || c.bootstrap.Progress != 5 {
+		t.Fatal("Unexpected result")
+	}
+	if commands.String() != "GETINFO version\r\n" {
+		t.Fatal("Unexpected command")
+	}
+}
+
+// TestControllerErrors checks whether we deal with errors.
+func TestControllerErrors(t *testing.T) {
+	c, _ := newTestController("250 OK\r\n")
+	if _, err := c.getinfo("version"); err == nil {
+		t.Fatal("We expected an error with a missing value")
+	}
+	c, _ = newTestController("552 Unrecognized key\r\n")
+	if _, err := c.getinfo("version"); err == nil {
+		t.Fatal("We expected an error with an unrecognized key")
+	}
+	c, _ = newTestController("")
+	if _, err := c.getinfo("version"); err != io.EOF {
+		t.Fatal("We expected EOF")
+	}
+	c, _ = newTestController("250 OK\r\n")
+	if err := c.waitBootstrap(); err != io.EOF {
+		t.Fatal("We expected EOF")
+	}
+	c, _ = newTestController("250 OK\r\n250 OK\r\n")
+	if err := c.waitBootstrap(); err == nil {
+		t.Fatal("We expected an error with a missing bootstrap phase")
+	}
+}
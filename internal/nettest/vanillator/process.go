@@ -0,0 +1,121 @@
+package vanillator
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLogLines is the maximum number of tor log lines we keep.
+const maxLogLines = 1000
+
+// pollInterval is the interval at which we check whether tor has
+// written the control port file.
+const pollInterval = 100 * time.Millisecond
+
+// ErrExited indicates that tor exited before bootstrapping.
+var ErrExited = errors.New("vanilla_tor: tor exited before bootstrapping")
+
+// process is a running tor process.
+type process struct {
+	cmd      *exec.Cmd
+	exited   chan struct{}
+	logs     []string
+	mu       sync.Mutex
+	portfile string
+	readDone chan struct{}
+}
+
+// startProcess starts the tor binary at path using datadir as the
+// data directory. The process is killed when ctx is done.
+func startProcess(ctx context.Context, path, datadir string) (*process, error) {
+	p := &process{
+		exited:   make(chan struct{}),
+		portfile: filepath.Join(datadir, "control-port"),
+		readDone: make(chan struct{}),
+	}
+	torrc := filepath.Join(datadir, "torrc")
+	if err := ioutil.WriteFile(torrc, nil, 0600); err != nil {
+		return nil, err
+	}
+	p.cmd = exec.CommandContext(
+		ctx, path,
+		"-f", torrc,
+		"DataDirectory", datadir,
+		"SocksPort", "auto",
+		"ControlPort", "auto",
+		"ControlPortWriteToFile", p.portfile,
+		"CookieAuthentication", "0",
+		"Log", "notice stdout",
+		"__OwningControllerProcess", strconv.Itoa(os.Getpid()),
+	)
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	p.cmd.Stderr = p.cmd.Stdout
+	if err := p.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go p.readLogs(stdout)
+	go func() {
+		<-p.readDone // Wait must be called after reading all the output
+		p.cmd.Wait()
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+// readLogs saves the log lines that tor writes on r.
+func (p *process) readLogs(r io.Reader) {
+	defer close(p.readDone)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.mu.Lock()
+		if len(p.logs) < maxLogLines {
+			p.logs = append(p.logs, scanner.Text())
+		}
+		p.mu.Unlock()
+	}
+}
+
+// dialControlPort waits for tor to write the control port file and
+// then connects to the control port.
+func (p *process) dialControlPort(ctx context.Context) (net.Conn, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		data, err := ioutil.ReadFile(p.portfile)
+		if err == nil && strings.HasSuffix(string(data), "\n") {
+			address := strings.TrimPrefix(strings.TrimSpace(string(data)), "PORT=")
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, "tcp", address)
+		}
+		select {
+		case <-ticker.C:
+		case <-p.exited:
+			return nil, ErrExited
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// stop kills tor, if it is still running, and returns its log lines.
+func (p *process) stop() []string {
+	p.cmd.Process.Kill()
+	<-p.exited
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.logs
+}
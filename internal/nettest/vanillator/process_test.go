@@ -0,0 +1,16 @@
+package vanillator
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestReadLogs checks whether we save at most maxLogLines lines.
+func TestReadLogs(t *testing.T) {
+	p := &process{readDone: make(chan struct{})}
+	p.readLogs(strings.NewReader(strings.Repeat("antani\n", maxLogLines+1)))
+	<-p.readDone
+	if len(p.logs) != maxLogLines || p.logs[0] != "antani" {
+		t.Fatal("Unexpected logs")
+	}
+}
@@ -0,0 +1,185 @@
+// Package vanillator implements the vanilla_tor nettest.
+//
+// We start the tor binary with a fresh data directory, connect to its
+// control port, and follow the bootstrap progress until tor has fully
+// bootstrapped or the timeout expires. We record how long it took, the
+// final bootstrap phase, and the tor logs.
+package vanillator
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+)
+
+// DefaultTorPath is the tor binary we use when Config.TorPath is empty,
+// which is searched in the PATH.
+const DefaultTorPath = "tor"
+
+// DefaultTimeout is the bootstrap timeout we use when Config.Timeout
+// is zero.
+const DefaultTimeout = 300 * time.Second
+
+// ErrTimeout indicates that tor did not bootstrap in time.
+var ErrTimeout = errors.New("vanilla_tor: bootstrap timed out")
+
+// Config contains the vanilla_tor nettest configuration.
+type Config struct {
+	// Timeout is the bootstrap timeout. If zero, we use DefaultTimeout.
+	Timeout time.Duration
+
+	// TorPath is the path of the tor binary. If empty, we
+	// use DefaultTorPath.
+	TorPath string
+
+	// WorkDirPath is the directory where we create the tor data
+	// directory. If empty, we use the default temporary directory.
+	WorkDirPath string
+}
+
+// TestKeys contains the vanilla_tor test keys.
+type TestKeys struct {
+	// BootstrapTime is the number of seconds it took to bootstrap, or
+	// zero if tor did not bootstrap.
+	BootstrapTime float64 `json:"bootstrap_time"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// FailureDetails is the original error message, if any.
+	FailureDetails *string `json:"failure_details"`
+
+	// Success indicates whether tor bootstrapped.
+	Success bool `json:"success"`
+
+	// Timeout is the bootstrap timeout in seconds.
+	Timeout float64 `json:"timeout"`
+
+	// TorLog contains the tor log lines.
+	TorLog []string `json:"tor_log"`
+
+	// TorProgress is the last bootstrap percentage.
+	TorProgress int64 `json:"tor_progress"`
+
+	// TorProgressSummary is the last human readable bootstrap phase.
+	TorProgressSummary string `json:"tor_progress_summary"`
+
+	// TorProgressTag is the last machine readable bootstrap phase.
+	TorProgressTag string `json:"tor_progress_tag"`
+
+	// TorVersion is the version of tor.
+	TorVersion string `json:"tor_version"`
+
+	// TransportName is always "vanilla".
+	TransportName string `json:"transport_name"`
+}
+
+// bootstrap connects to the control port of p and waits for tor to
+// bootstrap, updating tk and emitting events on out.
+func bootstrap(
+	ctx context.Context, p *process, tk *TestKeys, out chan<- model.Event,
+) error {
+	conn, err := p.dialControlPort(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close() // unblock reads
+		case <-done:
+		}
+	}()
+	c := newController(conn, func(ev BootstrapEvent) {
+		tk.TorProgress = ev.Progress
+		tk.TorProgressSummary = ev.Summary
+		tk.TorProgressTag = ev.Tag
+		out <- model.Event{
+			Key:   "vanilla_tor.bootstrap",
+			Value: ev,
+		}
+	})
+	if _, err := c.command("AUTHENTICATE"); err != nil {
+		return err
+	}
+	if tk.TorVersion, err = c.getinfo("version"); err != nil {
+		return err
+	}
+	return c.waitBootstrap()
+}
+
+// Measure runs vanilla_tor using config. It returns the test keys.
+func Measure(ctx context.Context, config Config, out chan<- model.Event) *TestKeys {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	torPath := config.TorPath
+	if torPath == "" {
+		torPath = DefaultTorPath
+	}
+	tk := &TestKeys{Timeout: timeout.Seconds(), TransportName: "vanilla"}
+	fail := func(err error) *TestKeys {
+		tk.Failure = errorx.FailureString(err)
+		tk.FailureDetails = errorx.DetailsString(err)
+		out <- model.NewFailureMeasurementEvent(0, err)
+		return tk
+	}
+	datadir, err := ioutil.TempDir(config.WorkDirPath, "vanillator")
+	if err != nil {
+		return fail(err)
+	}
+	defer os.RemoveAll(datadir)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	out <- model.NewLogInfoEvent("vanilla_tor: starting " + torPath)
+	begin := time.Now()
+	p, err := startProcess(ctx, torPath, datadir)
+	if err != nil {
+		return fail(err)
+	}
+	err = bootstrap(ctx, p, tk, out)
+	elapsed := time.Now().Sub(begin).Seconds()
+	tk.TorLog = p.stop()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = ErrTimeout
+		} else if err == io.EOF {
+			err = ErrExited
+		}
+		return fail(err)
+	}
+	tk.BootstrapTime = elapsed
+	tk.Success = true
+	return tk
+}
+
+// NewNettest creates a new vanilla_tor nettest.
+func NewNettest(config Config) *nettest.Nettest {
+	return &nettest.Nettest{
+		TestName:        "vanilla_tor",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+		Main: func(
+			ctx context.Context,
+			input string,
+			measurement *model.Measurement,
+			out chan<- model.Event,
+		) {
+			measurement.TestKeys = Measure(ctx, config, out)
+		},
+	}
+}
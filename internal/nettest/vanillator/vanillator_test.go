@@ -0,0 +1,215 @@
+package vanillator
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// fakeTorEnv is the environment variable that tells the test binary
+// to behave like tor. Its value selects the behavior.
+const fakeTorEnv = "VANILLATOR_FAKE_TOR"
+
+// The behaviors of the fake tor.
+const (
+	fakeTorBootstrap = "bootstrap"
+	fakeTorExit      = "exit"
+	fakeTorReject    = "reject"
+	fakeTorStall     = "stall"
+	fakeTorVerbose   = "verbose"
+)
+
+// TestMain allows the test binary to behave like tor.
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(fakeTorEnv); mode != "" {
+		fakeTor(mode)
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// fakeTor emulates the subset of tor we use.
+func fakeTor(mode string) {
+	fmt.Println("[notice] Tor 0.4.0-fake running")
+	if mode == fakeTorExit {
+		fmt.Println("[err] mocked error")
+		os.Exit(1)
+	}
+	if mode == fakeTorVerbose {
+		for i := 0; i < 2*maxLogLines; i++ {
+			fmt.Println("[notice] antani")
+		}
+	}
+	var portfile string
+	for i := 1; i+1 < len(os.Args); i++ {
+		if os.Args[i] == "ControlPortWriteToFile" {
+			portfile = os.Args[i+1]
+		}
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.Exit(1)
+	}
+	data := []byte("PORT=" + listener.Addr().String() + "\n")
+	if err := ioutil.WriteFile(portfile, data, 0600); err != nil {
+		os.Exit(1)
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		os.Exit(1)
+	}
+	const phase = "NOTICE BOOTSTRAP PROGRESS=50 TAG=loading_descriptors " +
+		`SUMMARY="Loading relay descriptors"`
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case "AUTHENTICATE":
+			if mode == fakeTorReject {
+				fmt.Fprint(conn, "515 Authentication failed\r\n")
+				continue
+			}
+			fmt.Fprint(conn, "250 OK\r\n")
+		case "GETINFO version":
+			fmt.Fprint(conn, "250-version=0.4.0-fake\r\n250 OK\r\n")
+		case "SETEVENTS STATUS_CLIENT":
+			fmt.Fprint(conn, "250 OK\r\n")
+			fmt.Fprint(conn, "650 STATUS_CLIENT "+phase+"\r\n")
+		case "GETINFO status/bootstrap-phase":
+			fmt.Fprint(conn, "250-status/bootstrap-phase="+phase+"\r\n250 OK\r\n")
+			if mode != fakeTorStall {
+				fmt.Fprint(conn, "650 STATUS_CLIENT NOTICE BOOTSTRAP PROGRESS=100 "+
+					"TAG=done SUMMARY=\"Done\"\r\n")
+			}
+		default:
+			fmt.Fprint(conn, "510 Unrecognized command\r\n")
+		}
+	}
+	time.Sleep(time.Hour) // wait to be killed like tor would do
+}
+
+// measure runs Measure using the fake tor with mode. It returns
+// the test keys and the events.
+func measure(t *testing.T, mode string, config Config) (*TestKeys, []model.Event) {
+	os.Setenv(fakeTorEnv, mode)
+	defer os.Unsetenv(fakeTorEnv)
+	if config.TorPath == "" {
+		config.TorPath = os.Args[0]
+	}
+	out := make(chan model.Event)
+	done := make(chan *TestKeys, 1)
+	go func() {
+		defer close(out)
+		done <- Measure(context.Background(), config, out)
+	}()
+	var events []model.Event
+	for ev := range out {
+		events = append(events, ev)
+	}
+	return <-done, events
+}
+
+// TestMeasureBootstrap checks whether we measure a successful bootstrap.
+func TestMeasureBootstrap(t *testing.T) {
+	tk, events := measure(t, fakeTorBootstrap, Config{})
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if !tk.Success || tk.BootstrapTime <= 0 || tk.TorProgress != 100 {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+	if tk.TorProgressTag != "done" || tk.TorProgressSummary != "Done" {
+		t.Fatal("Unexpected bootstrap phase")
+	}
+	if tk.TorVersion != "0.4.0-fake" || tk.TransportName != "vanilla" {
+		t.Fatal("Unexpected version or transport")
+	}
+	if len(tk.TorLog) != 1 || !strings.Contains(tk.TorLog[0], "Tor 0.4.0-fake") {
+		t.Fatalf("Unexpected logs: %+v", tk.TorLog)
+	}
+	var progress []int64
+	for _, ev := range events {
+		if ev.Key == "vanilla_tor.bootstrap" {
+			progress = append(progress, ev.Value.(BootstrapEvent).Progress)
+		}
+	}
+	if len(progress) != 2 || progress[0] != 50 || progress[1] != 100 {
+		t.Fatalf("Unexpected progress events: %+v", progress)
+	}
+}
+
+// TestMeasureTimeout checks whether we deal with tor not bootstrapping.
+func TestMeasureTimeout(t *testing.T) {
+	tk, _ := measure(t, fakeTorStall, Config{Timeout: time.Second})
+	if tk.Failure == nil || *tk.Failure != errorx.Classify(ErrTimeout) {
+		t.Fatal("We expected ErrTimeout")
+	}
+	if tk.Success || tk.TorProgress != 50 || tk.Timeout != 1 {
+		t.Fatalf("Unexpected test keys: %+v", tk)
+	}
+}
+
+// TestMeasureExited checks whether we deal with tor exiting.
+func TestMeasureExited(t *testing.T) {
+	tk, _ := measure(t, fakeTorExit, Config{})
+	if tk.Failure == nil || *tk.Failure != errorx.Classify(ErrExited) {
+		t.Fatal("We expected ErrExited")
+	}
+	if len(tk.TorLog) != 2 || tk.TorLog[1] != "[err] mocked error" {
+		t.Fatalf("Unexpected logs: %+v", tk.TorLog)
+	}
+}
+
+// TestMeasureReject checks whether we deal with control port errors.
+func TestMeasureReject(t *testing.T) {
+	tk, _ := measure(t, fakeTorReject, Config{})
+	if tk.Failure == nil || !strings.Contains(*tk.Failure, "515") {
+		t.Fatal("We expected an authentication error")
+	}
+}
+
+// TestMeasureVerbose checks whether we limit the number of log lines.
+func TestMeasureVerbose(t *testing.T) {
+	tk, _ := measure(t, fakeTorVerbose, Config{})
+	if tk.Failure != nil || len(tk.TorLog) != maxLogLines {
+		t.Fatal("Unexpected test keys")
+	}
+}
+
+// TestMeasureNotFound checks whether we deal with a missing tor binary.
+func TestMeasureNotFound(t *testing.T) {
+	tk, _ := measure(t, fakeTorBootstrap, Config{TorPath: "/nonexistent/tor"})
+	if tk.Failure == nil || tk.Success {
+		t.Fatal("We expected a failure")
+	}
+}
+
+// TestMeasureInvalidWorkDir checks whether we deal with an invalid
+// working directory.
+func TestMeasureInvalidWorkDir(t *testing.T) {
+	tk, _ := measure(t, fakeTorBootstrap, Config{WorkDirPath: "/nonexistent"})
+	if tk.Failure == nil || tk.Success {
+		t.Fatal("We expected a failure")
+	}
+}
+
+// TestNewNettest checks whether the nettest works.
+func TestNewNettest(t *testing.T) {
+	os.Setenv(fakeTorEnv, fakeTorBootstrap)
+	defer os.Unsetenv(fakeTorEnv)
+	nt := NewNettest(Config{TorPath: os.Args[0]})
+	measurement := nettesttest.Run(nt, "")
+	tk := measurement.TestKeys.(*TestKeys)
+	if !tk.Success {
+		t.Fatal("Unexpected result")
+	}
+}
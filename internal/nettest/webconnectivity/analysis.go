@@ -0,0 +1,196 @@
+package webconnectivity
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidInput indicates that the input is not an HTTP or HTTPS URL.
+var ErrInvalidInput = errors.New("input is not a valid HTTP or HTTPS URL")
+
+// ErrNoTestHelper indicates that no web-connectivity test helper is available.
+var ErrNoTestHelper = errors.New("no available web-connectivity test helper")
+
+// bodyProportionThreshold is the minimum body proportion for
+// considering the two bodies as matching.
+const bodyProportionThreshold = 0.7
+
+// commonHeaders contains the headers that most servers send and that,
+// therefore, are not useful to compare responses.
+var commonHeaders = map[string]bool{
+	"date":                      true,
+	"content-type":              true,
+	"server":                    true,
+	"cache-control":             true,
+	"vary":                      true,
+	"set-cookie":                true,
+	"location":                  true,
+	"expires":                   true,
+	"x-powered-by":              true,
+	"content-encoding":          true,
+	"last-modified":             true,
+	"accept-ranges":             true,
+	"pragma":                    true,
+	"x-frame-options":           true,
+	"etag":                      true,
+	"x-content-type-options":    true,
+	"age":                       true,
+	"via":                       true,
+	"p3p":                       true,
+	"x-xss-protection":          true,
+	"content-language":          true,
+	"cf-ray":                    true,
+	"strict-transport-security": true,
+	"link":                      true,
+	"x-varnish":                 true,
+	"content-length":            true,
+	"connection":                true,
+	"transfer-encoding":         true,
+}
+
+// boolPointer returns a pointer to v.
+func boolPointer(v bool) *bool {
+	return &v
+}
+
+// dnsConsistency compares the resolved addresses with the ones
+// resolved by the test helper.
+func dnsConsistency(tk *TestKeys, isIP bool) string {
+	if isIP {
+		return "consistent"
+	}
+	if tk.DNSExperimentFailure != nil {
+		if tk.Control.DNS.Failure != nil {
+			return "consistent"
+		}
+		return "inconsistent"
+	}
+	controlAddrs := make(map[string]bool)
+	for _, addr := range tk.Control.DNS.Addrs {
+		controlAddrs[addr] = true
+	}
+	for _, query := range tk.Queries {
+		for _, answer := range query.Answers {
+			if controlAddrs[answer.IPv4] || controlAddrs[answer.IPv6] {
+				return "consistent"
+			}
+		}
+	}
+	return "inconsistent"
+}
+
+// uncommonHeaders returns the lowercase names of the uncommon headers.
+func uncommonHeaders(headers map[string]string) map[string]bool {
+	out := make(map[string]bool)
+	for key := range headers {
+		key = strings.ToLower(key)
+		if !commonHeaders[key] {
+			out[key] = true
+		}
+	}
+	return out
+}
+
+// headersMatch returns whether the uncommon headers match.
+func headersMatch(probe, control map[string]string) bool {
+	probeUncommon := uncommonHeaders(probe)
+	controlUncommon := uncommonHeaders(control)
+	if len(probeUncommon) == 0 && len(controlUncommon) == 0 {
+		return true
+	}
+	for key := range probeUncommon {
+		if controlUncommon[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// titleMatch compares the first word of the probe title longer than
+// four characters with the control title. It returns nil when the
+// probe title does not contain any such word.
+func titleMatch(probe, control string) *bool {
+	for _, word := range strings.Fields(probe) {
+		if len(word) > 4 {
+			return boolPointer(strings.Contains(
+				strings.ToLower(control), strings.ToLower(word),
+			))
+		}
+	}
+	return nil
+}
+
+// compareHTTP compares the HTTP result with the control.
+func compareHTTP(tk *TestKeys, result httpResult) {
+	control := tk.Control.HTTPRequest
+	tk.StatusCodeMatch = boolPointer(result.statusCode == control.StatusCode)
+	small, large := result.bodyLength, control.BodyLength
+	if small > large {
+		small, large = large, small
+	}
+	tk.BodyProportion = 1.0
+	if large > 0 {
+		tk.BodyProportion = float64(small) / float64(large)
+	}
+	tk.BodyLengthMatch = boolPointer(tk.BodyProportion > bodyProportionThreshold)
+	tk.HeadersMatch = boolPointer(headersMatch(result.headers, control.Headers))
+	tk.TitleMatch = titleMatch(result.title, control.Title)
+}
+
+// allConnectsBlocked returns whether we failed to connect to all the
+// endpoints while the test helper succeeded.
+func allConnectsBlocked(tk *TestKeys) bool {
+	for idx := range tk.TCPConnect {
+		entry := &tk.TCPConnect[idx]
+		endpoint := net.JoinHostPort(entry.IP, strconv.Itoa(entry.Port))
+		status, found := tk.Control.TCPConnect[endpoint]
+		entry.Status.Blocked = boolPointer(
+			!entry.Status.Success && found && status.Status,
+		)
+	}
+	for _, entry := range tk.TCPConnect {
+		if !*entry.Status.Blocked {
+			return false
+		}
+	}
+	return len(tk.TCPConnect) > 0
+}
+
+// analyze compares the measurement results with the control and
+// fills the accessible and blocking test keys.
+func analyze(tk *TestKeys, isIP bool, result httpResult) {
+	if tk.ControlFailure != nil {
+		return // we cannot say anything without a control
+	}
+	tk.DNSConsistency = dnsConsistency(tk, isIP)
+	tcpBlocked := allConnectsBlocked(tk)
+	switch {
+	case tk.Control.HTTPRequest.Failure != nil && result.failure == nil:
+		// We cannot compare with a control that could not fetch
+	case tk.Control.HTTPRequest.Failure != nil:
+		// The website is also not accessible from the control
+		tk.Accessible, tk.Blocking = boolPointer(false), false
+	case result.failure == nil:
+		compareHTTP(tk, result)
+		bodyLike := *tk.BodyLengthMatch || *tk.HeadersMatch ||
+			(tk.TitleMatch != nil && *tk.TitleMatch)
+		if *tk.StatusCodeMatch && bodyLike {
+			tk.Accessible, tk.Blocking = boolPointer(true), false
+			return
+		}
+		tk.Accessible = boolPointer(false)
+		if tk.DNSConsistency == "inconsistent" {
+			tk.Blocking = "dns"
+			return
+		}
+		tk.Blocking = "http-diff"
+	case tk.DNSConsistency == "inconsistent":
+		tk.Accessible, tk.Blocking = boolPointer(false), "dns"
+	case tcpBlocked:
+		tk.Accessible, tk.Blocking = boolPointer(false), "tcp_ip"
+	default:
+		tk.Accessible, tk.Blocking = boolPointer(false), "http-failure"
+	}
+}
@@ -0,0 +1,102 @@
+package webconnectivity
+
+import "testing"
+
+// TestTitleMatch checks the title comparison.
+func TestTitleMatch(t *testing.T) {
+	if v := titleMatch("Example Domain", "example domain"); v == nil || !*v {
+		t.Fatal("The titles should match")
+	}
+	if v := titleMatch("Blocked Page", "Example Domain"); v == nil || *v {
+		t.Fatal("The titles should not match")
+	}
+	if v := titleMatch("a b c", "Example Domain"); v != nil {
+		t.Fatal("We should not be able to compare")
+	}
+}
+
+// TestHeadersMatch checks the headers comparison.
+func TestHeadersMatch(t *testing.T) {
+	common := map[string]string{"Date": "x", "Server": "y"}
+	if !headersMatch(common, common) {
+		t.Fatal("Only common headers should match")
+	}
+	uncommon := map[string]string{"Date": "x", "X-Foo": "y"}
+	if !headersMatch(uncommon, map[string]string{"x-foo": "z"}) {
+		t.Fatal("The uncommon headers should match")
+	}
+	if headersMatch(uncommon, common) {
+		t.Fatal("The headers should not match")
+	}
+}
+
+// TestDNSConsistency checks the DNS comparison.
+func TestDNSConsistency(t *testing.T) {
+	failure := "dns_nxdomain_error"
+	var cases = []struct {
+		tk       TestKeys
+		isIP     bool
+		expected string
+	}{
+		{TestKeys{}, true, "consistent"},
+		{TestKeys{
+			DNSExperimentFailure: &failure,
+			Control:              ControlResponse{DNS: ControlDNSResult{Failure: &failure}},
+		}, false, "consistent"},
+		{TestKeys{DNSExperimentFailure: &failure}, false, "inconsistent"},
+		{TestKeys{
+			Queries: []DNSQuery{{Answers: []DNSAnswer{{AnswerType: "A", IPv4: "1.1.1.1"}}}},
+			Control: ControlResponse{DNS: ControlDNSResult{Addrs: []string{"1.1.1.1"}}},
+		}, false, "consistent"},
+		{TestKeys{
+			Queries: []DNSQuery{{Answers: []DNSAnswer{{AnswerType: "A", IPv4: "10.0.0.1"}}}},
+			Control: ControlResponse{DNS: ControlDNSResult{Addrs: []string{"1.1.1.1"}}},
+		}, false, "inconsistent"},
+	}
+	for idx, c := range cases {
+		if v := dnsConsistency(&c.tk, c.isIP); v != c.expected {
+			t.Fatalf("case %d: expected %s, got %s", idx, c.expected, v)
+		}
+	}
+}
+
+// TestAnalyzeDNSBlocking checks whether we flag DNS blocking.
+func TestAnalyzeDNSBlocking(t *testing.T) {
+	tk := TestKeys{
+		Queries: []DNSQuery{{Answers: []DNSAnswer{{AnswerType: "A", IPv4: "10.0.0.1"}}}},
+		Control: ControlResponse{
+			DNS: ControlDNSResult{Addrs: []string{"1.1.1.1"}},
+			HTTPRequest: ControlHTTPRequestResult{
+				BodyLength: 1 << 14, StatusCode: 200, Title: "Example Domain",
+			},
+		},
+	}
+	analyze(&tk, false, httpResult{
+		bodyLength: 128, statusCode: 200, title: "Blocked Website",
+		headers: map[string]string{"X-Censor": "1"},
+	})
+	if tk.Blocking != "dns" || tk.Accessible == nil || *tk.Accessible {
+		t.Fatal("We expected dns blocking")
+	}
+}
+
+// TestAnalyzeControlHTTPFailure checks whether we do not flag blocking
+// when the control could not fetch the website and we could.
+func TestAnalyzeControlHTTPFailure(t *testing.T) {
+	failure := "generic_timeout_error"
+	tk := TestKeys{
+		Queries: []DNSQuery{{Answers: []DNSAnswer{{AnswerType: "A", IPv4: "10.0.0.1"}}}},
+		Control: ControlResponse{
+			DNS:         ControlDNSResult{Addrs: []string{"1.1.1.1"}},
+			HTTPRequest: ControlHTTPRequestResult{Failure: &failure},
+		},
+	}
+	analyze(&tk, false, httpResult{bodyLength: 128, statusCode: 200})
+	if tk.Blocking != nil || tk.Accessible != nil {
+		t.Fatal("We expected null blocking and accessible")
+	}
+	analyze(&tk, false, httpResult{failure: &failure})
+	if tk.Blocking != false || tk.Accessible == nil || *tk.Accessible {
+		t.Fatal("We expected the website to be down")
+	}
+}
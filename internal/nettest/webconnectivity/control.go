@@ -0,0 +1,52 @@
+package webconnectivity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/measurement-kit/engine/internal/httpx"
+	"github.com/measurement-kit/engine/internal/nettest/webconnectivity/wcth"
+)
+
+// ControlRequest is the request sent to the web_connectivity test helper.
+type ControlRequest = wcth.ControlRequest
+
+// ControlTCPConnectResult is the result of connecting to an endpoint
+// from the test helper vantage point.
+type ControlTCPConnectResult = wcth.ControlTCPConnectResult
+
+// ControlHTTPRequestResult is the result of fetching the URL from
+// the test helper vantage point.
+type ControlHTTPRequestResult = wcth.ControlHTTPRequestResult
+
+// ControlDNSResult is the result of resolving the URL hostname
+// from the test helper vantage point.
+type ControlDNSResult = wcth.ControlDNSResult
+
+// ControlResponse is the response returned by the web_connectivity
+// test helper.
+type ControlResponse = wcth.ControlResponse
+
+// control sends request to the test helper at URL and returns
+// the test helper response.
+func control(
+	ctx context.Context, URL string, request ControlRequest,
+) (ControlResponse, error) {
+	var response ControlResponse
+	data, err := json.Marshal(request)
+	if err != nil {
+		return response, err
+	}
+	data, err = httpx.POST(ctx, URL, "application/json", data)
+	if err != nil {
+		return response, err
+	}
+	err = json.Unmarshal(data, &response)
+	if err != nil {
+		return response, fmt.Errorf(
+			"cannot parse JSON returned by test helper: %s", err.Error(),
+		)
+	}
+	return response, nil
+}
@@ -0,0 +1,258 @@
+// Package wcth implements the web_connectivity test helper.
+//
+// The test helper receives a ControlRequest containing a URL and a list
+// of endpoints, resolves the URL hostname, connects to the endpoints, and
+// fetches the URL from its own vantage point. It then returns the results
+// as a ControlResponse, which the web_connectivity nettest compares with
+// what it has measured. See also the "Control protocol" section of
+// https://github.com/ooni/spec/blob/master/nettests/ts-017-web-connectivity.md.
+package wcth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+)
+
+// connectTimeout is the timeout for each TCP connect.
+const connectTimeout = 10 * time.Second
+
+// httpTimeout is the timeout for fetching the URL.
+const httpTimeout = 30 * time.Second
+
+// maxBodySize is the maximum number of body bytes we read.
+const maxBodySize = 1 << 20
+
+// maxRequestSize is the maximum size of a ControlRequest.
+const maxRequestSize = 1 << 16
+
+// maxEndpoints is the maximum number of endpoints we connect to.
+const maxEndpoints = 32
+
+// ControlRequest is the request sent to the web_connectivity test helper.
+type ControlRequest struct {
+	// HTTPRequest is the URL to fetch.
+	HTTPRequest string `json:"http_request"`
+
+	// HTTPRequestHeaders contains the headers to use when fetching.
+	HTTPRequestHeaders map[string][]string `json:"http_request_headers"`
+
+	// TCPConnect contains the endpoints to connect to.
+	TCPConnect []string `json:"tcp_connect"`
+}
+
+// ControlTCPConnectResult is the result of connecting to an endpoint
+// from the test helper vantage point.
+type ControlTCPConnectResult struct {
+	// Status indicates whether we could connect.
+	Status bool `json:"status"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+}
+
+// ControlHTTPRequestResult is the result of fetching the URL from
+// the test helper vantage point.
+type ControlHTTPRequestResult struct {
+	// BodyLength is the length of the response body.
+	BodyLength int64 `json:"body_length"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Title is the title of the web page.
+	Title string `json:"title"`
+
+	// Headers contains the response headers.
+	Headers map[string]string `json:"headers"`
+
+	// StatusCode is the response status code.
+	StatusCode int64 `json:"status_code"`
+}
+
+// ControlDNSResult is the result of resolving the URL hostname
+// from the test helper vantage point.
+type ControlDNSResult struct {
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Addrs contains the resolved addresses.
+	Addrs []string `json:"addrs"`
+}
+
+// ControlResponse is the response returned by the web_connectivity
+// test helper.
+type ControlResponse struct {
+	// TCPConnect maps each endpoint to the result of connecting to it.
+	TCPConnect map[string]ControlTCPConnectResult `json:"tcp_connect"`
+
+	// HTTPRequest is the result of fetching the URL.
+	HTTPRequest ControlHTTPRequestResult `json:"http_request"`
+
+	// DNS is the result of resolving the URL hostname.
+	DNS ControlDNSResult `json:"dns"`
+}
+
+// titleRegexp matches the title of a web page.
+var titleRegexp = regexp.MustCompile(`(?is)<title>([^<]{1,256})</title>`)
+
+// ExtractTitle returns the title of a web page, or an empty string.
+func ExtractTitle(body []byte) string {
+	match := titleRegexp.FindSubmatch(body)
+	if match == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(match[1]))
+}
+
+// resolve resolves hostname.
+func resolve(ctx context.Context, hostname string) ControlDNSResult {
+	if net.ParseIP(hostname) != nil {
+		return ControlDNSResult{Addrs: []string{hostname}}
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, hostname)
+	return ControlDNSResult{Addrs: addrs, Failure: errorx.FailureString(err)}
+}
+
+// connect connects to endpoint.
+func connect(ctx context.Context, endpoint string) ControlTCPConnectResult {
+	dialer := net.Dialer{Timeout: connectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", endpoint)
+	if err != nil {
+		return ControlTCPConnectResult{Failure: errorx.FailureString(err)}
+	}
+	conn.Close()
+	return ControlTCPConnectResult{Status: true}
+}
+
+// transport is the transport we use for fetching. It is shared by all
+// the requests and disables keep-alives, so that the test helper, which
+// is a long running server, does not keep idle connections around and
+// every request measures a fresh connection.
+var transport = &http.Transport{
+	DialContext:         (&net.Dialer{Timeout: connectTimeout}).DialContext,
+	DisableKeepAlives:   true,
+	TLSHandshakeTimeout: connectTimeout,
+}
+
+// fetch fetches URL using headers and following redirects.
+func fetch(
+	ctx context.Context, URL string, headers map[string][]string,
+) ControlHTTPRequestResult {
+	var result ControlHTTPRequestResult
+	ctx, cancel := context.WithTimeout(ctx, httpTimeout)
+	defer cancel()
+	request, err := http.NewRequest("GET", URL, nil)
+	if err != nil {
+		result.Failure = errorx.FailureString(err)
+		return result
+	}
+	for key, values := range headers {
+		request.Header[key] = values
+	}
+	client := &http.Client{Transport: transport}
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		result.Failure = errorx.FailureString(err)
+		return result
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxBodySize))
+	if err != nil {
+		result.Failure = errorx.FailureString(err)
+		return result
+	}
+	result.BodyLength = int64(len(body))
+	result.Headers = make(map[string]string)
+	for key, values := range response.Header {
+		result.Headers[key] = strings.Join(values, ", ")
+	}
+	result.StatusCode = int64(response.StatusCode)
+	result.Title = ExtractTitle(body)
+	return result
+}
+
+// Measure performs the measurements described by request from the
+// test helper vantage point and returns the results. We resolve the
+// hostname, connect to the endpoints, and fetch the URL in parallel.
+func Measure(ctx context.Context, request ControlRequest) ControlResponse {
+	var (
+		mu       sync.Mutex
+		response ControlResponse
+		wg       sync.WaitGroup
+	)
+	response.TCPConnect = make(map[string]ControlTCPConnectResult)
+	URL, err := url.Parse(request.HTTPRequest)
+	if err != nil {
+		response.DNS.Failure = errorx.FailureString(err)
+		response.HTTPRequest.Failure = errorx.FailureString(err)
+		return response
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		dns := resolve(ctx, URL.Hostname())
+		mu.Lock()
+		response.DNS = dns
+		mu.Unlock()
+	}()
+	go func() {
+		defer wg.Done()
+		result := fetch(ctx, request.HTTPRequest, request.HTTPRequestHeaders)
+		mu.Lock()
+		response.HTTPRequest = result
+		mu.Unlock()
+	}()
+	for idx, endpoint := range request.TCPConnect {
+		if idx >= maxEndpoints {
+			break
+		}
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			result := connect(ctx, endpoint)
+			mu.Lock()
+			response.TCPConnect[endpoint] = result
+			mu.Unlock()
+		}(endpoint)
+	}
+	wg.Wait()
+	return response
+}
+
+// Handler is the web_connectivity test helper HTTP handler. It
+// expects a ControlRequest to be POSTed as JSON and replies with
+// the corresponding ControlResponse serialized as JSON.
+type Handler struct{}
+
+// ServeHTTP implements http.Handler.ServeHTTP.
+func (Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var request ControlRequest
+	reader := io.LimitReader(r.Body, maxRequestSize)
+	err := json.NewDecoder(reader).Decode(&request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	data, err := json.Marshal(Measure(r.Context(), request))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,127 @@
+package wcth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// withTarget runs fn with a local stand-in for the target website.
+func withTarget(t *testing.T, fn func(URL string)) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("User-Agent") != "wcth-test/0.0.1" {
+				w.WriteHeader(400)
+				return
+			}
+			w.Header().Set("X-Example", "1")
+			w.Write([]byte(`<html><title> Example Domain </title></html>`))
+		},
+	))
+	defer srv.Close()
+	fn(srv.URL)
+}
+
+// post posts request to the test helper at URL.
+func post(t *testing.T, URL string, body []byte) (*http.Response, ControlResponse) {
+	var response ControlResponse
+	resp, err := http.Post(URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 200 {
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, response
+}
+
+// TestHandler checks whether the test helper measures the target.
+func TestHandler(t *testing.T) {
+	withTarget(t, func(target string) {
+		srv := httptest.NewServer(Handler{})
+		defer srv.Close()
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		closed := listener.Addr().String()
+		listener.Close()
+		parsed, _ := url.Parse(target)
+		data, _ := json.Marshal(ControlRequest{
+			HTTPRequest: target,
+			HTTPRequestHeaders: map[string][]string{
+				"User-Agent": {"wcth-test/0.0.1"},
+			},
+			TCPConnect: []string{parsed.Host, closed},
+		})
+		resp, response := post(t, srv.URL, data)
+		if resp.StatusCode != 200 {
+			t.Fatal("Unexpected status code")
+		}
+		if response.DNS.Failure != nil || len(response.DNS.Addrs) != 1 {
+			t.Fatal("Unexpected DNS result")
+		}
+		if !response.TCPConnect[parsed.Host].Status {
+			t.Fatal("We should be able to connect to the target")
+		}
+		if response.TCPConnect[closed].Status ||
+			response.TCPConnect[closed].Failure == nil {
+			t.Fatal("We should not be able to connect to a closed port")
+		}
+		http := response.HTTPRequest
+		if http.Failure != nil || http.StatusCode != 200 {
+			t.Fatal("Unexpected HTTP result")
+		}
+		if http.Title != "Example Domain" || http.Headers["X-Example"] != "1" {
+			t.Fatal("Unexpected title or headers")
+		}
+		if http.BodyLength != int64(len(`<html><title> Example Domain </title></html>`)) {
+			t.Fatal("Unexpected body length")
+		}
+	})
+}
+
+// TestHandlerFailures checks whether the test helper deals
+// with invalid requests and failures.
+func TestHandlerFailures(t *testing.T) {
+	srv := httptest.NewServer(Handler{})
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatal("GET should not be allowed")
+	}
+	resp, _ = post(t, srv.URL, []byte("{"))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatal("Invalid JSON should not be accepted")
+	}
+	_, response := post(t, srv.URL, []byte(`{"http_request":"\t"}`))
+	if response.DNS.Failure == nil || response.HTTPRequest.Failure == nil {
+		t.Fatal("We expected failures with an invalid URL")
+	}
+	_, response = post(t, srv.URL, []byte(`{"http_request":"ftp://127.0.0.1/"}`))
+	if response.HTTPRequest.Failure == nil {
+		t.Fatal("We expected an HTTP failure")
+	}
+}
+
+// TestExtractTitle checks whether we can extract titles.
+func TestExtractTitle(t *testing.T) {
+	if ExtractTitle([]byte("<TITLE>\nfoo\n</TITLE>")) != "foo" {
+		t.Fatal("We should extract the title")
+	}
+	if ExtractTitle([]byte("<html></html>")) != "" {
+		t.Fatal("There is no title here")
+	}
+}
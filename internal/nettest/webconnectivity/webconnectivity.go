@@ -0,0 +1,412 @@
+// Package webconnectivity implements the web_connectivity nettest.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-017-web-connectivity.md
+// for the nettest specification. We resolve the hostname of the input URL,
+// connect to every resolved address, fetch the URL following redirects,
+// and compare the results with the ones obtained by the web_connectivity
+// test helper to decide whether the website is accessible or blocked.
+package webconnectivity
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/nettest/webconnectivity/wcth"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+)
+
+// TestHelperName is the name of the test helper we use.
+const TestHelperName = "web-connectivity"
+
+// connectTimeout is the timeout for each TCP connect.
+const connectTimeout = 10 * time.Second
+
+// httpTimeout is the timeout for fetching the URL.
+const httpTimeout = 30 * time.Second
+
+// maxBodySize is the maximum number of body bytes we read.
+const maxBodySize = 1 << 20
+
+// httpRequestHeaders contains the headers we send when fetching the URL. We
+// send the same headers to the test helper such that it fetches the
+// URL like we do.
+var httpRequestHeaders = map[string][]string{
+	"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+	"Accept-Language": {"en-US;q=0.8,en;q=0.5"},
+	"User-Agent":      {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/74.0.3729.169 Safari/537.36"},
+}
+
+// DNSAnswer is an answer to a DNS query.
+type DNSAnswer struct {
+	// AnswerType is either "A" or "AAAA".
+	AnswerType string `json:"answer_type"`
+
+	// IPv4 is the IPv4 address for "A" answers.
+	IPv4 string `json:"ipv4,omitempty"`
+
+	// IPv6 is the IPv6 address for "AAAA" answers.
+	IPv6 string `json:"ipv6,omitempty"`
+}
+
+// DNSQuery is a DNS query and its answers.
+type DNSQuery struct {
+	// Answers contains the answers.
+	Answers []DNSAnswer `json:"answers"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Hostname is the hostname we resolved.
+	Hostname string `json:"hostname"`
+
+	// QueryType is the query type.
+	QueryType string `json:"query_type"`
+}
+
+// TCPConnectStatus is the status of a TCP connect.
+type TCPConnectStatus struct {
+	// Blocked indicates whether we think the connect was blocked.
+	Blocked *bool `json:"blocked"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Success indicates whether we could connect.
+	Success bool `json:"success"`
+}
+
+// TCPConnect is a TCP connect to an endpoint.
+type TCPConnect struct {
+	// IP is the IP address we connected to.
+	IP string `json:"ip"`
+
+	// Port is the port we connected to.
+	Port int `json:"port"`
+
+	// Status is the connect status.
+	Status TCPConnectStatus `json:"status"`
+}
+
+// HTTPRequest is an HTTP request.
+type HTTPRequest struct {
+	// Headers contains the request headers.
+	Headers map[string]string `json:"headers"`
+
+	// Method is the request method.
+	Method string `json:"method"`
+
+	// URL is the request URL.
+	URL string `json:"url"`
+}
+
+// HTTPResponse is an HTTP response.
+type HTTPResponse struct {
+	// Body is the response body. It is only set for the last response
+	// and it is truncated to a reasonable maximum size.
+	Body string `json:"body"`
+
+	// Code is the status code.
+	Code int64 `json:"code"`
+
+	// Headers contains the response headers.
+	Headers map[string]string `json:"headers"`
+}
+
+// HTTPRoundTrip is an HTTP request and the corresponding response.
+type HTTPRoundTrip struct {
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Request is the request.
+	Request HTTPRequest `json:"request"`
+
+	// Response is the response.
+	Response HTTPResponse `json:"response"`
+}
+
+// TestKeys contains the web_connectivity test keys.
+type TestKeys struct {
+	// Agent is the HTTP agent we used. We follow redirects, hence
+	// the value of this field is always "redirect".
+	Agent string `json:"agent"`
+
+	// DNSExperimentFailure is the DNS failure, if any.
+	DNSExperimentFailure *string `json:"dns_experiment_failure"`
+
+	// DNSConsistency is either "consistent" or "inconsistent". It is
+	// empty if we could not compare with the control.
+	DNSConsistency string `json:"dns_consistency"`
+
+	// ControlFailure is the failure talking to the test helper, if any.
+	ControlFailure *string `json:"control_failure"`
+
+	// HTTPExperimentFailure is the HTTP failure, if any.
+	HTTPExperimentFailure *string `json:"http_experiment_failure"`
+
+	// BodyLengthMatch indicates whether the body lengths match.
+	BodyLengthMatch *bool `json:"body_length_match"`
+
+	// BodyProportion is the ratio between the smaller and the
+	// larger of the body lengths.
+	BodyProportion float64 `json:"body_proportion"`
+
+	// HeadersMatch indicates whether the uncommon headers match.
+	HeadersMatch *bool `json:"headers_match"`
+
+	// StatusCodeMatch indicates whether the status codes match.
+	StatusCodeMatch *bool `json:"status_code_match"`
+
+	// TitleMatch indicates whether the titles match.
+	TitleMatch *bool `json:"title_match"`
+
+	// Accessible indicates whether the website is accessible. It is
+	// null when we cannot determine that.
+	Accessible *bool `json:"accessible"`
+
+	// Blocking is false if there is no blocking, null if we cannot
+	// determine that, or one of "dns", "tcp_ip", "http-failure", and
+	// "http-diff" depending on the kind of blocking.
+	Blocking interface{} `json:"blocking"`
+
+	// Queries contains the DNS queries.
+	Queries []DNSQuery `json:"queries"`
+
+	// TCPConnect contains the TCP connects.
+	TCPConnect []TCPConnect `json:"tcp_connect"`
+
+	// Requests contains the HTTP round trips.
+	Requests []HTTPRoundTrip `json:"requests"`
+
+	// Control contains the test helper response.
+	Control ControlResponse `json:"control"`
+}
+
+// httpResult is the result of fetching the URL.
+type httpResult struct {
+	bodyLength int64
+	failure    *string
+	headers    map[string]string
+	statusCode int64
+	title      string
+}
+
+// flattenHeaders converts HTTP headers into a map.
+func flattenHeaders(header http.Header) map[string]string {
+	out := make(map[string]string)
+	for key, values := range header {
+		out[key] = strings.Join(values, ", ")
+	}
+	return out
+}
+
+// resolve resolves hostname and fills tk.Queries.
+func resolve(ctx context.Context, hostname string, tk *TestKeys) []string {
+	if net.ParseIP(hostname) != nil {
+		return []string{hostname}
+	}
+	query := DNSQuery{Hostname: hostname, QueryType: "A"}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, hostname)
+	query.Failure = errorx.FailureString(err)
+	for _, addr := range addrs {
+		if strings.Contains(addr, ":") {
+			query.Answers = append(query.Answers, DNSAnswer{
+				AnswerType: "AAAA", IPv6: addr,
+			})
+		} else {
+			query.Answers = append(query.Answers, DNSAnswer{
+				AnswerType: "A", IPv4: addr,
+			})
+		}
+	}
+	tk.Queries = append(tk.Queries, query)
+	tk.DNSExperimentFailure = query.Failure
+	return addrs
+}
+
+// connect connects to every addr using port and fills tk.TCPConnect. It
+// returns the list of endpoints, to be passed to the test helper.
+func connect(
+	ctx context.Context, addrs []string, port string, tk *TestKeys,
+) []string {
+	var endpoints []string
+	portnum, _ := strconv.Atoi(port)
+	for _, addr := range addrs {
+		endpoint := net.JoinHostPort(addr, port)
+		endpoints = append(endpoints, endpoint)
+		dialer := net.Dialer{Timeout: connectTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", endpoint)
+		if err == nil {
+			conn.Close()
+		}
+		tk.TCPConnect = append(tk.TCPConnect, TCPConnect{
+			IP:   addr,
+			Port: portnum,
+			Status: TCPConnectStatus{
+				Failure: errorx.FailureString(err),
+				Success: err == nil,
+			},
+		})
+	}
+	return endpoints
+}
+
+// recorder is an http.RoundTripper recording every round trip.
+type recorder struct {
+	requests  []HTTPRoundTrip
+	transport http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip.
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.transport.RoundTrip(req)
+	rt := HTTPRoundTrip{
+		Failure: errorx.FailureString(err),
+		Request: HTTPRequest{
+			Headers: flattenHeaders(req.Header),
+			Method:  req.Method,
+			URL:     req.URL.String(),
+		},
+	}
+	if err == nil {
+		rt.Response = HTTPResponse{
+			Code:    int64(resp.StatusCode),
+			Headers: flattenHeaders(resp.Header),
+		}
+	}
+	r.requests = append(r.requests, rt)
+	return resp, err
+}
+
+// fetch fetches URL following redirects and fills tk.Requests.
+func fetch(ctx context.Context, URL string, tk *TestKeys) httpResult {
+	var result httpResult
+	ctx, cancel := context.WithTimeout(ctx, httpTimeout)
+	defer cancel()
+	transport := &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: connectTimeout}).DialContext,
+		TLSHandshakeTimeout: connectTimeout,
+	}
+	defer transport.CloseIdleConnections()
+	rec := &recorder{transport: transport}
+	defer func() { tk.Requests = rec.requests }()
+	request, err := http.NewRequest("GET", URL, nil)
+	if err != nil {
+		result.failure = errorx.FailureString(err)
+		return result
+	}
+	request.Header = make(http.Header)
+	for key, values := range httpRequestHeaders {
+		request.Header[key] = values
+	}
+	client := &http.Client{Transport: rec}
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		result.failure = errorx.FailureString(err)
+		return result
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxBodySize))
+	last := &rec.requests[len(rec.requests)-1]
+	if err != nil {
+		result.failure = errorx.FailureString(err)
+		last.Failure = result.failure
+		return result
+	}
+	last.Response.Body = string(body)
+	result.bodyLength = int64(len(body))
+	result.headers = last.Response.Headers
+	result.statusCode = last.Response.Code
+	result.title = wcth.ExtractTitle(body)
+	return result
+}
+
+// helperURL returns the URL of the first usable test helper.
+func helperURL(helpers map[string][]model.Service) string {
+	for _, e := range helpers[TestHelperName] {
+		if e.Type == "https" {
+			return e.Address
+		}
+	}
+	return ""
+}
+
+// Measure runs web_connectivity for input using the test helper at
+// helper. It returns the test keys.
+func Measure(
+	ctx context.Context, helper, input string, out chan<- model.Event,
+) *TestKeys {
+	tk := &TestKeys{Agent: "redirect"}
+	URL, err := url.Parse(input)
+	if err != nil || (URL.Scheme != "http" && URL.Scheme != "https") ||
+		URL.Hostname() == "" {
+		tk.HTTPExperimentFailure = errorx.FailureString(ErrInvalidInput)
+		out <- model.NewFailureMeasurementEvent(0, ErrInvalidInput)
+		return tk
+	}
+	port := URL.Port()
+	if port == "" {
+		port = "80"
+		if URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	out <- model.NewLogInfoEvent("web_connectivity: resolving " + URL.Hostname())
+	addrs := resolve(ctx, URL.Hostname(), tk)
+	out <- model.NewLogInfoEvent("web_connectivity: connecting to endpoints")
+	endpoints := connect(ctx, addrs, port, tk)
+	out <- model.NewLogInfoEvent("web_connectivity: fetching " + input)
+	result := fetch(ctx, input, tk)
+	tk.HTTPExperimentFailure = result.failure
+	if helper == "" {
+		err = ErrNoTestHelper
+	} else {
+		out <- model.NewLogInfoEvent("web_connectivity: contacting " + helper)
+		tk.Control, err = control(ctx, helper, ControlRequest{
+			HTTPRequest:        input,
+			HTTPRequestHeaders: httpRequestHeaders,
+			TCPConnect:         endpoints,
+		})
+	}
+	tk.ControlFailure = errorx.FailureString(err)
+	if err != nil {
+		out <- model.NewLogWarningEvent(err, "web_connectivity: control failed")
+	}
+	analyze(tk, net.ParseIP(URL.Hostname()) != nil, result)
+	return tk
+}
+
+// NewNettest creates a new web_connectivity nettest. The input of each
+// measurement is the URL to measure. We use the "web-connectivity" test
+// helper in the nettest AvailableTestHelpers as control.
+func NewNettest() *nettest.Nettest {
+	nt := &nettest.Nettest{
+		TestName:        "web_connectivity",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+	}
+	nt.Main = func(
+		ctx context.Context,
+		input string,
+		measurement *model.Measurement,
+		out chan<- model.Event,
+	) {
+		helper := helperURL(nt.AvailableTestHelpers)
+		if helper != "" {
+			measurement.TestHelpers = map[string]string{"backend": helper}
+		}
+		measurement.TestKeys = Measure(ctx, helper, input, out)
+	}
+	return nt
+}
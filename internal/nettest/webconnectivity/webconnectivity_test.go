@@ -0,0 +1,266 @@
+package webconnectivity
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/measurement-kit/engine/internal/nettest/webconnectivity/wcth"
+	"github.com/measurement-kit/engine/model"
+)
+
+const targetBody = `<html><head><title>Example Domain</title></head></html>`
+
+// withTarget runs fn with a local stand-in for the target website.
+func withTarget(t *testing.T, fn func(URL string)) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/redirect" {
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+			if r.URL.Path == "/reset" {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			w.Header().Set("X-Example", "1")
+			w.Write([]byte(targetBody))
+		},
+	))
+	defer srv.Close()
+	fn(srv.URL)
+}
+
+// withHelper runs fn with a local stand-in for the test helper
+// that returns the control response computed by makeResponse.
+func withHelper(
+	t *testing.T, makeResponse func(ControlRequest) ControlResponse,
+	fn func(URL string),
+) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var request ControlRequest
+			err := json.NewDecoder(r.Body).Decode(&request)
+			if err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			data, _ := json.Marshal(makeResponse(request))
+			w.Write(data)
+		},
+	))
+	defer srv.Close()
+	fn(srv.URL)
+}
+
+// withTestHelper runs fn with a local instance of the test helper.
+func withTestHelper(t *testing.T, fn func(URL string)) {
+	srv := httptest.NewServer(wcth.Handler{})
+	defer srv.Close()
+	fn(srv.URL)
+}
+
+// goodControl returns a control response consistent with the target.
+func goodControl(request ControlRequest) ControlResponse {
+	response := ControlResponse{
+		TCPConnect: make(map[string]ControlTCPConnectResult),
+		HTTPRequest: ControlHTTPRequestResult{
+			BodyLength: int64(len(targetBody)),
+			Headers:    map[string]string{"X-Example": "1"},
+			StatusCode: 200,
+			Title:      "Example Domain",
+		},
+	}
+	for _, endpoint := range request.TCPConnect {
+		response.TCPConnect[endpoint] = ControlTCPConnectResult{Status: true}
+	}
+	return response
+}
+
+// measure runs Measure and discards the emitted events.
+func measure(helper, input string) *TestKeys {
+	out := make(chan model.Event)
+	done := make(chan struct{})
+	go func() {
+		for range out {
+		}
+		close(done)
+	}()
+	tk := Measure(context.Background(), helper, input, out)
+	close(out)
+	<-done
+	return tk
+}
+
+// TestMeasureAccessible checks the case where the website is accessible.
+func TestMeasureAccessible(t *testing.T) {
+	withTarget(t, func(target string) {
+		withTestHelper(t, func(helper string) {
+			tk := measure(helper, target+"/redirect")
+			if tk.ControlFailure != nil {
+				t.Fatal(*tk.ControlFailure)
+			}
+			if tk.Accessible == nil || !*tk.Accessible || tk.Blocking != false {
+				t.Fatal("The website should be accessible")
+			}
+			if len(tk.Requests) != 2 {
+				t.Fatal("We did not record the redirect")
+			}
+			if tk.Requests[1].Response.Body != targetBody {
+				t.Fatal("We did not record the body")
+			}
+			if len(tk.TCPConnect) != 1 || !tk.TCPConnect[0].Status.Success {
+				t.Fatal("Unexpected TCP connect results")
+			}
+			if tk.DNSConsistency != "consistent" {
+				t.Fatal("Unexpected DNS consistency")
+			}
+		})
+	})
+}
+
+// TestMeasureHTTPDiff checks the case where the target returns
+// something different from what the control sees.
+func TestMeasureHTTPDiff(t *testing.T) {
+	withTarget(t, func(target string) {
+		withHelper(t, func(request ControlRequest) ControlResponse {
+			response := goodControl(request)
+			response.HTTPRequest.BodyLength = 1 << 14
+			response.HTTPRequest.StatusCode = 301
+			response.HTTPRequest.Title = "Something else"
+			response.HTTPRequest.Headers = map[string]string{"X-Other": "1"}
+			return response
+		}, func(helper string) {
+			tk := measure(helper, target)
+			if tk.Accessible == nil || *tk.Accessible || tk.Blocking != "http-diff" {
+				t.Fatal("We expected http-diff blocking")
+			}
+		})
+	})
+}
+
+// TestMeasureHTTPFailure checks the case where fetching fails
+// but the control succeeds.
+func TestMeasureHTTPFailure(t *testing.T) {
+	withTarget(t, func(target string) {
+		withHelper(t, goodControl, func(helper string) {
+			tk := measure(helper, target+"/reset")
+			if tk.HTTPExperimentFailure == nil {
+				t.Fatal("We expected an HTTP failure")
+			}
+			if tk.Blocking != "http-failure" {
+				t.Fatal("We expected http-failure blocking")
+			}
+		})
+	})
+}
+
+// TestMeasureTCPIP checks the case where connecting fails
+// but the control succeeds.
+func TestMeasureTCPIP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := "http://" + listener.Addr().String()
+	listener.Close() // now connecting fails
+	withHelper(t, goodControl, func(helper string) {
+		tk := measure(helper, target)
+		if tk.Blocking != "tcp_ip" {
+			t.Fatal("We expected tcp_ip blocking")
+		}
+		if !*tk.TCPConnect[0].Status.Blocked {
+			t.Fatal("The connect should be marked as blocked")
+		}
+	})
+}
+
+// TestMeasureWebsiteDown checks the case where both the
+// probe and the control cannot fetch the website.
+func TestMeasureWebsiteDown(t *testing.T) {
+	withTarget(t, func(target string) {
+		withHelper(t, func(request ControlRequest) ControlResponse {
+			response := goodControl(request)
+			failure := "connection_reset"
+			response.HTTPRequest = ControlHTTPRequestResult{Failure: &failure}
+			return response
+		}, func(helper string) {
+			tk := measure(helper, target+"/reset")
+			if tk.Accessible == nil || *tk.Accessible || tk.Blocking != false {
+				t.Fatal("The website should be down with no blocking")
+			}
+		})
+	})
+}
+
+// TestMeasureControlFailure checks the case where we cannot
+// talk with the test helper.
+func TestMeasureControlFailure(t *testing.T) {
+	withTarget(t, func(target string) {
+		for _, helper := range []string{"", target + "/reset"} {
+			tk := measure(helper, target)
+			if tk.ControlFailure == nil {
+				t.Fatal("We expected a control failure")
+			}
+			if tk.Accessible != nil || tk.Blocking != nil {
+				t.Fatal("We should not draw any conclusion")
+			}
+		}
+	})
+}
+
+// TestMeasureInvalidInput checks whether we deal with invalid input.
+func TestMeasureInvalidInput(t *testing.T) {
+	for _, input := range []string{"", "\t", "ftp://x.org/", "http://"} {
+		tk := measure("", input)
+		if tk.HTTPExperimentFailure == nil {
+			t.Fatalf("%s: we expected a failure", input)
+		}
+	}
+}
+
+// TestNewNettest checks whether the nettest uses the test helper
+// in its AvailableTestHelpers.
+func TestNewNettest(t *testing.T) {
+	withTarget(t, func(target string) {
+		withTestHelper(t, func(helper string) {
+			nt := NewNettest()
+			nt.AvailableTestHelpers = map[string][]model.Service{
+				TestHelperName: {
+					{Address: "httpo://o7mcp5y4ibyjkcgs.onion", Type: "onion"},
+					{Address: helper, Type: "https"},
+				},
+			}
+			measurement := nt.NewMeasurement()
+			for range nt.StartMeasurement(context.Background(), target, &measurement) {
+			}
+			if measurement.TestHelpers["backend"] != helper {
+				t.Fatal("We did not use the expected test helper")
+			}
+			tk := measurement.TestKeys.(*TestKeys)
+			if tk.Accessible == nil || !*tk.Accessible {
+				t.Fatal("The website should be accessible")
+			}
+		})
+	})
+}
+
+// TestResolve checks whether we record DNS queries.
+func TestResolve(t *testing.T) {
+	var tk TestKeys
+	addrs := resolve(context.Background(), "localhost", &tk)
+	if len(addrs) <= 0 || len(tk.Queries) != 1 {
+		t.Fatal("Unexpected resolve results")
+	}
+	if len(tk.Queries[0].Answers) != len(addrs) {
+		t.Fatal("We did not record all the answers")
+	}
+	if _, err := url.Parse("http://" + addrs[0]); err != nil {
+		t.Fatal(err)
+	}
+}
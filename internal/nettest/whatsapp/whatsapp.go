@@ -0,0 +1,195 @@
+// Package whatsapp implements the whatsapp nettest.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-018-whatsapp.md
+// for the nettest specification. We resolve the hostnames of the WhatsApp
+// endpoints, we connect to the resolved addresses using TCP, and we fetch
+// the registration service and the WhatsApp web interface.
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/nettest/im"
+	"github.com/measurement-kit/engine/internal/version"
+	"github.com/measurement-kit/engine/model"
+)
+
+// lookupHost allows to mock the system resolver in tests.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// DefaultRegistrationServerURL is the default registration service URL.
+const DefaultRegistrationServerURL = "https://v.whatsapp.net/v2/register"
+
+// DefaultEndpoints contains the default WhatsApp endpoints.
+var DefaultEndpoints = func() []string {
+	var endpoints []string
+	for i := 1; i <= 16; i++ {
+		hostname := fmt.Sprintf("e%d.whatsapp.net", i)
+		endpoints = append(endpoints, net.JoinHostPort(hostname, "443"))
+		endpoints = append(endpoints, net.JoinHostPort(hostname, "5222"))
+	}
+	return endpoints
+}()
+
+// DefaultWebURLs contains the default WhatsApp web interface URLs.
+var DefaultWebURLs = []string{
+	"http://web.whatsapp.com/",
+	"https://web.whatsapp.com/",
+}
+
+// Config contains the whatsapp nettest configuration.
+type Config struct {
+	// Endpoints contains the "hostname:port" WhatsApp endpoints. When
+	// empty, we use DefaultEndpoints.
+	Endpoints []string
+
+	// RegistrationServerURL is the registration service URL. When
+	// empty, we use DefaultRegistrationServerURL.
+	RegistrationServerURL string
+
+	// WebURLs contains the URLs of the web interface. When empty, we
+	// use DefaultWebURLs.
+	WebURLs []string
+}
+
+// TestKeys contains the whatsapp test keys.
+type TestKeys struct {
+	// RegistrationServerFailure is the registration service failure, if any.
+	RegistrationServerFailure *string `json:"registration_server_failure"`
+
+	// RegistrationServerStatus is "ok" if we could contact the
+	// registration service and "blocked" otherwise.
+	RegistrationServerStatus string `json:"registration_server_status"`
+
+	// Queries contains the DNS queries.
+	Queries []im.DNSQuery `json:"queries"`
+
+	// Requests contains the HTTP requests.
+	Requests []im.HTTPRequest `json:"requests"`
+
+	// TCPConnect contains the TCP connects.
+	TCPConnect []im.TCPConnect `json:"tcp_connect"`
+
+	// WhatsappEndpointsBlocked contains the hostnames of the endpoints
+	// to which we could not connect on any port.
+	WhatsappEndpointsBlocked []string `json:"whatsapp_endpoints_blocked"`
+
+	// WhatsappEndpointsStatus is "blocked" if we could not connect to
+	// any endpoint and "ok" otherwise. Considering the endpoints blocked
+	// only when all of them are blocked reduces false positives caused by
+	// individual endpoints being down.
+	WhatsappEndpointsStatus string `json:"whatsapp_endpoints_status"`
+
+	// WhatsappWebFailure is the first web interface failure, if any.
+	WhatsappWebFailure *string `json:"whatsapp_web_failure"`
+
+	// WhatsappWebStatus is "ok" if we could fetch all the web interface
+	// URLs and "blocked" otherwise.
+	WhatsappWebStatus string `json:"whatsapp_web_status"`
+}
+
+// measureEndpoints resolves the hostnames of the endpoints, connects
+// to the resolved addresses, and fills tk. We resolve each hostname
+// just once, even when it is used by several endpoints.
+func measureEndpoints(
+	ctx context.Context, endpoints []string, tk *TestKeys,
+	out chan<- model.Event,
+) {
+	reachable := make(map[string]bool)
+	resolved := make(map[string][]string)
+	var hostnames []string
+	for _, endpoint := range endpoints {
+		hostname, port, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			out <- model.NewLogWarningEvent(err, "whatsapp: invalid endpoint")
+			continue
+		}
+		if _, found := reachable[hostname]; !found {
+			hostnames = append(hostnames, hostname)
+			reachable[hostname] = false
+			out <- model.NewLogInfoEvent("whatsapp: resolving " + hostname)
+			query := im.Resolve(ctx, lookupHost, hostname)
+			tk.Queries = append(tk.Queries, query)
+			resolved[hostname] = query.Addrs
+		}
+		for _, addr := range resolved[hostname] {
+			address := net.JoinHostPort(addr, port)
+			out <- model.NewLogInfoEvent("whatsapp: connecting to " + address)
+			entry := im.Connect(ctx, address)
+			tk.TCPConnect = append(tk.TCPConnect, entry)
+			reachable[hostname] = reachable[hostname] || entry.Status.Success
+		}
+	}
+	tk.WhatsappEndpointsStatus = "ok"
+	for _, hostname := range hostnames {
+		if !reachable[hostname] {
+			tk.WhatsappEndpointsBlocked = append(
+				tk.WhatsappEndpointsBlocked, hostname,
+			)
+		}
+	}
+	if len(tk.WhatsappEndpointsBlocked) == len(hostnames) {
+		tk.WhatsappEndpointsStatus = "blocked"
+	}
+}
+
+// Measure runs whatsapp using config. It returns the test keys.
+func Measure(ctx context.Context, config Config, out chan<- model.Event) *TestKeys {
+	tk := &TestKeys{
+		RegistrationServerStatus: "ok",
+		WhatsappWebStatus:        "ok",
+	}
+	endpoints := config.Endpoints
+	if len(endpoints) <= 0 {
+		endpoints = DefaultEndpoints
+	}
+	measureEndpoints(ctx, endpoints, tk, out)
+	registrationURL := config.RegistrationServerURL
+	if registrationURL == "" {
+		registrationURL = DefaultRegistrationServerURL
+	}
+	out <- model.NewLogInfoEvent("whatsapp: fetching " + registrationURL)
+	result := im.Request(ctx, "GET", registrationURL)
+	tk.Requests = append(tk.Requests, result)
+	if result.Failure != nil {
+		tk.RegistrationServerFailure = result.Failure
+		tk.RegistrationServerStatus = "blocked"
+	}
+	webURLs := config.WebURLs
+	if len(webURLs) <= 0 {
+		webURLs = DefaultWebURLs
+	}
+	for _, URL := range webURLs {
+		out <- model.NewLogInfoEvent("whatsapp: fetching " + URL)
+		result := im.Request(ctx, "GET", URL)
+		tk.Requests = append(tk.Requests, result)
+		if result.Failure != nil && tk.WhatsappWebFailure == nil {
+			tk.WhatsappWebFailure = result.Failure
+			tk.WhatsappWebStatus = "blocked"
+		}
+	}
+	return tk
+}
+
+// NewNettest creates a new whatsapp nettest. We do not use the
+// measurement input.
+func NewNettest(config Config) *nettest.Nettest {
+	return &nettest.Nettest{
+		TestName:        "whatsapp",
+		TestVersion:     "0.0.1",
+		SoftwareName:    "MKEngine",
+		SoftwareVersion: version.Version,
+		TestStartTime:   nettest.FormatTimeNowUTC(),
+		Main: func(
+			ctx context.Context,
+			input string,
+			measurement *model.Measurement,
+			out chan<- model.Event,
+		) {
+			measurement.TestKeys = Measure(ctx, config, out)
+		},
+	}
+}
@@ -0,0 +1,161 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/measurement-kit/engine/internal/nettest/nettesttest"
+	"github.com/measurement-kit/engine/model"
+)
+
+// withServer runs fn with a local stand-in for WhatsApp.
+func withServer(t *testing.T, fn func(endpoint string)) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	fn(strings.TrimPrefix(server.URL, "http://"))
+}
+
+// closedPort returns a port where nobody is listening.
+func closedPort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+// withSystemResolver runs fn with a mocked system resolver using records.
+func withSystemResolver(records map[string][]string, fn func()) {
+	savedFunc := lookupHost
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		addrs, found := records[host]
+		if !found {
+			return nil, errors.New("mocked error")
+		}
+		return addrs, nil
+	}
+	defer func() { lookupHost = savedFunc }()
+	fn()
+}
+
+// records contains the records used by the mocked system resolver.
+var records = map[string][]string{
+	"127.0.0.1": {"127.0.0.1"},
+	"localhost": {"127.0.0.1"},
+}
+
+// measure runs Measure draining the events.
+func measure(config Config) *TestKeys {
+	var tk *TestKeys
+	nettesttest.Drain(func(out chan<- model.Event) {
+		tk = Measure(context.Background(), config, out)
+	})
+	return tk
+}
+
+// TestMeasureOK checks whether we do not flag blocking when
+// WhatsApp is reachable.
+func TestMeasureOK(t *testing.T) {
+	withServer(t, func(endpoint string) {
+		_, port, _ := net.SplitHostPort(endpoint)
+		var tk *TestKeys
+		withSystemResolver(records, func() {
+			tk = measure(Config{
+				Endpoints: []string{
+					"127.0.0.1:" + closedPort(t), "127.0.0.1:" + port,
+					"localhost:" + closedPort(t),
+				},
+				RegistrationServerURL: "http://" + endpoint + "/v2/register",
+				WebURLs:               []string{"http://" + endpoint + "/"},
+			})
+		})
+		if tk.WhatsappEndpointsStatus != "ok" {
+			t.Fatal("Unexpected endpoints blocking")
+		}
+		if !reflect.DeepEqual(tk.WhatsappEndpointsBlocked, []string{"localhost"}) {
+			t.Fatalf("Unexpected blocked endpoints: %+v", tk.WhatsappEndpointsBlocked)
+		}
+		if tk.RegistrationServerStatus != "ok" || tk.RegistrationServerFailure != nil {
+			t.Fatal("Unexpected registration server blocking")
+		}
+		if tk.WhatsappWebStatus != "ok" || tk.WhatsappWebFailure != nil {
+			t.Fatal("Unexpected web blocking")
+		}
+		if len(tk.Queries) != 2 || tk.Queries[1].Hostname != "localhost" {
+			t.Fatalf("Unexpected queries: %+v", tk.Queries)
+		}
+		if len(tk.TCPConnect) != 3 || len(tk.Requests) != 2 {
+			t.Fatal("Unexpected number of connects or requests")
+		}
+	})
+}
+
+// TestMeasureBlocked checks whether we flag blocking when
+// WhatsApp is not reachable.
+func TestMeasureBlocked(t *testing.T) {
+	closed := "http://127.0.0.1:" + closedPort(t) + "/"
+	var tk *TestKeys
+	withSystemResolver(records, func() {
+		tk = measure(Config{
+			Endpoints: []string{
+				"127.0.0.1:" + closedPort(t), "antani", "blocked.example:443",
+			},
+			RegistrationServerURL: closed,
+			WebURLs:               []string{closed},
+		})
+	})
+	if tk.WhatsappEndpointsStatus != "blocked" {
+		t.Fatal("Expected endpoints blocking")
+	}
+	if !reflect.DeepEqual(tk.WhatsappEndpointsBlocked, []string{
+		"127.0.0.1", "blocked.example",
+	}) {
+		t.Fatalf("Unexpected blocked endpoints: %+v", tk.WhatsappEndpointsBlocked)
+	}
+	if len(tk.Queries) != 2 || tk.Queries[1].Failure == nil {
+		t.Fatal("Expected a DNS failure")
+	}
+	if tk.RegistrationServerStatus != "blocked" || tk.RegistrationServerFailure == nil {
+		t.Fatal("Expected registration server blocking")
+	}
+	if tk.WhatsappWebStatus != "blocked" || tk.WhatsappWebFailure == nil {
+		t.Fatal("Expected web blocking")
+	}
+}
+
+// TestDefaultEndpoints checks whether the default endpoints are sane.
+func TestDefaultEndpoints(t *testing.T) {
+	if len(DefaultEndpoints) != 32 {
+		t.Fatal("Unexpected number of default endpoints")
+	}
+	if DefaultEndpoints[0] != "e1.whatsapp.net:443" {
+		t.Fatal("Unexpected first default endpoint")
+	}
+}
+
+// TestNewNettest checks whether the nettest uses the config.
+func TestNewNettest(t *testing.T) {
+	withServer(t, func(endpoint string) {
+		nt := NewNettest(Config{
+			Endpoints:             []string{endpoint},
+			RegistrationServerURL: "http://" + endpoint + "/v2/register",
+			WebURLs:               []string{"http://" + endpoint + "/"},
+		})
+		measurement := nettesttest.Run(nt, "")
+		tk := measurement.TestKeys.(*TestKeys)
+		if tk.WhatsappEndpointsStatus != "ok" || tk.WhatsappWebStatus != "ok" {
+			t.Fatal("Unexpected result")
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/tlsx"
+)
+
+// conn is a net.Conn saving read and write events.
+type conn struct {
+	net.Conn
+	proto string
+	saver *Saver
+}
+
+// Read implements net.Conn.Read.
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.saver.event(NetworkEvent{
+		Address:   c.RemoteAddr().String(),
+		Failure:   errorx.FailureString(err),
+		NumBytes:  int64(n),
+		Operation: "read",
+		Proto:     c.proto,
+	})
+	return n, err
+}
+
+// Write implements net.Conn.Write.
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.saver.event(NetworkEvent{
+		Address:   c.RemoteAddr().String(),
+		Failure:   errorx.FailureString(err),
+		NumBytes:  int64(n),
+		Operation: "write",
+		Proto:     c.proto,
+	})
+	return n, err
+}
+
+// Dialer dials TCP, UDP, and TLS connections and saves what happens.
+type Dialer struct {
+	// Ctx is the context used by DialTLS. We need it because the
+	// http.Transport DialTLS function has no context.
+	Ctx context.Context
+
+	// NoTLSVerify disables the verification of TLS certificates.
+	NoTLSVerify bool
+
+	// Resolver is the resolver to use.
+	Resolver *Resolver
+
+	// Saver saves the trace.
+	Saver *Saver
+
+	// SNI overrides the SNI we send during TLS handshakes.
+	SNI string
+}
+
+// connect connects to the endpoint made of addr and port.
+func (d *Dialer) connect(
+	ctx context.Context, network, addr, port string,
+) (net.Conn, error) {
+	endpoint := net.JoinHostPort(addr, port)
+	dialer := net.Dialer{Timeout: connectTimeout}
+	c, err := dialer.DialContext(ctx, network, endpoint)
+	proto := strings.TrimRight(network, "46")
+	if proto == "tcp" {
+		entry := TCPConnect{
+			IP: addr,
+			Status: TCPConnectStatus{
+				Failure: errorx.FailureString(err),
+				Success: err == nil,
+			},
+			T: d.Saver.Elapsed(),
+		}
+		entry.Port, _ = strconv.Atoi(port)
+		d.Saver.save("netx.tcp_connect", entry, func(trace *Trace) {
+			trace.TCPConnect = append(trace.TCPConnect, entry)
+		})
+	}
+	d.Saver.event(NetworkEvent{
+		Address: endpoint, Failure: errorx.FailureString(err),
+		Operation: "connect", Proto: proto,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, proto: proto, saver: d.Saver}, nil
+}
+
+// DialContext resolves the hostname in address, if needed, and
+// connects to each resolved address until one connect succeeds.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	hostname, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs := []string{hostname}
+	if net.ParseIP(hostname) == nil {
+		addrs, err = d.Resolver.LookupHost(ctx, hostname)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, addr := range addrs {
+		var c net.Conn
+		c, err = d.connect(ctx, network, addr, port)
+		if err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+// saveHandshake saves the TLS handshake.
+func (d *Dialer) saveHandshake(state tls.ConnectionState, serverName string, err error) {
+	entry := TLSHandshake{
+		CipherSuite:        tlsx.CipherSuiteString(state.CipherSuite),
+		Failure:            errorx.FailureString(err),
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		NoTLSVerify:        d.NoTLSVerify,
+		ServerName:         serverName,
+		T:                  d.Saver.Elapsed(),
+		TLSVersion:         tlsx.VersionString(state.Version),
+	}
+	for _, cert := range state.PeerCertificates {
+		entry.PeerCertificates = append(entry.PeerCertificates, PeerCertificate{
+			Data: cert.Raw, Format: "base64",
+		})
+	}
+	d.Saver.save("netx.tls_handshake", entry, func(trace *Trace) {
+		trace.TLSHandshakes = append(trace.TLSHandshakes, entry)
+	})
+}
+
+// DialTLSContext dials a TLS connection with address. We use the SNI
+// override, if any, rather than the hostname in address as the SNI.
+func (d *Dialer) DialTLSContext(ctx context.Context, network, address string) (net.Conn, error) {
+	c, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	serverName := d.SNI
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(address)
+	}
+	tlsconn := tls.Client(c, &tls.Config{
+		InsecureSkipVerify: d.NoTLSVerify,
+		NextProtos:         []string{"http/1.1"},
+		ServerName:         serverName,
+	})
+	d.Saver.event(NetworkEvent{Address: address, Operation: "tls_handshake_start"})
+	c.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	err = tlsconn.Handshake()
+	c.SetDeadline(time.Time{})
+	d.Saver.event(NetworkEvent{
+		Address: address, Failure: errorx.FailureString(err),
+		Operation: "tls_handshake_done",
+	})
+	d.saveHandshake(tlsconn.ConnectionState(), serverName, err)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tlsconn, nil
+}
+
+// DialTLS is like DialTLSContext but uses d.Ctx as the context.
+func (d *Dialer) DialTLS(network, address string) (net.Conn, error) {
+	return d.DialTLSContext(d.Ctx, network, address)
+}
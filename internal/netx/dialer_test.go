@@ -0,0 +1,102 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newDialer creates a new dialer using the system resolver.
+func newDialer(t *testing.T, config Config) (*Dialer, *Trace) {
+	trace := &Trace{}
+	d, err := NewDialer(context.Background(), config, NewSaver(trace, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, trace
+}
+
+// TestDialContextFailures checks whether DialContext deals with errors.
+func TestDialContextFailures(t *testing.T) {
+	d, trace := newDialer(t, Config{})
+	if _, err := d.DialContext(context.Background(), "tcp", "antani"); err == nil {
+		t.Fatal("We expected an error with an invalid address")
+	}
+	if _, err := d.DialTLS("tcp", "antani"); err == nil {
+		t.Fatal("We expected an error with an invalid address")
+	}
+	if _, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:0"); err == nil {
+		t.Fatal("We expected an error with an invalid port")
+	}
+	if len(trace.TCPConnect) != 1 || trace.TCPConnect[0].Status.Success {
+		t.Fatal("Unexpected TCP connects")
+	}
+	if _, err := NewDialer(context.Background(), Config{ResolverURL: "\t"}, nil); err == nil {
+		t.Fatal("We expected an error with an invalid resolver")
+	}
+}
+
+// TestDialTLS checks whether we save the TLS handshake and the
+// reads and writes of the connection.
+func TestDialTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "https://")
+	d, trace := newDialer(t, Config{SNI: "example.com"})
+	if _, err := d.DialTLS("tcp", address); err == nil {
+		t.Fatal("We expected a certificate error")
+	}
+	d.NoTLSVerify = true
+	conn, err := d.DialTLS("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if len(trace.TLSHandshakes) != 2 || trace.TLSHandshakes[0].Failure == nil {
+		t.Fatal("Unexpected TLS handshakes")
+	}
+	handshake := trace.TLSHandshakes[1]
+	if handshake.Failure != nil || handshake.ServerName != "example.com" ||
+		!handshake.NoTLSVerify || len(handshake.PeerCertificates) != 1 {
+		t.Fatalf("Unexpected TLS handshake: %+v", handshake)
+	}
+	var reads, writes int
+	for _, ev := range trace.NetworkEvents {
+		switch ev.Operation {
+		case "read":
+			reads++
+		case "write":
+			writes++
+		}
+	}
+	if reads <= 0 || writes <= 0 {
+		t.Fatal("Missing read or write events")
+	}
+}
+
+// TestDialUDP checks whether we save UDP events without TCP connects.
+func TestDialUDP(t *testing.T) {
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pconn.Close()
+	d, trace := newDialer(t, Config{})
+	conn, err := d.DialContext(context.Background(), "udp", pconn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("antani")); err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.TCPConnect) != 0 || len(trace.NetworkEvents) != 2 {
+		t.Fatal("Unexpected trace")
+	}
+	if trace.NetworkEvents[1].Proto != "udp" || trace.NetworkEvents[1].NumBytes != 6 {
+		t.Fatalf("Unexpected network event: %+v", trace.NetworkEvents[1])
+	}
+}
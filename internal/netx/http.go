@@ -0,0 +1,128 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/measurement-kit/engine/internal/errorx"
+)
+
+// maxBodySize is the maximum number of body bytes we save.
+const maxBodySize = 1 << 24
+
+// flattenHeaders converts HTTP headers into a map.
+func flattenHeaders(header http.Header) map[string]string {
+	out := make(map[string]string)
+	for key, values := range header {
+		out[key] = strings.Join(values, ", ")
+	}
+	return out
+}
+
+// HTTPTransport is an http.RoundTripper using a Dialer and saving every
+// round trip. It reads the beginning of the request and response bodies,
+// such that we can save them, and replaces each body with a reader that
+// returns what it has read followed by the rest of the body. Hence, only
+// the copy we save is truncated, while the caller sees the full bodies.
+type HTTPTransport struct {
+	saver     *Saver
+	transport *http.Transport
+}
+
+// NewHTTPTransport creates a new HTTPTransport using config and saving
+// the trace using saver. We use ctx for dialing TLS connections.
+func NewHTTPTransport(
+	ctx context.Context, config Config, saver *Saver,
+) (*HTTPTransport, error) {
+	dialer, err := NewDialer(ctx, config, saver)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPTransport{
+		saver: saver,
+		transport: &http.Transport{
+			DialContext: dialer.DialContext,
+			DialTLS:     dialer.DialTLS,
+		},
+	}, nil
+}
+
+// readCloser combines a Reader with a Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// readBody reads at most maxBodySize bytes from body. It returns what it
+// has read, whether body is longer than that, and a replacement for body
+// that returns what we have read followed by the rest of body.
+func readBody(body io.ReadCloser) ([]byte, bool, io.ReadCloser, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil {
+		return nil, false, nil, err
+	}
+	replacement := readCloser{
+		Reader: io.MultiReader(bytes.NewReader(data), body),
+		Closer: body,
+	}
+	if len(data) > maxBodySize {
+		return data[:maxBodySize], true, replacement, nil
+	}
+	return data, false, replacement, nil
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip.
+func (t *HTTPTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := HTTPRoundTrip{
+		Request: HTTPRequest{
+			Headers: flattenHeaders(req.Header),
+			Method:  req.Method,
+			URL:     req.URL.String(),
+		},
+	}
+	if req.Host != "" {
+		rt.Request.Headers["Host"] = req.Host
+	}
+	defer func() {
+		rt.T = t.saver.Elapsed()
+		t.saver.save("netx.http_round_trip", rt, func(trace *Trace) {
+			trace.Requests = append(trace.Requests, rt)
+		})
+	}()
+	if req.Body != nil {
+		body, truncated, replacement, err := readBody(req.Body)
+		if err != nil {
+			req.Body.Close()
+			rt.Failure = errorx.FailureString(err)
+			return nil, err
+		}
+		rt.Request.Body, rt.Request.BodyIsTruncated = string(body), truncated
+		req = req.WithContext(req.Context()) // do not modify the caller's request
+		req.Body = replacement
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		rt.Failure = errorx.FailureString(err)
+		return nil, err
+	}
+	rt.Response.Code = int64(resp.StatusCode)
+	rt.Response.Headers = flattenHeaders(resp.Header)
+	body, truncated, replacement, err := readBody(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		rt.Failure = errorx.FailureString(err)
+		return nil, err
+	}
+	rt.Response.Body, rt.Response.BodyIsTruncated = string(body), truncated
+	resp.Body = replacement
+	return resp, nil
+}
+
+// CloseIdleConnections closes the idle connections.
+func (t *HTTPTransport) CloseIdleConnections() {
+	t.transport.CloseIdleConnections()
+}
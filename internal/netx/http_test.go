@@ -0,0 +1,130 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/measurement-kit/engine/model"
+)
+
+// TestHTTPTransport checks whether we save round trips and whether we
+// emit the corresponding events.
+func TestHTTPTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("antani"))
+		},
+	))
+	defer server.Close()
+	trace := &Trace{}
+	out := make(chan model.Event)
+	keys := make(chan map[string]int)
+	go func() {
+		m := make(map[string]int)
+		for ev := range out {
+			m[ev.Key]++
+		}
+		keys <- m
+	}()
+	saver := NewSaver(trace, out)
+	transport, err := NewHTTPTransport(context.Background(), Config{}, saver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	transport.CloseIdleConnections()
+	saver.Stop()
+	close(out)
+	if err != nil || string(body) != "antani" {
+		t.Fatal("Unexpected body")
+	}
+	if len(trace.Requests) != 1 || trace.Requests[0].Response.Body != "antani" {
+		t.Fatal("Unexpected requests")
+	}
+	if len(trace.TCPConnect) != 1 || !trace.TCPConnect[0].Status.Success {
+		t.Fatal("Unexpected TCP connects")
+	}
+	m := <-keys
+	if m["netx.http_round_trip"] != 1 || m["netx.tcp_connect"] != 1 ||
+		m["netx.network_event"] != len(trace.NetworkEvents) {
+		t.Fatalf("Unexpected events: %+v", m)
+	}
+}
+
+// TestHTTPTransportLargeBodies checks whether we only truncate the bodies
+// we save, while the server and the caller see the full bodies.
+func TestHTTPTransportLargeBodies(t *testing.T) {
+	large := bytes.Repeat([]byte("a"), maxBodySize+17)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if !bytes.Equal(body, large) {
+				w.WriteHeader(400)
+				return
+			}
+			w.Write(large)
+		},
+	))
+	defer server.Close()
+	trace := &Trace{}
+	transport, err := NewHTTPTransport(
+		context.Background(), Config{}, NewSaver(trace, nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	response, err := client.Post(server.URL, "text/plain", bytes.NewReader(large))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil || response.StatusCode != 200 || !bytes.Equal(body, large) {
+		t.Fatal("Unexpected response")
+	}
+	if len(trace.Requests) != 1 {
+		t.Fatal("Unexpected requests")
+	}
+	rt := trace.Requests[0]
+	if len(rt.Request.Body) != maxBodySize || !rt.Request.BodyIsTruncated {
+		t.Fatal("Unexpected saved request body")
+	}
+	if len(rt.Response.Body) != maxBodySize || !rt.Response.BodyIsTruncated {
+		t.Fatal("Unexpected saved response body")
+	}
+}
+
+// TestHTTPTransportFailure checks whether we save failures.
+func TestHTTPTransportFailure(t *testing.T) {
+	trace := &Trace{}
+	transport, err := NewHTTPTransport(
+		context.Background(), Config{}, NewSaver(trace, nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+	if _, err := client.Get("http://127.0.0.1:0/"); err == nil {
+		t.Fatal("We expected an error here")
+	}
+	if len(trace.Requests) != 1 || trace.Requests[0].Failure == nil {
+		t.Fatal("Unexpected requests")
+	}
+	if _, err := NewHTTPTransport(context.Background(), Config{
+		ResolverURL: "\t",
+	}, nil); err != ErrInvalidResolver {
+		t.Fatal("We expected ErrInvalidResolver")
+	}
+}
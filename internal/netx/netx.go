@@ -0,0 +1,58 @@
+// Package netx contains network extensions.
+//
+// We use this package to perform measurement-grade network operations:
+// the Resolver, the Dialer, and the HTTPTransport record every DNS query,
+// TCP connect, TLS handshake, read, write, and HTTP round trip using the
+// OONI data formats: the DNS queries (df-002), the TCP connects (df-005),
+// the TLS handshakes (df-006), the HTTP round trips (df-001), and the
+// network events (df-008). A Saver collects such trace into a Trace,
+// which nettests embed into their test keys, and emits each entry as
+// a model.Event, such that apps can follow the measurement.
+package netx
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// connectTimeout is the timeout for each TCP connect.
+const connectTimeout = 10 * time.Second
+
+// queryTimeout is the timeout for each DNS query.
+const queryTimeout = 10 * time.Second
+
+// tlsHandshakeTimeout is the timeout for each TLS handshake.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// ErrInvalidResolver indicates that the resolver URL is not valid.
+var ErrInvalidResolver = errors.New("resolver URL is not valid")
+
+// Config contains the settings shared by the Dialer and the HTTPTransport.
+type Config struct {
+	// NoTLSVerify disables the verification of TLS certificates.
+	NoTLSVerify bool
+
+	// ResolverURL is the resolver to use. See NewResolver.
+	ResolverURL string
+
+	// SNI overrides the SNI we send during TLS handshakes.
+	SNI string
+}
+
+// NewDialer creates a new Dialer using config and saving the trace
+// using saver. We use ctx for dialing TLS connections, since the
+// http.Transport DialTLS function has no context.
+func NewDialer(ctx context.Context, config Config, saver *Saver) (*Dialer, error) {
+	resolver, err := NewResolver(config.ResolverURL, saver)
+	if err != nil {
+		return nil, err
+	}
+	return &Dialer{
+		Ctx:         ctx,
+		NoTLSVerify: config.NoTLSVerify,
+		Resolver:    resolver,
+		Saver:       saver,
+		SNI:         config.SNI,
+	}, nil
+}
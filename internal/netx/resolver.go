@@ -0,0 +1,114 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/measurement-kit/engine/internal/dnsx"
+	"github.com/measurement-kit/engine/internal/errorx"
+	"github.com/measurement-kit/engine/internal/resolver"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// lookupHost allows to mock the system resolver in tests.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// newAnswers converts the resolved addresses into answers.
+func newAnswers(addrs []string) []DNSAnswer {
+	var answers []DNSAnswer
+	for _, addr := range addrs {
+		if strings.Contains(addr, ":") {
+			answers = append(answers, DNSAnswer{AnswerType: "AAAA", IPv6: addr})
+		} else {
+			answers = append(answers, DNSAnswer{AnswerType: "A", IPv4: addr})
+		}
+	}
+	return answers
+}
+
+// Resolver resolves hostnames and saves the queries.
+type Resolver struct {
+	address string
+	engine  string
+	saver   *Saver
+}
+
+// NewResolver creates a resolver from URL, which is parsed using
+// resolver.Parse. We only support the system resolver, DNS over UDP,
+// and DNS over TCP, e.g., "", "udp://8.8.8.8", or "tcp://8.8.8.8:53".
+func NewResolver(URL string, saver *Saver) (*Resolver, error) {
+	epnt, err := resolver.Parse(URL)
+	if err != nil {
+		return nil, ErrInvalidResolver
+	}
+	switch epnt.Engine {
+	case "system", "udp", "tcp":
+		return &Resolver{
+			address: epnt.Address, engine: epnt.Engine, saver: saver,
+		}, nil
+	}
+	return nil, ErrInvalidResolver
+}
+
+// query sends a qtype query for hostname using the resolver address.
+func (r *Resolver) query(
+	ctx context.Context, hostname string, qtype dnsmessage.Type,
+) ([]string, error) {
+	query, err := dnsx.NewQuery(hostname, qtype)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	exchange := dnsx.ExchangeUDP
+	if r.engine == "tcp" {
+		exchange = dnsx.ExchangeTCP
+	}
+	reply, err := exchange(ctx, r.address, query)
+	if err != nil {
+		return nil, err
+	}
+	return dnsx.ParseReply(query, reply)
+}
+
+// save saves a query and its results.
+func (r *Resolver) save(hostname, qtype string, addrs []string, err error) {
+	query := DNSQuery{
+		Answers:         newAnswers(addrs),
+		Engine:          r.engine,
+		Failure:         errorx.FailureString(err),
+		Hostname:        hostname,
+		QueryType:       qtype,
+		ResolverAddress: r.address,
+		T:               r.saver.Elapsed(),
+	}
+	r.saver.save("netx.dns_query", query, func(trace *Trace) {
+		trace.Queries = append(trace.Queries, query)
+	})
+}
+
+// LookupHost resolves hostname. When not using the system resolver, we
+// send both A and AAAA queries and fail only if both of them fail.
+func (r *Resolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	r.saver.event(NetworkEvent{Address: hostname, Operation: "resolve_start"})
+	var addrs []string
+	var err error
+	if r.engine == "system" {
+		addrs, err = lookupHost(ctx, hostname)
+		r.save(hostname, "A", addrs, err)
+	} else {
+		ipv4, errA := r.query(ctx, hostname, dnsmessage.TypeA)
+		r.save(hostname, "A", ipv4, errA)
+		ipv6, errAAAA := r.query(ctx, hostname, dnsmessage.TypeAAAA)
+		r.save(hostname, "AAAA", ipv6, errAAAA)
+		addrs, err = append(ipv4, ipv6...), errA
+		if len(addrs) > 0 {
+			err = nil
+		}
+	}
+	r.saver.event(NetworkEvent{
+		Address: hostname, Failure: errorx.FailureString(err), Operation: "resolve_done",
+	})
+	return addrs, err
+}
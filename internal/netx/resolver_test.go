@@ -0,0 +1,90 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/measurement-kit/engine/internal/dnsx/dnstest"
+	"github.com/measurement-kit/engine/internal/errorx"
+)
+
+// TestNewResolver checks whether we parse resolver URLs.
+func TestNewResolver(t *testing.T) {
+	valid := map[string]string{
+		"":                 "system",
+		"system:///":       "system",
+		"udp://1.1.1.1":    "udp",
+		"udp://1.1.1.1:53": "udp",
+		"tcp://1.1.1.1:53": "tcp",
+	}
+	for URL, engine := range valid {
+		r, err := NewResolver(URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.engine != engine {
+			t.Fatalf("Unexpected engine for %q", URL)
+		}
+	}
+	invalid := []string{
+		"\t", "https://1.1.1.1/", "dot://1.1.1.1", "tcp://1.1.1.1:53/antani",
+	}
+	for _, URL := range invalid {
+		if _, err := NewResolver(URL, nil); err != ErrInvalidResolver {
+			t.Fatalf("Expected ErrInvalidResolver for %q", URL)
+		}
+	}
+}
+
+// TestResolverTCP checks whether we can resolve using TCP.
+func TestResolverTCP(t *testing.T) {
+	server, err := dnstest.NewTCPServer(map[string][]string{
+		"www.example.com": {"127.0.0.1", "::1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	trace := &Trace{}
+	r, err := NewResolver("tcp://"+server.Addr, NewSaver(trace, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := r.LookupHost(context.Background(), "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || addrs[0] != "127.0.0.1" || addrs[1] != "::1" {
+		t.Fatalf("Unexpected addrs: %+v", addrs)
+	}
+	if len(trace.Queries) != 2 || trace.Queries[1].Answers[0].AnswerType != "AAAA" {
+		t.Fatal("Unexpected queries")
+	}
+	_, err = r.LookupHost(context.Background(), "antani.example.com")
+	if err == nil {
+		t.Fatal("We expected an error here")
+	}
+}
+
+// TestResolverSystemFailure checks whether we save system failures.
+func TestResolverSystemFailure(t *testing.T) {
+	savedFunc := lookupHost
+	mockedError := errors.New("mocked error")
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return nil, mockedError
+	}
+	defer func() { lookupHost = savedFunc }()
+	trace := &Trace{}
+	r, _ := NewResolver("", NewSaver(trace, nil))
+	_, err := r.LookupHost(context.Background(), "www.example.com")
+	if err != mockedError {
+		t.Fatal("Not the error we expected")
+	}
+	if len(trace.Queries) != 1 || *trace.Queries[0].Failure != errorx.Classify(mockedError) {
+		t.Fatal("Unexpected queries")
+	}
+	if len(trace.NetworkEvents) != 2 || trace.NetworkEvents[1].Failure == nil {
+		t.Fatal("Unexpected network events")
+	}
+}
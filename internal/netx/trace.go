@@ -0,0 +1,256 @@
+package netx
+
+import (
+	"sync"
+	"time"
+
+	"github.com/measurement-kit/engine/model"
+)
+
+// DNSAnswer is an answer to a DNS query.
+type DNSAnswer struct {
+	// AnswerType is either "A" or "AAAA".
+	AnswerType string `json:"answer_type"`
+
+	// IPv4 is the IPv4 address for "A" answers.
+	IPv4 string `json:"ipv4,omitempty"`
+
+	// IPv6 is the IPv6 address for "AAAA" answers.
+	IPv6 string `json:"ipv6,omitempty"`
+}
+
+// DNSQuery is a DNS query and its answers.
+type DNSQuery struct {
+	// Answers contains the answers.
+	Answers []DNSAnswer `json:"answers"`
+
+	// Engine is the resolver engine, i.e., "system", "udp", or "tcp".
+	Engine string `json:"engine"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Hostname is the hostname we resolved.
+	Hostname string `json:"hostname"`
+
+	// QueryType is the query type. The system resolver returns both
+	// IPv4 and IPv6 addresses for "A" queries.
+	QueryType string `json:"query_type"`
+
+	// ResolverAddress is the resolver address, if known.
+	ResolverAddress string `json:"resolver_address"`
+
+	// T is the number of seconds since the beginning of the measurement.
+	T float64 `json:"t"`
+}
+
+// TCPConnectStatus is the status of a TCP connect.
+type TCPConnectStatus struct {
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Success indicates whether we could connect.
+	Success bool `json:"success"`
+}
+
+// TCPConnect is a TCP connect to an endpoint.
+type TCPConnect struct {
+	// IP is the IP address we connected to.
+	IP string `json:"ip"`
+
+	// Port is the port we connected to.
+	Port int `json:"port"`
+
+	// Status is the connect status.
+	Status TCPConnectStatus `json:"status"`
+
+	// T is the number of seconds since the beginning of the measurement.
+	T float64 `json:"t"`
+}
+
+// PeerCertificate is a certificate sent by the TLS peer.
+type PeerCertificate struct {
+	// Data is the DER encoded certificate, serialized as base64.
+	Data []byte `json:"data"`
+
+	// Format is always "base64".
+	Format string `json:"format"`
+}
+
+// TLSHandshake is a TLS handshake.
+type TLSHandshake struct {
+	// CipherSuite is the negotiated cipher suite.
+	CipherSuite string `json:"cipher_suite"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// NegotiatedProtocol is the protocol negotiated using ALPN.
+	NegotiatedProtocol string `json:"negotiated_protocol"`
+
+	// NoTLSVerify indicates whether we did not verify the certificate.
+	NoTLSVerify bool `json:"no_tls_verify"`
+
+	// PeerCertificates contains the certificates sent by the peer.
+	PeerCertificates []PeerCertificate `json:"peer_certificates"`
+
+	// ServerName is the SNI we sent.
+	ServerName string `json:"server_name"`
+
+	// T is the number of seconds since the beginning of the measurement.
+	T float64 `json:"t"`
+
+	// TLSVersion is the negotiated TLS version.
+	TLSVersion string `json:"tls_version"`
+}
+
+// HTTPRequest is an HTTP request.
+type HTTPRequest struct {
+	// Body is the request body.
+	Body string `json:"body"`
+
+	// BodyIsTruncated indicates whether we truncated the body.
+	BodyIsTruncated bool `json:"body_is_truncated"`
+
+	// Headers contains the request headers.
+	Headers map[string]string `json:"headers"`
+
+	// Method is the request method.
+	Method string `json:"method"`
+
+	// URL is the request URL.
+	URL string `json:"url"`
+}
+
+// HTTPResponse is an HTTP response.
+type HTTPResponse struct {
+	// Body is the response body.
+	Body string `json:"body"`
+
+	// BodyIsTruncated indicates whether we truncated the body.
+	BodyIsTruncated bool `json:"body_is_truncated"`
+
+	// Code is the status code.
+	Code int64 `json:"code"`
+
+	// Headers contains the response headers.
+	Headers map[string]string `json:"headers"`
+}
+
+// HTTPRoundTrip is an HTTP request and the corresponding response.
+type HTTPRoundTrip struct {
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// Request is the request.
+	Request HTTPRequest `json:"request"`
+
+	// Response is the response.
+	Response HTTPResponse `json:"response"`
+
+	// T is the number of seconds since the beginning of the measurement.
+	T float64 `json:"t"`
+}
+
+// NetworkEvent is a low level network event.
+type NetworkEvent struct {
+	// Address is the remote address, if any.
+	Address string `json:"address,omitempty"`
+
+	// Failure is the error that occurred, if any.
+	Failure *string `json:"failure"`
+
+	// NumBytes is the number of bytes read or written, if any.
+	NumBytes int64 `json:"num_bytes,omitempty"`
+
+	// Operation is one of "resolve_start", "resolve_done", "connect",
+	// "tls_handshake_start", "tls_handshake_done", "read", and "write".
+	Operation string `json:"operation"`
+
+	// Proto is the protocol, if any, e.g., "tcp".
+	Proto string `json:"proto,omitempty"`
+
+	// T is the number of seconds since the beginning of the measurement.
+	T float64 `json:"t"`
+}
+
+// Trace contains the trace of a measurement. Nettests usually embed it
+// into their test keys, such that its fields are archived at top level.
+type Trace struct {
+	// NetworkEvents contains the network events.
+	NetworkEvents []NetworkEvent `json:"network_events"`
+
+	// Queries contains the DNS queries.
+	Queries []DNSQuery `json:"queries"`
+
+	// Requests contains the HTTP round trips.
+	Requests []HTTPRoundTrip `json:"requests"`
+
+	// TCPConnect contains the TCP connects.
+	TCPConnect []TCPConnect `json:"tcp_connect"`
+
+	// TLSHandshakes contains the TLS handshakes.
+	TLSHandshakes []TLSHandshake `json:"tls_handshakes"`
+}
+
+// Saver saves the trace of a measurement and emits each entry we save
+// as a "netx.<kind>" event, e.g., "netx.dns_query". It is safe to use
+// it from concurrent goroutines.
+type Saver struct {
+	begin   time.Time
+	mu      sync.Mutex
+	out     chan<- model.Event
+	pending sync.WaitGroup
+	trace   *Trace
+}
+
+// NewSaver creates a new saver filling trace and emitting events on
+// out, which may be nil if you are not interested in events.
+func NewSaver(trace *Trace, out chan<- model.Event) *Saver {
+	return &Saver{begin: time.Now(), out: out, trace: trace}
+}
+
+// Elapsed returns the seconds since the beginning of the measurement.
+func (s *Saver) Elapsed() float64 {
+	return time.Now().Sub(s.begin).Seconds()
+}
+
+// save calls fn with the trace while holding the mutex and then emits
+// the key event with value. We do not hold the mutex while emitting,
+// such that a slow reader does not block the other goroutines. We do
+// nothing after Stop has been called.
+func (s *Saver) save(key string, value interface{}, fn func(trace *Trace)) {
+	s.mu.Lock()
+	if s.trace == nil {
+		s.mu.Unlock()
+		return
+	}
+	fn(s.trace)
+	if s.out == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.pending.Add(1)
+	s.mu.Unlock()
+	s.out <- model.Event{Key: key, Value: value}
+	s.pending.Done()
+}
+
+// Stop stops saving and waits for the pending events to be emitted. You
+// must call Stop before closing the channel passed to NewSaver, because
+// the HTTP transport may still be reading from its connections in
+// background after the measurement is complete.
+func (s *Saver) Stop() {
+	s.mu.Lock()
+	s.trace = nil
+	s.mu.Unlock()
+	s.pending.Wait()
+}
+
+// event saves a network event.
+func (s *Saver) event(ev NetworkEvent) {
+	ev.T = s.Elapsed()
+	s.save("netx.network_event", ev, func(trace *Trace) {
+		trace.NetworkEvents = append(trace.NetworkEvents, ev)
+	})
+}
@@ -0,0 +1,67 @@
+package netx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/measurement-kit/engine/model"
+)
+
+// TestSaverStop checks whether we do not save after stop.
+func TestSaverStop(t *testing.T) {
+	trace := &Trace{}
+	s := NewSaver(trace, nil)
+	s.event(NetworkEvent{Operation: "read"})
+	s.Stop()
+	s.event(NetworkEvent{Operation: "write"})
+	if len(trace.NetworkEvents) != 1 || trace.NetworkEvents[0].Operation != "read" {
+		t.Fatal("Unexpected network events")
+	}
+	if trace.NetworkEvents[0].T <= 0 {
+		t.Fatal("Unexpected network event time")
+	}
+}
+
+// TestSaverEvents checks whether we emit what we save.
+func TestSaverEvents(t *testing.T) {
+	out := make(chan model.Event, 1)
+	s := NewSaver(&Trace{}, out)
+	s.event(NetworkEvent{Operation: "read"})
+	ev := <-out
+	if ev.Key != "netx.network_event" || ev.Value.(NetworkEvent).Operation != "read" {
+		t.Fatalf("Unexpected event: %+v", ev)
+	}
+}
+
+// TestSaverSlowReader checks whether a slow reader does not prevent
+// other goroutines from saving and whether Stop waits for the events
+// that are still pending.
+func TestSaverSlowReader(t *testing.T) {
+	out := make(chan model.Event)
+	trace := &Trace{}
+	s := NewSaver(trace, out)
+	go s.event(NetworkEvent{Operation: "read"})
+	go s.event(NetworkEvent{Operation: "write"})
+	for {
+		s.mu.Lock()
+		count := len(trace.NetworkEvents)
+		s.mu.Unlock()
+		if count == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-stopped:
+			t.Fatal("Stop returned with pending events")
+		case <-out:
+		}
+	}
+	<-stopped
+}
@@ -0,0 +1,261 @@
+// Package resolver contains the DNS resolvers used by the engine to
+// resolve the names of the OONI backends.
+//
+// Besides the system resolver, we implement resolvers using DNS over
+// UDP, DNS over TCP, DNS over TLS (RFC7858), and DNS over HTTPS (RFC8484),
+// such that we can keep talking with the backends when the system
+// resolver is censored or otherwise misbehaving.
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/dnsx"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// queryTimeout is the timeout for each DNS query.
+var queryTimeout = 10 * time.Second
+
+// ErrInvalidURL indicates that the resolver URL is not valid.
+var ErrInvalidURL = errors.New("resolver URL is not valid")
+
+// Resolver resolves hostnames.
+type Resolver interface {
+	// LookupHost returns the IPv4 and IPv6 addresses of hostname.
+	LookupHost(ctx context.Context, hostname string) ([]string, error)
+}
+
+// exchangeFunc sends a raw DNS query and returns the raw reply.
+type exchangeFunc = func(ctx context.Context, query []byte) ([]byte, error)
+
+// query sends a qtype query for hostname using exchange.
+func query(
+	ctx context.Context, exchange exchangeFunc,
+	hostname string, qtype dnsmessage.Type,
+) ([]string, error) {
+	query, err := dnsx.NewQuery(hostname, qtype)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	reply, err := exchange(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return dnsx.ParseReply(query, reply)
+}
+
+// lookupHost sends both A and AAAA queries for hostname using exchange
+// and fails only if both of them fail. If hostname is already an IP
+// address, we return it without sending any query.
+func lookupHost(
+	ctx context.Context, exchange exchangeFunc, hostname string,
+) ([]string, error) {
+	if net.ParseIP(hostname) != nil {
+		return []string{hostname}, nil
+	}
+	ipv4, errA := query(ctx, exchange, hostname, dnsmessage.TypeA)
+	ipv6, errAAAA := query(ctx, exchange, hostname, dnsmessage.TypeAAAA)
+	if addrs := append(ipv4, ipv6...); len(addrs) > 0 {
+		return addrs, nil
+	}
+	if errA != nil {
+		return nil, errA
+	}
+	return nil, errAAAA
+}
+
+// System is the system resolver.
+type System struct{}
+
+// systemLookupHost allows to mock the system resolver in tests.
+var systemLookupHost = net.DefaultResolver.LookupHost
+
+// LookupHost implements Resolver.LookupHost.
+func (System) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	return systemLookupHost(ctx, hostname)
+}
+
+// UDP is a DNS over UDP resolver.
+type UDP struct {
+	// Address is the resolver endpoint (e.g. `8.8.8.8:53`).
+	Address string
+}
+
+// LookupHost implements Resolver.LookupHost.
+func (r UDP) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	return lookupHost(ctx, func(ctx context.Context, query []byte) ([]byte, error) {
+		return dnsx.ExchangeUDP(ctx, r.Address, query)
+	}, hostname)
+}
+
+// TCP is a DNS over TCP resolver.
+type TCP struct {
+	// Address is the resolver endpoint (e.g. `8.8.8.8:53`).
+	Address string
+}
+
+// LookupHost implements Resolver.LookupHost.
+func (r TCP) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	return lookupHost(ctx, func(ctx context.Context, query []byte) ([]byte, error) {
+		return dnsx.ExchangeTCP(ctx, r.Address, query)
+	}, hostname)
+}
+
+// TLS is a DNS over TLS resolver.
+type TLS struct {
+	// Address is the resolver endpoint (e.g. `1.1.1.1:853`).
+	Address string
+
+	// Config is the optional TLS config. When nil, we use the host
+	// in Address as the SNI and the system certificate roots.
+	Config *tls.Config
+}
+
+// LookupHost implements Resolver.LookupHost.
+func (r TLS) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	return lookupHost(ctx, func(ctx context.Context, query []byte) ([]byte, error) {
+		return dnsx.ExchangeTLS(ctx, r.Address, r.Config, query)
+	}, hostname)
+}
+
+// ErrNotIPAddress indicates that the host in the resolver URL is not
+// an IP address, hence we would need to resolve it.
+var ErrNotIPAddress = errors.New("resolver URL host is not an IP address")
+
+// httpsClient is the default client of HTTPS. It dials the host in the
+// URL only when it is an IP address, such that we never fall back to
+// the system resolver, which may be censored. We use a single client for
+// all the resolvers, such that we do not leak idle connections.
+var httpsClient = &http.Client{Transport: &http.Transport{
+	DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) == nil {
+			return nil, ErrNotIPAddress
+		}
+		dialer := net.Dialer{}
+		return dialer.DialContext(ctx, network, address)
+	},
+	TLSHandshakeTimeout: queryTimeout,
+}}
+
+// HTTPS is a DNS over HTTPS resolver.
+type HTTPS struct {
+	// Client is the optional HTTP client. When nil, we use a dedicated
+	// client that requires the host in URL to be an IP address.
+	Client *http.Client
+
+	// URL is the resolver URL (e.g. `https://1.1.1.1/dns-query`).
+	URL string
+}
+
+// LookupHost implements Resolver.LookupHost.
+func (r HTTPS) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	client := r.Client
+	if client == nil {
+		client = httpsClient
+	}
+	return lookupHost(ctx, func(ctx context.Context, query []byte) ([]byte, error) {
+		return dnsx.ExchangeHTTPS(ctx, client, r.URL, query)
+	}, hostname)
+}
+
+// Endpoint is a parsed resolver URL.
+type Endpoint struct {
+	// Engine is one of "system", "udp", "tcp", "dot", and "doh".
+	Engine string
+
+	// Address is the "host:port" endpoint of the resolver. It is
+	// empty when using the system resolver.
+	Address string
+
+	// URL is the original URL.
+	URL string
+}
+
+// endpoint returns the host in parsed using defaultPort when parsed
+// does not contain any port.
+func endpoint(parsed *url.URL, defaultPort string) string {
+	if parsed.Port() != "" {
+		return parsed.Host
+	}
+	return net.JoinHostPort(parsed.Hostname(), defaultPort)
+}
+
+// Parse parses a resolver URL. Use an empty URL or "system:///" for the
+// system resolver, "udp://host[:port]" for DNS over UDP, "tcp://host[:port]"
+// for DNS over TCP, "dot://host[:port]" (or "tls://host[:port]") for DNS
+// over TLS, and the URL of the server (e.g. "https://1.1.1.1/dns-query")
+// for DNS over HTTPS. This is the only parser of resolver URLs, such that
+// all the packages accept the same URLs.
+func Parse(URL string) (Endpoint, error) {
+	if URL == "" {
+		return Endpoint{Engine: "system"}, nil
+	}
+	parsed, err := url.Parse(URL)
+	if err != nil {
+		return Endpoint{}, ErrInvalidURL
+	}
+	if parsed.Scheme == "system" {
+		return Endpoint{Engine: "system", URL: URL}, nil
+	}
+	if parsed.Hostname() == "" {
+		return Endpoint{}, ErrInvalidURL
+	}
+	if parsed.Scheme == "https" {
+		return Endpoint{
+			Engine: "doh", Address: endpoint(parsed, "443"), URL: URL,
+		}, nil
+	}
+	if parsed.Path != "" {
+		return Endpoint{}, ErrInvalidURL
+	}
+	switch parsed.Scheme {
+	case "udp", "tcp":
+		return Endpoint{
+			Engine: parsed.Scheme, Address: endpoint(parsed, "53"), URL: URL,
+		}, nil
+	case "dot", "tls":
+		return Endpoint{
+			Engine: "dot", Address: endpoint(parsed, "853"), URL: URL,
+		}, nil
+	}
+	return Endpoint{}, ErrInvalidURL
+}
+
+// New creates a resolver from URL, which is parsed using Parse. Except
+// for the system resolver, the host in URL must be an IP address, such
+// that we do not depend on the system resolver to reach the server.
+func New(URL string) (Resolver, error) {
+	epnt, err := Parse(URL)
+	if err != nil {
+		return nil, err
+	}
+	if epnt.Engine == "system" {
+		return System{}, nil
+	}
+	host, _, _ := net.SplitHostPort(epnt.Address)
+	if net.ParseIP(host) == nil {
+		return nil, ErrNotIPAddress
+	}
+	switch epnt.Engine {
+	case "udp":
+		return UDP{Address: epnt.Address}, nil
+	case "tcp":
+		return TCP{Address: epnt.Address}, nil
+	case "dot":
+		return TLS{Address: epnt.Address}, nil
+	}
+	return HTTPS{URL: epnt.URL}, nil
+}
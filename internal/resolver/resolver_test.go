@@ -0,0 +1,200 @@
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/measurement-kit/engine/internal/dnsx"
+	"github.com/measurement-kit/engine/internal/dnsx/dnstest"
+)
+
+var records = map[string][]string{
+	"example.com":      {"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"},
+	"ipv4.example.com": {"93.184.216.34"},
+}
+
+// newCertPool returns a pool containing the server certificate.
+func newCertPool(server *dnstest.Server) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate)
+	return pool
+}
+
+// check checks whether r resolves names correctly.
+func check(t *testing.T, r Resolver) {
+	ctx := context.Background()
+	addrs, err := r.LookupHost(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || addrs[0] != "93.184.216.34" ||
+		addrs[1] != "2606:2800:220:1:248:1893:25c8:1946" {
+		t.Fatalf("Unexpected addrs: %+v", addrs)
+	}
+	addrs, err = r.LookupHost(ctx, "ipv4.example.com")
+	if err != nil || len(addrs) != 1 || addrs[0] != "93.184.216.34" {
+		t.Fatalf("Unexpected IPv4 only result: %+v %+v", addrs, err)
+	}
+	if _, err = r.LookupHost(ctx, "antani.example.com"); err != dnsx.ErrNXDOMAIN {
+		t.Fatal("We expected NXDOMAIN")
+	}
+	addrs, err = r.LookupHost(ctx, "127.0.0.1")
+	if err != nil || len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+		t.Fatal("We expected the IP address to be returned as is")
+	}
+}
+
+// TestUDP checks whether the DNS over UDP resolver works.
+func TestUDP(t *testing.T) {
+	server, err := dnstest.NewUDPServer(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	check(t, UDP{Address: server.Addr})
+}
+
+// TestTCP checks whether the DNS over TCP resolver works.
+func TestTCP(t *testing.T) {
+	server, err := dnstest.NewTCPServer(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	check(t, TCP{Address: server.Addr})
+}
+
+// TestTLS checks whether the DNS over TLS resolver works.
+func TestTLS(t *testing.T) {
+	server, err := dnstest.NewTLSServer(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	check(t, TLS{Address: server.Addr, Config: &tls.Config{
+		RootCAs: newCertPool(server), ServerName: "127.0.0.1",
+	}})
+	r := TLS{Address: server.Addr}
+	if _, err := r.LookupHost(context.Background(), "example.com"); err == nil {
+		t.Fatal("We expected a certificate error")
+	}
+}
+
+// TestHTTPS checks whether the DNS over HTTPS resolver works.
+func TestHTTPS(t *testing.T) {
+	server, err := dnstest.NewHTTPSServer(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	check(t, HTTPS{
+		Client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: newCertPool(server)},
+		}},
+		URL: server.URL,
+	})
+	r := HTTPS{URL: server.URL}
+	if _, err := r.LookupHost(context.Background(), "example.com"); err == nil {
+		t.Fatal("We expected a certificate error")
+	}
+	r = HTTPS{URL: "https://dns.google/dns-query"}
+	_, err = r.LookupHost(context.Background(), "example.com")
+	if uerr, ok := err.(*url.Error); !ok || uerr.Err != ErrNotIPAddress {
+		t.Fatalf("We expected ErrNotIPAddress, got: %+v", err)
+	}
+}
+
+// TestSystem checks whether the system resolver uses the
+// standard library resolver.
+func TestSystem(t *testing.T) {
+	savedFunc := systemLookupHost
+	defer func() {
+		systemLookupHost = savedFunc
+	}()
+	expected := errors.New("mocked error")
+	systemLookupHost = func(ctx context.Context, hostname string) ([]string, error) {
+		return nil, expected
+	}
+	if _, err := (System{}).LookupHost(context.Background(), "example.com"); err != expected {
+		t.Fatal("Not the error we expected")
+	}
+}
+
+// TestLookupHostInvalidName checks whether we deal with
+// names for which we cannot create a query.
+func TestLookupHostInvalidName(t *testing.T) {
+	r := UDP{Address: "127.0.0.1:0"}
+	if _, err := r.LookupHost(context.Background(), "antani..com"); err == nil {
+		t.Fatal("We expected an error here")
+	}
+}
+
+// TestNew checks whether New creates the expected resolvers.
+func TestNew(t *testing.T) {
+	var table = []struct {
+		URL      string
+		Resolver Resolver
+	}{
+		{"", System{}},
+		{"system:///", System{}},
+		{"udp://8.8.8.8", UDP{Address: "8.8.8.8:53"}},
+		{"udp://8.8.8.8:5353", UDP{Address: "8.8.8.8:5353"}},
+		{"tcp://[2001:4860:4860::8888]", TCP{Address: "[2001:4860:4860::8888]:53"}},
+		{"dot://1.1.1.1", TLS{Address: "1.1.1.1:853"}},
+		{"dot://1.1.1.1:8853", TLS{Address: "1.1.1.1:8853"}},
+		{"https://1.1.1.1/dns-query", HTTPS{URL: "https://1.1.1.1/dns-query"}},
+	}
+	for _, entry := range table {
+		r, err := New(entry.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r != entry.Resolver {
+			t.Fatalf("Unexpected resolver for %s: %+v", entry.URL, r)
+		}
+	}
+	for _, URL := range []string{
+		"\t", "udp://", "udp://8.8.8.8/antani", "antani://8.8.8.8", "https:///dns-query",
+	} {
+		if _, err := New(URL); err != ErrInvalidURL {
+			t.Fatalf("We expected ErrInvalidURL for %s", URL)
+		}
+	}
+	for _, URL := range []string{
+		"udp://dns.google", "tcp://dns.google", "dot://dns.google",
+		"https://dns.google/dns-query",
+	} {
+		if _, err := New(URL); err != ErrNotIPAddress {
+			t.Fatalf("We expected ErrNotIPAddress for %s", URL)
+		}
+	}
+}
+
+// TestParse checks whether Parse returns the expected endpoints.
+func TestParse(t *testing.T) {
+	var table = []struct {
+		URL      string
+		Endpoint Endpoint
+	}{
+		{"", Endpoint{Engine: "system"}},
+		{"udp://[::1]", Endpoint{Engine: "udp", Address: "[::1]:53", URL: "udp://[::1]"}},
+		{"tls://dns.google", Endpoint{Engine: "dot", Address: "dns.google:853", URL: "tls://dns.google"}},
+		{"https://dns.google/dns-query", Endpoint{
+			Engine: "doh", Address: "dns.google:443", URL: "https://dns.google/dns-query",
+		}},
+	}
+	for _, entry := range table {
+		epnt, err := Parse(entry.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if epnt != entry.Endpoint {
+			t.Fatalf("Unexpected endpoint for %s: %+v", entry.URL, epnt)
+		}
+	}
+}
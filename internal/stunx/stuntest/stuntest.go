@@ -0,0 +1,76 @@
+// Package stuntest implements a STUN server for testing.
+package stuntest
+
+import (
+	"net"
+	"sync"
+
+	"github.com/measurement-kit/engine/internal/stunx"
+)
+
+// Reply creates the reply to the binding request sent from addr.
+func Reply(request []byte, addr *net.UDPAddr) ([]byte, error) {
+	message, err := stunx.Unpack(request)
+	if err != nil {
+		return nil, err
+	}
+	if message.Type != stunx.TypeBindingRequest {
+		return nil, stunx.ErrInvalidMessage
+	}
+	response := &stunx.Message{
+		Attributes: map[uint16][]byte{
+			stunx.AttrXORMappedAddress: stunx.EncodeXORMappedAddress(
+				addr, message.TransactionID,
+			),
+		},
+		TransactionID: message.TransactionID,
+		Type:          stunx.TypeBindingSuccess,
+	}
+	return response.Pack(), nil
+}
+
+// Server is a STUN server listening on the loopback interface.
+type Server struct {
+	// Addr is the address where the server is listening.
+	Addr string
+
+	conn net.PacketConn
+	wg   sync.WaitGroup
+}
+
+// NewServer starts a STUN server that ignores the first drop binding
+// requests it receives, which allows to test retransmissions.
+func NewServer(drop int) (*Server, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{Addr: conn.LocalAddr().String(), conn: conn}
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		buffer := make([]byte, 1<<12)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if drop > 0 {
+				drop--
+				continue
+			}
+			reply, err := Reply(buffer[:n], addr.(*net.UDPAddr))
+			if err == nil {
+				conn.WriteTo(reply, addr)
+			}
+		}
+	}()
+	return server, nil
+}
+
+// Close shuts down the server.
+func (s *Server) Close() error {
+	err := s.conn.Close()
+	s.wg.Wait()
+	return err
+}
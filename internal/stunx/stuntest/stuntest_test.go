@@ -0,0 +1,64 @@
+package stuntest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/measurement-kit/engine/internal/stunx"
+)
+
+// exchange sends a binding request to server and parses the response.
+func exchange(t *testing.T, conn net.Conn) (string, error) {
+	request, err := stunx.NewBindingRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 1<<12)
+	conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+	return stunx.ParseBindingResponse(request, buffer[:n])
+}
+
+// TestServer checks whether the server drops the requested number of
+// requests and then replies with our mapped address.
+func TestServer(t *testing.T) {
+	server, err := NewServer(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("udp", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := exchange(t, conn); err == nil {
+		t.Fatal("We expected the first request to be dropped")
+	}
+	mapped, err := exchange(t, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapped != conn.LocalAddr().String() {
+		t.Fatalf("Unexpected mapped address: %s", mapped)
+	}
+}
+
+// TestReplyErrors checks whether we do not reply to invalid requests.
+func TestReplyErrors(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	if _, err := Reply([]byte("antani"), addr); err == nil {
+		t.Fatal("We expected an error here")
+	}
+	response := (&stunx.Message{Type: stunx.TypeBindingSuccess}).Pack()
+	if _, err := Reply(response, addr); err != stunx.ErrInvalidMessage {
+		t.Fatal("We expected ErrInvalidMessage")
+	}
+}
@@ -0,0 +1,211 @@
+// Package stunx contains STUN extensions.
+//
+// We use this package to build STUN binding requests and to parse the
+// binding responses, as described in RFC5389. Since we have access to
+// the raw messages, nettests can archive them.
+package stunx
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// HeaderLength is the length of the STUN message header.
+const HeaderLength = 20
+
+// MagicCookie is the STUN magic cookie.
+const MagicCookie = 0x2112A442
+
+// The STUN message types we use.
+const (
+	TypeBindingRequest       = 0x0001
+	TypeBindingSuccess       = 0x0101
+	TypeBindingErrorResponse = 0x0111
+)
+
+// The STUN attributes we use.
+const (
+	AttrMappedAddress    = 0x0001
+	AttrErrorCode        = 0x0009
+	AttrXORMappedAddress = 0x0020
+)
+
+// The address families used by mapped address attributes.
+const (
+	familyIPv4 = 0x01
+	familyIPv6 = 0x02
+)
+
+// ErrMismatch indicates that the response does not match the request.
+var ErrMismatch = errors.New("stun: response does not match request")
+
+// ErrNoMappedAddress indicates that the response does not contain
+// any mapped address.
+var ErrNoMappedAddress = errors.New("stun: no mapped address")
+
+// ErrInvalidMessage indicates that the message is not valid.
+var ErrInvalidMessage = errors.New("stun: invalid message")
+
+// Message is a STUN message.
+type Message struct {
+	// Attributes maps attribute types to their value.
+	Attributes map[uint16][]byte
+
+	// TransactionID is the transaction ID.
+	TransactionID [12]byte
+
+	// Type is the message type.
+	Type uint16
+}
+
+// NewBindingRequest creates a new binding request with a random
+// transaction ID and returns it serialized.
+func NewBindingRequest() ([]byte, error) {
+	message := Message{Type: TypeBindingRequest}
+	if _, err := rand.Read(message.TransactionID[:]); err != nil {
+		return nil, err
+	}
+	return message.Pack(), nil
+}
+
+// Pack serializes the message.
+func (m *Message) Pack() []byte {
+	var body bytes.Buffer
+	for attr, value := range m.Attributes {
+		binary.Write(&body, binary.BigEndian, attr)
+		binary.Write(&body, binary.BigEndian, uint16(len(value)))
+		body.Write(value)
+		body.Write(make([]byte, (4-len(value)%4)%4))
+	}
+	var message bytes.Buffer
+	binary.Write(&message, binary.BigEndian, m.Type)
+	binary.Write(&message, binary.BigEndian, uint16(body.Len()))
+	binary.Write(&message, binary.BigEndian, uint32(MagicCookie))
+	message.Write(m.TransactionID[:])
+	message.Write(body.Bytes())
+	return message.Bytes()
+}
+
+// Unpack parses a serialized message.
+func Unpack(data []byte) (*Message, error) {
+	if len(data) < HeaderLength || data[0]&0xc0 != 0 {
+		return nil, ErrInvalidMessage
+	}
+	if binary.BigEndian.Uint32(data[4:8]) != MagicCookie {
+		return nil, ErrInvalidMessage
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length%4 != 0 || len(data) != HeaderLength+length {
+		return nil, ErrInvalidMessage
+	}
+	m := &Message{
+		Attributes: make(map[uint16][]byte),
+		Type:       binary.BigEndian.Uint16(data[0:2]),
+	}
+	copy(m.TransactionID[:], data[8:HeaderLength])
+	for body := data[HeaderLength:]; len(body) > 0; {
+		if len(body) < 4 {
+			return nil, ErrInvalidMessage
+		}
+		attr := binary.BigEndian.Uint16(body[0:2])
+		size := int(binary.BigEndian.Uint16(body[2:4]))
+		padded := size + (4-size%4)%4
+		if len(body) < 4+padded {
+			return nil, ErrInvalidMessage
+		}
+		if _, found := m.Attributes[attr]; !found {
+			m.Attributes[attr] = body[4 : 4+size]
+		}
+		body = body[4+padded:]
+	}
+	return m, nil
+}
+
+// EncodeXORMappedAddress returns the value of the XOR-MAPPED-ADDRESS
+// attribute for addr in a message with the specified transaction ID.
+func EncodeXORMappedAddress(addr *net.UDPAddr, transactionID [12]byte) []byte {
+	family, ip := byte(familyIPv6), addr.IP.To16()
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		family, ip = familyIPv4, ip4
+	}
+	value := []byte{0, family, 0, 0}
+	binary.BigEndian.PutUint16(value[2:4], uint16(addr.Port)^(MagicCookie>>16))
+	return append(value, xorIP(ip, transactionID)...)
+}
+
+// xorIP returns ip XORed with the magic cookie and the transaction ID.
+func xorIP(ip net.IP, transactionID [12]byte) net.IP {
+	var key [16]byte
+	binary.BigEndian.PutUint32(key[:4], MagicCookie)
+	copy(key[4:], transactionID[:])
+	out := make(net.IP, len(ip))
+	for i := range ip {
+		out[i] = ip[i] ^ key[i]
+	}
+	return out
+}
+
+// decodeAddress decodes a mapped address attribute value.
+func decodeAddress(value []byte) (net.IP, int, error) {
+	if len(value) < 4 {
+		return nil, 0, ErrInvalidMessage
+	}
+	port := int(binary.BigEndian.Uint16(value[2:4]))
+	switch {
+	case value[1] == familyIPv4 && len(value) == 8:
+		return net.IP(value[4:8]), port, nil
+	case value[1] == familyIPv6 && len(value) == 20:
+		return net.IP(value[4:20]), port, nil
+	}
+	return nil, 0, ErrInvalidMessage
+}
+
+// ParseBindingResponse parses the response to the specified binding
+// request and returns the mapped address as "ip:port". We prefer the
+// XOR-MAPPED-ADDRESS to the MAPPED-ADDRESS when both are present.
+func ParseBindingResponse(request, response []byte) (string, error) {
+	req, err := Unpack(request)
+	if err != nil {
+		return "", err
+	}
+	resp, err := Unpack(response)
+	if err != nil {
+		return "", err
+	}
+	if resp.TransactionID != req.TransactionID {
+		return "", ErrMismatch
+	}
+	switch resp.Type {
+	case TypeBindingSuccess:
+	case TypeBindingErrorResponse:
+		value := resp.Attributes[AttrErrorCode]
+		if len(value) < 4 {
+			return "", errors.New("stun: server returned an error")
+		}
+		code := int(value[2]&0x07)*100 + int(value[3])
+		return "", fmt.Errorf("stun: server returned error %d: %s", code, value[4:])
+	default:
+		return "", ErrMismatch
+	}
+	if value, found := resp.Attributes[AttrXORMappedAddress]; found {
+		ip, port, err := decodeAddress(value)
+		if err != nil {
+			return "", err
+		}
+		ip = xorIP(ip, resp.TransactionID)
+		port ^= MagicCookie >> 16
+		return net.JoinHostPort(ip.String(), fmt.Sprint(port)), nil
+	}
+	if value, found := resp.Attributes[AttrMappedAddress]; found {
+		ip, port, err := decodeAddress(value)
+		if err != nil {
+			return "", err
+		}
+		return net.JoinHostPort(ip.String(), fmt.Sprint(port)), nil
+	}
+	return "", ErrNoMappedAddress
+}
@@ -0,0 +1,120 @@
+package stunx
+
+import (
+	"net"
+	"testing"
+)
+
+// newRequest creates a binding request or fails the test.
+func newRequest(t *testing.T) ([]byte, *Message) {
+	request, err := NewBindingRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := Unpack(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request, message
+}
+
+// TestNewBindingRequest checks whether we create valid requests.
+func TestNewBindingRequest(t *testing.T) {
+	request, message := newRequest(t)
+	if len(request) != HeaderLength || message.Type != TypeBindingRequest {
+		t.Fatal("Unexpected request")
+	}
+	other, _ := newRequest(t)
+	if string(other[8:]) == string(request[8:]) {
+		t.Fatal("Transaction IDs should be random")
+	}
+}
+
+// TestParseBindingResponse checks whether we parse both kinds of
+// mapped addresses and whether we prefer the XOR-MAPPED-ADDRESS.
+func TestParseBindingResponse(t *testing.T) {
+	request, message := newRequest(t)
+	for _, addr := range []*net.UDPAddr{
+		{IP: net.ParseIP("192.0.2.1"), Port: 3478},
+		{IP: net.ParseIP("2001:db8::1"), Port: 19302},
+	} {
+		response := &Message{
+			Attributes: map[uint16][]byte{
+				AttrXORMappedAddress: EncodeXORMappedAddress(addr, message.TransactionID),
+			},
+			TransactionID: message.TransactionID,
+			Type:          TypeBindingSuccess,
+		}
+		mapped, err := ParseBindingResponse(request, response.Pack())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mapped != addr.String() {
+			t.Fatalf("Unexpected mapped address: %s", mapped)
+		}
+	}
+	response := &Message{
+		Attributes: map[uint16][]byte{
+			AttrMappedAddress: {0, familyIPv4, 0x0d, 0x96, 192, 0, 2, 7},
+		},
+		TransactionID: message.TransactionID,
+		Type:          TypeBindingSuccess,
+	}
+	mapped, err := ParseBindingResponse(request, response.Pack())
+	if err != nil || mapped != "192.0.2.7:3478" {
+		t.Fatalf("Unexpected mapped address: %s", mapped)
+	}
+}
+
+// TestParseBindingResponseErrors checks whether we reject invalid,
+// mismatching and error responses.
+func TestParseBindingResponseErrors(t *testing.T) {
+	request, message := newRequest(t)
+	other, _ := newRequest(t)
+	table := []struct {
+		response []byte
+		err      error
+	}{
+		{response: []byte("antani"), err: ErrInvalidMessage},
+		{response: other, err: ErrMismatch},
+		{response: request, err: ErrMismatch},
+		{response: (&Message{
+			TransactionID: message.TransactionID,
+			Type:          TypeBindingSuccess,
+		}).Pack(), err: ErrNoMappedAddress},
+		{response: (&Message{
+			Attributes:    map[uint16][]byte{AttrXORMappedAddress: {0, 7}},
+			TransactionID: message.TransactionID,
+			Type:          TypeBindingSuccess,
+		}).Pack(), err: ErrInvalidMessage},
+	}
+	for _, entry := range table {
+		if _, err := ParseBindingResponse(request, entry.response); err != entry.err {
+			t.Fatalf("Expected %v, got %v", entry.err, err)
+		}
+	}
+	response := &Message{
+		Attributes:    map[uint16][]byte{AttrErrorCode: {0, 0, 4, 20, 'x'}},
+		TransactionID: message.TransactionID,
+		Type:          TypeBindingErrorResponse,
+	}
+	_, err := ParseBindingResponse(request, response.Pack())
+	if err == nil || err.Error() != "stun: server returned error 420: x" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// TestUnpackErrors checks whether we reject malformed messages.
+func TestUnpackErrors(t *testing.T) {
+	request, _ := newRequest(t)
+	truncated := append([]byte{}, request...)
+	truncated[3] = 8
+	badCookie := append([]byte{}, request...)
+	badCookie[4] = 0
+	badAttr := append(append([]byte{}, truncated...), 0, 1, 0, 8, 0, 0, 0, 0)
+	for _, data := range [][]byte{nil, truncated, badCookie, badAttr} {
+		if _, err := Unpack(data); err != ErrInvalidMessage {
+			t.Fatal("Expected ErrInvalidMessage")
+		}
+	}
+}
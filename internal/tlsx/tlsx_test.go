@@ -0,0 +1,32 @@
+package tlsx
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+// TestVersionString checks whether VersionString works.
+func TestVersionString(t *testing.T) {
+	if VersionString(tls.VersionTLS13) != "TLSv1.3" {
+		t.Fatal("Unexpected TLSv1.3 name")
+	}
+	if VersionString(0) != "" {
+		t.Fatal("Unexpected name for zero")
+	}
+	if VersionString(0xabcd) != "0xabcd" {
+		t.Fatal("Unexpected name for unknown version")
+	}
+}
+
+// TestCipherSuiteString checks whether CipherSuiteString works.
+func TestCipherSuiteString(t *testing.T) {
+	if CipherSuiteString(tls.TLS_AES_128_GCM_SHA256) != "TLS_AES_128_GCM_SHA256" {
+		t.Fatal("Unexpected TLS_AES_128_GCM_SHA256 name")
+	}
+	if CipherSuiteString(0) != "" {
+		t.Fatal("Unexpected name for zero")
+	}
+	if CipherSuiteString(0xabcd) != "0xabcd" {
+		t.Fatal("Unexpected name for unknown cipher suite")
+	}
+}
@@ -3,6 +3,8 @@ package model
 import (
 	"encoding/json"
 	"fmt"
+
+	"github.com/measurement-kit/engine/internal/errorx"
 )
 
 // Event is an event emitted by a nettest.
@@ -16,9 +18,13 @@ type Event struct {
 
 // failureMeasurementEvent is a measurement failure
 type failureMeasurementEvent struct {
-	// Failure is the error that occurred
+	// Failure is the error that occurred, classified using
+	// the OONI failure vocabulary
 	Failure string `json:"failure"`
 
+	// FailureDetails is the original error message
+	FailureDetails string `json:"failure_details"`
+
 	// Idx is the measurement index
 	Idx int64 `json:"idx"`
 }
@@ -28,8 +34,9 @@ func NewFailureMeasurementEvent(idx int64, err error) Event {
 	return Event{
 		Key: "failure.measurement",
 		Value: failureMeasurementEvent{
-			Failure: err.Error(),
-			Idx:     idx,
+			Failure:        errorx.Classify(err),
+			FailureDetails: errorx.Details(err),
+			Idx:            idx,
 		},
 	}
 }
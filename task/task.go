@@ -4,63 +4,182 @@ package task
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/measurement-kit/engine/internal/nettest"
+	"github.com/measurement-kit/engine/internal/nettest/bridgereachability"
+	"github.com/measurement-kit/engine/internal/nettest/dash"
+	"github.com/measurement-kit/engine/internal/nettest/dnscheck"
+	"github.com/measurement-kit/engine/internal/nettest/dnsconsistency"
+	"github.com/measurement-kit/engine/internal/nettest/fbmessenger"
+	"github.com/measurement-kit/engine/internal/nettest/httpheaderfieldmanipulation"
+	"github.com/measurement-kit/engine/internal/nettest/httpinvalidrequestline"
 	"github.com/measurement-kit/engine/internal/nettest/ndt7"
 	"github.com/measurement-kit/engine/internal/nettest/psiphontunnel"
 	"github.com/measurement-kit/engine/internal/nettest/psiphontunnel/runner"
+	"github.com/measurement-kit/engine/internal/nettest/sniblocking"
+	"github.com/measurement-kit/engine/internal/nettest/stunreachability"
+	"github.com/measurement-kit/engine/internal/nettest/tcpconnect"
+	"github.com/measurement-kit/engine/internal/nettest/telegram"
+	"github.com/measurement-kit/engine/internal/nettest/urlgetter"
+	"github.com/measurement-kit/engine/internal/nettest/vanillator"
+	"github.com/measurement-kit/engine/internal/nettest/webconnectivity"
+	"github.com/measurement-kit/engine/internal/nettest/whatsapp"
+	"github.com/measurement-kit/engine/internal/resolver"
 	"github.com/measurement-kit/engine/model"
 )
 
 // Config contains the task settings.
 type Config struct {
+	// BouncerCacheDir is the optional directory where we cache the
+	// services returned by the bouncer, such that running several
+	// tasks in a row does not query the bouncer every time.
+	BouncerCacheDir string
+
+	// BouncerCacheTTL is the number of seconds after which cached
+	// services are stale. When zero, we use a default TTL.
+	BouncerCacheTTL int64
+
 	// ConfigFilePath is the path to a task specific config file.
 	ConfigFilePath string
 
+	// ConfigIV is the hex encoded IV for EncryptedConfigFilePath.
+	ConfigIV string
+
+	// ConfigJSON is an inline task specific config. When it is not
+	// empty, it takes precedence over the config file paths.
+	ConfigJSON string
+
+	// ConfigKey is the hex encoded key for EncryptedConfigFilePath.
+	ConfigKey string
+
+	// ControlEndpoint is the "host:port" sni_blocking control
+	// endpoint. When empty, we use ControlSNI and port 443.
+	ControlEndpoint string
+
+	// ControlResolvers contains the control resolvers used by
+	// dns_consistency. When empty, we use the "dns" test helpers
+	// discovered using the bouncer. See dnsconsistency.Config.
+	ControlResolvers []model.Service
+
+	// ControlSNI is the SNI served by the sni_blocking control
+	// endpoint. When empty, we use a default SNI.
+	ControlSNI string
+
+	// DashServerURL is the base URL of the DASH server. When empty,
+	// we discover the server using mlab-ns.
+	DashServerURL string
+
+	// DNSCheckDomains contains the domains dnscheck resolves. When
+	// empty, we use a default list of domains.
+	DNSCheckDomains []string
+
+	// EncryptedConfigFilePath is the path to a task specific config
+	// file encrypted using AES-256-CBC, which takes precedence over the
+	// ConfigFilePath and is only decrypted in memory.
+	EncryptedConfigFilePath string
+
+	// HostHeader overrides the Host header of the first request
+	// sent by urlgetter.
+	HostHeader string
+
 	// IgnoreBouncerError indicates whether we should ignore bouncer errors.
 	IgnoreBouncerError bool
 
 	// Inputs is the list of inputs for the measurement task.
 	Inputs []string
 
+	// KeepWorkDir indicates whether the task should keep its state in
+	// the WorkDirPath across runs rather than starting from scratch.
+	KeepWorkDir bool
+
+	// MaxWorkDirSize is the maximum size in bytes of the working
+	// directory kept by psiphontunnel when KeepWorkDir is true. When
+	// zero, we use a default size.
+	MaxWorkDirSize int64
+
+	// NoTLSVerify indicates whether urlgetter should not verify
+	// the TLS certificates.
+	NoTLSVerify bool
+
 	// NoBouncer indicates whether we should not use the bouncer.
 	NoBouncer bool
 
 	// NoCollector indicates whether we should not use the collector.
 	NoCollector bool
 
+	// Parallelism is the number of inputs we measure concurrently. When
+	// it is zero or negative, we measure one input at a time.
+	Parallelism int
+
+	// RaceBackends indicates whether we should query all the bouncers
+	// concurrently and probe the collectors in parallel to use the
+	// fastest one, rather than trying them in order. This helps on
+	// censored networks, where the first entries may time out.
+	RaceBackends bool
+
+	// ResolverURL is the resolver we use to resolve the names of the
+	// bouncers and of the collectors, e.g., "udp://8.8.8.8", "tcp://8.8.8.8",
+	// "dot://1.1.1.1", or "https://8.8.8.8/dns-query". The host must be an
+	// IP address, such that we do not depend on the system resolver. When
+	// it is empty, we use the system resolver.
+	ResolverURL string
+
+	// SaveProbeIP indicates whether measurements may contain the probe
+	// IP, e.g., the address returned by STUN servers. By default, we
+	// replace such addresses with "[scrubbed]".
+	SaveProbeIP bool
+
+	// SNI overrides the SNI that urlgetter sends during TLS handshakes.
+	SNI string
+
+	// ThroughputSize is the maximum number of bytes psiphontunnel
+	// downloads from ThroughputURL. When zero, we use a default size.
+	ThroughputSize int64
+
+	// ThroughputURL is the optional URL of a large resource psiphontunnel
+	// downloads through the tunnel to measure its throughput.
+	ThroughputURL string
+
+	// TorPath is the path of the tor binary used by vanilla_tor. When
+	// empty, we search for tor in the PATH.
+	TorPath string
+
+	// URLGetterResolverURL is the resolver urlgetter uses for measuring,
+	// e.g., "udp://8.8.8.8". Unlike ResolverURL, which is for the OONI
+	// backends, the result is part of the measurement. When it is empty,
+	// urlgetter uses the system resolver.
+	URLGetterResolverURL string
+
 	// WorkDirPath is the working directory to use
 	WorkDirPath string
 }
 
-func discoverAvailableCollectors(
-	ctx context.Context, nt *nettest.Nettest,
-	config Config, out chan<- model.Event,
+func createResolver(
+	nt *nettest.Nettest, config Config, out chan<- model.Event,
 ) error {
-	if !config.NoBouncer {
-		out <- model.NewLogInfoEvent("discovering available collectors")
-		err := nt.DiscoverAvailableCollectors(ctx)
-		if err != nil && !config.IgnoreBouncerError {
-			out <- model.NewLogWarningEvent(
-				err, "cannot discover available collectors",
-			)
+	if config.ResolverURL != "" {
+		r, err := resolver.New(config.ResolverURL)
+		if err != nil {
+			out <- model.NewLogWarningEvent(err, "cannot create resolver")
 			return err
 		}
+		nt.Resolver = r
 	}
 	return nil
 }
 
-func discoverAvailableTestHelpers(
+func discoverAvailableServices(
 	ctx context.Context, nt *nettest.Nettest,
 	config Config, out chan<- model.Event,
 ) error {
 	if !config.NoBouncer {
-		out <- model.NewLogInfoEvent("discovering available test helpers")
-		err := nt.DiscoverAvailableTestHelpers(ctx)
+		out <- model.NewLogInfoEvent("discovering available services")
+		err := nt.DiscoverAvailableServices(ctx)
 		if err != nil && !config.IgnoreBouncerError {
 			out <- model.NewLogWarningEvent(
-				err, "cannot discover available test helpers",
+				err, "cannot discover available services",
 			)
 			return err
 		}
@@ -161,11 +280,14 @@ func performTask(
 	config Config, out chan<- model.Event,
 ) {
 	defer close(out) // tell the reader we're done
-	err := discoverAvailableCollectors(ctx, nt, config, out)
+	nt.BouncerCacheDir = config.BouncerCacheDir
+	nt.BouncerCacheTTL = time.Duration(config.BouncerCacheTTL) * time.Second
+	nt.RaceBackends = config.RaceBackends
+	err := createResolver(nt, config, out)
 	if err != nil {
 		return
 	}
-	err = discoverAvailableTestHelpers(ctx, nt, config, out)
+	err = discoverAvailableServices(ctx, nt, config, out)
 	if err != nil {
 		return
 	}
@@ -179,10 +301,26 @@ func performTask(
 		return
 	}
 	defer closeReport(ctx, nt, config)
-	// TODO(bassosimone): implement parallelism
+	parallelism := config.Parallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+	inputs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for input := range inputs {
+				performEmitAndSubmitMeasurement(ctx, nt, config, out, input)
+			}
+		}()
+	}
 	for _, input := range config.Inputs {
-		performEmitAndSubmitMeasurement(ctx, nt, config, out, input)
+		inputs <- input
 	}
+	close(inputs)
+	wg.Wait()
 }
 
 func startTaskAndFilterEvents(
@@ -209,6 +347,83 @@ func startTaskAndFilterEvents(
 	}
 }
 
+// StartBridgeReachability starts a new bridge_reachability task. The
+// config.Inputs are the bridge lines to measure. When there are no
+// inputs, we measure the bridges discovered using the bouncer.
+func StartBridgeReachability(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := bridgereachability.NewNettest()
+	if len(config.Inputs) <= 0 {
+		config.Inputs = []string{""} // measure the test helpers
+	}
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
+// StartDash starts a new dash task.
+func StartDash(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := dash.NewNettest(dash.Config{
+		ServerURL: config.DashServerURL,
+	})
+	config.Inputs = []string{""} // force running just once
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
+// StartDNSCheck starts a new dnscheck task. The config.Inputs are
+// the URLs of the resolvers to check, e.g., "https://dns.google/dns-query",
+// "dot://dns.google", "udp://8.8.8.8", and "tcp://8.8.8.8".
+func StartDNSCheck(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := dnscheck.NewNettest(dnscheck.Config{
+		Domains: config.DNSCheckDomains,
+	})
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
+// StartDNSConsistency starts a new dns_consistency task. The
+// config.Inputs are the domains to resolve. We use config.ControlResolvers
+// or, when empty, the "dns" test helpers discovered using the bouncer
+// as control resolvers.
+func StartDNSConsistency(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := dnsconsistency.NewNettest(dnsconsistency.Config{
+		ControlResolvers: config.ControlResolvers,
+	})
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
+// StartFacebookMessenger starts a new facebook_messenger task.
+func StartFacebookMessenger(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := fbmessenger.NewNettest(fbmessenger.Config{})
+	config.Inputs = []string{""} // force running just once
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
+// StartHTTPHeaderFieldManipulation starts a new
+// http_header_field_manipulation task.
+func StartHTTPHeaderFieldManipulation(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := httpheaderfieldmanipulation.NewNettest()
+	config.Inputs = []string{""} // force running just once
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
+// StartHTTPInvalidRequestLine starts a new http_invalid_request_line task.
+func StartHTTPInvalidRequestLine(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := httpinvalidrequestline.NewNettest()
+	config.Inputs = []string{""} // force running just once
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
 // StartNdt7 starts a new ndt7 task.
 func StartNdt7(ctx context.Context, config Config) <-chan model.Event {
 	out := make(chan model.Event)
@@ -218,14 +433,111 @@ func StartNdt7(ctx context.Context, config Config) <-chan model.Event {
 	return out
 }
 
-// StartPsiphonTunnel starts a new psiphontunnel task
+// StartPsiphonTunnel starts a new psiphontunnel task. The config.Inputs
+// are the URLs to fetch using the tunnel. We bootstrap the tunnel just
+// once and fetch all of them in the same measurement.
 func StartPsiphonTunnel(ctx context.Context, config Config) <-chan model.Event {
 	out := make(chan model.Event)
 	nt := psiphontunnel.NewNettest(runner.Config{
-		ConfigFilePath: config.ConfigFilePath,
-		WorkDirPath:    config.WorkDirPath,
+		ConfigFilePath:          config.ConfigFilePath,
+		ConfigIV:                config.ConfigIV,
+		ConfigJSON:              config.ConfigJSON,
+		ConfigKey:               config.ConfigKey,
+		EncryptedConfigFilePath: config.EncryptedConfigFilePath,
+		KeepWorkDir:             config.KeepWorkDir,
+		MaxWorkDirSize:          config.MaxWorkDirSize,
+		ThroughputSize:          config.ThroughputSize,
+		ThroughputURL:           config.ThroughputURL,
+		URLs:                    config.Inputs,
+		WorkDirPath:             config.WorkDirPath,
 	})
 	config.Inputs = []string{""} // force running just once
 	go startTaskAndFilterEvents(ctx, nt, config, out)
 	return out
 }
+
+// StartSNIBlocking starts a new sni_blocking task. The config.Inputs
+// are the domains to use as SNI.
+func StartSNIBlocking(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := sniblocking.NewNettest(sniblocking.Config{
+		ControlSNI: config.ControlSNI,
+		Endpoint:   config.ControlEndpoint,
+	})
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
+// StartSTUNReachability starts a new stun_reachability task. The
+// config.Inputs are the STUN endpoints to measure.
+func StartSTUNReachability(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := stunreachability.NewNettest(stunreachability.Config{
+		SaveProbeIP: config.SaveProbeIP,
+	})
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
+// StartTCPConnect starts a new tcp_connect task. The config.Inputs
+// are the "host:port" endpoints to connect to.
+func StartTCPConnect(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := tcpconnect.NewNettest()
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
+// StartTelegram starts a new telegram task.
+func StartTelegram(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := telegram.NewNettest(telegram.Config{})
+	config.Inputs = []string{""} // force running just once
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
+// StartURLGetter starts a new urlgetter task. The config.Inputs
+// are the URLs to fetch.
+func StartURLGetter(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := urlgetter.NewNettest(urlgetter.Config{
+		HostHeader:  config.HostHeader,
+		NoTLSVerify: config.NoTLSVerify,
+		ResolverURL: config.URLGetterResolverURL,
+		SNI:         config.SNI,
+	})
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
+// StartVanillaTor starts a new vanilla_tor task. We run the tor binary
+// at config.TorPath using a fresh data directory inside config.WorkDirPath.
+func StartVanillaTor(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := vanillator.NewNettest(vanillator.Config{
+		TorPath:     config.TorPath,
+		WorkDirPath: config.WorkDirPath,
+	})
+	config.Inputs = []string{""} // force running just once
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
+// StartWebConnectivity starts a new web_connectivity task. The
+// config.Inputs are the URLs to measure.
+func StartWebConnectivity(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := webconnectivity.NewNettest()
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
+
+// StartWhatsApp starts a new whatsapp task.
+func StartWhatsApp(ctx context.Context, config Config) <-chan model.Event {
+	out := make(chan model.Event)
+	nt := whatsapp.NewNettest(whatsapp.Config{})
+	config.Inputs = []string{""} // force running just once
+	go startTaskAndFilterEvents(ctx, nt, config, out)
+	return out
+}
@@ -3,6 +3,10 @@ package task_test
 import (
 	"context"
 	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/measurement-kit/engine/task"
@@ -35,3 +39,311 @@ func TestPsiphonTunnelIntegration(t *testing.T) {
 		t.Log(string(data))
 	}
 }
+
+// TestBridgeReachabilityIntegration runs a bridge_reachability nettest.
+func TestBridgeReachabilityIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{
+		Inputs: []string{
+			"obfs4 192.95.36.142:443 CDF2E852BF539B82BD10E27E9115A31734E378C2 " +
+				"cert=qUVQ0srL1JI/vO6V6m/24anYXiJD3QP2HgzUKQtQ7GRqqUvs7P+tG43RtAqdhLOALP7DJQ " +
+				"iat-mode=1",
+		},
+	}
+	for ev := range task.StartBridgeReachability(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestDashIntegration runs a dash nettest.
+func TestDashIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{}
+	for ev := range task.StartDash(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestDNSCheckIntegration runs a dnscheck nettest.
+func TestDNSCheckIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{
+		Inputs: []string{
+			"https://dns.google/dns-query",
+			"dot://dns.google",
+			"udp://8.8.8.8",
+			"tcp://8.8.8.8",
+		},
+	}
+	for ev := range task.StartDNSCheck(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestDNSConsistencyIntegration runs a dns_consistency nettest.
+func TestDNSConsistencyIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{
+		Inputs: []string{"www.example.com"},
+	}
+	for ev := range task.StartDNSConsistency(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestFacebookMessengerIntegration runs a facebook_messenger nettest.
+func TestFacebookMessengerIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{}
+	for ev := range task.StartFacebookMessenger(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestHTTPHeaderFieldManipulationIntegration runs a
+// http_header_field_manipulation nettest.
+func TestHTTPHeaderFieldManipulationIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{}
+	for ev := range task.StartHTTPHeaderFieldManipulation(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestHTTPInvalidRequestLineIntegration runs a http_invalid_request_line nettest.
+func TestHTTPInvalidRequestLineIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{}
+	for ev := range task.StartHTTPInvalidRequestLine(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestSNIBlockingIntegration runs a sni_blocking nettest.
+func TestSNIBlockingIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{
+		Inputs: []string{"www.example.org"},
+	}
+	for ev := range task.StartSNIBlocking(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestSTUNReachabilityIntegration runs a stun_reachability nettest.
+func TestSTUNReachabilityIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{
+		Inputs: []string{"stun.l.google.com:19302"},
+	}
+	for ev := range task.StartSTUNReachability(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestTCPConnectIntegration runs a tcp_connect nettest.
+func TestTCPConnectIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{
+		Inputs:      []string{"www.example.com:443", "www.example.com:80"},
+		Parallelism: 2,
+	}
+	for ev := range task.StartTCPConnect(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestTCPConnectParallelism checks whether we emit a measurement for
+// each input when measuring several inputs concurrently.
+func TestTCPConnectParallelism(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	count, inputs := 0, make(map[string]bool)
+	config := task.Config{NoBouncer: true, NoCollector: true, Parallelism: 4}
+	for i := 0; i < 10; i++ {
+		config.Inputs = append(config.Inputs, listener.Addr().String())
+	}
+	config.Inputs = append(config.Inputs, "127.0.0.1:")
+	for ev := range task.StartTCPConnect(context.Background(), config) {
+		if ev.Key != "measurement" {
+			continue
+		}
+		data, err := json.Marshal(ev.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var value struct {
+			JSONStr string `json:"json_str"`
+		}
+		if err := json.Unmarshal(data, &value); err != nil {
+			t.Fatal(err)
+		}
+		var measurement struct {
+			Input string `json:"input"`
+		}
+		if err := json.Unmarshal([]byte(value.JSONStr), &measurement); err != nil {
+			t.Fatal(err)
+		}
+		count, inputs[measurement.Input] = count+1, true
+	}
+	if count != len(config.Inputs) || len(inputs) != 2 {
+		t.Fatal("We did not emit a measurement for each input")
+	}
+}
+
+// TestTCPConnectInvalidResolverURL checks whether we stop the task
+// before measuring when the resolver URL is not valid.
+func TestTCPConnectInvalidResolverURL(t *testing.T) {
+	config := task.Config{
+		Inputs:      []string{"127.0.0.1:80"},
+		ResolverURL: "antani://8.8.8.8",
+	}
+	for ev := range task.StartTCPConnect(context.Background(), config) {
+		if ev.Key == "measurement" {
+			t.Fatal("We did not expect any measurement")
+		}
+	}
+}
+
+// TestTelegramIntegration runs a telegram nettest.
+func TestTelegramIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{}
+	for ev := range task.StartTelegram(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestURLGetterIntegration runs a urlgetter nettest.
+func TestURLGetterIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{
+		Inputs: []string{"https://www.example.com/"},
+	}
+	for ev := range task.StartURLGetter(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestURLGetterHostHeader checks whether we pass the urlgetter
+// settings in the task config to the nettest.
+func TestURLGetterHostHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Host))
+		},
+	))
+	defer server.Close()
+	config := task.Config{
+		HostHeader:  "www.example.org",
+		Inputs:      []string{server.URL},
+		NoBouncer:   true,
+		NoCollector: true,
+	}
+	var found bool
+	for ev := range task.StartURLGetter(context.Background(), config) {
+		if ev.Key != "measurement" {
+			continue
+		}
+		data, err := json.Marshal(ev.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = strings.Contains(string(data), `body\":\"www.example.org\"`)
+	}
+	if !found {
+		t.Fatal("The server did not receive the Host header")
+	}
+}
+
+// TestVanillaTorIntegration runs a vanilla_tor nettest.
+func TestVanillaTorIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{}
+	for ev := range task.StartVanillaTor(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestWebConnectivityIntegration runs a web_connectivity nettest.
+func TestWebConnectivityIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{
+		Inputs: []string{"https://www.example.com/"},
+	}
+	for ev := range task.StartWebConnectivity(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}
+
+// TestWhatsAppIntegration runs a whatsapp nettest.
+func TestWhatsAppIntegration(t *testing.T) {
+	ctx := context.Background()
+	config := task.Config{}
+	for ev := range task.StartWhatsApp(ctx, config) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(string(data))
+	}
+}